@@ -2,22 +2,34 @@ package cli
 
 import (
 	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/0xfe/microstellar"
 
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/stellar/go/amount"
+	"github.com/stellar/go/clients/horizon"
 )
 
 func (cli *CLI) buildAccountCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "account [new|set|address|seed|del]",
+		Use:   "account [new|set|set-signers|address|seed|del|list|info|watch-balance|thresholds-explain|flags-explain|activity|sequence|qr|verify-domain|require-memo]",
 		Short: "manage stellar keypairs and accounts",
 		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			if len(args) > 0 {
-				showError(logrus.Fields{"cmd": "accounts"}, "unrecognized account command: %s, expecting: new|set|address|seed|del", args[0])
+				showError(logrus.Fields{"cmd": "accounts"}, "unrecognized account command: %s, expecting: new|set|set-signers|address|seed|del|list|info|watch-balance|thresholds-explain|flags-explain|activity|sequence|qr|verify-domain|require-memo", args[0])
 				return
 			}
 		},
@@ -25,60 +37,359 @@ func (cli *CLI) buildAccountCmd() *cobra.Command {
 
 	cmd.AddCommand(cli.buildAccountNewCmd())
 	cmd.AddCommand(cli.buildAccountSetCmd())
+	cmd.AddCommand(cli.buildAccountSetSignersCmd())
 	cmd.AddCommand(cli.buildAccountDelCmd())
 	cmd.AddCommand(cli.buildAccountAddressCmd())
 	cmd.AddCommand(cli.buildAccountSeedCmd())
+	cmd.AddCommand(cli.buildAccountListCmd())
+	cmd.AddCommand(cli.buildAccountInfoCmd())
+	cmd.AddCommand(cli.buildAccountWatchBalanceCmd())
+	cmd.AddCommand(cli.buildAccountThresholdsExplainCmd())
+	cmd.AddCommand(cli.buildAccountFlagsExplainCmd())
+	cmd.AddCommand(cli.buildAccountActivityCmd())
+	cmd.AddCommand(cli.buildAccountSequenceCmd())
+	cmd.AddCommand(cli.buildAccountQRCmd())
+	cmd.AddCommand(cli.buildAccountVerifyDomainCmd())
+	cmd.AddCommand(cli.buildAccountRequireMemoCmd())
 
 	return cmd
 }
 
 func (cli *CLI) buildAccountNewCmd() *cobra.Command {
 	accountNewCmd := &cobra.Command{
-		Use:   "new [name]",
-		Short: "create a new random keypair named [name]",
+		Use:   "new [name|--batch N --prefix prefix] [--fund-from source --start-balance amount [--data key=value]... [--require-memo]]",
+		Short: "create a new random keypair named [name], and optionally create it on the network",
 		Args:  cobra.MinimumNArgs(0),
 		Run: func(cmd *cobra.Command, args []string) {
-			pair, err := cli.ms.CreateKeyPair()
-			showSuccess("%s %s", pair.Address, pair.Seed)
+			logFields := logrus.Fields{"cmd": "account", "subcmd": "new"}
+			fundFrom, _ := cmd.Flags().GetString("fund-from")
+			startBalance, _ := cmd.Flags().GetString("start-balance")
+			dataFlags, _ := cmd.Flags().GetStringArray("data")
+			requireMemo, _ := cmd.Flags().GetBool("require-memo")
+			batchSize, _ := cmd.Flags().GetInt("batch")
+			prefix, _ := cmd.Flags().GetString("prefix")
 
-			if len(args) == 0 {
+			var batchNames []string
+			if cmd.Flags().Changed("batch") || prefix != "" {
+				var err error
+				if batchNames, err = cli.newBatchNames(cmd, args, batchSize, prefix); err != nil {
+					cli.error(logFields, "%v", err)
+					return
+				}
+
+				if len(dataFlags) > 0 || requireMemo {
+					cli.error(logFields, "--data and --require-memo can't be used with --batch")
+					return
+				}
+			}
+
+			// Catch bad funding requests before generating a keypair
+			var source string
+			var entries []dataEntry
+			if len(dataFlags) > 0 && fundFrom == "" {
+				cli.error(logFields, "--data needs --fund-from and --start-balance")
 				return
 			}
 
-			name := args[0]
+			if requireMemo && fundFrom == "" {
+				cli.error(logFields, "--require-memo needs --fund-from and --start-balance")
+				return
+			}
 
-			if err != nil {
-				showError(logrus.Fields{"cmd": "account", "subcmd": "new"}, "could not create keypair: %s", name)
+			// It's a data entry like any other, so it's set in the same transaction
+			if requireMemo {
+				dataFlags = append(dataFlags, memoRequiredKey+"=1")
+			}
+
+			if fundFrom != "" || startBalance != "" {
+				if fundFrom == "" || startBalance == "" {
+					cli.error(logFields, "--fund-from and --start-balance must be used together")
+					return
+				}
+
+				if err := validateAmount(startBalance, false); err != nil {
+					cli.error(logFields, "bad --start-balance: %v", err)
+					return
+				}
+
+				var err error
+				if entries, err = parseDataEntries(dataFlags); err != nil {
+					cli.error(logFields, "%v", err)
+					return
+				}
+
+				// One create account operation, and one manage data operation per entry
+				if ops := 1 + len(entries); ops > maxOpsPerTx {
+					cli.error(logFields, "too many --data entries: %d operations, the limit is %d", ops, maxOpsPerTx)
+					return
+				}
+
+				source, err = cli.ResolveAccount(logFields, fundFrom, "seed")
+				if err != nil || microstellar.ValidSeed(source) != nil {
+					cli.error(logFields, "no seed found in --fund-from: %s", fundFrom)
+					return
+				}
+
+				// There's no base reserve to check against on the fake network, unless
+				// it's overridden
+				ledger, err := cli.loadLatestLedger(logFields)
+				if err != nil {
+					cli.errorWithCode(ExitNetworkError, logFields, "can't load base reserve: %v", cli.errorString(err))
+					return
+				}
+
+				if ledger != nil || cli.getSetting("base-reserve-override", "base_reserve") != "" {
+					baseReserve, err := cli.baseReserve(ledger)
+					if err != nil {
+						cli.error(logFields, "%v", err)
+						return
+					}
+
+					if err := checkStartBalance(startBalance, baseReserve, len(entries)); err != nil {
+						cli.error(logFields, "%v", err)
+						return
+					}
+				}
+			}
+
+			if batchNames != nil {
+				cli.createAccountBatch(cmd, logFields, batchNames, source, startBalance)
 				return
 			}
 
-			err = cli.SetVar(fmt.Sprintf("account:%s:address", name), pair.Address)
+			pair, err := cli.ms.CreateKeyPair()
+			showSuccess("%s %s", pair.Address, pair.Seed)
+
+			if len(args) > 0 {
+				name := args[0]
+
+				if err != nil {
+					showError(logrus.Fields{"cmd": "account", "subcmd": "new"}, "could not create keypair: %s", name)
+					return
+				}
+
+				err = cli.SetVar(fmt.Sprintf("account:%s:address", name), pair.Address)
+
+				if err != nil {
+					showError(logrus.Fields{"cmd": "account", "subcmd": "new"}, "could not save keypair: %s", name)
+					return
+				}
+
+				err = cli.SetVar(fmt.Sprintf("account:%s:seed", name), pair.Seed)
+
+				if err != nil {
+					showError(logrus.Fields{"cmd": "account", "subcmd": "new"}, "could not save keypair: %s", name)
+					return
+				}
+			}
+
+			if source == "" || err != nil {
+				return
+			}
 
+			// Create the account on the network, funded by --fund-from
+			opts, err := cli.genTxOptions(cmd, logFields, source)
 			if err != nil {
-				showError(logrus.Fields{"cmd": "account", "subcmd": "new"}, "could not save keypair: %s", name)
+				cli.error(logFields, "can't generate transaction: %v", err)
 				return
 			}
 
-			err = cli.SetVar(fmt.Sprintf("account:%s:seed", name), pair.Seed)
+			debugf(logFields, "creating %s with %s XLM from %s", pair.Address, startBalance, fundFrom)
+			if len(entries) == 0 {
+				err = cli.ms.FundAccount(source, pair.Address, startBalance, opts)
+			} else {
+				err = cli.createAccountWithData(source, pair, startBalance, entries, opts)
+			}
 
 			if err != nil {
-				showError(logrus.Fields{"cmd": "account", "subcmd": "new"}, "could not save keypair: %s", name)
+				cli.errorWithCode(txExitCode(err), logFields, "could not create account %s: %v", pair.Address, cli.errorString(err))
 				return
 			}
 		},
 	}
 
 	accountNewCmd.Flags().String("name", "", "give the account a name")
+	accountNewCmd.Flags().String("fund-from", "", "create the account on the network, funded by this account")
+	accountNewCmd.Flags().String("start-balance", "", "the XLM to create the account with (at least two base reserves, plus one per --data entry)")
+	accountNewCmd.Flags().StringArray("data", []string{}, "also set this data entry (key=value) on the new account, in the same transaction (repeatable)")
+	accountNewCmd.Flags().Bool("require-memo", false, "make the new account require a memo on incoming payments (SEP-29), in the same transaction")
+	accountNewCmd.Flags().Int("batch", 0, "create this many keypairs, named --prefix followed by 0, 1, 2, ...")
+	accountNewCmd.Flags().String("prefix", "", "with --batch, the name of the new accounts, before their number")
 	return accountNewCmd
 }
 
+// newBatchNames returns the names of the size accounts account new --batch creates:
+// prefix followed by 0 to size-1. It returns an error if the flags are bad, or if any
+// of the names is already taken, so that no seeds are overwritten.
+func (cli *CLI) newBatchNames(cmd *cobra.Command, args []string, size int, prefix string) ([]string, error) {
+	if !cmd.Flags().Changed("batch") {
+		return nil, errors.Errorf("--prefix is only for --batch")
+	}
+
+	if size < 1 {
+		return nil, errors.Errorf("bad --batch: %d, expecting at least 1", size)
+	}
+
+	if prefix == "" {
+		return nil, errors.Errorf("--batch needs --prefix to name the accounts")
+	}
+
+	if len(args) > 0 {
+		return nil, errors.Errorf("--batch names the accounts with --prefix, so it can't be used with a name: %s", args[0])
+	}
+
+	existing, err := cli.AccountNames()
+	if err != nil {
+		return nil, errors.Wrap(err, "can't list accounts")
+	}
+
+	taken := map[string]bool{}
+	for _, name := range existing {
+		taken[name] = true
+	}
+
+	names := make([]string, size)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s%d", prefix, i)
+		if taken[names[i]] {
+			return nil, errors.Errorf("account %s already exists", names[i])
+		}
+	}
+
+	return names, nil
+}
+
+// createAccountBatch generates a keypair for each of names, saves it, and prints the
+// name and address. If source is set, it then creates the accounts on the network with
+// startBalance XLM each, funded by source, in as few transactions as possible. The
+// keypairs are saved first, so none are lost if funding fails.
+func (cli *CLI) createAccountBatch(cmd *cobra.Command, logFields logrus.Fields, names []string, source, startBalance string) {
+	addresses := make([]string, len(names))
+	for i, name := range names {
+		pair, err := cli.ms.CreateKeyPair()
+		if err != nil {
+			cli.error(logFields, "could not create keypair: %s", name)
+			return
+		}
+
+		if err := cli.SetVar(fmt.Sprintf("account:%s:address", name), pair.Address); err != nil {
+			cli.errorWithCode(ExitStoreError, logFields, "could not save keypair: %s", name)
+			return
+		}
+
+		if err := cli.SetVar(fmt.Sprintf("account:%s:seed", name), pair.Seed); err != nil {
+			cli.errorWithCode(ExitStoreError, logFields, "could not save keypair: %s", name)
+			return
+		}
+
+		addresses[i] = pair.Address
+		showSuccess("%s %s", name, pair.Address)
+	}
+
+	if source == "" {
+		return
+	}
+
+	opts, err := cli.genTxOptions(cmd, logFields, source)
+	if err != nil {
+		cli.error(logFields, "can't generate transaction: %v", err)
+		return
+	}
+
+	// WithSigner adds to opts, so it's only called once for all the transactions
+	opts = opts.WithSigner(source)
+
+	for start := 0; start < len(addresses); start += maxOpsPerTx {
+		end := start + maxOpsPerTx
+		if end > len(addresses) {
+			end = len(addresses)
+		}
+
+		cli.ms.Start(addressFromSeed(source), opts)
+		for i, address := range addresses[start:end] {
+			if err := cli.ms.FundAccount(source, address, startBalance); err != nil {
+				cli.error(logFields, "can't add creation of %s: %v", names[start+i], cli.errorString(err))
+				return
+			}
+		}
+
+		debugf(logFields, "creating accounts %d to %d of %d with %s XLM each", start+1, end, len(addresses), startBalance)
+		if err := cli.ms.Submit(); err != nil {
+			cli.errorWithCode(txExitCode(err), logFields, "created %d of %d accounts, then failed (the rest are saved, but not on the network): %v", start, len(addresses), cli.errorString(err))
+			return
+		}
+	}
+}
+
+// createAccountWithData creates the account pair on the network, funded by source, and
+// sets its data entries in the same transaction. The entries are sourced from (and
+// signed by) the new account.
+func (cli *CLI) createAccountWithData(source string, pair *microstellar.KeyPair, startBalance string, entries []dataEntry, opts *microstellar.Options) error {
+	cli.ms.Start(addressFromSeed(source), opts.WithSigner(source).WithSigner(pair.Seed))
+
+	if err := cli.ms.FundAccount(source, pair.Address, startBalance, opts); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := cli.ms.SetData(pair.Seed, entry.key, entry.value, opts); err != nil {
+			return err
+		}
+	}
+
+	return cli.ms.Submit()
+}
+
+// checkStartBalance returns an error if startBalance (in XLM) is less than the minimum
+// balance of a new account with the given number of subentries (e.g., data entries),
+// i.e., two base reserves (in stroops), plus one per subentry.
+func checkStartBalance(startBalance string, baseReserve int64, subentries int) error {
+	balance, err := amount.ParseInt64(startBalance)
+	if err != nil {
+		return errors.Errorf("bad --start-balance: %s", startBalance)
+	}
+
+	if minimum := int64(2+subentries) * baseReserve; balance < minimum {
+		return errors.Errorf("--start-balance %s is below the minimum balance of a new account (%s XLM)",
+			startBalance, amount.StringFromInt64(minimum))
+	}
+
+	return nil
+}
+
 func (cli *CLI) buildAccountSetCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "set [name] [address|seed]...",
+	cmd := &cobra.Command{
+		Use:   "set [name] [address|seed]... [--note note] [--encrypt]",
 		Short: "set address or seed of [name]",
-		Args:  cobra.MinimumNArgs(2),
+		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			name := args[0]
+			logFields := logrus.Fields{"cmd": "account", "subcmd": "set"}
+
+			if len(args) < 2 && !cmd.Flag("note").Changed {
+				cli.error(logFields, "need an address, seed, or --note for account: %s", name)
+				return
+			}
+
+			encrypt, _ := cmd.Flags().GetBool("encrypt")
+			var passphrase string
+			if encrypt {
+				hasSeed := false
+				for _, arg := range args[1:] {
+					hasSeed = hasSeed || microstellar.ValidSeed(arg) == nil
+				}
+
+				if !hasSeed {
+					cli.error(logFields, "--encrypt needs a seed for account: %s", name)
+					return
+				}
+
+				var err error
+				if passphrase, err = cli.seedPassphrase(name, true); err != nil {
+					cli.error(logFields, "%v", err)
+					return
+				}
+			}
 
 			for i := range args {
 				if i == 0 {
@@ -89,10 +400,25 @@ func (cli *CLI) buildAccountSetCmd() *cobra.Command {
 				keyType := ""
 
 				key := fmt.Sprintf("account:%s:", name)
-				if microstellar.ValidAddress(code) == nil || strings.Contains(code, "*") {
+				if microstellar.ValidAddress(code) == nil || isMuxedAddress(code) || strings.Contains(code, "*") {
 					keyType = "address"
 				} else if microstellar.ValidSeed(code) == nil {
 					keyType = "seed"
+
+					// The address is stored in the clear, so that it can be looked up
+					// without the passphrase
+					if encrypt {
+						if err := cli.SetVar(key+"address", addressFromSeed(code)); err != nil {
+							cli.errorWithCode(ExitStoreError, logFields, "could not save account: %s", name)
+							return
+						}
+
+						var err error
+						if code, err = encryptSeed(code, passphrase); err != nil {
+							cli.error(logFields, "can't encrypt seed: %v", err)
+							return
+						}
+					}
 				} else {
 					logrus.WithFields(logrus.Fields{"cmd": "account", "subcmd": "sed"}).Errorf("skipping invalid seed or address: %v", code)
 					continue
@@ -101,14 +427,112 @@ func (cli *CLI) buildAccountSetCmd() *cobra.Command {
 				err := cli.SetVar(key+keyType, code)
 
 				if err != nil {
-					cli.error(logrus.Fields{"cmd": "account", "subcmd": "set"}, "could not save account: %s", name)
+					cli.errorWithCode(ExitStoreError, logFields, "could not save account: %s", name)
 					return
 				}
 			}
+
+			if cmd.Flag("note").Changed {
+				note, _ := cmd.Flags().GetString("note")
+				key := fmt.Sprintf("account:%s:note", name)
+
+				var err error
+				if note == "" {
+					err = cli.DelVar(key)
+				} else {
+					err = cli.SetVar(key, note)
+				}
+
+				if err != nil {
+					cli.errorWithCode(ExitStoreError, logFields, "could not save note for account: %s", name)
+					return
+				}
+			}
+		},
+	}
+
+	cmd.Flags().String("note", "", "local note for this account, never sent to the network (empty to remove)")
+	cmd.Flags().Bool("encrypt", false, "encrypt the seed with a passphrase, asked for (or read from $"+seedPassphraseEnv+") whenever it's used")
+	return cmd
+}
+
+func (cli *CLI) buildAccountSetSignersCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "set-signers [name] [signer1,signer2...]",
+		Short: "sign transactions from [name] with these accounts (or seeds) by default, like --signers (empty to remove)",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			name := args[0]
+			logFields := logrus.Fields{"cmd": "account", "subcmd": "set-signers"}
+			key := fmt.Sprintf("account:%s:signers", name)
+
+			if args[1] == "" {
+				if err := cli.DelVar(key); err != nil {
+					cli.errorWithCode(ExitStoreError, logFields, "could not remove signers of account: %s", name)
+				}
+				return
+			}
+
+			// Store the names, so the signers' seeds are resolved when they sign
+			signers := strings.Split(args[1], ",")
+			for _, signer := range signers {
+				seed, err := cli.ResolveAccount(logFields, signer, "seed")
+				if err != nil || microstellar.ValidSeed(seed) != nil {
+					cli.error(logFields, "no seed found in signer: %s", signer)
+					return
+				}
+			}
+
+			if err := cli.SetVar(key, strings.Join(signers, ",")); err != nil {
+				cli.errorWithCode(ExitStoreError, logFields, "could not save signers of account: %s", name)
+				return
+			}
 		},
 	}
 }
 
+func (cli *CLI) buildAccountRequireMemoCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "require-memo [account] [--clear]",
+		Short: "make [account] require a memo on incoming payments (SEP-29), or stop requiring one with --clear",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			name := args[0]
+			logFields := logrus.Fields{"cmd": "account", "subcmd": "require-memo"}
+
+			seed, err := cli.ResolveAccount(logFields, name, "seed")
+			if err != nil || microstellar.ValidSeed(seed) != nil {
+				cli.error(logFields, "no seed found in %s", name)
+				return
+			}
+
+			opts, err := cli.genTxOptions(cmd, logFields, seed)
+			if err != nil {
+				cli.error(logFields, "can't generate transaction: %v", err)
+				return
+			}
+
+			clear, _ := cmd.Flags().GetBool("clear")
+			if clear {
+				debugf(logFields, "clearing %s on %s", memoRequiredKey, name)
+				err = cli.ms.ClearData(seed, memoRequiredKey, opts)
+			} else {
+				debugf(logFields, "setting %s on %s", memoRequiredKey, name)
+				err = cli.ms.SetData(seed, memoRequiredKey, []byte("1"), opts)
+			}
+
+			if err != nil {
+				cli.errorWithCode(txExitCode(err), logFields, "failed to update %s on %s: %v", memoRequiredKey, name, cli.errorString(err))
+				return
+			}
+		},
+	}
+
+	cmd.Flags().Bool("clear", false, "stop requiring a memo")
+	buildFlagsForTxOptions(cmd)
+	return cmd
+}
+
 func (cli *CLI) buildAccountAddressCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:   "address [name]",
@@ -116,7 +540,8 @@ func (cli *CLI) buildAccountAddressCmd() *cobra.Command {
 		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			name := args[0]
-			code, err := cli.ResolveAccount(logrus.Fields{"cmd": "account", "subcmd": "address"}, name, "address")
+			// Show muxed addresses as stored
+			code, err := cli.resolveAccount(logrus.Fields{"cmd": "account", "subcmd": "address"}, name, "address")
 
 			if err != nil || microstellar.ValidSeed(code) == nil {
 				cli.error(logrus.Fields{"cmd": "account", "subcmd": "address"}, "could not get address for account: %s", name)
@@ -128,6 +553,54 @@ func (cli *CLI) buildAccountAddressCmd() *cobra.Command {
 	}
 }
 
+func (cli *CLI) buildAccountSequenceCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sequence [account] [--next]",
+		Short: "get the current sequence number of [account], and nothing else",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			name := args[0]
+			logFields := logrus.Fields{"cmd": "account", "subcmd": "sequence"}
+
+			account := cli.LoadAccount(logFields, name)
+			if account == nil {
+				return
+			}
+
+			next, _ := cmd.Flags().GetBool("next")
+			seq, err := sequenceNumber(account.Sequence, next)
+			if err != nil {
+				cli.errorWithCode(ExitNetworkError, logFields, "bad sequence number for %s: %v", name, err)
+				return
+			}
+
+			showSuccess(seq)
+		},
+	}
+
+	cmd.Flags().Bool("next", false, "print the sequence number that the next transaction will use (i.e., the current one plus 1)")
+	return cmd
+}
+
+// sequenceNumber returns the sequence number seq (as returned by horizon), or the one
+// after it if next is set.
+func sequenceNumber(seq string, next bool) (string, error) {
+	current, err := strconv.ParseInt(seq, 10, 64)
+	if err != nil || current < 0 {
+		return "", errors.Errorf("expecting a non-negative number, got: %q", seq)
+	}
+
+	if !next {
+		return strconv.FormatInt(current, 10), nil
+	}
+
+	if current == math.MaxInt64 {
+		return "", errors.Errorf("%d is the last sequence number", current)
+	}
+
+	return strconv.FormatInt(current+1, 10), nil
+}
+
 func (cli *CLI) buildAccountSeedCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:   "seed [name]",
@@ -135,9 +608,13 @@ func (cli *CLI) buildAccountSeedCmd() *cobra.Command {
 		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			name := args[0]
-			key := fmt.Sprintf("account:%s:seed", name)
 
-			code, err := cli.GetVar(key)
+			code, err := cli.GetAccount(name, "seed")
+
+			if _, ok := err.(*seedDecryptError); ok {
+				cli.error(logrus.Fields{"cmd": "account", "subcmd": "seed"}, "%v", err)
+				return
+			}
 
 			if err != nil {
 				cli.error(logrus.Fields{"cmd": "account", "subcmd": "seed"}, "could not get seed for account: %s", name)
@@ -156,14 +633,533 @@ func (cli *CLI) buildAccountDelCmd() *cobra.Command {
 		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			name := args[0]
+			logFields := logrus.Fields{"cmd": "account", "subcmd": "del"}
 
-			err := cli.DelVar(fmt.Sprintf("account:%s:seed", name))
-			err = cli.DelVar(fmt.Sprintf("account:%s:address", name))
+			// Encrypted seeds don't need to be decrypted to be deleted
+			seed, err := cli.GetVar(fmt.Sprintf("account:%s:seed", name))
+			address := ""
+			if err == nil && isEncryptedSeed(seed) {
+				address, _ = cli.GetAccount(name, "address")
+			} else if err == nil {
+				address = addressFromSeed(seed)
+			}
+
+			if err == nil && !cli.confirm("delete account %s (%s) and its seed from the local store", name, address) {
+				cli.error(logFields, "not deleting account %s, use --yes to confirm", name)
+				return
+			}
+
+			if err := cli.deleteAccount(name); err != nil {
+				cli.errorWithCode(ExitStoreError, logFields, "could not delete account: %s", name)
+				return
+			}
+		},
+	}
+}
+
+// deleteAccount deletes name's address, seed, note, and signers from the local store.
+func (cli *CLI) deleteAccount(name string) error {
+	cli.DelVar(fmt.Sprintf("account:%s:note", name))
+	cli.DelVar(fmt.Sprintf("account:%s:signers", name))
+	cli.DelVar(fmt.Sprintf("account:%s:seed", name))
+	return cli.DelVar(fmt.Sprintf("account:%s:address", name))
+}
+
+func (cli *CLI) buildAccountListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list [--with-balances [--concurrency 8]]",
+		Short: "list all accounts in the current namespace",
+		Args:  cobra.MinimumNArgs(0),
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "account", "subcmd": "list"}
+
+			withBalances, _ := cmd.Flags().GetBool("with-balances")
+			concurrency, _ := cmd.Flags().GetInt("concurrency")
+			if !withBalances && cmd.Flags().Changed("concurrency") {
+				cli.error(logFields, "--concurrency is only for --with-balances")
+				return
+			}
+
+			if concurrency < 1 {
+				cli.error(logFields, "bad --concurrency: %d, expecting at least 1", concurrency)
+				return
+			}
+
+			names, err := cli.AccountNames()
 
 			if err != nil {
-				cli.error(logrus.Fields{"cmd": "account", "subcmd": "del"}, "could not delete account: %s", name)
+				logrus.WithFields(logFields).Debugf("%v", err)
+				cli.errorWithCode(ExitStoreError, logFields, "could not list accounts")
+				return
+			}
+
+			var listed, addresses []string
+			for _, name := range names {
+				address, err := cli.ResolveAccount(logFields, name, "address")
+				if err != nil {
+					logrus.WithFields(logFields).Debugf("skipping %s: %v", name, err)
+					continue
+				}
+
+				if microstellar.ValidSeed(address) == nil {
+					address = addressFromSeed(address)
+				}
+
+				listed = append(listed, name)
+				addresses = append(addresses, address)
+			}
+
+			var balances []accountBalance
+			if withBalances {
+				balances = fetchBalances(addresses, concurrency, cli.balanceLoader(microstellar.NativeAsset))
+			}
+
+			failed := 0
+			for i, name := range listed {
+				note := ""
+				if val, err := cli.GetVar(fmt.Sprintf("account:%s:note", name)); err == nil {
+					note = fmt.Sprintf(" (note: %s)", val)
+				}
+
+				if !withBalances {
+					showSuccess("%s %s%s", name, addresses[i], note)
+					continue
+				}
+
+				switch balance := balances[i]; {
+				case balance.err != nil:
+					failed++
+					showError(logFields, "can't load balance of %s: %v", name, cli.errorString(balance.err))
+					showSuccess("%s %s unknown%s", name, addresses[i], note)
+				case !balance.found:
+					showSuccess("%s %s not found%s", name, addresses[i], note)
+				default:
+					showSuccess("%s %s %s XLM%s", name, addresses[i], balance.balance, note)
+				}
+			}
+
+			if failed > 0 {
+				cli.errorWithCode(ExitNetworkError, logFields, "can't load %d of %d balances", failed, len(listed))
+			}
+		},
+	}
+
+	cmd.Flags().Bool("with-balances", false, "also show the XLM balance of each account, or \"not found\" if it's not funded")
+	cmd.Flags().Int("concurrency", 8, "with --with-balances, load at most this many balances at once")
+	return cmd
+}
+
+// accountBalance is the balance of an asset on an account, as loaded by fetchBalances.
+type accountBalance struct {
+	balance string
+	found   bool
+	err     error
+}
+
+// balanceLoader returns a function that loads the balance of asset on the account at
+// an address, for fetchBalances. Accounts that don't exist aren't errors, they're just
+// not found. Accounts without a trustline for asset have a balance of 0.
+func (cli *CLI) balanceLoader(asset *microstellar.Asset) func(address string) accountBalance {
+	return func(address string) accountBalance {
+		account, err := cli.ms.LoadAccount(address)
+		if err != nil {
+			if herr, ok := errors.Cause(err).(*horizon.Error); ok && herr.Problem.Status == http.StatusNotFound {
+				return accountBalance{}
+			}
+
+			return accountBalance{err: err}
+		}
+
+		balance := account.GetBalance(asset)
+		if balance == "" {
+			balance = "0"
+		}
+
+		return accountBalance{balance: balance, found: true}
+	}
+}
+
+// fetchBalances calls load for each address, with at most concurrency calls in flight,
+// and returns the results in the same order as addresses.
+func fetchBalances(addresses []string, concurrency int, load func(address string) accountBalance) []accountBalance {
+	balances := make([]accountBalance, len(addresses))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < concurrency && worker < len(addresses); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				balances[i] = load(addresses[i])
+			}
+		}()
+	}
+
+	for i := range addresses {
+		jobs <- i
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	return balances
+}
+
+func (cli *CLI) buildAccountInfoCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "info [name] [--effects N] [--pretty]",
+		Short: "get account info for [name], including its local note",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			effects, _ := cmd.Flags().GetInt("effects")
+			pretty, _ := cmd.Flags().GetBool("pretty")
+			cli.showAccountInfo(logrus.Fields{"cmd": "account", "subcmd": "info"}, args[0], effects, pretty)
+		},
+	}
+
+	buildEffectsFlag(cmd)
+	buildPrettyFlag(cmd)
+	return cmd
+}
+
+// thresholdOps lists the operations that need each threshold, with the lumen commands
+// that submit them.
+var thresholdOps = []struct {
+	level string
+	ops   []string
+}{
+	{"low", []string{
+		"allow_trust (trust authorize)",
+		"set_trust_line_flags",
+		"bump_sequence (tx bump-seq)",
+		"claim_claimable_balance (claimable sweep)",
+	}},
+	{"medium", []string{
+		"create_account (pay --fund, account new --fund-from)",
+		"payment and path payments (pay)",
+		"manage_sell_offer, manage_buy_offer, create_passive_sell_offer (dex trade)",
+		"change_trust (trust create, trust remove)",
+		"manage_data (data)",
+		"set_options for flags and home domain (flags)",
+		"create_claimable_balance",
+		"liquidity_pool_deposit, liquidity_pool_withdraw (pool deposit, pool withdraw)",
+		"sponsorship and clawback operations",
+	}},
+	{"high", []string{
+		"account_merge",
+		"set_options for signers, thresholds, and master weight (signer add|remove|thresholds|masterweight)",
+	}},
+}
+
+func (cli *CLI) buildAccountThresholdsExplainCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "thresholds-explain [account]",
+		Short: "show the thresholds of [account], and which operations need which threshold",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "account", "subcmd": "thresholds-explain"}
+			account := cli.LoadAccount(logFields, args[0])
+			if account == nil {
 				return
 			}
+
+			weights := map[string]byte{
+				"low":    account.Thresholds.Low,
+				"medium": account.Thresholds.Medium,
+				"high":   account.Thresholds.High,
+			}
+
+			for _, threshold := range thresholdOps {
+				showSuccess("%s: %d", threshold.level, weights[threshold.level])
+				for _, op := range threshold.ops {
+					showSuccess("  %s", op)
+				}
+			}
+
+			showSuccess("A transaction's signers need a total weight of at least the highest threshold of its operations. The master key has weight %d.",
+				account.GetMasterWeight())
 		},
 	}
 }
+
+// accountFlags are the flags of an account (see: lumen flags.) They're loaded from
+// horizon, because microstellar doesn't know about auth_clawback_enabled.
+type accountFlags struct {
+	AuthRequired        bool `json:"auth_required"`
+	AuthRevocable       bool `json:"auth_revocable"`
+	AuthImmutable       bool `json:"auth_immutable"`
+	AuthClawbackEnabled bool `json:"auth_clawback_enabled"`
+}
+
+// flagExplanation is whether a flag is set, and what that means.
+type flagExplanation struct {
+	name    string
+	set     bool
+	meaning string
+}
+
+// explain returns whether each of flags is set, and what that means.
+func (flags accountFlags) explain() []flagExplanation {
+	explain := func(name string, set bool, ifSet, ifUnset string) flagExplanation {
+		if set {
+			return flagExplanation{name, true, ifSet}
+		}
+
+		return flagExplanation{name, false, ifUnset}
+	}
+
+	return []flagExplanation{
+		explain("auth_required", flags.AuthRequired,
+			"accounts need the issuer to authorize their trustlines before they can hold its assets",
+			"accounts can hold the issuer's assets without authorization"),
+		explain("auth_revocable", flags.AuthRevocable,
+			"the issuer can revoke authorization, freezing its assets in holders' accounts",
+			"authorization can't be revoked once granted"),
+		explain("auth_immutable", flags.AuthImmutable,
+			"none of these flags can be changed, and the account can't be merged",
+			"these flags can be changed"),
+		explain("auth_clawback_enabled", flags.AuthClawbackEnabled,
+			"the issuer can claw back its assets from new trustlines",
+			"the issuer can't claw back its assets"),
+	}
+}
+
+// loadAccountFlags returns the flags of address. On the fake network, none are set.
+func (cli *CLI) loadAccountFlags(logFields logrus.Fields, address string) (*accountFlags, error) {
+	var account struct {
+		Flags accountFlags `json:"flags"`
+	}
+
+	if err := cli.getHorizonJSON(logFields, "/accounts/"+address, &account); err != nil {
+		return nil, err
+	}
+
+	return &account.Flags, nil
+}
+
+func (cli *CLI) buildAccountFlagsExplainCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "flags-explain [account] [--format json]",
+		Short: "show which flags are set on [account] (see: lumen flags), and what they mean",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "account", "subcmd": "flags-explain"}
+			name := args[0]
+
+			format, _ := cmd.Flags().GetString("format")
+			if format != "line" && format != "json" {
+				cli.error(logFields, "bad --format: %s, expecting: line|json", format)
+				return
+			}
+
+			address, err := cli.ResolveAccount(logFields, name, "address")
+			if err != nil {
+				cli.error(logFields, "invalid account: %s", name)
+				return
+			}
+
+			flags, err := cli.loadAccountFlags(logFields, address)
+			if err != nil {
+				cli.errorWithCode(ExitNetworkError, logFields, "can't load account: %v", cli.errorString(err))
+				return
+			}
+
+			if format == "json" {
+				pretty, _ := cmd.Flags().GetBool("pretty")
+				data, err := marshalJSON(flags, pretty)
+				if err != nil {
+					cli.error(logFields, "can't encode flags: %v", err)
+					return
+				}
+
+				showSuccess("%s", string(data))
+				return
+			}
+
+			for _, flag := range flags.explain() {
+				state := "not set"
+				if flag.set {
+					state = "set"
+				}
+
+				showSuccess("%s: %s (%s)", flag.name, state, flag.meaning)
+			}
+		},
+	}
+
+	cmd.Flags().String("format", "line", "output format (json, line)")
+	buildPrettyFlag(cmd)
+	return cmd
+}
+
+func (cli *CLI) buildAccountWatchBalanceCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "watch-balance [account] [asset] --below X [--exec cmd] [--interval 1m]",
+		Short: "poll the balance of [asset] on [account], and alert when it falls below X",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "account", "subcmd": "watch-balance"}
+			name := args[0]
+			asset := microstellar.NativeAsset
+
+			if len(args) > 1 {
+				var err error
+				if asset, err = cli.ResolveAsset(args[1]); err != nil {
+					cli.error(logFields, "bad asset: %s", args[1])
+					return
+				}
+			}
+
+			address, err := cli.ResolveAccount(logFields, name, "address")
+			if err != nil {
+				cli.error(logFields, "invalid account: %s", name)
+				return
+			}
+
+			below, _ := cmd.Flags().GetString("below")
+			threshold, err := amount.ParseInt64(below)
+			if err != nil {
+				cli.error(logFields, "bad --below amount: %s", below)
+				return
+			}
+
+			interval, _ := cmd.Flags().GetDuration("interval")
+			if interval <= 0 {
+				cli.error(logFields, "bad --interval: %v", interval)
+				return
+			}
+
+			execCmd, _ := cmd.Flags().GetString("exec")
+			count, _ := cmd.Flags().GetUint("count")
+
+			limiter, err := getRateLimiter(cmd)
+			if err != nil {
+				cli.error(logFields, "%v", err)
+				return
+			}
+
+			alerted := false
+
+			for poll := uint(1); ; poll++ {
+				balance, err := cli.pollBalance(address, asset)
+
+				if err != nil {
+					// Keep polling through transient failures
+					showError(logFields, "can't load balance: %v", cli.errorString(err))
+				} else {
+					debugf(logFields, "poll %d: %s has %s %s", poll, name, amount.StringFromInt64(balance), assetCode(asset))
+
+					if balance >= threshold {
+						alerted = false
+					} else if !alerted {
+						// Only alert when crossing below the threshold, not on every poll
+						alerted = true
+						if delay := limiter.wait(1); delay > 0 {
+							debugf(logFields, "rate limited: waited %v", delay)
+						}
+
+						cli.alertBalance(logFields, name, asset, amount.StringFromInt64(balance), below, execCmd)
+					}
+				}
+
+				if count > 0 && poll >= count {
+					return
+				}
+
+				time.Sleep(interval)
+			}
+		},
+	}
+
+	cmd.Flags().String("below", "", "alert when the balance falls below this amount")
+	cmd.Flags().String("exec", "", "run this shell command (with sh, or cmd on Windows) on alerts, with LUMEN_ACCOUNT, LUMEN_ASSET, and LUMEN_BALANCE set")
+	cmd.Flags().Duration("interval", time.Minute, "time between polls")
+	cmd.Flags().Uint("count", 0, "stop after this many polls, 0 to poll forever")
+	buildRateLimitFlag(cmd, "run --exec at most this many times per second, on average")
+	cmd.MarkFlagRequired("below")
+
+	return cmd
+}
+
+// pollBalance returns the balance of asset on address in stroops.
+func (cli *CLI) pollBalance(address string, asset *microstellar.Asset) (int64, error) {
+	account, err := cli.ms.LoadAccount(address)
+	if err != nil {
+		return 0, err
+	}
+
+	balance := account.GetBalance(asset)
+	if balance == "" {
+		return 0, nil
+	}
+
+	return amount.ParseInt64(balance)
+}
+
+// alertBalance reports that name's balance fell below threshold, either by running
+// execCmd, or by printing a message if execCmd is empty.
+func (cli *CLI) alertBalance(logFields logrus.Fields, name string, asset *microstellar.Asset, balance, threshold, execCmd string) {
+	if execCmd == "" {
+		showSuccess("balance of %s fell below %s %s: %s", name, threshold, assetCode(asset), balance)
+		return
+	}
+
+	debugf(logFields, "running: %s", execCmd)
+	alert := shellCommand(execCmd)
+	alert.Env = append(os.Environ(),
+		"LUMEN_ACCOUNT="+name,
+		"LUMEN_ASSET="+assetCode(asset),
+		"LUMEN_BALANCE="+balance)
+	alert.Stdout = os.Stdout
+	alert.Stderr = os.Stderr
+
+	if err := alert.Run(); err != nil {
+		showError(logFields, "--exec failed: %v", err)
+	}
+}
+
+// shellCommand returns a command that runs command line in the system's shell: sh, or
+// cmd on Windows.
+func shellCommand(line string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", line)
+	}
+
+	return exec.Command("sh", "-c", line)
+}
+
+// AccountNames returns the sorted names of all the accounts stored in
+// the current namespace.
+func (cli *CLI) AccountNames() ([]string, error) {
+	return cli.aliasNames("account")
+}
+
+// aliasNames returns the sorted names of all the aliases of kind (account or
+// asset) stored in the current namespace.
+func (cli *CLI) aliasNames(kind string) ([]string, error) {
+	keys, err := cli.ListVars(kind + ":")
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	seen := map[string]bool{}
+
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, kind+":")
+		i := strings.LastIndex(name, ":")
+		if i < 0 {
+			continue
+		}
+
+		name = name[:i]
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
@@ -1,6 +1,20 @@
 package cli
 
-import "testing"
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xfe/microstellar"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
 
 // Note: add -v to any of these commands to enable verbose logging
 
@@ -26,6 +40,486 @@ func TestAccounts(t *testing.T) {
 	expectOutput(t, cli, "error", "account address master")
 
 	cli.TestCommand("ns test")
-	cli.TestCommand("account del master")
+	expectOutput(t, cli, "error", "account del master")
+	expectOutput(t, cli, "", "account del master --yes")
 	expectOutput(t, cli, "error", "account address master")
 }
+
+func TestAccountNotes(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("ns test")
+	cli.TestCommand("set config:network fake")
+
+	address := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+
+	cli.Embeddable().Run("account", "set", "hot", address, "--note", "hot wallet")
+	cli.TestCommand("account set cold " + address)
+
+	expectOutput(t, cli, "cold "+address+"\nhot "+address+" (note: hot wallet)", "account list")
+	expectOutput(t, cli, "error", "account set nothing")
+
+	if info := cli.TestCommand("account info hot"); !strings.Contains(info, `"note":"hot wallet"`) {
+		t.Errorf("note not in account info: %v", info)
+	}
+
+	cli.Embeddable().Run("account", "set", "hot", "--note", "")
+	expectOutput(t, cli, "cold "+address+"\nhot "+address, "account list")
+
+	cli.TestCommand("account del hot")
+	cli.TestCommand("account del cold")
+	expectOutput(t, cli, "", "account list")
+}
+
+func TestAccountWatchBalance(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new mo")
+
+	expectOutput(t, cli, "error", "account watch-balance nobody --below 10 --count 1")
+	expectOutput(t, cli, "error", "account watch-balance mo --below lots --count 1")
+	expectOutput(t, cli, "error", "account watch-balance mo --below 10 --interval 0s --count 1")
+
+	// Accounts on the fake network are empty, so this alerts once, on the first poll
+	expectOutput(t, cli, "balance of mo fell below 10 XLM: 0.0000000", "account watch-balance mo --below 10 --interval 1ms --count 3")
+	expectOutput(t, cli, "", "account watch-balance mo --below 0 --interval 1ms --count 2")
+	expectOutput(t, cli, "error", "account watch-balance mo --below 10 --count 1 --rate-limit -0.5")
+
+	// --exec runs in sh, or cmd on Windows
+	echo := "echo low: $LUMEN_ACCOUNT $LUMEN_BALANCE"
+	if runtime.GOOS == "windows" {
+		echo = "echo low: %LUMEN_ACCOUNT% %LUMEN_BALANCE%"
+	}
+
+	got := cli.Embeddable().Run("account", "watch-balance", "mo", "--below", "10", "--count", "1", "--exec", echo)
+	if want := "low: mo 0.0000000"; strings.TrimSpace(got) != want {
+		t.Errorf("wrong --exec output: want %v, got %v", want, got)
+	}
+}
+
+func TestAccountNewFundFrom(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account set viewer GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+
+	expectOutput(t, cli, "error", "account new kelly --fund-from mo")
+	expectOutput(t, cli, "error", "account new kelly --start-balance 5")
+	expectOutput(t, cli, "error", "account new kelly --fund-from mo --start-balance -5")
+	expectOutput(t, cli, "error", "account new kelly --fund-from viewer --start-balance 5")
+	expectOutput(t, cli, "error", "account address kelly")
+
+	cli.TestCommand("account new kelly --fund-from mo --start-balance 5")
+	if result := cli.TestCommand("account address kelly"); result[0] != 'G' {
+		t.Error("not an address: ", result)
+	}
+
+	// Check against the base reserve of the latest ledger
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"_embedded": {"records": [{"base_fee_in_stroops": 100, "base_reserve_in_stroops": 5000000}]}}`)
+	})
+	defer server.Close()
+
+	expectOutput(t, cli, "error", "account new sam --fund-from mo --start-balance 0.9999999")
+	expectOutput(t, cli, "error", "account address sam")
+}
+
+func TestAccountNewWithData(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new mo")
+
+	expectOutput(t, cli, "error", "account new kelly --data role=escrow")
+	expectOutput(t, cli, "error", "account new kelly --fund-from mo --start-balance 5 --data role")
+	expectOutput(t, cli, "error", "account new kelly --fund-from mo --start-balance 5 --data role=")
+	expectOutput(t, cli, "error", "account new kelly --fund-from mo --start-balance 5 --data role=a --data role=b")
+	expectOutput(t, cli, "error", "account new kelly --fund-from mo --start-balance 5 --data role="+strings.Repeat("x", 65))
+	expectOutput(t, cli, "error", "account address kelly")
+
+	entries := ""
+	for i := 0; i < maxOpsPerTx; i++ {
+		entries += fmt.Sprintf(" --data key%d=value", i)
+	}
+	expectOutput(t, cli, "error", "account new kelly --fund-from mo --start-balance 500"+entries)
+
+	got := cli.TestCommand("account new kelly --fund-from mo --start-balance 5 --data role=escrow --data owner=mo")
+	if strings.Contains(got, "error") {
+		t.Errorf("unexpected error creating account with data: %s", got)
+	}
+
+	// Each data entry needs another base reserve
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"_embedded": {"records": [{"base_fee_in_stroops": 100, "base_reserve_in_stroops": 5000000}]}}`)
+	})
+	defer server.Close()
+
+	expectOutput(t, cli, "error", "account new sam --fund-from mo --start-balance 1.4999999 --data role=escrow")
+	expectOutput(t, cli, "error", "account address sam")
+}
+
+func TestAccountNewBatch(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account new worker2")
+
+	expectOutput(t, cli, "error", "account new --batch 3")
+	expectOutput(t, cli, "error", "account new --batch 0 --prefix worker")
+	expectOutput(t, cli, "error", "account new --prefix worker")
+	expectOutput(t, cli, "error", "account new kelly --batch 3 --prefix worker")
+	expectOutput(t, cli, "error", "account new --batch 3 --prefix node --fund-from mo --start-balance 5 --data role=node")
+	expectOutput(t, cli, "error", "account new --batch 3 --prefix node --fund-from mo")
+
+	// Existing accounts aren't overwritten
+	expectOutput(t, cli, "error", "account new --batch 3 --prefix worker")
+	expectOutput(t, cli, "error", "account address worker0")
+
+	got := cli.TestCommand("account new --batch 3 --prefix node")
+	lines := strings.Split(strings.TrimSpace(got), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("want 3 accounts, got: %q", got)
+	}
+
+	for i, line := range lines {
+		name := fmt.Sprintf("node%d", i)
+		if address := strings.TrimSpace(cli.TestCommand("account address " + name)); line != name+" "+address {
+			t.Errorf("want %q, got %q", name+" "+address, line)
+		}
+	}
+
+	// Funded in transactions of up to maxOpsPerTx operations
+	got = cli.TestCommand(fmt.Sprintf("account new --batch %d --prefix funded --fund-from mo --start-balance 5", maxOpsPerTx+1))
+	if lines := strings.Split(strings.TrimSpace(got), "\n"); len(lines) != maxOpsPerTx+1 || strings.Contains(got, "error") {
+		t.Errorf("want %d funded accounts, got: %q", maxOpsPerTx+1, got)
+	}
+
+	expectOutput(t, cli, "error", "account new --batch 3 --prefix node")
+}
+
+func TestRequireMemo(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account set viewer GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+
+	expectOutput(t, cli, "error", "account new deposits --require-memo")
+	expectOutput(t, cli, "error", "account new deposits --fund-from mo --start-balance 5 --require-memo --data config.memo_required=0")
+
+	got := cli.TestCommand("account new deposits --fund-from mo --start-balance 5 --require-memo")
+	if strings.Contains(got, "error") {
+		t.Errorf("unexpected error creating account that requires memos: %s", got)
+	}
+
+	expectOutput(t, cli, "", "account require-memo deposits")
+	expectOutput(t, cli, "", "account require-memo deposits --clear")
+	expectOutput(t, cli, "error", "account require-memo viewer")
+	expectOutput(t, cli, "error", "account require-memo nobody")
+
+	// The entry the flag sets is what the sending side checks (horizon returns data
+	// entries base64-encoded)
+	account := &microstellar.Account{Data: map[string]string{memoRequiredKey: base64.StdEncoding.EncodeToString([]byte("1"))}}
+	if !requiresMemo(account) {
+		t.Errorf("want account with %s=1 to require a memo", memoRequiredKey)
+	}
+
+	account.Data[memoRequiredKey] = base64.StdEncoding.EncodeToString([]byte("0"))
+	if requiresMemo(account) {
+		t.Errorf("want account with %s=0 to not require a memo", memoRequiredKey)
+	}
+
+	delete(account.Data, memoRequiredKey)
+	if requiresMemo(account) {
+		t.Errorf("want account without %s to not require a memo", memoRequiredKey)
+	}
+}
+
+func TestParseDataEntries(t *testing.T) {
+	entries, err := parseDataEntries([]string{"role=escrow", "url=https://example.com/?a=b"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(entries) != 2 || entries[0].key != "role" || string(entries[0].value) != "escrow" ||
+		entries[1].key != "url" || string(entries[1].value) != "https://example.com/?a=b" {
+		t.Errorf("wrong entries: %+v", entries)
+	}
+
+	for _, bad := range [][]string{{"role"}, {"=escrow"}, {"role="}, {strings.Repeat("k", 65) + "=v"}, {"a=1", "a=2"}} {
+		if _, err := parseDataEntries(bad); err == nil {
+			t.Errorf("want error for %v", bad)
+		}
+	}
+}
+
+func TestAccountInfoEffects(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account set mo GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+
+	expectOutput(t, cli, "error", "account info mo --effects -1")
+	expectOutput(t, cli, "error", "info mo --effects 201")
+	if info := cli.TestCommand("account info mo --effects 5"); strings.Contains(info, "error") {
+		t.Errorf("unexpected error: %v", info)
+	}
+
+	var query string
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Path + "?" + r.URL.RawQuery
+		fmt.Fprint(w, `{"_embedded": {"records": [
+			{"_links": {"operation": {"href": "/operations/2"}}, "type": "trustline_created", "asset_code": "USD"},
+			{"_links": {"operation": {"href": "/operations/1"}}, "type": "account_credited", "amount": "10.0000000"}]}}`)
+	})
+	defer server.Close()
+
+	effects, err := cli.loadRecentEffects(logrus.Fields{"test": "effects"}, "mo", 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := "/accounts/GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM/effects?order=desc&limit=2"; query != want {
+		t.Errorf("want query %s, got %s", want, query)
+	}
+
+	got, _ := json.Marshal(effects)
+	if want := `[{"asset_code":"USD","type":"trustline_created"},{"amount":"10.0000000","type":"account_credited"}]`; string(got) != want {
+		t.Errorf("want effects %s, got %s", want, got)
+	}
+}
+
+func TestCheckStartBalance(t *testing.T) {
+	tests := []struct {
+		balance    string
+		subentries int
+		ok         bool
+	}{
+		{"1", 0, true},
+		{"1.0000001", 0, true},
+		{"0.9999999", 0, false},
+		{"1000", 0, true},
+		{"bad", 0, false},
+		{"1", 1, false},
+		{"1.5", 1, true},
+		{"2", 2, true},
+	}
+
+	for _, test := range tests {
+		err := checkStartBalance(test.balance, 5000000, test.subentries)
+		if (err == nil) != test.ok {
+			t.Errorf("start balance %s (%d subentries): want ok=%v, got %v", test.balance, test.subentries, test.ok, err)
+		}
+	}
+}
+
+func TestAccountThresholdsExplain(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account set viewer GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+
+	got := cli.TestCommand("account thresholds-explain viewer")
+	for _, want := range []string{"low: 0\n  allow_trust", "medium: 0\n  create_account", "high: 0\n  account_merge", "  payment and path payments (pay)\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want %q in:\n%s", want, got)
+		}
+	}
+
+	expectOutput(t, cli, "error", "account thresholds-explain nobody")
+}
+
+func TestAccountFlagsExplain(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account set citibank GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+
+	// No flags on the fake network
+	got := cli.TestCommand("account flags-explain citibank")
+	for _, want := range []string{"auth_required: not set (", "auth_revocable: not set (", "auth_immutable: not set (", "auth_clawback_enabled: not set ("} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want %q in:\n%s", want, got)
+		}
+	}
+
+	expectOutput(t, cli, "error", "account flags-explain nobody")
+	expectOutput(t, cli, "error", "account flags-explain citibank --format struct")
+
+	var path string
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		fmt.Fprint(w, `{"flags": {"auth_required": false, "auth_revocable": true, "auth_immutable": false, "auth_clawback_enabled": true}}`)
+	})
+	defer server.Close()
+
+	got = cli.TestCommand("account flags-explain citibank")
+	if path != "/accounts/GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM" {
+		t.Errorf("want account loaded, got path %s", path)
+	}
+
+	for _, want := range []string{"auth_required: not set (", "auth_revocable: set (the issuer can revoke", "auth_immutable: not set (", "auth_clawback_enabled: set (the issuer can claw back"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want %q in:\n%s", want, got)
+		}
+	}
+
+	expectOutput(t, cli, `{"auth_required":false,"auth_revocable":true,"auth_immutable":false,"auth_clawback_enabled":true}`,
+		"account flags-explain citibank --format json")
+}
+
+func TestAccountSequence(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new mo")
+
+	// No sequence numbers on the fake network
+	expectOutput(t, cli, "error", "account sequence mo")
+	expectOutput(t, cli, "error", "account sequence nobody --next")
+
+	tests := []struct {
+		seq  string
+		next bool
+		want string
+	}{
+		{"123456789012", false, "123456789012"},
+		{"123456789012", true, "123456789013"},
+		{"0", true, "1"},
+		{"9223372036854775807", false, "9223372036854775807"},
+		{"9223372036854775807", true, ""},
+		{"", false, ""},
+		{"-1", false, ""},
+		{"12a", true, ""},
+	}
+
+	for _, test := range tests {
+		got, err := sequenceNumber(test.seq, test.next)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("sequenceNumber(%q, %v): want error, got %s", test.seq, test.next, got)
+			}
+		} else if got != test.want {
+			t.Errorf("sequenceNumber(%q, %v): want %s, got %s (%v)", test.seq, test.next, test.want, got, err)
+		}
+	}
+}
+
+func TestAccountListWithBalances(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	address := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+	cli.Embeddable().Run("account", "set", "hot", address, "--note", "hot wallet")
+	cli.TestCommand("account set cold " + address)
+
+	expectOutput(t, cli, "cold "+address+" 0 XLM\nhot "+address+" 0 XLM (note: hot wallet)", "account list --with-balances")
+	expectOutput(t, cli, "error", "account list --with-balances --concurrency 0")
+	expectOutput(t, cli, "error", "account list --concurrency 2")
+
+	// Results stay in order, unfunded accounts aren't errors, and there are never more
+	// than concurrency loads at once
+	addresses := []string{"GA", "GB", "GC", "GD", "GE", "GF", "GG"}
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+
+	load := func(address string) accountBalance {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		switch address {
+		case "GC":
+			return accountBalance{}
+		case "GD":
+			return accountBalance{err: fmt.Errorf("horizon is down")}
+		}
+
+		return accountBalance{balance: address, found: true}
+	}
+
+	balances := fetchBalances(addresses, 3, load)
+	if len(balances) != len(addresses) {
+		t.Fatalf("want %d balances, got %d", len(addresses), len(balances))
+	}
+
+	for i, address := range addresses {
+		balance := balances[i]
+		switch address {
+		case "GC":
+			if balance.found || balance.err != nil {
+				t.Errorf("want %s not found, got %+v", address, balance)
+			}
+		case "GD":
+			if balance.err == nil {
+				t.Errorf("want error for %s, got %+v", address, balance)
+			}
+		default:
+			if !balance.found || balance.balance != address {
+				t.Errorf("want balance %s, got %+v", address, balance)
+			}
+		}
+	}
+
+	if maxInFlight > 3 || maxInFlight < 2 {
+		t.Errorf("want at most 3 (and more than 1) loads at once, got %d", maxInFlight)
+	}
+}
+
+func TestAccountSetSigners(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account new kelly")
+	cli.TestCommand("account new sam")
+	cli.TestCommand("account set viewer GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+
+	expectOutput(t, cli, "error", "account set-signers mo kelly,viewer")
+	expectOutput(t, cli, "error", "account set-signers mo kelly,nobody")
+	expectOutput(t, cli, "", "account set-signers mo kelly,sam")
+
+	signers := func(account string, args ...string) []string {
+		cmd := &cobra.Command{}
+		buildFlagsForTxOptions(cmd)
+		if err := cmd.Flags().Parse(args); err != nil {
+			t.Fatalf("can't parse %v: %v", args, err)
+		}
+
+		return cli.txSigners(cmd, nil, account)
+	}
+
+	// The defaults apply to transactions from mo, unless --signers overrides them
+	if got := strings.Join(signers("mo"), ","); got != "kelly,sam" {
+		t.Errorf("want default signers kelly,sam, got %q", got)
+	}
+
+	if got := strings.Join(signers("mo", "--signers", "mo"), ","); got != "mo" {
+		t.Errorf("want --signers mo, got %q", got)
+	}
+
+	if got := signers("kelly"); len(got) != 0 {
+		t.Errorf("want no signers for kelly, got %v", got)
+	}
+
+	expectOutput(t, cli, "", "pay 1 --from mo --to kelly")
+	expectOutput(t, cli, "", "pay 1 --from mo --to kelly --signers mo")
+	expectOutput(t, cli, "", "trust create mo USD:GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM 100")
+
+	// Seeds are resolved at use time
+	cli.TestCommand("account del sam --yes")
+	expectOutput(t, cli, "error", "pay 1 --from mo --to kelly")
+	expectOutput(t, cli, "", "pay 1 --from mo --to kelly --signers mo")
+
+	if got := cli.Embeddable().Run("account", "set-signers", "mo", ""); got != "" {
+		t.Errorf("want no output removing signers, got %q", got)
+	}
+
+	if got := signers("mo"); len(got) != 0 {
+		t.Errorf("want no signers after removing them, got %v", got)
+	}
+
+	expectOutput(t, cli, "", "pay 1 --from mo --to kelly")
+}
@@ -0,0 +1,542 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/0xfe/microstellar"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/stellar/go/amount"
+)
+
+// assetActivity summarizes the payments and balance changes of one asset.
+type assetActivity struct {
+	Asset    string `json:"asset"`
+	Received int    `json:"payments_received"`
+	Sent     int    `json:"payments_sent"`
+	Net      string `json:"net_change"`
+}
+
+// failedTx is a failed transaction, see account activity --include-failed.
+type failedTx struct {
+	Hash        string         `json:"hash"`
+	CreatedAt   string         `json:"created_at"`
+	ResultCodes *txResultCodes `json:"result_codes"`
+}
+
+// accountActivity summarizes what happened on an account since a point in time.
+type accountActivity struct {
+	Since  string          `json:"since"`
+	Until  string          `json:"until,omitempty"`
+	Assets []assetActivity `json:"assets"`
+	Trades int             `json:"trades"`
+	Fees   string          `json:"fees_paid"`
+	Failed []failedTx      `json:"failed_transactions,omitempty"`
+}
+
+// summarizeActivity aggregates effects (oldest first) and fees (in stroops) into
+// payment counts and net changes per asset, in the order the assets were seen. Path
+// payments count as payments, not trades. Fees are included in the net change of XLM.
+func summarizeActivity(effects []balanceEffect, fees int64) (*accountActivity, error) {
+	history := newBalanceHistory()
+	if err := history.apply(effects); err != nil {
+		return nil, err
+	}
+
+	history.balances["native"] -= fees
+
+	var assets []string
+	activity := map[string]*assetActivity{}
+	see := func(asset string) *assetActivity {
+		if _, ok := activity[asset]; !ok {
+			assets = append(assets, asset)
+			activity[asset] = &assetActivity{Asset: asset}
+		}
+
+		return activity[asset]
+	}
+
+	summary := &accountActivity{Assets: []assetActivity{}, Fees: amount.StringFromInt64(fees)}
+	for _, effect := range effects {
+		switch effect.Type {
+		case "account_created":
+			see("native").Received++
+		case "account_credited":
+			see(effectAsset(effect.AssetType, effect.AssetCode, effect.AssetIssuer)).Received++
+		case "account_debited":
+			see(effectAsset(effect.AssetType, effect.AssetCode, effect.AssetIssuer)).Sent++
+		case "trade":
+			if !history.payments[effect.operationID()] {
+				summary.Trades++
+				see(effectAsset(effect.SoldAssetType, effect.SoldAssetCode, effect.SoldAssetIssuer))
+				see(effectAsset(effect.BoughtAssetType, effect.BoughtAssetCode, effect.BoughtAssetIssuer))
+			}
+		case "liquidity_pool_deposited":
+			for _, reserve := range effect.ReservesDeposited {
+				see(reserve.Asset)
+			}
+		case "liquidity_pool_withdrew":
+			for _, reserve := range effect.ReservesReceived {
+				see(reserve.Asset)
+			}
+		}
+	}
+
+	if fees > 0 {
+		see("native")
+	}
+
+	for _, asset := range assets {
+		activity[asset].Net = amount.StringFromInt64(history.balances[asset])
+		summary.Assets = append(summary.Assets, *activity[asset])
+	}
+
+	return summary, nil
+}
+
+// assetRollup is the total in, out, and traded of one asset, see account activity
+// --group-by-asset.
+type assetRollup struct {
+	Asset       string `json:"asset"`
+	In          string `json:"total_in"`
+	Out         string `json:"total_out"`
+	Net         string `json:"net"`
+	TradeVolume string `json:"trade_volume"`
+}
+
+// accountRollup is accountActivity, with totals instead of counts.
+type accountRollup struct {
+	Since  string        `json:"since"`
+	Until  string        `json:"until,omitempty"`
+	Assets []assetRollup `json:"assets"`
+	Trades int           `json:"trades"`
+	Fees   string        `json:"fees_paid"`
+	Failed []failedTx    `json:"failed_transactions,omitempty"`
+}
+
+// activityRollup adds up the amounts (in stroops) that go in and out of an account,
+// and that it trades, per asset (as a horizon asset string), one operation at a time.
+type activityRollup struct {
+	seen   map[string]bool
+	in     map[string]int64
+	out    map[string]int64
+	volume map[string]int64
+	trades int
+}
+
+func newActivityRollup() *activityRollup {
+	return &activityRollup{seen: map[string]bool{}, in: map[string]int64{}, out: map[string]int64{}, volume: map[string]int64{}}
+}
+
+// add adds val of asset to each of totals.
+func (rollup *activityRollup) add(asset, val string, totals ...map[string]int64) error {
+	amt, err := amount.ParseInt64(val)
+	if err != nil {
+		return errors.Errorf("bad amount for %s: %s", asset, val)
+	}
+
+	rollup.seen[asset] = true
+	for _, total := range totals {
+		total[asset] += amt
+	}
+
+	return nil
+}
+
+// addOperation adds the effects of one operation. Like summarizeActivity, the trades of
+// path payments aren't counted, since the payment's debit and credit already are.
+func (rollup *activityRollup) addOperation(effects []balanceEffect) error {
+	payment := false
+	for _, effect := range effects {
+		if effect.Type == "account_credited" || effect.Type == "account_debited" {
+			payment = true
+		}
+	}
+
+	for _, effect := range effects {
+		var err error
+
+		switch effect.Type {
+		case "account_created":
+			err = rollup.add("native", effect.StartingBalance, rollup.in)
+		case "account_credited":
+			err = rollup.add(effectAsset(effect.AssetType, effect.AssetCode, effect.AssetIssuer), effect.Amount, rollup.in)
+		case "account_debited":
+			err = rollup.add(effectAsset(effect.AssetType, effect.AssetCode, effect.AssetIssuer), effect.Amount, rollup.out)
+		case "trade":
+			if payment {
+				continue
+			}
+
+			rollup.trades++
+			sold := effectAsset(effect.SoldAssetType, effect.SoldAssetCode, effect.SoldAssetIssuer)
+			if err = rollup.add(sold, effect.SoldAmount, rollup.out, rollup.volume); err == nil {
+				bought := effectAsset(effect.BoughtAssetType, effect.BoughtAssetCode, effect.BoughtAssetIssuer)
+				err = rollup.add(bought, effect.BoughtAmount, rollup.in, rollup.volume)
+			}
+		case "liquidity_pool_deposited":
+			for _, reserve := range effect.ReservesDeposited {
+				if err = rollup.add(reserve.Asset, reserve.Amount, rollup.out); err != nil {
+					break
+				}
+			}
+		case "liquidity_pool_withdrew":
+			for _, reserve := range effect.ReservesReceived {
+				if err = rollup.add(reserve.Asset, reserve.Amount, rollup.in); err != nil {
+					break
+				}
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// assets returns the totals of each asset, XLM first, then sorted by asset. Fees (in
+// stroops) count as XLM out.
+func (rollup *activityRollup) assets(fees int64) []assetRollup {
+	if fees > 0 {
+		rollup.out["native"] += fees
+		rollup.seen["native"] = true
+	}
+
+	var assets []string
+	for asset := range rollup.seen {
+		if asset != "native" {
+			assets = append(assets, asset)
+		}
+	}
+
+	sort.Strings(assets)
+	if rollup.seen["native"] {
+		assets = append([]string{"native"}, assets...)
+	}
+
+	result := []assetRollup{}
+	for _, asset := range assets {
+		in, out := rollup.in[asset], rollup.out[asset]
+		result = append(result, assetRollup{
+			Asset:       asset,
+			In:          amount.StringFromInt64(in),
+			Out:         amount.StringFromInt64(out),
+			Net:         amount.StringFromInt64(in - out),
+			TradeVolume: amount.StringFromInt64(rollup.volume[asset]),
+		})
+	}
+
+	return result
+}
+
+// parseSince parses --since (or --until), which is a UTC time ('YYYY-MM-DD
+// HH:MM:SS'), or a duration before now (e.g., 24h.)
+func parseSince(since string, now time.Time) (time.Time, error) {
+	if ago, err := time.ParseDuration(since); err == nil && ago > 0 {
+		return now.Add(-ago), nil
+	}
+
+	t, err := time.Parse("2006-01-02 15:04:05", since)
+	if err != nil {
+		return time.Time{}, errors.Errorf("expecting YYYY-MM-DD HH:MM:SS or a duration (e.g., 24h), got: %s", since)
+	}
+
+	return t, nil
+}
+
+// closedBefore returns true if createdAt (RFC 3339) is before since.
+func closedBefore(createdAt string, since time.Time) bool {
+	closed, err := time.Parse(time.RFC3339, createdAt)
+	return err == nil && closed.Before(since)
+}
+
+// closedAfter returns true if createdAt (RFC 3339) is after until, which is never if
+// until is zero.
+func closedAfter(createdAt string, until time.Time) bool {
+	if until.IsZero() {
+		return false
+	}
+
+	closed, err := time.Parse(time.RFC3339, createdAt)
+	return err == nil && closed.After(until)
+}
+
+// forEachOperation pages backwards from now through the effects on address, and calls
+// fn with the effects of each operation closed between since and until (see
+// closedAfter), newest first. Only one operation's effects are kept at a time.
+func (cli *CLI) forEachOperation(logFields logrus.Fields, address string, since, until time.Time, fn func([]balanceEffect) error) error {
+	var op []balanceEffect
+	cursor := ""
+
+pages:
+	for {
+		records, err := cli.loadEffectsPage(logFields, address, "desc", cursor)
+		if err != nil {
+			return errors.Wrap(err, "can't load effects")
+		}
+
+		for _, effect := range records {
+			if closedBefore(effect.CreatedAt, since) {
+				break pages
+			}
+
+			if closedAfter(effect.CreatedAt, until) {
+				continue
+			}
+
+			// The effects of an operation can span pages
+			if len(op) > 0 && op[0].operationID() != effect.operationID() {
+				if err := fn(op); err != nil {
+					return err
+				}
+
+				op = nil
+			}
+
+			op = append(op, effect)
+		}
+
+		if len(records) < maxPageSize {
+			break
+		}
+
+		cursor = records[len(records)-1].PagingToken
+	}
+
+	if len(op) > 0 {
+		return fn(op)
+	}
+
+	return nil
+}
+
+// loadActivityFees returns the fees address paid between since and until (failed
+// transactions pay fees too), and its failed transactions, newest first.
+func (cli *CLI) loadActivityFees(logFields logrus.Fields, address string, since, until time.Time) (int64, []feeRecord, error) {
+	var fees int64
+	var failed []feeRecord
+	cursor := ""
+
+	for {
+		records, err := cli.loadTransactionsPage(logFields, address, "desc", cursor)
+		if err != nil {
+			return 0, nil, errors.Wrap(err, "can't load transactions")
+		}
+
+		for _, tx := range records {
+			if closedBefore(tx.CreatedAt, since) {
+				return fees, failed, nil
+			}
+
+			if closedAfter(tx.CreatedAt, until) {
+				continue
+			}
+
+			fee, err := tx.paidBy(address)
+			if err != nil {
+				return 0, nil, err
+			}
+
+			fees += fee
+			if tx.failed() {
+				failed = append(failed, tx)
+			}
+		}
+
+		if len(records) < maxPageSize {
+			return fees, failed, nil
+		}
+
+		cursor = records[len(records)-1].PagingToken
+	}
+}
+
+// loadActivityEffects returns the effects on address between since and until, oldest
+// first.
+func (cli *CLI) loadActivityEffects(logFields logrus.Fields, address string, since, until time.Time) ([]balanceEffect, error) {
+	var effects []balanceEffect
+	err := cli.forEachOperation(logFields, address, since, until, func(op []balanceEffect) error {
+		effects = append(effects, op...)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Newest first to oldest first
+	for i, j := 0, len(effects)-1; i < j; i, j = i+1, j-1 {
+		effects[i], effects[j] = effects[j], effects[i]
+	}
+
+	return effects, nil
+}
+
+// failedTxs returns the failed transactions with their result codes.
+func failedTxs(logFields logrus.Fields, txs []feeRecord) []failedTx {
+	var result []failedTx
+	for _, tx := range txs {
+		codes, err := decodeResultCodes(tx.ResultXDR)
+		if err != nil {
+			debugf(logFields, "can't decode result of %s: %v", tx.Hash, err)
+			codes = &txResultCodes{Transaction: "unknown"}
+		}
+
+		result = append(result, failedTx{Hash: tx.Hash, CreatedAt: tx.CreatedAt, ResultCodes: codes})
+	}
+
+	return result
+}
+
+func (cli *CLI) buildAccountActivityCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "activity [account] --since ['YYYY-MM-DD HH:MM:SS'|duration] [--until ...] [--group-by-asset] [--include-failed]",
+		Short: "summarize the payments, trades, and fees of [account] since a point in time",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			name := args[0]
+			logFields := logrus.Fields{"cmd": "account", "subcmd": "activity"}
+
+			format, _ := cmd.Flags().GetString("format")
+			if format != "line" && format != "json" {
+				cli.error(logFields, "bad --format: %s, expecting: line|json", format)
+				return
+			}
+
+			now := time.Now().UTC()
+			sinceFlag, _ := cmd.Flags().GetString("since")
+			since, err := parseSince(sinceFlag, now)
+			if err != nil {
+				cli.error(logFields, "bad --since: %v", err)
+				return
+			}
+
+			var until time.Time
+			if untilFlag, _ := cmd.Flags().GetString("until"); untilFlag != "" {
+				if until, err = parseSince(untilFlag, now); err != nil {
+					cli.error(logFields, "bad --until: %v", err)
+					return
+				}
+
+				if !until.After(since) {
+					cli.error(logFields, "--until must be after --since")
+					return
+				}
+			}
+
+			address, err := cli.ResolveAccount(logFields, name, "address")
+			if err != nil {
+				cli.error(logFields, "invalid account: %s", name)
+				return
+			}
+
+			if microstellar.ValidSeed(address) == nil {
+				address = addressFromSeed(address)
+			}
+
+			groupByAsset, _ := cmd.Flags().GetBool("group-by-asset")
+
+			// With --group-by-asset, each operation is added up as it's loaded, instead of
+			// loading them all first
+			var effects []balanceEffect
+			rollup := newActivityRollup()
+			if groupByAsset {
+				err = cli.forEachOperation(logFields, address, since, until, rollup.addOperation)
+			} else {
+				effects, err = cli.loadActivityEffects(logFields, address, since, until)
+			}
+
+			if err != nil {
+				cli.errorWithCode(ExitNetworkError, logFields, "can't load activity of %s: %v", name, cli.errorString(err))
+				return
+			}
+
+			fees, failedRecords, err := cli.loadActivityFees(logFields, address, since, until)
+			if err != nil {
+				cli.errorWithCode(ExitNetworkError, logFields, "can't load activity of %s: %v", name, cli.errorString(err))
+				return
+			}
+
+			// Failed transactions have no effects, just fees
+			var failed []failedTx
+			if includeFailed, _ := cmd.Flags().GetBool("include-failed"); includeFailed {
+				failed = failedTxs(logFields, failedRecords)
+			}
+
+			untilString := ""
+			if !until.IsZero() {
+				untilString = until.Format(time.RFC3339)
+			}
+
+			var result interface{}
+			var lines []string
+			var trades int
+
+			if groupByAsset {
+				summary := &accountRollup{
+					Since:  since.Format(time.RFC3339),
+					Until:  untilString,
+					Assets: rollup.assets(fees),
+					Trades: rollup.trades,
+					Fees:   amount.StringFromInt64(fees),
+					Failed: failed,
+				}
+
+				for _, asset := range summary.Assets {
+					lines = append(lines, fmt.Sprintf("%s: in %s, out %s, net %s, traded %s", asset.Asset, asset.In, asset.Out, asset.Net, asset.TradeVolume))
+				}
+
+				result, trades = summary, summary.Trades
+			} else {
+				summary, err := summarizeActivity(effects, fees)
+				if err != nil {
+					cli.errorWithCode(ExitNetworkError, logFields, "can't summarize activity of %s: %v", name, err)
+					return
+				}
+
+				summary.Since, summary.Until, summary.Failed = since.Format(time.RFC3339), untilString, failed
+				for _, asset := range summary.Assets {
+					lines = append(lines, fmt.Sprintf("%s: %d received, %d sent, net %s", asset.Asset, asset.Received, asset.Sent, asset.Net))
+				}
+
+				result, trades = summary, summary.Trades
+			}
+
+			if format == "json" {
+				pretty, _ := cmd.Flags().GetBool("pretty")
+				data, err := marshalJSON(result, pretty)
+				if err != nil {
+					cli.error(logFields, "can't encode activity: %v", err)
+					return
+				}
+
+				showSuccess(string(data))
+				return
+			}
+
+			for _, line := range lines {
+				showSuccess(line)
+			}
+
+			showSuccess("trades: %d", trades)
+			showSuccess("fees: %s XLM", amount.StringFromInt64(fees))
+			for _, tx := range failed {
+				showSuccess("failed: %s at %s: %s", tx.Hash, tx.CreatedAt, tx.ResultCodes)
+			}
+		},
+	}
+
+	cmd.Flags().String("since", "", "summarize activity since 'YYYY-MM-DD HH:MM:SS' in UTC, or this long ago (e.g., 24h)")
+	cmd.Flags().String("until", "", "summarize activity until 'YYYY-MM-DD HH:MM:SS' in UTC, or this long ago (default: now)")
+	cmd.Flags().Bool("group-by-asset", false, "show the total in, out, net, and trade volume of each asset, instead of payment counts")
+	cmd.Flags().String("format", "line", "output format (json, line)")
+	cmd.Flags().Bool("include-failed", false, "also list failed transactions, with their result codes")
+	buildPrettyFlag(cmd)
+	cmd.MarkFlagRequired("since")
+	return cmd
+}
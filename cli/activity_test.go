@@ -0,0 +1,205 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2020, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	if got, err := parseSince("24h", now); err != nil || !got.Equal(time.Date(2020, 1, 14, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("want a day ago, got %v (%v)", got, err)
+	}
+
+	if got, err := parseSince("2020-01-01 00:00:00", now); err != nil || !got.Equal(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("want 2020-01-01, got %v (%v)", got, err)
+	}
+
+	for _, bad := range []string{"", "yesterday", "-24h", "2020-01-01"} {
+		if _, err := parseSince(bad, now); err == nil {
+			t.Errorf("want error for --since %q", bad)
+		}
+	}
+}
+
+func TestAccountActivity(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	address := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+	cli.TestCommand("account set mo " + address)
+
+	expectOutput(t, cli, "trades: 0\nfees: 0.0000000 XLM", "account activity mo --since 24h")
+	expectOutput(t, cli, "error", "account activity mo --since yesterday")
+	expectOutput(t, cli, "error", "account activity mo --since 24h --format xml")
+	expectOutput(t, cli, "error", "account activity nobody --since 24h")
+
+	// One effect (and transaction) per day from 2020-01-10, newest first. The first
+	// one is before --since.
+	usd := `"asset_type": "credit_alphanum4", "asset_code": "USD", "asset_issuer": "GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U"`
+	effects := []string{
+		`"type": "account_created", "starting_balance": "100.0000000"`,
+		`"type": "account_debited", "asset_type": "native", "amount": "10.0000000"`,
+		`"type": "trade", "sold_asset_type": "native", "sold_amount": "5.0000000", "bought_amount": "10.0000000", ` +
+			strings.Replace(usd, `"asset_`, `"bought_asset_`, -1),
+		`"type": "account_debited", ` + usd + `, "amount": "2.0000000"`,
+		`"type": "account_credited", "asset_type": "native", "amount": "50.0000000"`,
+	}
+
+	var queries []string
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Path+"?"+r.URL.RawQuery)
+		records := []string{}
+
+		for i := len(effects) - 1; i >= 0; i-- {
+			ledger := int64(10 + i)
+			createdAt := fmt.Sprintf("2020-01-%dT00:00:00Z", ledger)
+			id := ledger << 32
+
+			switch r.URL.Path {
+			case "/accounts/" + address + "/effects":
+				records = append(records, fmt.Sprintf(`{"paging_token": "%d-1", "created_at": "%s", %s}`, id, createdAt, effects[i]))
+			case "/accounts/" + address + "/transactions":
+				// mo doesn't pay for the payment it gets
+				source := address
+				if ledger == 14 {
+					source = "GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U"
+				}
+
+				records = append(records, fmt.Sprintf(`{"paging_token": "%d", "ledger": %d, "created_at": "%s", "source_account": "%s", "fee_charged": "100"}`,
+					id, ledger, createdAt, source))
+			}
+		}
+
+		fmt.Fprintf(w, `{"_embedded": {"records": [%s]}}`, strings.Join(records, ","))
+	})
+	defer server.Close()
+
+	want := "native: 1 received, 1 sent, net 34.9999700\n" +
+		"USD:GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U: 0 received, 1 sent, net 8.0000000\n" +
+		"trades: 1\n" +
+		"fees: 0.0000300 XLM"
+	if got := cli.Embeddable().Run("account", "activity", "mo", "--since", "2020-01-11 00:00:00"); got != want+"\n" {
+		t.Errorf("want activity since 2020-01-11:\n%s\ngot:\n%s", want, got)
+	}
+
+	for _, query := range queries {
+		if !strings.Contains(query, "order=desc") {
+			t.Errorf("want newest first, got query %s", query)
+		}
+	}
+
+	want = `{
+  "since": "2020-01-14T00:00:00Z",
+  "assets": [
+    {
+      "asset": "native",
+      "payments_received": 1,
+      "payments_sent": 0,
+      "net_change": "50.0000000"
+    }
+  ],
+  "trades": 0,
+  "fees_paid": "0.0000000"
+}`
+	if got := cli.Embeddable().Run("account", "activity", "mo", "--since", "2020-01-14 00:00:00", "--format", "json", "--pretty"); got != want+"\n" {
+		t.Errorf("want activity since 2020-01-14:\n%s\ngot:\n%s", want, got)
+	}
+
+	// Totals per asset, with fees as XLM out, and trades as volume
+	want = "native: in 50.0000000, out 15.0000300, net 34.9999700, traded 5.0000000\n" +
+		"USD:GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U: in 10.0000000, out 2.0000000, net 8.0000000, traded 10.0000000\n" +
+		"trades: 1\n" +
+		"fees: 0.0000300 XLM"
+	if got := cli.Embeddable().Run("account", "activity", "mo", "--since", "2020-01-11 00:00:00", "--group-by-asset"); got != want+"\n" {
+		t.Errorf("want totals since 2020-01-11:\n%s\ngot:\n%s", want, got)
+	}
+
+	want = `{"since":"2020-01-11T00:00:00Z","until":"2020-01-12T12:00:00Z","assets":[` +
+		`{"asset":"native","total_in":"0.0000000","total_out":"15.0000200","net":"-15.0000200","trade_volume":"5.0000000"},` +
+		`{"asset":"USD:GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U","total_in":"10.0000000","total_out":"0.0000000","net":"10.0000000","trade_volume":"10.0000000"}],` +
+		`"trades":1,"fees_paid":"0.0000200"}`
+	if got := cli.Embeddable().Run("account", "activity", "mo", "--since", "2020-01-11 00:00:00", "--until", "2020-01-12 12:00:00", "--group-by-asset", "--format", "json"); got != want+"\n" {
+		t.Errorf("want totals from 2020-01-11 until 2020-01-12 12:00:\n%s\ngot:\n%s", want, got)
+	}
+
+	expectOutput(t, cli, "error", "account activity mo --since 24h --until 48h")
+	expectOutput(t, cli, "error", "account activity mo --since 24h --until tomorrow")
+}
+
+func TestActivityRollup(t *testing.T) {
+	rollup := newActivityRollup()
+
+	// A path payment sending XLM for USD has a debit and a trade, which isn't counted
+	payment := []balanceEffect{
+		{PagingToken: "5-1", Type: "account_debited", AssetType: "native", Amount: "5.0000000"},
+		{PagingToken: "5-2", Type: "trade", SoldAssetType: "native", SoldAmount: "5.0000000", BoughtAssetType: "credit_alphanum4",
+			BoughtAssetCode: "USD", BoughtAssetIssuer: "GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U", BoughtAmount: "10.0000000"},
+	}
+
+	deposit := []balanceEffect{
+		{PagingToken: "6-1", Type: "liquidity_pool_deposited", ReservesDeposited: []poolReserve{{Asset: "native", Amount: "1.0000000"}, {Asset: "EUR:GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U", Amount: "2.0000000"}}},
+	}
+
+	for _, op := range [][]balanceEffect{payment, deposit} {
+		if err := rollup.addOperation(op); err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+	}
+
+	got := rollup.assets(100)
+	want := []assetRollup{
+		{"native", "0.0000000", "6.0000100", "-6.0000100", "0.0000000"},
+		{"EUR:GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U", "0.0000000", "2.0000000", "-2.0000000", "0.0000000"},
+	}
+
+	if len(got) != len(want) || rollup.trades != 0 {
+		t.Fatalf("got %+v (%d trades), want %+v", got, rollup.trades, want)
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %+v, want %+v", got[i], want[i])
+		}
+	}
+
+	if err := rollup.addOperation([]balanceEffect{{Type: "account_credited", AssetType: "native", Amount: "lots"}}); err == nil {
+		t.Errorf("want error for bad amount")
+	}
+}
+
+func TestAccountActivityIncludeFailed(t *testing.T) {
+	cli, _ := newTestCLI()
+	address := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+	cli.TestCommand("account set mo " + address)
+
+	hash := strings.Repeat("ab", 32)
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		records := ""
+		if r.URL.Path == "/accounts/"+address+"/transactions" {
+			records = fmt.Sprintf(`{"paging_token": "1", "hash": "%s", "created_at": "%s", "source_account": "%s", "fee_charged": "100", "successful": false, "result_xdr": "%s"}`,
+				hash, time.Now().UTC().Format(time.RFC3339), address, resultXDR(-1, [2]int32{1, -2}))
+		}
+
+		fmt.Fprintf(w, `{"_embedded": {"records": [%s]}}`, records)
+	})
+	defer server.Close()
+
+	// Failed transactions still pay fees, but are only listed with --include-failed
+	want := "native: 0 received, 0 sent, net -0.0000100\ntrades: 0\nfees: 0.0000100 XLM"
+	expectOutput(t, cli, want, "account activity mo --since 1h")
+
+	got := cli.TestCommand("account activity mo --since 1h --include-failed")
+	if !strings.HasPrefix(got, want+"\nfailed: "+hash+" at ") || !strings.HasSuffix(strings.TrimSpace(got), ": tx_failed (op_underfunded)") {
+		t.Errorf("want failed transaction, got:\n%s", got)
+	}
+
+	got = cli.TestCommand("account activity mo --since 1h --include-failed --format json")
+	if !strings.Contains(got, `"failed_transactions":[{"hash":"`+hash+`"`) || !strings.Contains(got, `"result_codes":{"transaction":"tx_failed","operations":["op_underfunded"]}`) {
+		t.Errorf("want failed transaction in JSON, got:\n%s", got)
+	}
+}
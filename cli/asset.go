@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/0xfe/microstellar"
+	"github.com/pkg/errors"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -39,65 +40,69 @@ func (cli *CLI) buildAssetSetCmd() *cobra.Command {
 		Run: func(cmd *cobra.Command, args []string) {
 			name := args[0]
 			issuer := args[1]
+			logFields := logrus.Fields{"cmd": "asset", "subcmd": "set"}
+
 			code := name
-			assetType := string(microstellar.NativeType)
+			if cmd.Flag("code").Changed {
+				code, _ = cmd.Flags().GetString("code")
+			}
+
+			assetType := defaultAssetType(code)
+			if cmd.Flag("type").Changed {
+				assetType, _ = cmd.Flags().GetString("type")
+			}
+
+			if err := validateAssetCode(code, assetType); err != nil {
+				cli.error(logFields, "%v", err)
+				return
+			}
+
+			// Issuers given as account names are saved with their current address, and
+			// re-resolved whenever the asset is used (see ResolveAsset.)
+			alias := ""
+			if microstellar.ValidAddress(issuer) != nil {
+				alias = issuer
+			}
 
-			for _, part := range []string{"issuer", "code", "type"} {
+			for _, part := range []string{"issuer", "issuer_alias", "code", "type"} {
 				key := fmt.Sprintf("asset:%s:%s", name, part)
 				value := ""
 
 				if part == "issuer" {
 					value = issuer
-					if microstellar.ValidAddress(issuer) != nil {
+					if alias != "" {
 						var err error
-						value, err = cli.GetAccount(issuer, "address")
+						value, err = cli.GetAccount(alias, "address")
 						if err != nil {
-							cli.error(logrus.Fields{"cmd": "asset", "subcmd": "set"}, "invalid issuer: %s", issuer)
+							cli.error(logFields, "invalid issuer: %s", issuer)
+							return
 						}
 					}
 				}
 
-				if part == "code" {
-					if cmd.Flag("code").Changed {
-						code, _ = cmd.Flags().GetString("code")
+				if part == "issuer_alias" {
+					if alias == "" {
+						cli.DelVar(key)
+						continue
 					}
 
+					value = alias
+				}
+
+				if part == "code" {
 					value = code
 				}
 
 				if part == "type" {
-					if cmd.Flag("type").Changed {
-						assetType, _ = cmd.Flags().GetString("type")
-						switch assetType {
-						case
-							string(microstellar.Credit64Type),
-							string(microstellar.Credit12Type),
-							string(microstellar.Credit4Type),
-							string(microstellar.NativeType):
-							break
-						default:
-							cli.error(logrus.Fields{"cmd": "asset", "subcmd": "set"}, "bad asset type: %s", assetType)
-							return
-						}
-					} else {
-						if len(code) > 12 {
-							assetType = string(microstellar.Credit64Type)
-						} else if len(code) > 4 {
-							assetType = string(microstellar.Credit12Type)
-						} else {
-							assetType = string(microstellar.Credit4Type)
-						}
-					}
-
 					value = assetType
 				}
 
-				logrus.WithFields(logrus.Fields{"cmd": "asset", "subcmd": "set"}).Debugf("saving asset %s: %s %s", name, part, value)
+				logrus.WithFields(logFields).Debugf("saving asset %s: %s %s", name, part, value)
 				err := cli.SetVar(key, value)
 
 				if err != nil {
-					logrus.WithFields(logrus.Fields{"cmd": "asset", "subcmd": "set"}).Debugf("%v", err)
-					cli.error(logrus.Fields{"cmd": "asset", "subcmd": "set"}, "could not save asset: %s", name)
+					logrus.WithFields(logFields).Debugf("%v", err)
+					cli.errorWithCode(ExitStoreError, logFields, "could not save asset: %s", name)
 					return
 				}
 			}
@@ -110,6 +115,49 @@ func (cli *CLI) buildAssetSetCmd() *cobra.Command {
 	return cmd
 }
 
+// defaultAssetType returns the asset type implied by the length of code.
+func defaultAssetType(code string) string {
+	if len(code) > 12 {
+		return string(microstellar.Credit64Type)
+	} else if len(code) > 4 {
+		return string(microstellar.Credit12Type)
+	}
+
+	return string(microstellar.Credit4Type)
+}
+
+// validateAssetCode returns an error if code can't be used for an asset of type
+// assetType. Asset codes are alphanumeric, and credit_alphanum4 codes have 1-4
+// characters, credit_alphanum12 codes 5-12, and credit_alphanum64 codes 13-64.
+func validateAssetCode(code, assetType string) error {
+	var min, max int
+
+	switch assetType {
+	case string(microstellar.NativeType):
+		return nil
+	case string(microstellar.Credit4Type):
+		min, max = 1, 4
+	case string(microstellar.Credit12Type):
+		min, max = 5, 12
+	case string(microstellar.Credit64Type):
+		min, max = 13, 64
+	default:
+		return errors.Errorf("bad asset type: %s", assetType)
+	}
+
+	if len(code) < min || len(code) > max {
+		return errors.Errorf("%s asset codes must have %d-%d characters: %s", assetType, min, max, code)
+	}
+
+	for _, c := range code {
+		if !(c >= 'A' && c <= 'Z') && !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') {
+			return errors.Errorf("asset codes can only have letters and digits: %s", code)
+		}
+	}
+
+	return nil
+}
+
 func (cli *CLI) buildAssetCodeCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "code [name]",
@@ -186,7 +234,7 @@ func (cli *CLI) buildAssetDelCmd() *cobra.Command {
 		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			name := args[0]
-			for _, part := range []string{"issuer", "code", "type"} {
+			for _, part := range []string{"issuer", "issuer_alias", "code", "type"} {
 				key := fmt.Sprintf("asset:%s:%s", name, part)
 				cli.DelVar(key)
 			}
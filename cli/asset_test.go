@@ -33,5 +33,60 @@ func TestAssets(t *testing.T) {
 	expectOutput(t, cli, "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM", "asset issuer USD:citibank")
 	expectOutput(t, cli, "USD", "asset code USD:citibank")
 	expectOutput(t, cli, "credit_alphanum4", "asset type USD:citibank")
-	expectOutput(t, cli, "credit_alphanum12", "asset type USD:citibank:credit_alphanum12")
+	expectOutput(t, cli, "credit_alphanum12", "asset type USDCOIN:citibank:credit_alphanum12")
+	expectOutput(t, cli, "error", "asset type USD:citibank:credit_alphanum12")
+	expectOutput(t, cli, "error", "asset type USD$:citibank")
+}
+
+func TestAssetCodes(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("ns test")
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account new issuer")
+
+	// 5-12 character codes are credit_alphanum12
+	expectOutput(t, cli, "", "asset set USDCOIN issuer")
+	expectOutput(t, cli, "credit_alphanum12", "asset type USDCOIN")
+	expectOutput(t, cli, "", "asset set long issuer --code ABCDEFGHIJKL")
+	expectOutput(t, cli, "credit_alphanum12", "asset type long")
+	expectOutput(t, cli, "", "asset set short issuer --code USDC --type credit_alphanum4")
+	expectOutput(t, cli, "credit_alphanum4", "asset type short")
+
+	expectOutput(t, cli, "error", "asset set bad issuer --code USD --type credit_alphanum12")
+	expectOutput(t, cli, "error", "asset set bad issuer --code USDCOIN --type credit_alphanum4")
+	expectOutput(t, cli, "error", "asset set bad issuer --code US-D")
+	expectOutput(t, cli, "error", "asset code bad")
+
+	expectOutput(t, cli, "", "trust create mo USDCOIN 1000")
+	expectOutput(t, cli, "", "pay 10 USDCOIN --from issuer --to mo")
+	expectOutput(t, cli, "", "dex trade mo --buy native --sell USDCOIN --amount 5 --price 2")
+}
+
+func TestAssetIssuerAlias(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("ns test")
+
+	cli.TestCommand("account set citibank GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+	expectOutput(t, cli, "", "asset set USD-citi citibank --code USD")
+	expectOutput(t, cli, "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM", "asset issuer USD-citi")
+
+	// Assets follow their issuer's account name...
+	cli.TestCommand("account set citibank GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U")
+	expectOutput(t, cli, "GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U", "asset issuer USD-citi")
+
+	// ... but not if they were set with an address
+	expectOutput(t, cli, "", "asset set USD-citi GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM --code USD")
+	cli.TestCommand("account set citibank GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U")
+	expectOutput(t, cli, "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM", "asset issuer USD-citi")
+
+	// Assets whose issuer's account is gone can't be used
+	expectOutput(t, cli, "", "asset set USD-citi citibank --code USD")
+	cli.TestCommand("account del citibank")
+	expectOutput(t, cli, "error", "asset issuer USD-citi")
+
+	cli.TestCommand("asset del USD-citi")
+	cli.TestCommand("account set citibank GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+	expectOutput(t, cli, "error", "asset issuer USD-citi")
 }
@@ -0,0 +1,364 @@
+package cli
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// backupVersion is the version of the export-accounts file format.
+const backupVersion = 1
+
+// backupPassphraseEnv is the environment variable export-accounts --encrypt and
+// import-accounts read the passphrase from, if there's no --passphrase-file.
+const backupPassphraseEnv = "LUMEN_BACKUP_PASSPHRASE"
+
+// accountBackup is the contents of an export-accounts file: the store keys (without
+// the namespace) of the exported aliases and variables, and their values. With
+// --encrypt, the file has the accountBackup as sealedData instead.
+type accountBackup struct {
+	Version   int               `json:"version"`
+	Namespace string            `json:"namespace"`
+	Keys      map[string]string `json:"keys"`
+}
+
+// backupPassphrase returns the passphrase in --passphrase-file (without the trailing
+// newline) if set, or else in $LUMEN_BACKUP_PASSPHRASE.
+func backupPassphrase(cmd *cobra.Command) (string, error) {
+	passphrase := os.Getenv(backupPassphraseEnv)
+
+	if file, _ := cmd.Flags().GetString("passphrase-file"); file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", errors.Wrap(err, "can't read --passphrase-file")
+		}
+
+		passphrase = strings.TrimRight(string(data), "\r\n")
+	}
+
+	if passphrase == "" {
+		return "", errors.Errorf("no passphrase: use --passphrase-file, or set %s", backupPassphraseEnv)
+	}
+
+	return passphrase, nil
+}
+
+// isAliasKey returns true if key belongs to an account or asset alias.
+func isAliasKey(key string) bool {
+	return strings.HasPrefix(key, "account:") || strings.HasPrefix(key, "asset:")
+}
+
+// backupGroup returns the group key belongs to, which is imported (or refused) as a
+// whole: the alias (e.g., account:mo) for alias keys, or the key itself for
+// variables.
+func backupGroup(key string) string {
+	if isAliasKey(key) {
+		if i := strings.LastIndex(key, ":"); i > strings.Index(key, ":") {
+			return key[:i]
+		}
+	}
+
+	return key
+}
+
+// currentGroup returns the keys and values in the current namespace that are in group
+// (see backupGroup.)
+func (cli *CLI) currentGroup(group string) (map[string]string, error) {
+	keys := []string{group}
+	if isAliasKey(group) {
+		listed, err := cli.ListVars(group + ":")
+		if err != nil {
+			return nil, err
+		}
+
+		keys = nil
+		for _, key := range listed {
+			// Skip the keys of other aliases that start with this one, e.g., mo:x
+			if backupGroup(key) == group {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	current := map[string]string{}
+	for _, key := range keys {
+		if val, err := cli.GetVar(key); err == nil {
+			current[key] = val
+		}
+	}
+
+	return current, nil
+}
+
+// exportKeys returns the keys and values of all the accounts in the current namespace
+// and, optionally, assets and config variables.
+func (cli *CLI) exportKeys(withAssets, withConfig bool) (map[string]string, error) {
+	prefixes := []string{"account:"}
+	if withAssets {
+		prefixes = append(prefixes, "asset:")
+	}
+
+	if withConfig {
+		prefixes = append(prefixes, "vars:config:")
+	}
+
+	values := map[string]string{}
+	for _, prefix := range prefixes {
+		keys, err := cli.ListVars(prefix)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't list %s keys", strings.TrimSuffix(prefix, ":"))
+		}
+
+		for _, key := range keys {
+			val, err := cli.GetVar(key)
+			if err != nil {
+				// Expired since it was listed
+				continue
+			}
+
+			values[key] = val
+		}
+	}
+
+	return values, nil
+}
+
+// importKeys writes the keys in backup to the current namespace, and returns the
+// groups (see backupGroup) it imported. If the namespace already has any of the
+// groups with different keys or values, nothing is imported unless overwrite is set,
+// in which case the existing groups are replaced.
+func (cli *CLI) importKeys(backup map[string]string, overwrite bool) ([]string, error) {
+	groups := map[string]map[string]string{}
+	for key, val := range backup {
+		group := backupGroup(key)
+		if groups[group] == nil {
+			groups[group] = map[string]string{}
+		}
+
+		groups[group][key] = val
+	}
+
+	names := []string{}
+	for group := range groups {
+		names = append(names, group)
+	}
+	sort.Strings(names)
+
+	var conflicts []string
+	existing := map[string]map[string]string{}
+	for _, group := range names {
+		current, err := cli.currentGroup(group)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't read %s", group)
+		}
+
+		if len(current) > 0 && !sameKeys(current, groups[group]) {
+			conflicts = append(conflicts, group)
+			existing[group] = current
+		}
+	}
+
+	if len(conflicts) > 0 && !overwrite {
+		return nil, errors.Errorf("already in namespace with different values (use --overwrite to replace): %s", strings.Join(conflicts, ", "))
+	}
+
+	imported := []string{}
+	for _, group := range names {
+		for key := range existing[group] {
+			if err := cli.DelVar(key); err != nil {
+				return imported, errors.Wrapf(err, "can't replace %s", group)
+			}
+		}
+
+		for key, val := range groups[group] {
+			if err := cli.SetVar(key, val); err != nil {
+				return imported, errors.Wrapf(err, "can't write %s", key)
+			}
+		}
+
+		imported = append(imported, group)
+	}
+
+	return imported, nil
+}
+
+// sameKeys returns true if a and b have the same keys and values.
+func sameKeys(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for key, val := range a {
+		if other, ok := b[key]; !ok || other != val {
+			return false
+		}
+	}
+
+	return true
+}
+
+// countGroups returns the number of accounts, assets, and variables in groups.
+func countGroups(groups []string) (accounts, assets, vars int) {
+	for _, group := range groups {
+		switch {
+		case strings.HasPrefix(group, "account:"):
+			accounts++
+		case strings.HasPrefix(group, "asset:"):
+			assets++
+		default:
+			vars++
+		}
+	}
+
+	return accounts, assets, vars
+}
+
+func (cli *CLI) buildExportAccountsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export-accounts [file] [--encrypt [--passphrase-file file]] [--with-assets] [--with-config]",
+		Short: "back up all the accounts (and their seeds) in the current namespace to a file",
+		Long: `Back up all the accounts (and their seeds) in the current namespace to a file, which
+import-accounts restores. With --encrypt, the file is encrypted with the passphrase
+in --passphrase-file or $LUMEN_BACKUP_PASSPHRASE. Without it, the seeds are in the
+clear, so keep the file safe.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "export-accounts"}
+
+			encrypt, _ := cmd.Flags().GetBool("encrypt")
+			if !encrypt && cmd.Flags().Changed("passphrase-file") {
+				cli.error(logFields, "--passphrase-file is only for --encrypt")
+				return
+			}
+
+			var passphrase string
+			if encrypt {
+				var err error
+				if passphrase, err = backupPassphrase(cmd); err != nil {
+					cli.error(logFields, "%v", err)
+					return
+				}
+			}
+
+			withAssets, _ := cmd.Flags().GetBool("with-assets")
+			withConfig, _ := cmd.Flags().GetBool("with-config")
+			keys, err := cli.exportKeys(withAssets, withConfig)
+			if err != nil {
+				cli.errorWithCode(ExitStoreError, logFields, "%v", err)
+				return
+			}
+
+			data, err := json.MarshalIndent(accountBackup{Version: backupVersion, Namespace: cli.ns, Keys: keys}, "", "  ")
+			if err != nil {
+				cli.error(logFields, "can't encode backup: %v", err)
+				return
+			}
+
+			if encrypt {
+				if data, err = seal(data, passphrase); err != nil {
+					cli.error(logFields, "can't encrypt backup: %v", err)
+					return
+				}
+			}
+
+			if err := ioutil.WriteFile(args[0], append(data, '\n'), 0600); err != nil {
+				cli.error(logFields, "can't write backup: %v", err)
+				return
+			}
+
+			groups := map[string]bool{}
+			for key := range keys {
+				groups[backupGroup(key)] = true
+			}
+
+			var names []string
+			for group := range groups {
+				names = append(names, group)
+			}
+
+			accounts, assets, vars := countGroups(names)
+			showSuccess("exported %d accounts, %d assets, and %d config variables from namespace %s", accounts, assets, vars, cli.ns)
+		},
+	}
+
+	cmd.Flags().Bool("encrypt", false, "encrypt the file with a passphrase")
+	cmd.Flags().String("passphrase-file", "", "read the passphrase from this file instead of $"+backupPassphraseEnv)
+	cmd.Flags().Bool("with-assets", false, "also export asset aliases")
+	cmd.Flags().Bool("with-config", false, "also export config variables (e.g., config:network)")
+	return cmd
+}
+
+func (cli *CLI) buildImportAccountsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import-accounts [file] [--passphrase-file file] [--overwrite]",
+		Short: "restore the accounts in an export-accounts file to the current namespace",
+		Long: `Restore the accounts (and any assets and config variables) in an export-accounts
+file to the current namespace. Encrypted files are decrypted with the passphrase in
+--passphrase-file or $LUMEN_BACKUP_PASSPHRASE. If the namespace already has any of
+them with different values, nothing is imported unless --overwrite is set.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "import-accounts"}
+
+			data, err := ioutil.ReadFile(args[0])
+			if err != nil {
+				cli.error(logFields, "can't read backup: %v", err)
+				return
+			}
+
+			var encrypted sealedData
+			if err := json.Unmarshal(data, &encrypted); err != nil {
+				cli.error(logFields, "bad backup file %s: %v", args[0], err)
+				return
+			}
+
+			if len(encrypted.Ciphertext) > 0 {
+				passphrase, err := backupPassphrase(cmd)
+				if err != nil {
+					cli.error(logFields, "%s is encrypted: %v", args[0], err)
+					return
+				}
+
+				if data, err = unseal(&encrypted, passphrase); err != nil {
+					cli.error(logFields, "can't decrypt %s: %v", args[0], err)
+					return
+				}
+			}
+
+			var backup accountBackup
+			if err := json.Unmarshal(data, &backup); err != nil {
+				cli.error(logFields, "bad backup file %s: %v", args[0], err)
+				return
+			}
+
+			if backup.Version != backupVersion || backup.Keys == nil {
+				cli.error(logFields, "bad backup file %s: unsupported version %d", args[0], backup.Version)
+				return
+			}
+
+			overwrite, _ := cmd.Flags().GetBool("overwrite")
+			imported, err := cli.importKeys(backup.Keys, overwrite)
+			if err != nil {
+				if imported == nil {
+					cli.error(logFields, "not importing: %v", err)
+				} else {
+					cli.errorWithCode(ExitStoreError, logFields, "imported %d of them, then failed: %v", len(imported), err)
+				}
+				return
+			}
+
+			accounts, assets, vars := countGroups(imported)
+			showSuccess("imported %d accounts, %d assets, and %d config variables from namespace %s", accounts, assets, vars, backup.Namespace)
+		},
+	}
+
+	cmd.Flags().String("passphrase-file", "", "read the passphrase of an encrypted file from this file instead of $"+backupPassphraseEnv)
+	cmd.Flags().Bool("overwrite", false, "replace accounts, assets, and config variables that already have different values")
+	return cmd
+}
@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportImportAccounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lumen-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	passphraseFile := filepath.Join(dir, "passphrase")
+	ioutil.WriteFile(passphraseFile, []byte("correct horse\n"), 0600)
+	wrongFile := filepath.Join(dir, "wrong")
+	ioutil.WriteFile(wrongFile, []byte("battery staple\n"), 0600)
+
+	cli, _ := newTestCLI()
+	cli.TestCommand("ns old")
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account set kelly GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM --note savings")
+	cli.TestCommand("asset set USD kelly")
+
+	seed := strings.TrimSpace(cli.TestCommand("account seed mo"))
+	address := strings.TrimSpace(cli.TestCommand("account address mo"))
+	encrypted := filepath.Join(dir, "encrypted.json")
+	plain := filepath.Join(dir, "plain.json")
+
+	expectOutput(t, cli, "error", "export-accounts "+encrypted+" --encrypt")
+	expectOutput(t, cli, "error", "export-accounts "+plain+" --passphrase-file "+passphraseFile)
+	expectOutput(t, cli, "exported 2 accounts, 1 assets, and 1 config variables from namespace old",
+		"export-accounts "+encrypted+" --encrypt --passphrase-file "+passphraseFile+" --with-assets --with-config")
+	expectOutput(t, cli, "exported 2 accounts, 0 assets, and 0 config variables from namespace old", "export-accounts "+plain)
+
+	// The seeds are only in the clear without --encrypt
+	data, _ := ioutil.ReadFile(encrypted)
+	if strings.Contains(string(data), seed) {
+		t.Error("want seed encrypted with --encrypt")
+	}
+
+	data, _ = ioutil.ReadFile(plain)
+	if !strings.Contains(string(data), seed) {
+		t.Error("want seed in unencrypted export")
+	}
+
+	// Round trip into a new namespace
+	cli.TestCommand("ns new")
+	expectOutput(t, cli, "error", "import-accounts "+encrypted)
+	expectOutput(t, cli, "error", "import-accounts "+encrypted+" --passphrase-file "+wrongFile)
+	expectOutput(t, cli, "error", "import-accounts "+filepath.Join(dir, "missing.json"))
+	expectOutput(t, cli, "imported 2 accounts, 1 assets, and 1 config variables from namespace old",
+		"import-accounts "+encrypted+" --passphrase-file "+passphraseFile)
+
+	expectOutput(t, cli, seed, "account seed mo")
+	expectOutput(t, cli, "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM", "account address kelly")
+	expectOutput(t, cli, "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM", "asset issuer USD")
+	expectOutput(t, cli, "fake", "get config:network")
+	expectOutput(t, cli, "kelly GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM (note: savings)\nmo "+address, "account list")
+
+	// Importing the same accounts again is fine
+	expectOutput(t, cli, "imported 2 accounts, 0 assets, and 0 config variables from namespace old", "import-accounts "+plain)
+
+	// Collisions are refused, and nothing is imported, unless --overwrite is set
+	cli.TestCommand("account del mo")
+	cli.TestCommand("account new mo")
+	newSeed := strings.TrimSpace(cli.TestCommand("account seed mo"))
+	cli.TestCommand("account set kelly --note checking")
+
+	expectOutput(t, cli, "error", "import-accounts "+plain)
+	expectOutput(t, cli, newSeed, "account seed mo")
+
+	expectOutput(t, cli, "imported 2 accounts, 0 assets, and 0 config variables from namespace old", "import-accounts "+plain+" --overwrite")
+	expectOutput(t, cli, seed, "account seed mo")
+	expectOutput(t, cli, "kelly GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM (note: savings)\nmo "+address, "account list")
+}
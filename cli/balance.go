@@ -1,24 +1,44 @@
 package cli
 
 import (
-	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/0xfe/microstellar"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/stellar/go/amount"
 )
 
 func (cli *CLI) buildBalanceCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "balance [account] [asset]",
-		Short: "check the balance of [asset] on [account]",
-		Args:  cobra.MinimumNArgs(1),
+		Use:   "balance [account] [asset] [--at-ledger N|--at-time 'YYYY-MM-DD HH:MM:SS'] [--min amount] | balance --sum a,b,c [asset]",
+		Short: "check the balance of [asset] on [account], now or in the past, or the total on several accounts",
+		Args:  cobra.RangeArgs(0, 2),
 		Run: func(cmd *cobra.Command, args []string) {
-			name := args[0]
 			asset := microstellar.NativeAsset
 
 			logFields := logrus.Fields{"cmd": "balance"}
 
+			// With --sum, the accounts are in the flag
+			sum, _ := cmd.Flags().GetStringSlice("sum")
+			if len(sum) > 0 {
+				args = append([]string{""}, args...)
+			}
+
+			if len(sum) == 0 && cmd.Flags().Changed("concurrency") {
+				cli.error(logFields, "--concurrency is only for --sum")
+				return
+			}
+
+			if len(args) == 0 || len(args) > 2 {
+				cli.error(logFields, "expecting: balance [account] [asset], or balance --sum a,b,c [asset]")
+				return
+			}
+
+			name := args[0]
 			if len(args) > 1 {
 				var err error
 				assetName := args[1]
@@ -30,41 +50,335 @@ func (cli *CLI) buildBalanceCmd() *cobra.Command {
 				}
 			}
 
-			account := cli.LoadAccount(logFields, name)
-			if account == nil {
+			format, _ := cmd.Flags().GetString("format")
+			if format != "line" && format != "json" {
+				cli.error(logFields, "bad --format: %s, expecting: line|json", format)
 				return
 			}
 
-			balance := account.GetBalance(asset)
+			min, _ := cmd.Flags().GetString("min")
+			if min != "" {
+				if err := validateAmount(min, true); err != nil {
+					cli.error(logFields, "bad --min: %v", err)
+					return
+				}
+			}
+
+			atLedger, _ := cmd.Flags().GetString("at-ledger")
+			atTime, _ := cmd.Flags().GetString("at-time")
+
+			var balance string
+			var breakdown []balanceSummand
+			if len(sum) > 0 {
+				if atLedger != "" || atTime != "" {
+					cli.error(logFields, "--sum can't be used with --at-ledger or --at-time")
+					return
+				}
+
+				var ok bool
+				if balance, breakdown, ok = cli.sumBalances(cmd, logFields, sum, asset); !ok {
+					return
+				}
+			} else if atLedger != "" || atTime != "" {
+				cutoff, err := parseHistoryCutoff(atLedger, atTime)
+				if err != nil {
+					cli.error(logFields, "%v", err)
+					return
+				}
+
+				address, err := cli.ResolveAccount(logFields, name, "address")
+				if err != nil {
+					cli.error(logFields, "invalid account: %s", name)
+					return
+				}
+
+				if microstellar.ValidSeed(address) == nil {
+					address = addressFromSeed(address)
+				}
+
+				balances, err := cli.historicalBalances(logFields, address, cutoff)
+				if err != nil {
+					cli.errorWithCode(ExitNetworkError, logFields, "can't reconstruct balance of %s: %v", name, cli.errorString(err))
+					return
+				}
+
+				balance = amount.StringFromInt64(balances[horizonAssetString(asset)])
+			} else {
+				account := cli.LoadAccount(logFields, name)
+				if account == nil {
+					return
+				}
+
+				balance = account.GetBalance(asset)
+			}
 
 			if balance == "" {
-				showSuccess("0")
+				balance = "0"
+			}
+
+			if format == "json" {
+				pretty, _ := cmd.Flags().GetBool("pretty")
+				data, err := marshalJSON(struct {
+					Asset    string           `json:"asset"`
+					Balance  string           `json:"balance"`
+					AtLedger string           `json:"at_ledger,omitempty"`
+					AtTime   string           `json:"at_time,omitempty"`
+					Accounts []balanceSummand `json:"accounts,omitempty"`
+				}{horizonAssetString(asset), balance, atLedger, atTime, breakdown}, pretty)
+				if err != nil {
+					cli.error(logFields, "can't encode balance: %v", err)
+					return
+				}
+
+				showSuccess(string(data))
 			} else {
+				// The breakdown is only shown with -v
+				if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
+					for _, summand := range breakdown {
+						if summand.Found {
+							showSuccess("%s: %s", summand.Account, summand.Balance)
+						} else {
+							showSuccess("%s: %s (not found)", summand.Account, summand.Balance)
+						}
+					}
+				}
+
 				showSuccess(balance)
 			}
+
+			if min != "" {
+				cli.checkMinBalance(logFields, balance, min)
+			}
 		},
 	}
 
+	cmd.Flags().String("at-ledger", "", "reconstruct the balance as of this ledger, by replaying the account's history (slow)")
+	cmd.Flags().String("at-time", "", "reconstruct the balance as of 'YYYY-MM-DD HH:MM:SS' in UTC, by replaying the account's history (slow)")
+	cmd.Flags().String("format", "line", "output format (json, line)")
+	buildPrettyFlag(cmd)
+	cmd.Flags().String("min", "", "exit with code 6 if the balance is below this amount (after printing it)")
+	cmd.Flags().StringSlice("sum", []string{}, "show the total balance of these accounts (comma separated) instead, and each one's with -v")
+	cmd.Flags().Int("concurrency", 8, "with --sum, load at most this many balances at once")
 	return cmd
 }
 
+// balanceSummand is one account's part of a balance --sum.
+type balanceSummand struct {
+	Account string `json:"account"`
+	Address string `json:"address"`
+	Balance string `json:"balance"`
+	Found   bool   `json:"found"`
+}
+
+// addBalances returns the total of balances, in stroops. Accounts that weren't found
+// count as zero, but accounts that couldn't be loaded are an error, since the total
+// would be wrong.
+func addBalances(balances []accountBalance) (int64, error) {
+	var total int64
+	for _, balance := range balances {
+		if balance.err != nil {
+			return 0, balance.err
+		}
+
+		if !balance.found {
+			continue
+		}
+
+		val, err := amount.ParseInt64(balance.balance)
+		if err != nil {
+			return 0, errors.Errorf("bad balance: %s", balance.balance)
+		}
+
+		total += val
+	}
+
+	return total, nil
+}
+
+// sumBalances loads the balances of asset on the accounts in names (for balance --sum),
+// and returns their total and each account's part. It returns false (after reporting
+// the error) if any account is invalid or can't be loaded.
+func (cli *CLI) sumBalances(cmd *cobra.Command, logFields logrus.Fields, names []string, asset *microstellar.Asset) (string, []balanceSummand, bool) {
+	concurrency, _ := cmd.Flags().GetInt("concurrency")
+	if concurrency < 1 {
+		cli.error(logFields, "bad --concurrency: %d, expecting at least 1", concurrency)
+		return "", nil, false
+	}
+
+	addresses := make([]string, len(names))
+	for i, name := range names {
+		address, err := cli.ResolveAccount(logFields, name, "address")
+		if err != nil {
+			cli.error(logFields, "invalid account: %s", name)
+			return "", nil, false
+		}
+
+		if microstellar.ValidSeed(address) == nil {
+			address = addressFromSeed(address)
+		}
+
+		addresses[i] = address
+	}
+
+	balances := fetchBalances(addresses, concurrency, cli.balanceLoader(asset))
+
+	failed := 0
+	breakdown := make([]balanceSummand, len(names))
+	for i, balance := range balances {
+		switch {
+		case balance.err != nil:
+			failed++
+			showError(logFields, "can't load balance of %s: %v", names[i], cli.errorString(balance.err))
+		case !balance.found:
+			debugf(logFields, "%s not found, counting it as 0", names[i])
+			balance.balance = "0"
+		}
+
+		breakdown[i] = balanceSummand{Account: names[i], Address: addresses[i], Balance: balance.balance, Found: balance.found}
+	}
+
+	if failed > 0 {
+		cli.errorWithCode(ExitNetworkError, logFields, "can't load %d of %d balances", failed, len(names))
+		return "", nil, false
+	}
+
+	total, err := addBalances(balances)
+	if err != nil {
+		cli.errorWithCode(ExitNetworkError, logFields, "%v", err)
+		return "", nil, false
+	}
+
+	return amount.StringFromInt64(total), breakdown, true
+}
+
+// checkMinBalance fails with ExitBelowMin if balance is less than min (both amounts.)
+func (cli *CLI) checkMinBalance(logFields logrus.Fields, balance, min string) {
+	have, err := amount.ParseInt64(balance)
+	if err != nil {
+		cli.errorWithCode(ExitNetworkError, logFields, "bad balance: %s", balance)
+		return
+	}
+
+	want, _ := amount.ParseInt64(min)
+	if have < want {
+		cli.errorWithCode(ExitBelowMin, logFields, "balance %s is below --min %s", balance, min)
+	}
+}
+
+// parseHistoryCutoff returns the cutoff for --at-ledger or --at-time (only one of which
+// can be set.)
+func parseHistoryCutoff(atLedger, atTime string) (historyCutoff, error) {
+	if atLedger != "" && atTime != "" {
+		return historyCutoff{}, errors.Errorf("--at-ledger and --at-time are mutually exclusive")
+	}
+
+	if atLedger != "" {
+		ledger, err := strconv.ParseInt(atLedger, 10, 32)
+		if err != nil || ledger <= 0 {
+			return historyCutoff{}, errors.Errorf("bad --at-ledger: expecting a ledger number, got: %s", atLedger)
+		}
+
+		return historyCutoff{ledger: ledger}, nil
+	}
+
+	cutoff, err := time.Parse("2006-01-02 15:04:05", atTime)
+	if err != nil {
+		return historyCutoff{}, errors.Errorf("bad --at-time: expecting YYYY-MM-DD HH:MM:SS, got: %s", atTime)
+	}
+
+	return historyCutoff{time: cutoff}, nil
+}
+
 func (cli *CLI) buildInfoCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "info [account]",
+		Use:   "info [account] [--effects N] [--pretty]",
 		Short: "get account info",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			name := args[0]
-			logFields := logrus.Fields{"cmd": "balance"}
-			account := cli.LoadAccount(logFields, name)
-			if account == nil {
-				return
-			}
-
-			info, _ := json.MarshalIndent(*account, "", "  ")
-			showSuccess(string(info))
+			effects, _ := cmd.Flags().GetInt("effects")
+			pretty, _ := cmd.Flags().GetBool("pretty")
+			cli.showAccountInfo(logrus.Fields{"cmd": "info"}, args[0], effects, pretty)
 		},
 	}
 
+	buildEffectsFlag(cmd)
+	buildPrettyFlag(cmd)
 	return cmd
 }
+
+func buildEffectsFlag(cmd *cobra.Command) {
+	cmd.Flags().Int("effects", 0, fmt.Sprintf("also show the account's N most recent effects (up to %d)", maxPageSize))
+}
+
+// accountInfo is the account as loaded from horizon, along with the
+// local metadata lumen keeps for it, and optionally its recent effects.
+type accountInfo struct {
+	*microstellar.Account
+	Note    string                   `json:"note,omitempty"`
+	Effects []map[string]interface{} `json:"effects,omitempty"`
+}
+
+// showAccountInfo shows name's account as JSON (indented if pretty is set), with its n
+// most recent effects (newest first) if n > 0.
+func (cli *CLI) showAccountInfo(logFields logrus.Fields, name string, n int, pretty bool) {
+	if n < 0 || n > maxPageSize {
+		cli.error(logFields, "bad --effects: expecting 0 to %d, got: %d", maxPageSize, n)
+		return
+	}
+
+	account := cli.LoadAccount(logFields, name)
+	if account == nil {
+		return
+	}
+
+	note, _ := cli.GetVar(fmt.Sprintf("account:%s:note", name))
+
+	var effects []map[string]interface{}
+	if n > 0 {
+		var err error
+		if effects, err = cli.loadRecentEffects(logFields, name, n); err != nil {
+			cli.errorWithCode(ExitNetworkError, logFields, "can't load effects for %s: %v", name, cli.errorString(err))
+			return
+		}
+	}
+
+	info, err := marshalJSON(accountInfo{account, note, effects}, pretty)
+	if err != nil {
+		cli.error(logFields, "can't encode account info: %v", err)
+		return
+	}
+
+	showSuccess(string(info))
+}
+
+// loadRecentEffects returns the n most recent effects on name, newest first, as
+// returned by horizon. Their fields depend on their types, so they're kept as is,
+// except for the links.
+func (cli *CLI) loadRecentEffects(logFields logrus.Fields, name string, n int) ([]map[string]interface{}, error) {
+	address, err := cli.ResolveAccount(logFields, name, "address")
+	if err != nil {
+		return nil, err
+	}
+
+	if microstellar.ValidSeed(address) == nil {
+		address = addressFromSeed(address)
+	}
+
+	var page struct {
+		Embedded struct {
+			Records []map[string]interface{} `json:"records"`
+		} `json:"_embedded"`
+	}
+
+	if err := cli.getHorizonJSON(logFields, fmt.Sprintf("/accounts/%s/effects?order=desc&limit=%d", address, n), &page); err != nil {
+		return nil, err
+	}
+
+	effects := page.Embedded.Records
+	for _, effect := range effects {
+		delete(effect, "_links")
+	}
+
+	debugf(logFields, "got %d recent effects", len(effects))
+	return effects, nil
+}
@@ -1,6 +1,11 @@
 package cli
 
-import "testing"
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
 
 // Note: add -v to any of these commands to enable verbose logging
 
@@ -19,4 +24,141 @@ func TestBalance(t *testing.T) {
 
 	expectOutput(t, cli, "0", "balance worker")
 	expectOutput(t, cli, "0", "balance worker USD")
+
+	// Low balances print the balance, then fail with ExitBelowMin
+	expectOutput(t, cli, "0", "balance worker --min 0")
+	expectOutput(t, cli, "0\nerror", "balance worker --min 10")
+	if code := cli.ExitCode(); code != ExitBelowMin {
+		t.Errorf("want exit code %d for low balance, got %d", ExitBelowMin, code)
+	}
+
+	expectOutput(t, cli, `{"asset":"native","balance":"0"}`+"\nerror", "balance worker --min 10 --format json")
+	expectOutput(t, cli, "error", "balance worker --min lots")
+	if code := cli.ExitCode(); code != ExitBadArgs {
+		t.Errorf("want exit code %d for bad --min, got %d", ExitBadArgs, code)
+	}
+}
+
+func TestBalanceSum(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account new hot")
+	cli.TestCommand("account new cold")
+	cli.TestCommand("account new issuer-chase")
+	cli.TestCommand("asset set USD issuer-chase")
+
+	expectOutput(t, cli, "0.0000000", "balance --sum hot,cold")
+	expectOutput(t, cli, "0.0000000", "balance --sum hot,cold USD")
+	// -v shows the breakdown (at the info level, so the debug logs stay quiet)
+	expectOutput(t, cli, "hot: 0\ncold: 0\n0.0000000", "balance --sum hot,cold -v --log-level info")
+	expectOutput(t, cli, "0.0000000\nerror", "balance --sum hot,cold --min 1")
+
+	want := `{"asset":"native","balance":"0.0000000","accounts":[{"account":"hot","address":"G`
+	if got := cli.TestCommand("balance --sum hot --format json"); !strings.HasPrefix(got, want) {
+		t.Errorf("want JSON starting with %s, got %s", want, got)
+	}
+
+	expectOutput(t, cli, "error", "balance --sum hot,nobody")
+	expectOutput(t, cli, "error", "balance --sum hot,cold INR")
+	expectOutput(t, cli, "error", "balance --sum hot,cold USD extra")
+	expectOutput(t, cli, "error", "balance --sum hot,cold --at-ledger 10")
+	expectOutput(t, cli, "error", "balance --sum hot,cold --concurrency 0")
+	expectOutput(t, cli, "error", "balance hot --concurrency 2")
+	expectOutput(t, cli, "error", "balance")
+
+	balances := []accountBalance{{balance: "10.5", found: true}, {}, {balance: "0.0000001", found: true}}
+	if total, err := addBalances(balances); err != nil || total != 105000001 {
+		t.Errorf("want total 105000001 stroops, got %d: %v", total, err)
+	}
+
+	balances = append(balances, accountBalance{err: fmt.Errorf("horizon is down")})
+	if _, err := addBalances(balances); err == nil {
+		t.Errorf("want error for unloadable balance")
+	}
+}
+
+func TestBalanceAtLedger(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account set mo GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+	cli.TestCommand("asset set USD GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U")
+
+	expectOutput(t, cli, "0.0000000", "balance mo --at-ledger 10")
+	expectOutput(t, cli, "error", "balance mo --at-ledger yesterday")
+	expectOutput(t, cli, "error", "balance mo --at-ledger -10")
+	expectOutput(t, cli, "error", "balance mo --at-time yesterday")
+	expectOutput(t, cli, "error", "balance mo --format xml")
+	expectOutput(t, cli, "error", "balance nobody --at-ledger 10")
+
+	// One effect (and transaction) per ledger, from ledger 10 on
+	address := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+	usd := `"asset_type": "credit_alphanum4", "asset_code": "USD", "asset_issuer": "GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U"`
+	effects := []string{
+		`"type": "account_created", "starting_balance": "100.0000000"`,
+		`"type": "account_debited", "asset_type": "native", "amount": "10.0000000"`,
+		`"type": "trade", "sold_asset_type": "native", "sold_amount": "5.0000000", "bought_amount": "10.0000000", ` +
+			strings.Replace(usd, `"asset_`, `"bought_asset_`, -1),
+		`"type": "account_debited", "asset_type": "native", "amount": "2.0000000"`,
+		`"type": "account_credited", "asset_type": "native", "amount": "50.0000000"`,
+	}
+
+	// The path payment in ledger 13 also has a trade, which is already in the debit
+	pathTrade := `"type": "trade", "sold_asset_type": "native", "sold_amount": "2.0000000", "bought_amount": "4.0000000", ` +
+		strings.Replace(usd, `"asset_`, `"bought_asset_`, -1)
+
+	var paths []string
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		records := []string{}
+
+		for i, effect := range effects {
+			ledger := int64(10 + i)
+			createdAt := fmt.Sprintf("2020-01-%dT00:00:00Z", ledger)
+			id := ledger << 32
+
+			switch r.URL.Path {
+			case "/accounts/" + address + "/effects":
+				records = append(records, fmt.Sprintf(`{"paging_token": "%d-1", "created_at": "%s", %s}`, id, createdAt, effect))
+				if ledger == 13 {
+					records = append(records, fmt.Sprintf(`{"paging_token": "%d-2", "created_at": "%s", %s}`, id, createdAt, pathTrade))
+				}
+			case "/accounts/" + address + "/transactions":
+				// mo doesn't pay for its creation, or the payment it gets
+				source := address
+				if ledger == 10 || ledger == 14 {
+					source = "GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U"
+				}
+
+				records = append(records, fmt.Sprintf(`{"paging_token": "%d", "ledger": %d, "created_at": "%s", "source_account": "%s", "fee_charged": "100"}`,
+					id, ledger, createdAt, source))
+			}
+		}
+
+		fmt.Fprintf(w, `{"_embedded": {"records": [%s]}}`, strings.Join(records, ","))
+	})
+	defer server.Close()
+
+	expectOutput(t, cli, "100.0000000", "balance mo --at-ledger 10")
+	expectOutput(t, cli, "89.9999900", "balance mo --at-ledger 11")
+	expectOutput(t, cli, "84.9999800", "balance mo --at-ledger 12")
+	expectOutput(t, cli, "82.9999700", "balance mo --at-ledger 13")
+	expectOutput(t, cli, "132.9999700", "balance mo --at-ledger 100")
+	expectOutput(t, cli, "10.0000000", "balance mo USD --at-ledger 13")
+
+	if got := cli.Embeddable().Run("balance", "mo", "--at-time", "2020-01-12 12:00:00"); got != "84.9999800\n" {
+		t.Errorf("want balance at noon on 2020-01-12, got %q", got)
+	}
+
+	if len(paths) != 2*7 {
+		t.Errorf("want an effects and a transactions query per balance, got %v", paths)
+	}
+
+	want := `{
+  "asset": "native",
+  "balance": "0.0000000",
+  "at_ledger": "9"
+}`
+	expectOutput(t, cli, want, "balance mo --at-ledger 9 --format json --pretty")
 }
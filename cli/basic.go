@@ -5,8 +5,11 @@ package cli
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/0xfe/lumen/store"
 	"github.com/0xfe/microstellar"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -34,7 +37,7 @@ func (cli *CLI) buildNSCmd() *cobra.Command {
 
 				err := cli.SetGlobalVar("ns", ns)
 				if err != nil {
-					cli.error(logrus.Fields{"cmd": "setNS"}, "set failed: ", err)
+					cli.errorWithCode(ExitStoreError, logrus.Fields{"cmd": "setNS"}, "set failed: %v", err)
 					return
 				}
 
@@ -58,7 +61,7 @@ func (cli *CLI) buildSetCmd() *cobra.Command {
 
 			err := cli.SetVar(key, val)
 			if err != nil {
-				cli.error(logrus.Fields{"cmd": "set"}, "set failed: ", err)
+				cli.errorWithCode(ExitStoreError, logrus.Fields{"cmd": "set"}, "set failed: %v", err)
 				return
 			}
 		},
@@ -98,7 +101,7 @@ func (cli *CLI) buildDelCmd() *cobra.Command {
 
 			err := cli.DelVar(key)
 			if err != nil {
-				cli.error(logrus.Fields{"cmd": "del"}, "del failed: %s\n", err)
+				cli.errorWithCode(ExitStoreError, logrus.Fields{"cmd": "del"}, "del failed: %s\n", err)
 				return
 			}
 		},
@@ -125,7 +128,7 @@ func (cli *CLI) buildFriendbotCmd() *cobra.Command {
 			response, err := cli.ms.FundWithFriendBot(address)
 
 			if err != nil {
-				cli.error(logFields, "friendbot error: %v", err)
+				cli.errorWithCode(ExitNetworkError, logFields, "friendbot error: %v", err)
 				return
 			}
 
@@ -174,7 +177,7 @@ func (cli *CLI) buildFlagsCmd() *cobra.Command {
 				}
 			}
 
-			opts, err := cli.genTxOptions(cmd, logFields)
+			opts, err := cli.genTxOptions(cmd, logFields, address)
 			if err != nil {
 				cli.error(logFields, "can't generate transaction: %v", err)
 				return
@@ -189,7 +192,7 @@ func (cli *CLI) buildFlagsCmd() *cobra.Command {
 			}
 
 			if err != nil {
-				cli.error(logFields, "can't set flags: %v", microstellar.ErrorString(err))
+				cli.errorWithCode(txExitCode(err), logFields, "can't set flags: %v", cli.errorString(err))
 				return
 			}
 		},
@@ -200,3 +203,83 @@ func (cli *CLI) buildFlagsCmd() *cobra.Command {
 
 	return cmd
 }
+
+func (cli *CLI) buildStoreCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "store [migrate]",
+		Short: "manage the local store",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cli.error(logrus.Fields{"cmd": "store"}, "unrecognized store command: %s, expecting: migrate", args[0])
+		},
+	}
+
+	cmd.AddCommand(cli.buildStoreMigrateCmd())
+	return cmd
+}
+
+// openStore opens the store in spec (see --store.) The internal store only lasts as
+// long as lumen runs, so it's only the current one, e.g., in a REPL session.
+func (cli *CLI) openStore(spec string) (store.API, error) {
+	driver, params := parseStoreSpec(spec)
+	if driver != "internal" {
+		return store.NewStore(driver, params)
+	}
+
+	if _, ok := cli.store.(*store.Internal); !ok {
+		return nil, errors.Errorf("the internal store only lasts for a session, use it with: lumen repl --store internal")
+	}
+
+	return cli.store, nil
+}
+
+func (cli *CLI) buildStoreMigrateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate --from [store] --to [store] [--overwrite]",
+		Short: "copy every key in every namespace from one store to another (e.g., file,/path/to/file)",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "store", "subcmd": "migrate"}
+
+			from, _ := cmd.Flags().GetString("from")
+			to, _ := cmd.Flags().GetString("to")
+			if from == to {
+				cli.error(logFields, "--from and --to are the same store: %s", from)
+				return
+			}
+
+			src, err := cli.openStore(from)
+			if err != nil {
+				cli.errorWithCode(ExitStoreError, logFields, "can't open --from store %s: %v", from, err)
+				return
+			}
+
+			dst, err := cli.openStore(to)
+			if err != nil {
+				cli.errorWithCode(ExitStoreError, logFields, "can't open --to store %s: %v", to, err)
+				return
+			}
+
+			overwrite, _ := cmd.Flags().GetBool("overwrite")
+			keys, err := store.Copy(src, dst, overwrite)
+			if err != nil {
+				cli.errorWithCode(ExitStoreError, logFields, "migrated %d keys, then failed: %v", len(keys), err)
+				return
+			}
+
+			namespaces := map[string]bool{}
+			for _, key := range keys {
+				namespaces[strings.SplitN(key, ":", 2)[0]] = true
+			}
+
+			showSuccess("migrated %d keys in %d namespaces", len(keys), len(namespaces))
+		},
+	}
+
+	cmd.Flags().String("from", "", "the store to read, as for --store")
+	cmd.Flags().String("to", "", "the store to write, as for --store")
+	cmd.Flags().Bool("overwrite", false, "replace keys that already have different values in --to")
+	cmd.MarkFlagRequired("from")
+	cmd.MarkFlagRequired("to")
+	return cmd
+}
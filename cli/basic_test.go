@@ -1,7 +1,15 @@
 package cli
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/0xfe/lumen/store"
+	"github.com/sirupsen/logrus"
 )
 
 // Note: add -v to any of these commands to enable verbose logging
@@ -33,3 +41,219 @@ func TestVariables(t *testing.T) {
 	expectOutput(t, cli, "", "flags mo none")
 	expectOutput(t, cli, "", "flags mo auth_revocable auth_immutable")
 }
+
+func TestExitCodes(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("version")
+	if code := cli.ExitCode(); code != 0 {
+		t.Errorf("version: want exit code 0, got %d", code)
+	}
+
+	cli.TestCommand("balance nobody")
+	if code := cli.ExitCode(); code != ExitBadArgs {
+		t.Errorf("bad args: want exit code %d, got %d", ExitBadArgs, code)
+	}
+
+	cli.TestCommand("set config:network custom;http://127.0.0.1:1;nopass")
+	cli.TestCommand("balance GAF5WUGRUYH7TAPUHSOWAAZCCNYB2FNSIJGXQDTNELEHBLNSDV2KUQY6")
+	if code := cli.ExitCode(); code != ExitNetworkError {
+		t.Errorf("unreachable network: want exit code %d, got %d", ExitNetworkError, code)
+	}
+
+	dummyStore, _ := store.NewStore("dummy", "")
+	cli.SetStore(dummyStore)
+	cli.TestCommand("set foo bar")
+	if code := cli.ExitCode(); code != ExitStoreError {
+		t.Errorf("store failure: want exit code %d, got %d", ExitStoreError, code)
+	}
+}
+
+func TestHorizonTimeout(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("version")
+	if cli.horizonTimeout != DefaultHorizonTimeout {
+		t.Errorf("want default timeout %v, got %v", DefaultHorizonTimeout, cli.horizonTimeout)
+	}
+
+	cli.TestCommand("set config:horizon_timeout 5")
+	cli.TestCommand("version")
+	if cli.horizonTimeout != 5*time.Second {
+		t.Errorf("want timeout 5s from config, got %v", cli.horizonTimeout)
+	}
+
+	cli.TestCommand("version --horizon-timeout 1m")
+	if cli.horizonTimeout != time.Minute {
+		t.Errorf("want timeout 1m from flag, got %v", cli.horizonTimeout)
+	}
+
+	for _, spec := range []string{"-1s", "soon", ""} {
+		if _, err := parseTimeout(spec); err == nil {
+			t.Errorf("want error for timeout %q", spec)
+		}
+	}
+}
+
+func TestValidateAmount(t *testing.T) {
+	tests := []struct {
+		amount    string
+		allowZero bool
+		valid     bool
+	}{
+		{"100000", false, true},
+		{"99995.0000000", false, true},
+		{"0.0000001", false, true},
+		{"922337203685.4775807", false, true},
+		{"0", true, true},
+		{"0.0", true, true},
+		{"0", false, false},
+		{"0.00000000", true, false},
+		{"1.12345678", false, false},
+		{"-1", false, false},
+		{"-0", true, false},
+		{"+1", false, false},
+		{"1e5", false, false},
+		{"ten", false, false},
+		{"1.2.3", false, false},
+		{"1,000", false, false},
+		{".", false, false},
+		{"1.", false, false},
+		{".5", false, false},
+		{"", true, false},
+		{"922337203685.4775808", false, false},
+	}
+
+	for _, test := range tests {
+		err := validateAmount(test.amount, test.allowZero)
+		if test.valid && err != nil {
+			t.Errorf("want %q (allowZero: %v) to be valid, got: %v", test.amount, test.allowZero, err)
+		}
+
+		if !test.valid && err == nil {
+			t.Errorf("want %q (allowZero: %v) to be invalid", test.amount, test.allowZero)
+		}
+	}
+
+	if err := validateAmount("1.12345678", false); err == nil || !strings.Contains(err.Error(), "at most 7 decimal places") {
+		t.Errorf("want decimal places error, got: %v", err)
+	}
+}
+
+func TestOutputFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lumen-output")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account set mo GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+
+	file := filepath.Join(dir, "address.txt")
+	expectOutput(t, cli, "", "account address mo --output "+file)
+
+	got, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("can't read output file: %v", err)
+	}
+
+	if want := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM\n"; string(got) != want {
+		t.Errorf("want %q in output file, got %q", want, got)
+	}
+
+	// Errors aren't written to the file, and stdout is restored afterwards
+	expectOutput(t, cli, "error", "account address nobody --output "+file)
+	if got, _ := ioutil.ReadFile(file); len(got) != 0 {
+		t.Errorf("want empty output file on error, got %q", got)
+	}
+
+	expectOutput(t, cli, "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM", "account address mo")
+
+	cli.TestCommand("version --output " + filepath.Join(dir, "missing", "file"))
+	if code := cli.ExitCode(); code != ExitBadArgs {
+		t.Errorf("unwritable output file: want exit code %d, got %d", ExitBadArgs, code)
+	}
+}
+
+func TestLogging(t *testing.T) {
+	level := logrus.GetLevel()
+	formatter := logrus.StandardLogger().Formatter
+	defer func() {
+		logrus.SetLevel(level)
+		logrus.SetFormatter(formatter)
+	}()
+
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	expectOutput(t, cli, "", "set config:foo bar --log-level warn --log-format json")
+	if logrus.GetLevel() != logrus.WarnLevel {
+		t.Errorf("want warn level, got %v", logrus.GetLevel())
+	}
+
+	if _, ok := logrus.StandardLogger().Formatter.(*logrus.JSONFormatter); !ok {
+		t.Errorf("want JSON logs, got %T", logrus.StandardLogger().Formatter)
+	}
+
+	// Bad values are reported (on stderr), and don't change anything
+	expectOutput(t, cli, "bar", "get config:foo --log-level loud --log-format xml")
+	if logrus.GetLevel() != logrus.WarnLevel {
+		t.Errorf("want warn level to be kept, got %v", logrus.GetLevel())
+	}
+
+	expectOutput(t, cli, "bar", "get config:foo --log-level error --log-format text")
+	if _, ok := logrus.StandardLogger().Formatter.(*logrus.TextFormatter); !ok || logrus.GetLevel() != logrus.ErrorLevel {
+		t.Errorf("want text logs at error level, got %T at %v", logrus.StandardLogger().Formatter, logrus.GetLevel())
+	}
+
+	// -v is --log-level debug, unless the level is given explicitly
+	cli.rootCmd.ParseFlags([]string{"--log-level", "info"})
+	cli.setupLogging(cli.rootCmd, true)
+	if logrus.GetLevel() != logrus.InfoLevel {
+		t.Errorf("want explicit info level with -v, got %v", logrus.GetLevel())
+	}
+
+	cli.rootCmd.ParseFlags([]string{"--log-level", ""})
+	cli.setupLogging(cli.rootCmd, true)
+	if logrus.GetLevel() != logrus.DebugLevel {
+		t.Errorf("want debug level with -v, got %v", logrus.GetLevel())
+	}
+}
+
+func TestStoreMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lumen-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account set mo GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+	cli.TestCommand("ns staging")
+	cli.TestCommand("set config:network test")
+	cli.TestCommand("ns default")
+
+	path := filepath.Join(dir, "lumen-data.yml")
+	// The current namespace is in the global one
+	expectOutput(t, cli, "migrated 4 keys in 3 namespaces", "store migrate --from internal --to file,"+path)
+	expectOutput(t, cli, "error", "store migrate --from file,"+path+" --to file,"+path)
+	expectOutput(t, cli, "error", "store migrate --from nothing --to file,"+path)
+
+	// Into another session's store, keeping namespaces
+	other, _ := newTestCLI()
+	expectOutput(t, other, "migrated 4 keys in 3 namespaces", "store migrate --from file,"+path+" --to internal")
+	expectOutput(t, other, "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM", "account address mo")
+	other.TestCommand("ns staging")
+	expectOutput(t, other, "test", "get config:network")
+
+	// The internal store only lasts for a session
+	onFile := NewCLI()
+	fileStore, _ := store.NewStore("file", filepath.Join(dir, "other.yml"))
+	onFile.SetStore(fileStore)
+	expectOutput(t, onFile, "error", "store migrate --from file,"+path+" --to internal")
+}
@@ -0,0 +1,574 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/0xfe/microstellar"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// A batch is a set of commands that are submitted together in a single (atomic)
+// transaction. Commands run with --batch are validated and recorded in the store,
+// and replayed as operations of a multi-op transaction on "batch commit".
+
+// loadBatch returns the source account and the pending commands of the batch in
+// the current namespace.
+func (cli *CLI) loadBatch() (string, [][]string, error) {
+	source, err := cli.GetVar("batch:source")
+	if err != nil {
+		return "", nil, errors.Errorf("no batch in progress, see: lumen batch begin")
+	}
+
+	var ops [][]string
+	data, err := cli.GetVar("batch:ops")
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "can't load batch")
+	}
+
+	if err := json.Unmarshal([]byte(data), &ops); err != nil {
+		return "", nil, errors.Wrapf(err, "bad batch")
+	}
+
+	return source, ops, nil
+}
+
+func (cli *CLI) saveBatch(ops [][]string) error {
+	data, err := json.Marshal(ops)
+	if err != nil {
+		return errors.Wrapf(err, "can't encode batch")
+	}
+
+	return cli.SetVar("batch:ops", string(data))
+}
+
+func (cli *CLI) clearBatch() error {
+	if err := cli.DelVar("batch:ops"); err != nil {
+		return err
+	}
+
+	return cli.DelVar("batch:source")
+}
+
+// recordBatched adds the current command to the pending batch if it ran with --batch
+// and succeeded, i.e., its transaction was built and signed without errors. Nothing
+// is built on the fake network, so there it only has to succeed.
+func (cli *CLI) recordBatched(logFields logrus.Fields) {
+	if !cli.batching || cli.exitCode != 0 {
+		return
+	}
+
+	if !cli.batchBuilt && cli.horizonURL() != "" {
+		debugf(logFields, "no transaction built, not adding to batch")
+		return
+	}
+
+	if err := cli.addToBatch(logFields); err != nil {
+		cli.errorWithCode(ExitStoreError, logFields, "can't add to batch: %v", err)
+	}
+}
+
+// addToBatch records the current command line (without --batch) in the pending batch.
+func (cli *CLI) addToBatch(logFields logrus.Fields) error {
+	_, ops, err := cli.loadBatch()
+	if err != nil {
+		return err
+	}
+
+	op := []string{}
+	for _, arg := range cli.args {
+		if arg != "--batch" && arg != "--batch=true" {
+			op = append(op, arg)
+		}
+	}
+
+	debugf(logFields, "adding to batch: %v", op)
+	return cli.saveBatch(append(ops, op))
+}
+
+func (cli *CLI) buildBatchCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "batch [begin|show|commit|abort|pay]",
+		Short: "combine operations from multiple commands into one transaction",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cli.error(logrus.Fields{"cmd": "batch"}, "unrecognized batch command: %s, expecting: begin|show|commit|abort|pay", args[0])
+		},
+	}
+
+	cmd.AddCommand(cli.buildBatchBeginCmd())
+	cmd.AddCommand(cli.buildBatchShowCmd())
+	cmd.AddCommand(cli.buildBatchCommitCmd())
+	cmd.AddCommand(cli.buildBatchAbortCmd())
+	cmd.AddCommand(cli.buildBatchPayCmd())
+
+	return cmd
+}
+
+func (cli *CLI) buildBatchBeginCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "begin [source]",
+		Short: "start a batch, whose transaction is sourced from [source]. Add to it with --batch",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "batch", "subcmd": "begin"}
+			source := args[0]
+
+			if pending, err := cli.GetVar("batch:source"); err == nil {
+				cli.error(logFields, "batch from %s already in progress, see: lumen batch commit|abort", pending)
+				return
+			}
+
+			if _, err := cli.ResolveAccount(logFields, source, "address"); err != nil {
+				cli.error(logFields, "invalid source: %s", source)
+				return
+			}
+
+			if err := cli.saveBatch([][]string{}); err != nil {
+				cli.errorWithCode(ExitStoreError, logFields, "can't save batch: %v", err)
+				return
+			}
+
+			if err := cli.SetVar("batch:source", source); err != nil {
+				cli.errorWithCode(ExitStoreError, logFields, "can't save batch: %v", err)
+				return
+			}
+		},
+	}
+}
+
+func (cli *CLI) buildBatchShowCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "show",
+		Short: "show the commands in the pending batch",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "batch", "subcmd": "show"}
+
+			source, ops, err := cli.loadBatch()
+			if err != nil {
+				cli.error(logFields, "%v", err)
+				return
+			}
+
+			showSuccess("source: %s", source)
+			for i, op := range ops {
+				showSuccess("%d: lumen %s", i+1, strings.Join(op, " "))
+			}
+		},
+	}
+}
+
+func (cli *CLI) buildBatchCommitCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "commit [--signers seed1,seed2...]",
+		Short: "submit all the commands in the pending batch as one transaction",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "batch", "subcmd": "commit"}
+
+			if batch, _ := cmd.Flags().GetBool("batch"); batch {
+				cli.error(logFields, "can't add a commit to a batch")
+				return
+			}
+
+			source, ops, err := cli.loadBatch()
+			if err != nil {
+				cli.error(logFields, "%v", err)
+				return
+			}
+
+			if len(ops) == 0 {
+				cli.error(logFields, "batch is empty, add to it with --batch")
+				return
+			}
+
+			sourceAddress, err := cli.ResolveAccount(logFields, source, "address")
+			if err != nil {
+				cli.error(logFields, "invalid source: %s", source)
+				return
+			}
+
+			opts, err := cli.genTxOptions(cmd, logFields, sourceAddress)
+			if err != nil {
+				cli.error(logFields, "can't generate transaction: %v", err)
+				return
+			}
+
+			// Sign with the source's seed if we have it
+			if seed, err := cli.GetAccount(source, "seed"); err == nil && microstellar.ValidSeed(seed) == nil {
+				opts = opts.WithSigner(seed)
+			}
+
+			cli.ms.Start(sourceAddress, opts)
+
+			for i, op := range ops {
+				debugf(logFields, "replaying %d: %v", i+1, op)
+
+				replay := NewCLI()
+				replay.store = cli.store
+				replay.ns = cli.ns
+				replay.ms = cli.ms
+				replay.testing = cli.testing
+				replay.replaying = true
+				replay.rootCmd.SetArgs(op)
+
+				if err := replay.rootCmd.Execute(); err != nil || replay.exitCode != 0 {
+					cli.error(logFields, "batch command %d failed: lumen %s", i+1, strings.Join(op, " "))
+					return
+				}
+			}
+
+			if err := cli.ms.Submit(); err != nil {
+				cli.errorWithCode(txExitCode(err), logFields, "batch failed: %v", cli.errorString(err))
+				return
+			}
+
+			if nosubmit, _ := cli.rootCmd.Flags().GetBool("nosubmit"); nosubmit {
+				// Keep the batch around until it's actually submitted
+				return
+			}
+
+			if err := cli.clearBatch(); err != nil {
+				cli.errorWithCode(ExitStoreError, logFields, "batch submitted, but can't clear it: %v", err)
+				return
+			}
+		},
+	}
+
+	buildFlagsForTxOptions(cmd)
+	return cmd
+}
+
+func (cli *CLI) buildBatchAbortCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "abort",
+		Short: "discard the pending batch",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "batch", "subcmd": "abort"}
+
+			if _, _, err := cli.loadBatch(); err != nil {
+				cli.error(logFields, "%v", err)
+				return
+			}
+
+			if err := cli.clearBatch(); err != nil {
+				cli.errorWithCode(ExitStoreError, logFields, "can't clear batch: %v", err)
+				return
+			}
+		},
+	}
+}
+
+// csvPayment is a payment in a row of a CSV file, see batch pay. Payments with a
+// source are sourced from (and signed by) sourceSeed, instead of the transaction's
+// source.
+type csvPayment struct {
+	row        int
+	to         string
+	target     string
+	amount     string
+	asset      *microstellar.Asset
+	memo       string
+	source     string
+	sourceSeed string
+}
+
+// maxMemoText is the longest text memo (in bytes) the network accepts.
+const maxMemoText = 28
+
+// readCSVPayments reads the payments in the CSV file at path. The header names the
+// columns: "to" and "amount" are required, "asset" is optional (XLM if empty), and
+// memoColumn (if not empty) holds each payment's memo, of type memoType (text or id.)
+// sourceColumn (or "source", which is optional, if empty) holds the account each
+// payment is from, if not the transaction's source. Its seed must be available.
+func (cli *CLI) readCSVPayments(logFields logrus.Fields, path, memoColumn, memoType, sourceColumn string) ([]csvPayment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't open %s", path)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read header of %s", path)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, name := range []string{"to", "amount"} {
+		if _, ok := columns[name]; !ok {
+			return nil, errors.Errorf("no %q column in %s", name, path)
+		}
+	}
+
+	for _, name := range []string{memoColumn, sourceColumn} {
+		if _, ok := columns[strings.ToLower(name)]; name != "" && !ok {
+			return nil, errors.Errorf("no %q column in %s", name, path)
+		}
+	}
+
+	if sourceColumn == "" {
+		sourceColumn = "source"
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[strings.ToLower(name)]; ok {
+			return strings.TrimSpace(record[i])
+		}
+
+		return ""
+	}
+
+	payments := []csvPayment{}
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't read %s", path)
+		}
+
+		payment := csvPayment{row: row, to: field(record, "to"), amount: field(record, "amount")}
+
+		if err := validateAmount(payment.amount, false); err != nil {
+			return nil, errors.Errorf("row %d: %v", row, err)
+		}
+
+		target, muxedID, err := cli.ResolveDestination(logFields, payment.to)
+		if err != nil {
+			return nil, errors.Errorf("row %d: bad address: %s", row, payment.to)
+		}
+
+		// The embedded ID of a muxed address is a memo, which would clash with the row's
+		if muxedID != nil {
+			return nil, errors.Errorf("row %d: can't pay muxed address: %s", row, payment.to)
+		}
+
+		payment.target = target
+
+		if payment.asset, err = cli.ResolveAsset(field(record, "asset")); err != nil {
+			return nil, errors.Errorf("row %d: bad asset: %s", row, field(record, "asset"))
+		}
+
+		if memoColumn != "" {
+			payment.memo = field(record, memoColumn)
+
+			if memoType == "id" && payment.memo != "" {
+				if _, err := strconv.ParseUint(payment.memo, 10, 64); err != nil {
+					return nil, errors.Errorf("row %d: bad memo id: %s", row, payment.memo)
+				}
+			} else if len(payment.memo) > maxMemoText {
+				return nil, errors.Errorf("row %d: memo is longer than %d bytes: %s", row, maxMemoText, payment.memo)
+			}
+		}
+
+		if payment.source = field(record, sourceColumn); payment.source != "" {
+			seed, err := cli.ResolveAccount(logFields, payment.source, "seed")
+			if err != nil || microstellar.ValidSeed(seed) != nil {
+				return nil, errors.Errorf("row %d: no seed found for source: %s", row, payment.source)
+			}
+
+			payment.sourceSeed = seed
+		}
+
+		payments = append(payments, payment)
+	}
+
+	return payments, nil
+}
+
+// groupPaymentsByMemo splits payments into transactions. A transaction has only one
+// memo, so payments with different memos go in different transactions, and no
+// transaction has more than maxOpsPerTx payments. Transactions are in the order that
+// their first payment appears.
+func groupPaymentsByMemo(payments []csvPayment) [][]csvPayment {
+	var memos []string
+	groups := map[string][]csvPayment{}
+
+	for _, payment := range payments {
+		if _, ok := groups[payment.memo]; !ok {
+			memos = append(memos, payment.memo)
+		}
+
+		groups[payment.memo] = append(groups[payment.memo], payment)
+	}
+
+	txs := [][]csvPayment{}
+	for _, memo := range memos {
+		group := groups[memo]
+		for len(group) > maxOpsPerTx {
+			txs = append(txs, group[:maxOpsPerTx])
+			group = group[maxOpsPerTx:]
+		}
+
+		txs = append(txs, group)
+	}
+
+	return txs
+}
+
+func (cli *CLI) buildBatchPayCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pay [csv file] --from [source] [--memo-from-csv column --memo-type text|id] [--operation-source column]",
+		Short: "make the payments in [csv file] (with to, amount, and asset columns), in as few transactions as possible",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "batch", "subcmd": "pay"}
+
+			if batch, _ := cmd.Flags().GetBool("batch"); batch {
+				cli.error(logFields, "can't add CSV payments to a batch")
+				return
+			}
+
+			from, _ := cmd.Flags().GetString("from")
+			source, err := cli.ResolveAccount(logFields, from, "seed")
+			if err != nil || microstellar.ValidSeed(source) != nil {
+				cli.error(logFields, "no seed found in --from: %s", from)
+				return
+			}
+
+			sourceAddress, err := cli.ResolveAccount(logFields, from, "address")
+			if err != nil {
+				cli.error(logFields, "no address in --from: %s", from)
+				return
+			}
+
+			memoColumn, _ := cmd.Flags().GetString("memo-from-csv")
+			memoType, _ := cmd.Flags().GetString("memo-type")
+			if memoType != "text" && memoType != "id" {
+				cli.error(logFields, "bad --memo-type: %s, expecting: text|id", memoType)
+				return
+			}
+
+			if memoColumn != "" && hasMemo(cmd) {
+				cli.error(logFields, "--memo-from-csv can't be used with memo flags")
+				return
+			}
+
+			sourceColumn, _ := cmd.Flags().GetString("operation-source")
+			payments, err := cli.readCSVPayments(logFields, args[0], memoColumn, memoType, sourceColumn)
+			if err != nil {
+				cli.error(logFields, "%v", err)
+				return
+			}
+
+			if len(payments) == 0 {
+				cli.error(logFields, "no payments in %s", args[0])
+				return
+			}
+
+			// Refuse to pay accounts that require a memo (SEP-29) without one
+			if skip, _ := cmd.Flags().GetBool("skip-memo-check"); !skip && !cli.sendsMemo(cmd) {
+				checked := map[string]bool{}
+				for _, payment := range payments {
+					if payment.memo != "" || checked[payment.target] {
+						continue
+					}
+
+					checked[payment.target] = true
+					required, err := cli.memoRequired(payment.target)
+					if err != nil {
+						cli.errorWithCode(ExitNetworkError, logFields, "can't load account %s: %v", payment.to, cli.errorString(err))
+						return
+					}
+
+					if required {
+						cli.error(logFields, "row %d: %s requires a memo, use --memo-from-csv (or --skip-memo-check)", payment.row, payment.to)
+						return
+					}
+				}
+			}
+
+			limiter, err := getRateLimiter(cmd)
+			if err != nil {
+				cli.error(logFields, "%v", err)
+				return
+			}
+
+			txs := groupPaymentsByMemo(payments)
+			for i, tx := range txs {
+				opts, err := cli.genTxOptions(cmd, logFields, sourceAddress)
+				if err != nil {
+					cli.error(logFields, "can't generate transaction: %v", err)
+					return
+				}
+
+				if memo := tx[0].memo; memo != "" {
+					if memoType == "id" {
+						id, _ := strconv.ParseUint(memo, 10, 64)
+						opts = opts.WithMemoID(id)
+					} else {
+						opts = opts.WithMemoText(memo)
+					}
+				}
+
+				if delay := limiter.wait(len(tx)); delay > 0 {
+					debugf(logFields, "rate limited: waited %v", delay)
+				}
+
+				// Every account with a payment in the transaction signs it
+				opts = opts.WithSigner(source)
+				signers := map[string]bool{source: true}
+				for _, payment := range tx {
+					if payment.sourceSeed != "" && !signers[payment.sourceSeed] {
+						signers[payment.sourceSeed] = true
+						opts = opts.WithSigner(payment.sourceSeed)
+					}
+				}
+
+				debugf(logFields, "transaction %d of %d: %d payments, memo %q, %d signers", i+1, len(txs), len(tx), tx[0].memo, len(signers))
+				cli.ms.Start(sourceAddress, opts)
+
+				for _, payment := range tx {
+					paymentSource := source
+					if payment.sourceSeed != "" {
+						paymentSource = payment.sourceSeed
+					}
+
+					if err := cli.ms.Pay(paymentSource, payment.target, payment.amount, payment.asset); err != nil {
+						cli.error(logFields, "row %d: can't add payment: %v", payment.row, cli.errorString(err))
+						return
+					}
+				}
+
+				if err := cli.ms.Submit(); err != nil {
+					// Earlier transactions were submitted, so say which rows weren't
+					var rows []string
+					for _, payment := range tx {
+						rows = append(rows, strconv.Itoa(payment.row))
+					}
+
+					cli.errorWithCode(txExitCode(err), logFields, "transaction %d of %d (rows %s) failed: %v",
+						i+1, len(txs), strings.Join(rows, ","), cli.errorString(err))
+					return
+				}
+			}
+		},
+	}
+
+	buildFlagsForTxOptions(cmd)
+	cmd.Flags().String("from", "", "source account seed or name")
+	cmd.Flags().String("memo-from-csv", "", "set each payment's memo from this column")
+	cmd.Flags().String("memo-type", "text", "the type of the memos in --memo-from-csv: text or id")
+	cmd.Flags().String("operation-source", "", "the column with the account each payment is from, if not --from (which still pays the fees), default: source, if there is one")
+	cmd.Flags().Bool("skip-memo-check", false, "pay without a memo, even if a target requires one (SEP-29)")
+	buildRateLimitFlag(cmd, "submit at most this many payments per second, on average")
+	cmd.MarkFlagRequired("from")
+	return cmd
+}
@@ -0,0 +1,170 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Note: add -v to any of these commands to enable verbose logging
+
+func TestBatch(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account new kelly")
+	cli.TestCommand("account new issuer")
+	cli.TestCommand("asset set USD issuer")
+
+	// No batch in progress
+	expectOutput(t, cli, "error", "pay 1 --from mo --to kelly --batch")
+	expectOutput(t, cli, "error", "batch commit")
+	expectOutput(t, cli, "error", "batch abort")
+	expectOutput(t, cli, "error", "batch begin nobody")
+
+	expectOutput(t, cli, "", "batch begin mo")
+	expectOutput(t, cli, "error", "batch begin mo")
+	expectOutput(t, cli, "error", "batch commit")
+
+	expectOutput(t, cli, "", "trust create kelly USD --batch")
+	expectOutput(t, cli, "", "pay 10 USD --from mo --to kelly --batch")
+	expectOutput(t, cli, "error", "pay 10 USD --from mo --to kelly --batch --nosubmit")
+
+	// Invalid commands aren't added to the batch
+	expectOutput(t, cli, "error", "pay 10 USD --from mo --to nobody --batch")
+	expectOutput(t, cli, "error", "pay 10 --from mo --to kelly --create-account --fund --batch")
+
+	got := strings.TrimSpace(cli.TestCommand("batch show"))
+	want := "source: mo\n1: lumen trust create kelly USD\n2: lumen pay 10 USD --from mo --to kelly"
+	if got != want {
+		t.Errorf("wrong batch: want %v, got %v", want, got)
+	}
+
+	expectOutput(t, cli, "", "batch commit --signers kelly")
+	expectOutput(t, cli, "error", "batch show")
+
+	// Abort discards the batch
+	expectOutput(t, cli, "", "batch begin kelly")
+	expectOutput(t, cli, "", "pay 1 --from kelly --to mo --batch")
+	expectOutput(t, cli, "", "batch abort")
+	expectOutput(t, cli, "error", "batch show")
+	expectOutput(t, cli, "error", "batch commit")
+}
+
+func TestBatchPay(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account new kelly")
+	cli.TestCommand("account new issuer")
+	cli.TestCommand("account set viewer GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+	cli.TestCommand("asset set USD issuer")
+
+	writeCSV := func(contents string) string {
+		file, err := ioutil.TempFile("", "lumen-payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		file.WriteString(contents)
+		return file.Name()
+	}
+
+	good := writeCSV("to,amount,asset,ref\nkelly,10,USD,1\nviewer,5,,2\nkelly,1,,1\n")
+	defer os.Remove(good)
+
+	expectOutput(t, cli, "", "batch pay "+good+" --from mo")
+	expectOutput(t, cli, "", "batch pay "+good+" --from mo --memo-from-csv ref")
+	expectOutput(t, cli, "", "batch pay "+good+" --from mo --rate-limit 1000")
+	expectOutput(t, cli, "error", "batch pay "+good+" --from mo --rate-limit -1")
+	expectOutput(t, cli, "", "batch pay "+good+" --from mo --memo-from-csv REF --memo-type id")
+	expectOutput(t, cli, "error", "batch pay "+good+" --from mo --memo-from-csv ref --memo-type hash")
+	expectOutput(t, cli, "error", "batch pay "+good+" --from mo --memo-from-csv ref --memotext hi")
+	expectOutput(t, cli, "error", "batch pay "+good+" --from mo --memo-from-csv nothing")
+	expectOutput(t, cli, "error", "batch pay "+good+" --from viewer")
+	expectOutput(t, cli, "error", "batch pay "+good+" --from mo --batch")
+	expectOutput(t, cli, "error", "batch pay /nonexistent --from mo")
+
+	// Every row is validated before anything is submitted
+	badRows := map[string]string{
+		"nobody,5,1": "text",
+		"kelly,-1,1": "text",
+		"kelly,1,a":  "id",
+		"kelly,1,a very long memo that doesn't fit": "text",
+	}
+
+	for row, memoType := range badRows {
+		bad := writeCSV("to,amount,memo\nkelly,10,1\n" + row + "\n")
+		defer os.Remove(bad)
+		expectOutput(t, cli, "error", "batch pay "+bad+" --from mo --memo-from-csv memo --memo-type "+memoType)
+	}
+
+	noAmounts := writeCSV("to,memo\nkelly,a\n")
+	defer os.Remove(noAmounts)
+	expectOutput(t, cli, "error", "batch pay "+noAmounts+" --from mo")
+
+	// Payments from other accounts, which sign the transaction too
+	sources := writeCSV("to,amount,source,payer\nkelly,10,,kelly\nmo,5,kelly,\nmo,1,issuer,\n")
+	defer os.Remove(sources)
+	expectOutput(t, cli, "", "batch pay "+sources+" --from mo")
+	expectOutput(t, cli, "", "batch pay "+sources+" --from mo --operation-source payer")
+	expectOutput(t, cli, "error", "batch pay "+sources+" --from mo --operation-source nothing")
+
+	payments, err := cli.readCSVPayments(nil, sources, "", "text", "")
+	if err != nil {
+		t.Fatalf("readCSVPayments: %v", err)
+	}
+
+	kellySeed, _ := cli.GetAccount("kelly", "seed")
+	if payments[0].sourceSeed != "" || payments[1].sourceSeed != kellySeed {
+		t.Errorf("want payments from --from, then kelly, got %+v", payments[:2])
+	}
+
+	noSeed := writeCSV("to,amount,source\nkelly,10,viewer\n")
+	defer os.Remove(noSeed)
+	expectOutput(t, cli, "error", "batch pay "+noSeed+" --from mo")
+
+	// Memos on pending batches are set on commit, not per command
+	cli.TestCommand("batch begin mo")
+	expectOutput(t, cli, "error", "pay 1 --from mo --to kelly --memotext hi --batch")
+	expectOutput(t, cli, "", "pay 1 --from mo --to kelly --batch")
+	expectOutput(t, cli, "", "batch commit --memotext hi")
+}
+
+func TestGroupPaymentsByMemo(t *testing.T) {
+	var payments []csvPayment
+	for row := 2; row < 2+2*maxOpsPerTx+1; row++ {
+		memo := ""
+		if row%2 == 0 {
+			memo = "even"
+		}
+
+		payments = append(payments, csvPayment{row: row, memo: memo})
+	}
+
+	txs := groupPaymentsByMemo(payments)
+
+	var sizes []int
+	for _, tx := range txs {
+		sizes = append(sizes, len(tx))
+		for _, payment := range tx {
+			if payment.memo != tx[0].memo {
+				t.Errorf("memos %q and %q in the same transaction", payment.memo, tx[0].memo)
+			}
+		}
+	}
+
+	// 101 even rows (split in two), then 100 odd ones
+	if want := []int{100, 1, 100}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("want transaction sizes %v, got %v", want, sizes)
+	}
+
+	if txs[0][0].memo != "even" || txs[2][0].row != 3 {
+		t.Errorf("transactions out of order: %+v", txs[2][0])
+	}
+}
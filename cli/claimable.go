@@ -0,0 +1,243 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/stellar/go/amount"
+)
+
+// claimPredicate is the condition under which a claimant can claim a balance, as
+// returned by horizon. Relative predicates are converted to absolute ones when the
+// balance is created, so horizon only returns abs_before.
+type claimPredicate struct {
+	Unconditional bool             `json:"unconditional,omitempty"`
+	And           []claimPredicate `json:"and,omitempty"`
+	Or            []claimPredicate `json:"or,omitempty"`
+	Not           *claimPredicate  `json:"not,omitempty"`
+	AbsBefore     string           `json:"abs_before,omitempty"`
+}
+
+// claimableBalance is a claimable balance, as returned by horizon.
+type claimableBalance struct {
+	ID          string `json:"id"`
+	PagingToken string `json:"paging_token"`
+	Asset       string `json:"asset"`
+	Amount      string `json:"amount"`
+	Claimants   []struct {
+		Destination string         `json:"destination"`
+		Predicate   claimPredicate `json:"predicate"`
+	} `json:"claimants"`
+}
+
+// maxOpsPerTx is the largest number of operations the network accepts in one transaction.
+const maxOpsPerTx = 100
+
+// claimBalanceOp returns the XDR-encoded parameters of a claim of the claimable
+// balance with ID id, as horizon returns it: the hex-encoded XDR of the ID's type
+// (always 0), then its hash.
+func claimBalanceOp(id string) ([]byte, error) {
+	raw, err := hex.DecodeString(id)
+	if err != nil || len(raw) != 36 || !bytes.Equal(raw[:4], []byte{0, 0, 0, 0}) {
+		return nil, errors.Errorf("bad claimable balance ID: %s", id)
+	}
+
+	return raw, nil
+}
+
+// satisfied returns true if the predicate holds at time now. Predicates that lumen
+// doesn't understand never hold.
+func (p claimPredicate) satisfied(now time.Time) bool {
+	switch {
+	case p.Unconditional:
+		return true
+	case len(p.And) > 0:
+		for _, q := range p.And {
+			if !q.satisfied(now) {
+				return false
+			}
+		}
+		return true
+	case len(p.Or) > 0:
+		for _, q := range p.Or {
+			if q.satisfied(now) {
+				return true
+			}
+		}
+		return false
+	case p.Not != nil:
+		return !p.Not.satisfied(now)
+	case p.AbsBefore != "":
+		before, err := time.Parse(time.RFC3339, p.AbsBefore)
+		return err == nil && now.Before(before)
+	}
+
+	return false
+}
+
+// canClaim returns true if address can claim the balance at time now.
+func (balance claimableBalance) canClaim(address string, now time.Time) bool {
+	for _, claimant := range balance.Claimants {
+		if claimant.Destination == address && claimant.Predicate.satisfied(now) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadClaimableBalances returns all the claimable balances that list address as a
+// claimant, whether or not it can claim them yet.
+func (cli *CLI) loadClaimableBalances(logFields logrus.Fields, address string) ([]claimableBalance, error) {
+	balances := []claimableBalance{}
+	cursor := ""
+
+	for {
+		query := url.Values{}
+		query.Set("claimant", address)
+		query.Set("limit", strconv.Itoa(maxPageSize))
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+
+		var page struct {
+			Embedded struct {
+				Records []claimableBalance `json:"records"`
+			} `json:"_embedded"`
+		}
+
+		if err := cli.getHorizonJSON(logFields, "/claimable_balances?"+query.Encode(), &page); err != nil {
+			return nil, err
+		}
+
+		records := page.Embedded.Records
+		debugf(logFields, "got %d claimable balances after cursor %q", len(records), cursor)
+		balances = append(balances, records...)
+
+		if len(records) < maxPageSize {
+			break
+		}
+
+		cursor = records[len(records)-1].PagingToken
+	}
+
+	return balances, nil
+}
+
+func (cli *CLI) buildClaimableCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "claimable [sweep]",
+		Short: "manage claimable balances",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cli.error(logrus.Fields{"cmd": "claimable"}, "unrecognized claimable command: %s, expecting: sweep", args[0])
+		},
+	}
+
+	cmd.AddCommand(cli.buildClaimableSweepCmd())
+	return cmd
+}
+
+func (cli *CLI) buildClaimableSweepCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sweep [account]",
+		Short: "claim all the claimable balances that [account] can claim now, and show the totals per asset",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "claimable", "subcmd": "sweep"}
+			name := args[0]
+
+			// The claims are sourced from (and by default signed by) the account
+			tx, seeds, err := cli.newRawTx(cmd, logFields, name)
+			if err != nil {
+				cli.error(logFields, "can't generate claim transaction: %v", err)
+				return
+			}
+			address := tx.source
+
+			balances, err := cli.loadClaimableBalances(logFields, address)
+			if err != nil {
+				cli.errorWithCode(ExitNetworkError, logFields, "can't load claimable balances for %s: %v", name, cli.errorString(err))
+				return
+			}
+
+			// Total up what's claimable per asset, in the order the assets were seen
+			now := time.Now()
+			var assets []string
+			totals := map[string]int64{}
+			counts := map[string]int{}
+			var claims [][]byte
+
+			for _, balance := range balances {
+				if !balance.canClaim(address, now) {
+					debugf(logFields, "can't claim %s yet", balance.ID)
+					continue
+				}
+
+				amt, err := amount.ParseInt64(balance.Amount)
+				if err != nil {
+					cli.errorWithCode(ExitNetworkError, logFields, "bad amount for claimable balance %s: %s", balance.ID, balance.Amount)
+					return
+				}
+
+				claim, err := claimBalanceOp(balance.ID)
+				if err != nil {
+					cli.errorWithCode(ExitNetworkError, logFields, "%v", err)
+					return
+				}
+
+				if _, ok := totals[balance.Asset]; !ok {
+					assets = append(assets, balance.Asset)
+				}
+
+				totals[balance.Asset] += amt
+				counts[balance.Asset]++
+				claims = append(claims, claim)
+			}
+
+			if len(claims) == 0 {
+				debugf(logFields, "nothing to claim for %s (%d claimable balances)", address, len(balances))
+				return
+			}
+
+			for _, asset := range assets {
+				showSuccess("%s %s (%d balances)", amount.StringFromInt64(totals[asset]), asset, counts[asset])
+			}
+
+			current, err := cli.currentSequence(address, tx.params)
+			if err != nil {
+				cli.errorWithCode(ExitNetworkError, logFields, "can't load sequence number of %s: %v", name, cli.errorString(err))
+				return
+			}
+
+			// Claim in as few transactions as possible, each with the next sequence number
+			for start := 0; start < len(claims); start += maxOpsPerTx {
+				end := start + maxOpsPerTx
+				if end > len(claims) {
+					end = len(claims)
+				}
+
+				current++
+				claimTx := &rawTx{source: address, seq: current, params: tx.params}
+				for _, claim := range claims[start:end] {
+					claimTx.addOp(opClaimClaimableBalance, claim)
+				}
+
+				debugf(logFields, "claiming balances %d to %d of %d for %s", start+1, end, len(claims), address)
+				if err := cli.submitRawTx(logFields, claimTx, seeds); err != nil {
+					cli.errorWithCode(txExitCode(err), logFields, "failed to claim balances for %s (%d of %d claimed): %v", name, start, len(claims), cli.errorString(err))
+					return
+				}
+			}
+		},
+	}
+
+	buildFlagsForTxParams(cmd)
+	return cmd
+}
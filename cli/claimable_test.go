@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClaimPredicates(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	past := claimPredicate{AbsBefore: "2020-01-01T00:00:00Z"}
+	future := claimPredicate{AbsBefore: "2021-01-01T00:00:00Z"}
+
+	tests := []struct {
+		predicate claimPredicate
+		want      bool
+	}{
+		{claimPredicate{Unconditional: true}, true},
+		{claimPredicate{}, false},
+		{past, false},
+		{future, true},
+		{claimPredicate{AbsBefore: "soon"}, false},
+		{claimPredicate{Not: &past}, true},
+		{claimPredicate{Not: &future}, false},
+		{claimPredicate{And: []claimPredicate{future, {Not: &past}}}, true},
+		{claimPredicate{And: []claimPredicate{future, past}}, false},
+		{claimPredicate{Or: []claimPredicate{past, future}}, true},
+		{claimPredicate{Or: []claimPredicate{past, {Not: &future}}}, false},
+	}
+
+	for i, test := range tests {
+		if got := test.predicate.satisfied(now); got != test.want {
+			t.Errorf("predicate %d (%+v): want %v, got %v", i, test.predicate, test.want, got)
+		}
+	}
+}
+
+func TestClaimableSweep(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account set viewer GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+	address := strings.TrimSpace(cli.TestCommand("account address mo"))
+
+	expectOutput(t, cli, "", "claimable sweep mo")
+	expectOutput(t, cli, "error", "claimable sweep nobody")
+	expectOutput(t, cli, "error", "claimable nothing")
+
+	// 101 claimable native balances (two transactions' worth), one USD balance, one
+	// that expired, and one for someone else
+	var queries []string
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+
+		claimant := func(destination, predicate string) string {
+			return fmt.Sprintf(`{"destination": "%s", "predicate": %s}`, destination, predicate)
+		}
+
+		balance := func(id int, asset, amount string, claimants ...string) string {
+			return fmt.Sprintf(`{"id": "00000000%064x", "paging_token": "%d", "asset": "%s", "amount": "%s", "claimants": [%s]}`,
+				id, id, asset, amount, strings.Join(claimants, ","))
+		}
+
+		records := []string{}
+		if r.URL.Query().Get("cursor") == "" {
+			for i := 1; i <= 101; i++ {
+				records = append(records, balance(i, "native", "1.5000000", claimant(address, `{"unconditional": true}`)))
+			}
+
+			records = append(records,
+				balance(102, "USD:GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM", "10.0000000",
+					claimant("GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM", `{"unconditional": true}`),
+					claimant(address, `{"not": {"abs_before": "2000-01-01T00:00:00Z"}}`)),
+				balance(103, "native", "100.0000000", claimant(address, `{"abs_before": "2000-01-01T00:00:00Z"}`)),
+				balance(104, "native", "100.0000000", claimant("GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM", `{"unconditional": true}`)))
+		}
+
+		fmt.Fprintf(w, `{"_embedded": {"records": [%s]}}`, strings.Join(records, ","))
+	})
+	defer server.Close()
+
+	// Submitted (and fails, since there's no real horizon)
+	got := cli.TestCommand("claimable sweep mo --sequence 5")
+	want := "151.5000000 native (101 balances)\n" +
+		"10.0000000 USD:GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM (1 balances)\n" +
+		"error\n"
+	if got != want {
+		t.Errorf("want totals:\n%s\ngot:\n%s", want, got)
+	}
+
+	if len(queries) == 0 || !strings.Contains(queries[0], "claimant="+address) {
+		t.Errorf("want a query for %s's balances, got %v", address, queries)
+	}
+
+	// 102 claims, in two transactions with consecutive sequence numbers
+	lines := strings.Split(strings.TrimSpace(cli.TestCommand("claimable sweep mo --sequence 5 --nosubmit")), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("want totals and two transactions, got %q", lines)
+	}
+
+	for i, want := range []struct{ seq, ops int }{{5, 100}, {6, 2}} {
+		raw, _ := base64.StdEncoding.DecodeString(lines[2+i])
+		if len(raw) < 60 || binary.BigEndian.Uint64(raw[40:48]) != uint64(want.seq) || binary.BigEndian.Uint32(raw[56:60]) != uint32(want.ops) {
+			t.Errorf("want sequence number %d and %d claims, got %q", want.seq, want.ops, lines[2+i])
+		}
+	}
+
+	claim, _ := claimBalanceOp(fmt.Sprintf("00000000%064x", 102))
+	if raw, _ := base64.StdEncoding.DecodeString(lines[3]); !bytes.Contains(raw, append([]byte{0, 0, 0, 0, 0, 0, 0, 15}, claim...)) {
+		t.Errorf("want claim of balance 102, got %q", lines[3])
+	}
+
+	// Can't claim without a seed
+	queries = nil
+	expectOutput(t, cli, "error", "claimable sweep viewer")
+	if len(queries) != 0 {
+		t.Errorf("want no queries without a seed, got %v", queries)
+	}
+
+	for _, id := range []string{"", "nothex", "00000000", "00000001" + strings.Repeat("ab", 32)} {
+		if _, err := claimBalanceOp(id); err == nil {
+			t.Errorf("want error for claimable balance ID %q", id)
+		}
+	}
+}
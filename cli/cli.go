@@ -4,11 +4,16 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/0xfe/lumen/store"
 	"github.com/0xfe/microstellar"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -16,25 +21,44 @@ import (
 // CLI represents a command-line interface. This class is
 // not threadsafe.
 type CLI struct {
-	store       store.API
-	ms          *microstellar.MicroStellar
-	ns          string // namespace
-	rootCmd     *cobra.Command
-	version     string
-	testing     bool
-	stopWatcher func()
+	store          store.API
+	ms             *microstellar.MicroStellar
+	network        string // network spec, e.g., test, public, or custom;url;passphrase
+	ns             string // namespace
+	rootCmd        *cobra.Command
+	version        string
+	testing        bool
+	exitCode       int      // exit code of the last command
+	args           []string // arguments of the current command
+	replaying      bool     // replaying a batch, see: batch commit
+	interactive    bool     // running commands in the repl, so errors don't exit
+	horizonTimeout time.Duration
+	output         *os.File // --output file, if set
+	stdout         *os.File // the real stdout, while writing to output
+	stopWatcher    func()
+	submitted      string            // the last transaction submitted by the current command
+	submittedID    string            // and its hash, if lumen encoded it, see: rawTx
+	batching       bool              // the current command runs with --batch, see: recordBatched
+	batchBuilt     bool              // and its transaction was built and signed
+	networkChecked bool              // horizon's network passphrase was checked for the current command
+	seeds          map[string]string // decrypted seeds by account name, for the current command
+	httpClient     *http.Client      // for the current command's requests to horizon, see: setupHTTPClient
+	restoreHTTP    func()            // undoes lendHTTPClient
 }
 
 // NewCLI returns an initialized CLI
 func NewCLI() *CLI {
 	cli := &CLI{
-		store:       nil,
-		ms:          nil,
-		ns:          "",
-		rootCmd:     nil,
-		version:     "v0.0",
-		testing:     false,
-		stopWatcher: func() {},
+		store:          nil,
+		ms:             nil,
+		network:        "",
+		ns:             "",
+		rootCmd:        nil,
+		version:        "v0.0",
+		testing:        false,
+		exitCode:       0,
+		horizonTimeout: DefaultHorizonTimeout,
+		stopWatcher:    func() {},
 	}
 
 	cli.buildRootCmd()
@@ -43,7 +67,14 @@ func NewCLI() *CLI {
 
 // Execute parses the command line and processes it.
 func (cli *CLI) Execute() {
-	cli.rootCmd.Execute()
+	cli.args = os.Args[1:]
+	err := cli.rootCmd.Execute()
+	cli.returnHTTPClient()
+	cli.closeOutput()
+
+	if err != nil {
+		os.Exit(ExitBadArgs)
+	}
 }
 
 // SetStore lets you set the data store (used for testing.)
@@ -66,9 +97,7 @@ func (cli *CLI) Run(args ...string) string {
 
 	os.Stdout = w
 
-	cli.rootCmd.SetArgs(args)
-	cli.rootCmd.Execute()
-	cli.buildRootCmd()
+	cli.execute(args)
 
 	w.Close()
 
@@ -79,6 +108,25 @@ func (cli *CLI) Run(args ...string) string {
 	return stdOut.String()
 }
 
+// execute runs the command in args, and resets the command tree for the next one.
+func (cli *CLI) execute(args []string) {
+	cli.exitCode = 0
+	cli.submitted = ""
+	cli.submittedID = ""
+	cli.batching = false
+	cli.batchBuilt = false
+	cli.networkChecked = false
+	cli.seeds = nil
+	cli.args = args
+	cli.rootCmd.SetArgs(args)
+	if err := cli.rootCmd.Execute(); err != nil {
+		cli.exitCode = ExitBadArgs
+	}
+	cli.returnHTTPClient()
+	cli.closeOutput()
+	cli.buildRootCmd()
+}
+
 // RunCommand is a helper that lets you send a full command line to Run, so you don't
 // have to break up your arguments.
 func (cli *CLI) RunCommand(command string) string {
@@ -95,6 +143,12 @@ func (cli *CLI) TestCommand(command string) string {
 	return result
 }
 
+// ExitCode returns the exit code of the last command executed with Run, or
+// 0 if it succeeded. See ExitBadArgs and friends.
+func (cli *CLI) ExitCode() int {
+	return cli.exitCode
+}
+
 // Stop an existing watcher from streaming.
 func (cli *CLI) StopWatcher() {
 	cli.stopWatcher()
@@ -134,6 +188,23 @@ func (cli *CLI) DelVar(key string) error {
 	return cli.store.Delete(key)
 }
 
+// ListVars returns the keys (without the namespace) in the current namespace that
+// start with prefix.
+func (cli *CLI) ListVars(prefix string) ([]string, error) {
+	nsPrefix := fmt.Sprintf("%s:", cli.ns)
+	logrus.WithFields(logrus.Fields{"type": "cli", "method": "ListVars"}).Debugf("listing %s%s", nsPrefix, prefix)
+	keys, err := cli.store.Keys(nsPrefix + prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range keys {
+		keys[i] = strings.TrimPrefix(keys[i], nsPrefix)
+	}
+
+	return keys, nil
+}
+
 // setup turns up the CLI environment, and gets called by Cobra before
 // a command is executed.
 func (cli *CLI) setup(cmd *cobra.Command, args []string) {
@@ -142,11 +213,8 @@ func (cli *CLI) setup(cmd *cobra.Command, args []string) {
 		logrus.SetOutput(buf)
 	}
 
-	if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
-		logrus.SetOutput(os.Stderr)
-		logrus.SetLevel(logrus.DebugLevel)
-		logrus.SetFormatter(&logrus.TextFormatter{})
-	}
+	verbose, _ := cmd.Flags().GetBool("verbose")
+	cli.setupLogging(cmd, verbose)
 
 	env := os.Getenv("LUMEN_ENV")
 	if env != "" {
@@ -158,10 +226,8 @@ func (cli *CLI) setup(cmd *cobra.Command, args []string) {
 	config := readConfig(env)
 
 	// Do this again if the configuration file says so
-	if config.verbose {
-		logrus.SetOutput(os.Stderr)
-		logrus.SetLevel(logrus.DebugLevel)
-		logrus.SetFormatter(&logrus.TextFormatter{})
+	if config.verbose && !verbose {
+		cli.setupLogging(cmd, true)
 	}
 
 	logrus.WithFields(logrus.Fields{"type": "setup"}).Debugf("using storage driver %s with %s", config.storageDriver, config.storageParams)
@@ -169,6 +235,103 @@ func (cli *CLI) setup(cmd *cobra.Command, args []string) {
 	cli.setupStore(config.storageDriver, config.storageParams)
 	cli.setupNameSpace()
 	cli.setupNetwork()
+	cli.setupHTTPClient(cmd)
+	cli.setupOutput()
+}
+
+// teardown gets called by Cobra after a command is executed, unless it failed outside
+// of the repl or tests (which exits.)
+func (cli *CLI) teardown(cmd *cobra.Command, args []string) {
+	cli.recordBatched(logrus.Fields{"type": "batch"})
+	cli.waitForSubmitted(cmd, args)
+}
+
+// setupLogging configures the diagnostic logs (not the command output) from
+// --log-level and --log-format. Verbose is the same as --log-level debug, which
+// also sends the logs to stderr in tests. An explicit --log-level wins over -v.
+func (cli *CLI) setupLogging(cmd *cobra.Command, verbose bool) {
+	logFields := logrus.Fields{"type": "setup"}
+
+	level := logrus.GetLevel()
+	if verbose {
+		level = logrus.DebugLevel
+	}
+
+	if spec, _ := cmd.Flags().GetString("log-level"); spec != "" {
+		if parsed, err := logrus.ParseLevel(spec); err != nil {
+			showError(logFields, "bad log level %s, expecting: panic|fatal|error|warn|info|debug", spec)
+		} else {
+			level = parsed
+		}
+	}
+
+	if level == logrus.DebugLevel {
+		logrus.SetOutput(os.Stderr)
+	}
+
+	logrus.SetLevel(level)
+
+	switch format, _ := cmd.Flags().GetString("log-format"); format {
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	case "text":
+		logrus.SetFormatter(&logrus.TextFormatter{})
+	case "":
+		// Keep the current format
+	default:
+		showError(logFields, "bad log format %s, expecting: text|json", format)
+	}
+}
+
+// setupOutput redirects stdout to the file in --output, if set. Errors and logs go to
+// stderr, so they're not written to the file.
+func (cli *CLI) setupOutput() {
+	if !cli.rootCmd.Flag("output").Changed {
+		return
+	}
+
+	fileName, _ := cli.rootCmd.Flags().GetString("output")
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		cli.error(logrus.Fields{"type": "setup"}, "can't open output file: %v", err)
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{"type": "setup"}).Debugf("writing output to %s", fileName)
+	cli.stdout = os.Stdout
+	cli.output = file
+	os.Stdout = file
+}
+
+// closeOutput closes the --output file, if any, and restores stdout.
+func (cli *CLI) closeOutput() {
+	if cli.output == nil {
+		return
+	}
+
+	os.Stdout = cli.stdout
+	cli.output.Close()
+	cli.output = nil
+}
+
+// terminal returns the real stdout, even if it's redirected with --output.
+func (cli *CLI) terminal() *os.File {
+	if cli.output != nil {
+		return cli.stdout
+	}
+
+	return os.Stdout
+}
+
+// parseStoreSpec splits a store spec (e.g., file,/path/to/file) into its driver and
+// parameters, see --store.
+func parseStoreSpec(spec string) (string, string) {
+	parts := strings.Split(spec, ",")
+	if len(parts) > 1 {
+		return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	}
+
+	return strings.TrimSpace(parts[0]), ""
 }
 
 // setupStore sets up the storage backend.
@@ -180,13 +343,7 @@ func (cli *CLI) setupStore(driver, params string) {
 
 	parseStoreParams := func(store string) {
 		logrus.WithFields(logrus.Fields{"type": "setup"}).Debugf("using store %s", store)
-		parts := strings.Split(store, ",")
-		driver = strings.TrimSpace(parts[0])
-		if len(parts) > 1 {
-			params = strings.TrimSpace(parts[1])
-		} else {
-			params = ""
-		}
+		driver, params = parseStoreSpec(store)
 		logrus.WithFields(logrus.Fields{"type": "setup"}).Debugf("selecting store driver: %s params: %s", driver, params)
 	}
 
@@ -236,16 +393,193 @@ func (cli *CLI) setupNameSpace() {
 
 // setupNetwork ensures that lumen is operating on the correct network.
 func (cli *CLI) setupNetwork() {
+	if cli.replaying {
+		// Keep the multi-op transaction of the batch being committed
+		return
+	}
+
 	if cli.rootCmd.Flag("network").Changed {
 		network, _ := cli.rootCmd.Flags().GetString("network")
 		logrus.WithFields(logrus.Fields{"type": "setup"}).Debugf("using horizon network: %s", network)
-		cli.ms = microstellar.NewFromSpec(network)
+		cli.network = network
 	} else {
 		network, err := cli.GetVar("vars:config:network")
 		if err != nil {
-			cli.ms = microstellar.NewFromSpec("test")
+			cli.network = "test"
 		} else {
-			cli.ms = microstellar.NewFromSpec(network)
+			cli.network = network
+		}
+	}
+
+	cli.ms = microstellar.NewFromSpec(cli.network)
+}
+
+// DefaultHorizonTimeout is used when neither --horizon-timeout nor config:horizon_timeout
+// is set.
+const DefaultHorizonTimeout = 30 * time.Second
+
+// DefaultHorizonRetries is used when neither --horizon-retries nor config:horizon_retries
+// is set.
+const DefaultHorizonRetries = 3
+
+// setupHTTPClient sets up a new HTTP client for the command's requests to horizon,
+// which bounds them, and retries transient failures. Streaming commands (watch) only
+// bound connection setup, so long-lived streams aren't killed.
+func (cli *CLI) setupHTTPClient(cmd *cobra.Command) {
+	logFields := logrus.Fields{"type": "setup"}
+
+	timeout := DefaultHorizonTimeout
+	if spec := cli.getSetting("horizon-timeout", "horizon_timeout"); spec != "" {
+		var err error
+		timeout, err = parseTimeout(spec)
+		if err != nil {
+			showError(logFields, "bad horizon timeout %s, using %v", spec, DefaultHorizonTimeout)
+			timeout = DefaultHorizonTimeout
+		}
+	}
+
+	retries := DefaultHorizonRetries
+	if spec := cli.getSetting("horizon-retries", "horizon_retries"); spec != "" {
+		val, err := strconv.ParseUint(spec, 10, 8)
+		if err != nil {
+			showError(logFields, "bad horizon retry count %s, using %v", spec, DefaultHorizonRetries)
+		} else {
+			retries = int(val)
+		}
+	}
+
+	cli.horizonTimeout = timeout
+	cli.httpClient = &http.Client{Timeout: timeout}
+	defer cli.lendHTTPClient()
+
+	if offline, _ := cli.rootCmd.Flags().GetBool("offline"); offline {
+		logrus.WithFields(logFields).Debugf("offline mode, all requests to horizon will fail")
+		cli.httpClient.Transport = offlineTransport{}
+		return
+	}
+
+	streaming := isStreamingCmd(cmd)
+	logrus.WithFields(logFields).Debugf("horizon timeout: %v, retries: %d (streaming: %v)", timeout, retries, streaming)
+
+	var transport http.RoundTripper = &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+	}
+
+	// Inside the retries, so that each attempt is traced
+	if trace, _ := cli.rootCmd.Flags().GetBool("trace"); trace {
+		transport = &traceTransport{transport: transport, out: os.Stderr}
+	}
+
+	cli.httpClient.Transport = &retryTransport{
+		transport: transport,
+		retries:   retries,
+		baseDelay: 500 * time.Millisecond,
+		maxDelay:  timeout,
+	}
+
+	// Outside the retries, so that each transaction is only written once
+	if verboseXDR, _ := cli.rootCmd.Flags().GetBool("verbose-xdr"); verboseXDR {
+		cli.httpClient.Transport = &xdrTransport{transport: cli.httpClient.Transport, out: os.Stderr}
+	}
+
+	if streaming {
+		cli.httpClient.Timeout = 0
+	}
+}
+
+// horizonClient returns the HTTP client for requests to horizon that lumen makes
+// itself. Outside of commands (e.g., in tests), that's http.DefaultClient.
+func (cli *CLI) horizonClient() *http.Client {
+	if cli.httpClient == nil {
+		return http.DefaultClient
+	}
+
+	return cli.httpClient
+}
+
+// lendHTTPClient sets up http.DefaultClient like the command's own client until the
+// command is done (see: returnHTTPClient), since the horizon clients used by
+// microstellar are hard-wired to it.
+func (cli *CLI) lendHTTPClient() {
+	if cli.restoreHTTP != nil {
+		cli.restoreHTTP()
+	}
+
+	transport, timeout := http.DefaultClient.Transport, http.DefaultClient.Timeout
+	cli.restoreHTTP = func() {
+		http.DefaultClient.Transport, http.DefaultClient.Timeout = transport, timeout
+	}
+
+	http.DefaultClient.Transport, http.DefaultClient.Timeout = cli.httpClient.Transport, cli.httpClient.Timeout
+}
+
+// useSequence answers microstellar's lookups of address's account with sequence (see
+// sequenceTransport), on http.DefaultClient, until the command is done. Lumen's own
+// requests aren't affected.
+func (cli *CLI) useSequence(address string, sequence uint64) {
+	transport := http.DefaultTransport
+	if cli.httpClient != nil && cli.httpClient.Transport != nil {
+		transport = cli.httpClient.Transport
+	}
+
+	http.DefaultClient.Transport = &sequenceTransport{transport: transport, address: address, sequence: sequence}
+}
+
+// returnHTTPClient restores http.DefaultClient after lendHTTPClient, once the command
+// is done with its client.
+func (cli *CLI) returnHTTPClient() {
+	if cli.restoreHTTP != nil {
+		cli.restoreHTTP()
+		cli.restoreHTTP = nil
+	}
+
+	cli.httpClient = nil
+}
+
+// getSetting returns the value of the global flag --flagName if set, or the
+// value of config:configKey in the current namespace. Returns "" if neither is set.
+func (cli *CLI) getSetting(flagName, configKey string) string {
+	logFields := logrus.Fields{"type": "setup"}
+
+	if cli.rootCmd.Flag(flagName).Changed {
+		val, _ := cli.rootCmd.Flags().GetString(flagName)
+		logrus.WithFields(logFields).Debugf("using %s from flag --%s", val, flagName)
+		return val
+	}
+
+	if val, err := cli.GetVar("vars:config:" + configKey); err == nil {
+		logrus.WithFields(logFields).Debugf("using %s from config:%s", val, configKey)
+		return val
+	}
+
+	return ""
+}
+
+// parseTimeout accepts Go durations (e.g., 10s, 1m30s), or plain seconds. A timeout of
+// 0 disables it.
+func parseTimeout(spec string) (time.Duration, error) {
+	if secs, err := strconv.ParseUint(spec, 10, 64); err == nil {
+		return time.Duration(secs) * time.Second, nil
+	}
+
+	timeout, err := time.ParseDuration(spec)
+	if err != nil || timeout < 0 {
+		return 0, errors.Errorf("invalid timeout: %s", spec)
+	}
+
+	return timeout, nil
+}
+
+// isStreamingCmd returns true if cmd holds a long-lived connection to horizon.
+func isStreamingCmd(cmd *cobra.Command) bool {
+	for c := cmd; c != nil; c = c.Parent() {
+		if c.Name() == "watch" {
+			return true
 		}
 	}
+
+	return false
 }
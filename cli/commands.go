@@ -2,6 +2,7 @@ package cli
 
 import (
 	"fmt"
+	"time"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
@@ -14,19 +15,38 @@ func (cli *CLI) buildRootCmd() {
 	}
 
 	rootCmd := &cobra.Command{
-		Use:              "lumen",
-		Short:            "Lumen is a commandline client for the Stellar blockchain",
-		Run:              cli.help,
-		PersistentPreRun: cli.setup,
+		Use:               "lumen",
+		Short:             "Lumen is a commandline client for the Stellar blockchain",
+		Run:               cli.help,
+		PersistentPreRun:  cli.setup,
+		PersistentPostRun: cli.teardown,
 	}
 	cli.rootCmd = rootCmd
 
 	home, _ := homedir.Dir()
 
 	// Global flags
-	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "verbose output (false)")
+	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "verbose output, same as --log-level debug (false)")
+	rootCmd.PersistentFlags().String("log-level", "", "log level: panic, fatal, error, warn, info, or debug (info)")
+	rootCmd.PersistentFlags().String("log-format", "", "log format, separate from command output: text or json (text)")
 	rootCmd.PersistentFlags().Bool("nosubmit", false, "display transaction without submitting")
+	rootCmd.PersistentFlags().Bool("offline", false, "fail any request to horizon, for air-gapped use (false)")
+	rootCmd.PersistentFlags().Bool("trace", false, "write each request to horizon, and its response, to stderr (false)")
+	rootCmd.PersistentFlags().Bool("verbose-xdr", false, "write the base64-encoded XDR of each transaction to stderr as it's submitted (false)")
+	rootCmd.PersistentFlags().BoolP("yes", "y", false, "don't ask for confirmation before destructive operations")
+	rootCmd.PersistentFlags().Bool("no-confirm", false, "same as --yes")
 	rootCmd.PersistentFlags().String("network", "test", "network to use (test)")
+	rootCmd.PersistentFlags().String("confirm-network", "", "refuse to submit transactions unless the network is this one: test, public, custom, or fake")
+	rootCmd.PersistentFlags().Bool("wait", false, "after submitting, wait until the transaction is in a closed ledger, and print the ledger (false)")
+	rootCmd.PersistentFlags().Duration("wait-timeout", 60*time.Second, "how long --wait waits before reporting the transaction as pending")
+	rootCmd.PersistentFlags().Bool("fee-account-balance-check", false, "before submitting, check that the transaction's source account can cover its fee (false)")
+	rootCmd.PersistentFlags().Bool("skip-network-passphrase-check", false, "don't check that horizon is on the network transactions are signed for before submitting (false)")
+	rootCmd.PersistentFlags().Bool("network-passphrase-check", true, "before submitting, check that horizon is on the network transactions are signed for (true)")
+	rootCmd.PersistentFlags().MarkDeprecated("network-passphrase-check", "it's on by default, use --skip-network-passphrase-check to turn it off")
+	rootCmd.PersistentFlags().String("output", "", "write command output to this file instead of stdout")
+	rootCmd.PersistentFlags().String("horizon-timeout", "30s", "timeout for requests to horizon, 0 to disable (30s)")
+	rootCmd.PersistentFlags().String("horizon-retries", "3", "retries for rate-limited or unavailable horizon requests (3)")
+	rootCmd.PersistentFlags().String("base-reserve-override", "", "base reserve in XLM, for networks where horizon doesn't report it (from the latest ledger)")
 	rootCmd.PersistentFlags().String("ns", "default", "namespace to use (default)")
 	rootCmd.PersistentFlags().String("store", fmt.Sprintf("file:%s/.lumen-data.yml", home), "namespace to use (default)")
 
@@ -36,23 +56,41 @@ func (cli *CLI) buildRootCmd() {
 	rootCmd.AddCommand(cli.buildSetCmd())     // set
 	rootCmd.AddCommand(cli.buildGetCmd())     // get
 	rootCmd.AddCommand(cli.buildDelCmd())     // del
+	rootCmd.AddCommand(cli.buildStoreCmd())   // store
+
+	// Backup commands
+	rootCmd.AddCommand(cli.buildExportAccountsCmd()) // export-accounts
+	rootCmd.AddCommand(cli.buildImportAccountsCmd()) // import-accounts
 
 	// Core commands
-	rootCmd.AddCommand(cli.buildPayCmd())    // pay
-	rootCmd.AddCommand(cli.buildTrustCmd())  // trust
-	rootCmd.AddCommand(cli.buildSignerCmd()) // signer
-	rootCmd.AddCommand(cli.buildDexCmd())    // dex
-	rootCmd.AddCommand(cli.buildTxCmd())     // tx
+	rootCmd.AddCommand(cli.buildPayCmd())       // pay
+	rootCmd.AddCommand(cli.buildTrustCmd())     // trust
+	rootCmd.AddCommand(cli.buildSignerCmd())    // signer
+	rootCmd.AddCommand(cli.buildDexCmd())       // dex
+	rootCmd.AddCommand(cli.buildPoolCmd())      // pool
+	rootCmd.AddCommand(cli.buildClaimableCmd()) // claimable
+	rootCmd.AddCommand(cli.buildTxCmd())        // tx
+	rootCmd.AddCommand(cli.buildBatchCmd())     // batch
+	rootCmd.AddCommand(cli.buildInvoiceCmd())   // invoice
+	rootCmd.AddCommand(cli.buildURICmd())       // uri
 
 	// Aux commands
-	rootCmd.AddCommand(cli.buildFriendbotCmd()) // friendbot
-	rootCmd.AddCommand(cli.buildInfoCmd())      // info
-	rootCmd.AddCommand(cli.buildBalanceCmd())   // balance
-	rootCmd.AddCommand(cli.buildWatchCmd())     // watch
-	rootCmd.AddCommand(cli.buildFlagsCmd())     // flags
-	rootCmd.AddCommand(cli.buildDataCmd())      // data
+	rootCmd.AddCommand(cli.buildFriendbotCmd())  // friendbot
+	rootCmd.AddCommand(cli.buildTestnetCmd())    // testnet
+	rootCmd.AddCommand(cli.buildInfoCmd())       // info
+	rootCmd.AddCommand(cli.buildBalanceCmd())    // balance
+	rootCmd.AddCommand(cli.buildWatchCmd())      // watch
+	rootCmd.AddCommand(cli.buildFlagsCmd())      // flags
+	rootCmd.AddCommand(cli.buildDataCmd())       // data
+	rootCmd.AddCommand(cli.buildAddressCmd())    // address
+	rootCmd.AddCommand(cli.buildDecodeXDRCmd())  // decode-xdr
+	rootCmd.AddCommand(cli.buildCompletionCmd()) // completion
+	rootCmd.AddCommand(cli.buildREPLCmd())       // repl
 
 	// Alias commands
 	rootCmd.AddCommand(cli.buildAccountCmd()) // account
 	rootCmd.AddCommand(cli.buildAssetCmd())   // asset
+
+	// Hidden commands
+	rootCmd.AddCommand(cli.buildCompleteWordsCmd()) // __complete-words
 }
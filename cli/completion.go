@@ -0,0 +1,347 @@
+package cli
+
+import (
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// The completion scripts call back into lumen (with __complete-words) to get their
+// candidates, so every shell gets the same completions, including the account and
+// asset aliases in the store. The last argument is the word being completed.
+const bashCompletion = `# bash completion for lumen. Add this to your ~/.bashrc:
+#   source <(lumen completion bash)
+_lumen_completions() {
+    local IFS=$'\n'
+    COMPREPLY=( $(lumen __complete-words "${COMP_WORDS[@]:1:$((COMP_CWORD-1))}" "${COMP_WORDS[COMP_CWORD]}" 2>/dev/null) )
+}
+
+complete -o default -F _lumen_completions lumen`
+
+const zshCompletion = `#compdef lumen
+# zsh completion for lumen. Add this to your ~/.zshrc (after compinit):
+#   source <(lumen completion zsh)
+_lumen() {
+    local -a completions
+    completions=(${(f)"$(lumen __complete-words "${(@)words[2,$((CURRENT-1))]}" "${words[CURRENT]}" 2>/dev/null)"})
+
+    if (( ${#completions} == 0 )); then
+        _files
+    else
+        compadd -a completions
+    fi
+}
+
+if [ "$funcstack[1]" = "_lumen" ]; then
+    _lumen "$@"
+else
+    compdef _lumen lumen
+fi`
+
+const fishCompletion = `# fish completion for lumen. Save this as ~/.config/fish/completions/lumen.fish:
+#   lumen completion fish > ~/.config/fish/completions/lumen.fish
+function __lumen_complete
+    set -l tokens (commandline -opc)
+    set -e tokens[1]
+    lumen __complete-words $tokens (commandline -ct) 2>/dev/null
+end
+
+complete -c lumen -f -a '(__lumen_complete)'`
+
+// Windows PowerShell drops empty arguments to native commands, so an empty word
+// is sent as "" (two quote characters.)
+const powershellCompletion = `# powershell completion for lumen. Add this to your $PROFILE:
+#   lumen completion powershell | Out-String | Invoke-Expression
+Register-ArgumentCompleter -Native -CommandName lumen -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+
+    $words = @($commandAst.CommandElements | Select-Object -Skip 1 |
+        Where-Object { $_.Extent.EndOffset -lt $cursorPosition } | ForEach-Object { $_.ToString() })
+
+    $current = $wordToComplete
+    if ($current -eq '') {
+        $current = '""'
+    }
+
+    & lumen __complete-words @words $current 2>$null | ForEach-Object {
+        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+    }
+}`
+
+// argCompletions lists what each positional argument of a command completes to:
+// "account" or "asset" for stored aliases, "" for nothing, or a space-separated list
+// of choices.
+var argCompletions = map[string][]string{
+	"account activity":           {"account"},
+	"account address":            {"account"},
+	"account seed":               {"account"},
+	"account sequence":           {"account"},
+	"account qr":                 {"account"},
+	"account require-memo":       {"account"},
+	"account verify-domain":      {"account"},
+	"account set-signers":        {"account"},
+	"account del":                {"account"},
+	"account info":               {"account"},
+	"account watch-balance":      {"account", "asset"},
+	"account thresholds-explain": {"account"},
+	"account flags-explain":      {"account"},
+	"address to-muxed":           {"account"},
+	"asset code":                 {"asset"},
+	"asset issuer":               {"asset"},
+	"asset type":                 {"asset"},
+	"asset del":                  {"asset"},
+	"asset set":                  {"", "account"},
+	"balance":                    {"account", "asset"},
+	"batch begin":                {"account"},
+	"claimable sweep":            {"account"},
+	"data":                       {"account"},
+	"decode-xdr":                 {"tx txresult txmeta"},
+	"dex expire-sweep":           {"account"},
+	"dex list":                   {"account"},
+	"dex offers-for-pair":        {"asset", "asset"},
+	"dex orderbook":              {"asset", "asset"},
+	"dex reprice":                {"account"},
+	"dex trade":                  {"account"},
+	"flags":                      {"account", "none auth_required auth_revocable auth_immutable"},
+	"friendbot":                  {"account"},
+	"info":                       {"account"},
+	"pay":                        {"", "asset"},
+	"pool deposit":               {"account", "asset", "asset"},
+	"pool withdraw":              {"account"},
+	"signer add":                 {"account"},
+	"signer list":                {"account"},
+	"signer masterweight":        {"account"},
+	"signer remove":              {"account"},
+	"signer replace":             {"account"},
+	"signer simulate":            {"account"},
+	"signer sync":                {"account"},
+	"signer thresholds":          {"account"},
+	"trust allow":                {"account", "asset"},
+	"trust authorize":            {"account", "account", "asset"},
+	"trust create":               {"account", "asset"},
+	"trust create-pool":          {"account", "asset", "asset"},
+	"trust remove":               {"account", "asset"},
+	"trust remove-all":           {"account"},
+	"tx bump-seq":                {"account"},
+	"tx estimate":                {"account"},
+	"watch":                      {"payments transactions ledger", "account"},
+}
+
+// flagCompletions lists what the values of flags complete to, in any command that
+// has them. See argCompletions.
+var flagCompletions = map[string]string{
+	"from":              "account",
+	"fund-from":         "account",
+	"exact-fee-account": "account",
+	"to":                "account",
+	"signers":           "account",
+	"seller":            "account",
+	"old":               "account",
+	"new":               "account",
+	"merge-to":          "account",
+	"send-asset":        "asset",
+	"path":              "asset",
+	"buy":               "asset",
+	"sell":              "asset",
+	"network":           "test public",
+}
+
+func (cli *CLI) buildCompletionCmd() *cobra.Command {
+	scripts := map[string]string{
+		"bash":       bashCompletion,
+		"zsh":        zshCompletion,
+		"fish":       fishCompletion,
+		"powershell": powershellCompletion,
+	}
+
+	return &cobra.Command{
+		Use:   "completion [bash|zsh|fish|powershell]",
+		Short: "print a shell completion script",
+		Long: `Print a completion script for your shell, which completes commands, flags,
+and the account and asset aliases in the current namespace.
+
+  bash:       source <(lumen completion bash)
+  zsh:        source <(lumen completion zsh)
+  fish:       lumen completion fish > ~/.config/fish/completions/lumen.fish
+  powershell: lumen completion powershell | Out-String | Invoke-Expression`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			script, ok := scripts[args[0]]
+			if !ok {
+				cli.error(logrus.Fields{"cmd": "completion"}, "unsupported shell: %s, expecting: bash|zsh|fish|powershell", args[0])
+				return
+			}
+
+			showSuccess("%s", script)
+		},
+	}
+}
+
+func (cli *CLI) buildCompleteWordsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:                "__complete-words [words...] [word]",
+		Short:              "print the completions for [word] after [words...] (used by completion scripts)",
+		Hidden:             true,
+		DisableFlagParsing: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				return
+			}
+
+			word := args[len(args)-1]
+			if word == `""` {
+				word = ""
+			}
+
+			for _, completion := range cli.completeWords(args[:len(args)-1], word) {
+				showSuccess("%s", completion)
+			}
+		},
+	}
+}
+
+// completeWords returns the completions for word, which follows words on the
+// command line.
+func (cli *CLI) completeWords(words []string, word string) []string {
+	cmd := cli.rootCmd
+	numArgs := 0
+	valueFlag := ""
+
+	for i := 0; i < len(words); i++ {
+		if strings.HasPrefix(words[i], "-") {
+			name := strings.TrimLeft(words[i], "-")
+			if strings.Contains(name, "=") {
+				continue
+			}
+
+			// Skip over the values of non-boolean flags
+			if flag := lookupFlag(cmd, name); flag != "" {
+				if i++; i == len(words) {
+					valueFlag = flag
+				}
+			}
+
+			continue
+		}
+
+		if sub := findSubcommand(cmd, words[i]); sub != nil {
+			cmd = sub
+			continue
+		}
+
+		numArgs++
+	}
+
+	if valueFlag != "" {
+		// Only complete the last item of comma-separated lists
+		prefix := ""
+		if i := strings.LastIndex(word, ","); i >= 0 {
+			prefix, word = word[:i+1], word[i+1:]
+		}
+
+		var completions []string
+		for _, candidate := range cli.completionCandidates(flagCompletions[valueFlag], word) {
+			completions = append(completions, prefix+candidate)
+		}
+
+		return completions
+	}
+
+	if strings.HasPrefix(word, "-") {
+		var candidates []string
+		addFlag := func(flag *pflag.Flag) {
+			if !flag.Hidden {
+				candidates = append(candidates, "--"+flag.Name)
+			}
+		}
+
+		cmd.LocalFlags().VisitAll(addFlag)
+		cmd.InheritedFlags().VisitAll(addFlag)
+		return filterPrefix(candidates, word)
+	}
+
+	if cmd.HasAvailableSubCommands() {
+		var candidates []string
+		for _, sub := range cmd.Commands() {
+			if sub.IsAvailableCommand() {
+				candidates = append(candidates, sub.Name())
+			}
+		}
+
+		return filterPrefix(candidates, word)
+	}
+
+	path := strings.TrimPrefix(strings.TrimPrefix(cmd.CommandPath(), cli.rootCmd.Name()), " ")
+	if kinds := argCompletions[path]; numArgs < len(kinds) {
+		return cli.completionCandidates(kinds[numArgs], word)
+	}
+
+	return nil
+}
+
+// completionCandidates returns the completions for word of the given kind. See
+// argCompletions.
+func (cli *CLI) completionCandidates(kind, word string) []string {
+	var candidates []string
+
+	switch kind {
+	case "account", "asset":
+		names, err := cli.aliasNames(kind)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"cmd": "__complete-words"}).Debugf("can't list %s aliases: %v", kind, err)
+			return nil
+		}
+
+		candidates = names
+		if kind == "asset" {
+			candidates = append(candidates, "native")
+		}
+	default:
+		candidates = strings.Fields(kind)
+	}
+
+	return filterPrefix(candidates, word)
+}
+
+// lookupFlag returns the name of cmd's flag in name (without dashes) if it takes
+// a value, or "" if it's a boolean flag or doesn't exist.
+func lookupFlag(cmd *cobra.Command, name string) string {
+	// InheritedFlags merges the persistent flags of parents into cmd.Flags()
+	cmd.InheritedFlags()
+
+	flag := cmd.Flags().Lookup(name)
+	if flag == nil && len(name) == 1 {
+		flag = cmd.Flags().ShorthandLookup(name)
+	}
+
+	if flag == nil || flag.Value.Type() == "bool" {
+		return ""
+	}
+
+	return flag.Name
+}
+
+// findSubcommand returns the subcommand of cmd called name, or nil.
+func findSubcommand(cmd *cobra.Command, name string) *cobra.Command {
+	for _, sub := range cmd.Commands() {
+		if sub.Name() == name || sub.HasAlias(name) {
+			return sub
+		}
+	}
+
+	return nil
+}
+
+// filterPrefix returns the candidates that start with prefix.
+func filterPrefix(candidates []string, prefix string) []string {
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) {
+			matches = append(matches, candidate)
+		}
+	}
+
+	return matches
+}
@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+// Note: add -v to any of these commands to enable verbose logging
+
+func TestCompletion(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("ns test")
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account new mary")
+	cli.TestCommand("account new kelly")
+	cli.TestCommand("asset set USD mo")
+	cli.TestCommand("asset set USDCOIN mo")
+
+	for _, shell := range []string{"bash", "zsh", "fish", "powershell"} {
+		if got := cli.TestCommand("completion " + shell); !strings.Contains(got, "lumen __complete-words") {
+			t.Errorf("(completion %s) want script calling __complete-words, got: %s", shell, got)
+		}
+	}
+
+	expectOutput(t, cli, "error", "completion tcsh")
+
+	tests := []struct {
+		words []string
+		want  string
+	}{
+		{[]string{"tr"}, "trust"},
+		{[]string{"trust", ""}, "allow authorize create create-pool remove remove-all"},
+		{[]string{"trust", "create", "m"}, "mary mo"},
+		{[]string{"trust", "create", "mo", "USD"}, "USD USDCOIN"},
+		{[]string{"trust", "create", "mo", "USD", ""}, ""},
+		{[]string{"pay", "10", "U"}, "USD USDCOIN"},
+		{[]string{"pay", "10", "USD", "--from", "k"}, "kelly"},
+		{[]string{"pay", "10", "USD", "--from", "kelly", "--to", ""}, "kelly mary mo"},
+		{[]string{"pay", "10", "USD", "--nosubmit", "--with", "n"}, "native"},
+		{[]string{"pay", "10", "--signers", "kelly,m"}, "kelly,mary kelly,mo"},
+		{[]string{"pay", "10", "--memot"}, "--memotext"},
+		{[]string{"pay", "10", "--no"}, "--no-memo --nosign --no-confirm --nosubmit"},
+		{[]string{"dex", "orderbook", "USD", "US"}, "USD USDCOIN"},
+		{[]string{"watch", "p"}, "payments"},
+		{[]string{"balance", "--network", ""}, "test public"},
+		{[]string{"version", ""}, ""},
+		{[]string{"__complete-"}, ""},
+	}
+
+	for _, test := range tests {
+		args := append([]string{"__complete-words"}, test.words...)
+		got := strings.Fields(cli.Embeddable().Run(args...))
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("(%s) want completions %q, got %q", strings.Join(test.words, " "), test.want, got)
+		}
+	}
+}
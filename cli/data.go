@@ -1,14 +1,17 @@
 package cli
 
 import (
-	"github.com/0xfe/microstellar"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 func (cli *CLI) buildDataCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "data [account] [key] [value] [--clear]",
+		Use:   "data [account] [key] [value] [--clear] [--if-equals expected|--if-absent]",
 		Short: "get, set, or remove data records on an account",
 		Args:  cobra.MinimumNArgs(2),
 		Run: func(cmd *cobra.Command, args []string) {
@@ -24,18 +27,27 @@ func (cli *CLI) buildDataCmd() *cobra.Command {
 				return
 			}
 
-			opts, err := cli.genTxOptions(cmd, logFields)
-			if err != nil {
-				cli.error(logFields, "can't generate transaction: %v", err)
-				return
-			}
-
 			if len(args) > 2 {
 				val = args[2]
 			}
 
 			clear, _ := cmd.Flags().GetBool("clear")
 
+			// Conditional updates (compare-and-swap) check the current value first
+			ifEquals, _ := cmd.Flags().GetString("if-equals")
+			ifAbsent, _ := cmd.Flags().GetBool("if-absent")
+			if cmd.Flags().Changed("if-equals") || ifAbsent {
+				if !cli.checkDataCondition(cmd, logFields, account, key, ifEquals, ifAbsent, clear || val != "") {
+					return
+				}
+			}
+
+			opts, err := cli.genTxOptions(cmd, logFields, seed)
+			if err != nil {
+				cli.error(logFields, "can't generate transaction: %v", err)
+				return
+			}
+
 			if clear {
 				err = cli.ms.ClearData(seed, key, opts)
 			} else if val != "" {
@@ -49,7 +61,7 @@ func (cli *CLI) buildDataCmd() *cobra.Command {
 
 				a, err := cli.ms.LoadAccount(address)
 				if err != nil {
-					cli.error(logFields, "could not load account %s: %v", account, microstellar.ErrorString(err))
+					cli.errorWithCode(ExitNetworkError, logFields, "could not load account %s: %v", account, cli.errorString(err))
 					return
 				}
 
@@ -63,14 +75,110 @@ func (cli *CLI) buildDataCmd() *cobra.Command {
 			}
 
 			if err != nil {
-				cli.error(logFields, "failed to update data for %s (%s): %v", account, key, microstellar.ErrorString(err))
+				cli.errorWithCode(txExitCode(err), logFields, "failed to update data for %s (%s): %v", account, key, cli.errorString(err))
 				return
 			}
 		},
 	}
 
 	cmd.Flags().Bool("clear", false, "remove data associated with key")
+	cmd.Flags().String("if-equals", "", "only set (or clear) the key if its current value is this")
+	cmd.Flags().Bool("if-absent", false, "only set the key if it doesn't exist yet")
 
 	buildFlagsForTxOptions(cmd)
 	return cmd
 }
+
+// checkDataCondition returns true if the current value of key on account satisfies
+// --if-equals or --if-absent. Stellar has no compare-and-swap, so it also pins the
+// transaction to the sequence number the value was read at: if the account submits
+// anything else in between, the update fails (with tx_bad_seq) instead of clobbering
+// it. Operations sourced from the account in other accounts' transactions can still
+// race.
+func (cli *CLI) checkDataCondition(cmd *cobra.Command, logFields logrus.Fields, account, key, ifEquals string, ifAbsent, update bool) bool {
+	if !update {
+		cli.error(logFields, "--if-equals and --if-absent need a value to set, or --clear")
+		return false
+	}
+
+	if ifAbsent && cmd.Flags().Changed("if-equals") {
+		cli.error(logFields, "--if-equals and --if-absent are mutually exclusive")
+		return false
+	}
+
+	if batch, _ := cmd.Flags().GetBool("batch"); batch {
+		cli.error(logFields, "conditional updates can't be batched")
+		return false
+	}
+
+	address, err := cli.ResolveAccount(logFields, account, "address")
+	if err != nil {
+		cli.error(logFields, "invalid account: %s", account)
+		return false
+	}
+
+	a, err := cli.ms.LoadAccount(address)
+	if err != nil {
+		cli.errorWithCode(ExitNetworkError, logFields, "could not load account %s: %v", account, cli.errorString(err))
+		return false
+	}
+
+	current, ok := a.GetData(key)
+	switch {
+	case ifAbsent && ok:
+		cli.error(logFields, "not updating %s: it's already set to %q", key, current)
+		return false
+	case !ifAbsent && !ok:
+		cli.error(logFields, "not updating %s: it's not set, expected %q", key, ifEquals)
+		return false
+	case !ifAbsent && string(current) != ifEquals:
+		cli.error(logFields, "not updating %s: its value is %q, expected %q", key, current, ifEquals)
+		return false
+	}
+
+	// There are no sequence numbers on the fake network
+	if seq, err := strconv.ParseUint(a.Sequence, 10, 63); err == nil && !cmd.Flags().Changed("sequence") {
+		debugf(logFields, "read %s at sequence %d", key, seq)
+		cmd.Flags().Set("sequence", strconv.FormatUint(seq+1, 10))
+	}
+
+	return true
+}
+
+// maxDataSize is the largest data entry key, or value, in bytes.
+const maxDataSize = 64
+
+// dataEntry is a data entry to set on an account.
+type dataEntry struct {
+	key   string
+	value []byte
+}
+
+// parseDataEntries parses key=value data entries (in order), and checks that keys and
+// values fit and keys aren't repeated. Values can't be empty, since setting an empty
+// value removes the entry.
+func parseDataEntries(entries []string) ([]dataEntry, error) {
+	var parsed []dataEntry
+	seen := map[string]bool{}
+
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.Errorf("bad --data: %s, expecting: key=value", entry)
+		}
+
+		key, value := parts[0], parts[1]
+		if len(key) > maxDataSize || len(value) > maxDataSize {
+			return nil, errors.Errorf("bad --data: %s, keys and values can be at most %d bytes", key, maxDataSize)
+		}
+
+		if seen[key] {
+			return nil, errors.Errorf("repeated --data key: %s", key)
+		}
+
+		seen[key] = true
+		parsed = append(parsed, dataEntry{key: key, value: []byte(value)})
+	}
+
+	return parsed, nil
+}
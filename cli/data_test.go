@@ -15,3 +15,21 @@ func TestData(t *testing.T) {
 	expectOutput(t, cli, "", "data master foo --clear")
 	expectOutput(t, cli, "error", "data worker foo --clear")
 }
+
+func TestDataConditional(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("ns test")
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account new master")
+
+	// Keys are never set on the fake network
+	expectOutput(t, cli, "", "data master foo bar --if-absent")
+	expectOutput(t, cli, "error", "data master foo baz --if-equals bar")
+	expectOutput(t, cli, "error", "data master foo --clear --if-equals bar")
+
+	expectOutput(t, cli, "error", "data master foo bar --if-absent --if-equals bar")
+	expectOutput(t, cli, "error", "data master foo --if-absent")
+	expectOutput(t, cli, "error", "data master foo bar --if-absent --batch")
+	expectOutput(t, cli, "error", "data worker foo bar --if-absent")
+}
@@ -1,16 +1,27 @@
 package cli
 
 import (
-	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/0xfe/microstellar"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/stellar/go/amount"
+	"github.com/stellar/go/clients/horizon"
 )
 
 func (cli *CLI) buildDexCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "dex [trade|list|orderbook]",
+		Use:   "dex [trade|reprice|expire-sweep|list|orderbook|offers-for-pair]",
 		Short: "trade assets on the DEX",
 		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
@@ -22,16 +33,19 @@ func (cli *CLI) buildDexCmd() *cobra.Command {
 	}
 
 	cmd.AddCommand(cli.buildDexTradeCmd())
+	cmd.AddCommand(cli.buildDexRepriceCmd())
+	cmd.AddCommand(cli.buildDexExpireSweepCmd())
 	cmd.AddCommand(cli.buildDexListCmd())
 	cmd.AddCommand(cli.buildDexOrderBookCmd())
+	cmd.AddCommand(cli.buildDexOffersForPairCmd())
 
 	return cmd
 }
 
 func (cli *CLI) buildDexTradeCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "trade [account] --buy [asset1] --sell [asset2] --amount [sellAmount] --price [rate]",
-		Short: "offer to sell [sellAmount] quantity of asset2 for asset1 at price [rate]",
+		Use:   "trade [account] --buy [asset1] --sell [asset2] --amount [sellAmount] --price [rate]|--market [--slippage pct] [--fill-or-kill|--immediate-or-cancel|--post-only|--expire duration]",
+		Short: "offer to sell [sellAmount] quantity of asset2 for asset1 at price [rate] (or enough to buy --buy-amount of asset1)",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			logFields := logrus.Fields{"cmd": "dex", "subcmd": "trade"}
@@ -40,75 +54,993 @@ func (cli *CLI) buildDexTradeCmd() *cobra.Command {
 			buy, _ := cmd.Flags().GetString("buy")
 			sell, _ := cmd.Flags().GetString("sell")
 			amount, _ := cmd.Flags().GetString("amount")
+			buyAmount, _ := cmd.Flags().GetString("buy-amount")
 			price, _ := cmd.Flags().GetString("price")
 			update, _ := cmd.Flags().GetString("update")
 			delete, _ := cmd.Flags().GetString("delete")
 			isPassive, _ := cmd.Flags().GetBool("passive")
 
+			buyAsset, err := cli.ResolveAsset(buy)
+			if err != nil {
+				cli.error(logFields, "invalid buy asset: %s", buy)
+				return
+			}
+
+			sellAsset, err := cli.ResolveAsset(sell)
+			if err != nil {
+				cli.error(logFields, "invalid sell asset: %s", sell)
+				return
+			}
+
+			offerType := microstellar.OfferCreate
+			offerID := ""
+
+			market, _ := cmd.Flags().GetBool("market")
+			slippage, _ := cmd.Flags().GetString("slippage")
+			if market {
+				if price != "" {
+					cli.error(logFields, "--market and --price are mutually exclusive")
+					return
+				}
+
+				if delete != "" {
+					cli.error(logFields, "--market can't be used with --delete")
+					return
+				}
+
+				// Cross the best offer selling what this buys (see estimateFill)
+				orderbook, err := cli.ms.LoadOrderBook(buyAsset, sellAsset, microstellar.Opts().WithLimit(1))
+				if err != nil {
+					cli.errorWithCode(ExitNetworkError, logFields, "can't load offers: %v", cli.errorString(err))
+					return
+				}
+
+				if price, err = marketPrice(orderbook.Asks, slippage); err != nil {
+					cli.error(logFields, "can't price --market offer: %v", err)
+					return
+				}
+
+				debugf(logFields, "market price: %s %s/%s (best ask: %s, slippage: %s%%)", price, assetCode(buyAsset), assetCode(sellAsset), orderbook.Asks[0].Price, slippage)
+			} else if cmd.Flags().Changed("slippage") {
+				cli.error(logFields, "--slippage needs --market")
+				return
+			} else if price == "" {
+				cli.error(logFields, "need --price (or --market)")
+				return
+			}
+
+			if update != "" {
+				offerType = microstellar.OfferUpdate
+				offerID = update
+			} else if delete != "" {
+				offerType = microstellar.OfferDelete
+				offerID = delete
+			} else if isPassive {
+				offerType = microstellar.OfferCreatePassive
+			}
+
+			if offerType != microstellar.OfferDelete && buyAmount != "" {
+				if amount != "" {
+					cli.error(logFields, "can't use both --amount and --buy-amount")
+					return
+				}
+
+				if err := validateAmount(buyAmount, false); err != nil {
+					cli.error(logFields, "bad --buy-amount: %v", err)
+					return
+				}
+
+				amount, err = sellAmountFor(buyAmount, price)
+				if err != nil {
+					cli.error(logFields, "can't compute amount to sell: %v", err)
+					return
+				}
+
+				debugf(logFields, "selling %s %s to buy %s %s at %s", amount, assetCode(sellAsset), buyAmount, assetCode(buyAsset), price)
+			}
+
+			if offerType != microstellar.OfferDelete {
+				if err := validateAmount(amount, false); err != nil {
+					cli.error(logFields, "bad --amount: %v", err)
+					return
+				}
+			}
+
+			fillOrKill, _ := cmd.Flags().GetBool("fill-or-kill")
+			immediateOrCancel, _ := cmd.Flags().GetBool("immediate-or-cancel")
+			if fillOrKill || immediateOrCancel {
+				if fillOrKill && immediateOrCancel {
+					cli.error(logFields, "--fill-or-kill and --immediate-or-cancel are mutually exclusive")
+					return
+				}
+
+				if offerType != microstellar.OfferCreate && offerType != microstellar.OfferCreatePassive {
+					cli.error(logFields, "--fill-or-kill and --immediate-or-cancel are only for new offers")
+					return
+				}
+
+				// Both check the orderbook first, and the cancel needs its own transaction
+				batch, _ := cmd.Flags().GetBool("batch")
+				nosubmit, _ := cli.rootCmd.Flags().GetBool("nosubmit")
+				if batch || nosubmit {
+					cli.error(logFields, "--fill-or-kill and --immediate-or-cancel need the offer to be submitted now, so they can't be used with --batch or --nosubmit")
+					return
+				}
+			}
+
+			postOnly, _ := cmd.Flags().GetBool("post-only")
+			if postOnly {
+				if offerType == microstellar.OfferDelete {
+					cli.error(logFields, "--post-only can't be used with --delete")
+					return
+				}
+
+				if market || fillOrKill || immediateOrCancel {
+					cli.error(logFields, "--post-only offers never take liquidity, so they can't be used with --market, --fill-or-kill, or --immediate-or-cancel")
+					return
+				}
+
+				// The orderbook is checked now, so the offer must be submitted now
+				batch, _ := cmd.Flags().GetBool("batch")
+				nosubmit, _ := cli.rootCmd.Flags().GetBool("nosubmit")
+				if batch || nosubmit {
+					cli.error(logFields, "--post-only needs the offer to be submitted now, so it can't be used with --batch or --nosubmit")
+					return
+				}
+			}
+
+			expire, _ := cmd.Flags().GetDuration("expire")
+			if cmd.Flags().Changed("expire") {
+				if expire <= 0 {
+					cli.error(logFields, "bad --expire: must be positive: %v", expire)
+					return
+				}
+
+				if offerType != microstellar.OfferCreate && offerType != microstellar.OfferCreatePassive {
+					cli.error(logFields, "--expire is only for new offers")
+					return
+				}
+
+				if fillOrKill || immediateOrCancel {
+					cli.error(logFields, "--expire is for offers that rest on the book, so it can't be used with --fill-or-kill or --immediate-or-cancel")
+					return
+				}
+
+				// The new offer's ID isn't known until it's applied
+				batch, _ := cmd.Flags().GetBool("batch")
+				nosubmit, _ := cli.rootCmd.Flags().GetBool("nosubmit")
+				if batch || nosubmit {
+					cli.error(logFields, "--expire needs the offer to be submitted now, so it can't be used with --batch or --nosubmit")
+					return
+				}
+			}
+
+			if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+				if offerType == microstellar.OfferDelete {
+					cli.error(logFields, "nothing to simulate for --delete")
+					return
+				}
+
+				fill := cli.estimateFill(logFields, buyAsset, sellAsset, amount, price, isPassive)
+				if fill == nil {
+					return
+				}
+
+				sellCode, buyCode := assetCode(sellAsset), assetCode(buyAsset)
+				if fill.Sold == "0.0000000" {
+					showSuccess("fills: nothing")
+				} else {
+					showSuccess("fills: %s %s for %s %s (average price: %s %s/%s)", fill.Sold, sellCode, fill.Bought, buyCode, fill.AveragePrice, buyCode, sellCode)
+				}
+
+				if fill.Remaining == "0.0000000" {
+					showSuccess("rests: nothing")
+				} else {
+					showSuccess("rests: %s %s at %s %s/%s", fill.Remaining, sellCode, price, buyCode, sellCode)
+				}
+
+				return
+			}
+
 			source, err := cli.ResolveAccount(logFields, account, "seed")
 			if err != nil {
 				cli.error(logFields, "invalid account: %s", account)
 				return
 			}
 
-			buyAsset, err := cli.ResolveAsset(buy)
+			// Stellar has no fill-or-kill or immediate-or-cancel offers, so check what
+			// would fill against the orderbook first. It can change before the offer
+			// is applied.
+			if fillOrKill || immediateOrCancel {
+				fill := cli.estimateFill(logFields, buyAsset, sellAsset, amount, price, isPassive)
+				if fill == nil {
+					return
+				}
+
+				if fillOrKill && fill.Remaining != "0.0000000" {
+					cli.error(logFields, "not submitting: only %s of %s %s would fill now (--fill-or-kill)", fill.Sold, amount, assetCode(sellAsset))
+					return
+				}
+
+				if immediateOrCancel && fill.Sold == "0.0000000" {
+					cli.error(logFields, "not submitting: nothing would fill now (--immediate-or-cancel)")
+					return
+				}
+			}
+
+			// Unlike --passive, which is applied by the network (and still takes better
+			// priced offers), --post-only refuses offers that would cross the orderbook
+			// at all. The orderbook can change between the check and the offer being
+			// applied, so in rare cases a post-only offer still takes liquidity.
+			if postOnly {
+				orderbook, err := cli.ms.LoadOrderBook(buyAsset, sellAsset, microstellar.Opts().WithLimit(1))
+				if err != nil {
+					cli.errorWithCode(ExitNetworkError, logFields, "can't load offers: %v", cli.errorString(err))
+					return
+				}
+
+				crosses, err := crossesBook(orderbook.Asks, price, isPassive)
+				if err != nil {
+					cli.error(logFields, "can't check orderbook: %v", err)
+					return
+				}
+
+				if crosses {
+					cli.error(logFields, "not submitting: the offer would take the best offer on the orderbook, at %s %s/%s (--post-only)", orderbook.Asks[0].Price, assetCode(sellAsset), assetCode(buyAsset))
+					return
+				}
+			}
+
+			// Remember the account's offers, to tell which one is the new remainder (or
+			// the new offer to expire)
+			var existing map[int64]bool
+			if immediateOrCancel || expire > 0 {
+				existing = cli.loadOfferIDs(logFields, addressFromSeed(source))
+				if existing == nil {
+					return
+				}
+			}
+
+			opts, err := cli.genTxOptionsFor(cmd, logFields, account)
+			if err != nil {
+				cli.error(logFields, "can't generate offer: %v", err)
+				return
+			}
+
+			err = cli.ms.ManageOffer(source, &microstellar.OfferParams{
+				OfferType:  offerType,
+				SellAsset:  sellAsset,
+				SellAmount: amount,
+				BuyAsset:   buyAsset,
+				Price:      price,
+				OfferID:    offerID,
+			}, opts)
+
+			if err != nil {
+				cli.errorWithCode(txExitCode(err), logFields, "failed to submit offer: %v", cli.errorString(err))
+				return
+			}
+
+			if immediateOrCancel {
+				cli.cancelRemainder(cmd, logFields, account, source, sellAsset, buyAsset, existing)
+			}
+
+			if expire > 0 {
+				cli.trackExpiry(logFields, addressFromSeed(source), sellAsset, buyAsset, existing, time.Now().Add(expire))
+			}
+		},
+	}
+
+	cmd.Flags().String("buy", "", "asset to buy")
+	cmd.Flags().String("sell", "", "asset to sell")
+	cmd.Flags().String("amount", "", "amount to sell")
+	cmd.Flags().String("buy-amount", "", "amount to buy, instead of --amount (sells this divided by --price, rounded up)")
+	cmd.Flags().String("price", "", "price in units-of-buy per unit-of-sell")
+	cmd.Flags().Bool("market", false, "instead of --price, use the price of the best offer on the other side of the orderbook")
+	cmd.Flags().String("slippage", "0", "with --market, accept a price up to this percentage worse than the best offer, to cross more of the orderbook")
+	cmd.Flags().String("update", "", "Offer ID to update")
+	cmd.Flags().String("delete", "", "Offer ID to delete")
+	cmd.Flags().Bool("passive", false, "make this a passive offer")
+	cmd.Flags().Bool("dry-run", false, "show how much of the offer would fill immediately against the orderbook, without submitting it")
+	cmd.Flags().Bool("fill-or-kill", false, "only submit the offer if the orderbook shows it would fill completely right away")
+	cmd.Flags().Bool("immediate-or-cancel", false, "only submit the offer if some of it would fill right away, then cancel whatever's left")
+	cmd.Flags().Bool("post-only", false, "only submit the offer if the orderbook shows none of it would fill right away")
+	cmd.Flags().Duration("expire", 0, "remember to cancel the offer after this long (e.g., 1h), with dex expire-sweep")
+
+	cmd.MarkFlagRequired("buy")
+	cmd.MarkFlagRequired("sell")
+
+	buildFlagsForTxOptions(cmd)
+	return cmd
+}
+
+// sellAmountFor returns the amount to sell at price (in units-of-buy per
+// unit-of-sell) to buy buyAmount, rounded up to the nearest stroop so that the
+// offer buys at least buyAmount.
+func sellAmountFor(buyAmount, price string) (string, error) {
+	buy, err := amount.ParseInt64(buyAmount)
+	if err != nil {
+		return "", errors.Errorf("bad amount: %s", buyAmount)
+	}
+
+	rate, ok := new(big.Rat).SetString(price)
+	if !ok || rate.Sign() <= 0 {
+		return "", errors.Errorf("bad price: %s", price)
+	}
+
+	sell := new(big.Rat).Quo(new(big.Rat).SetInt64(buy), rate)
+	stroops, remainder := new(big.Int).QuoRem(sell.Num(), sell.Denom(), new(big.Int))
+	if remainder.Sign() > 0 {
+		stroops.Add(stroops, big.NewInt(1))
+	}
+
+	if !stroops.IsInt64() {
+		return "", errors.Errorf("amount to sell is too large (more than %s)", maxAmount)
+	}
+
+	return amount.StringFromInt64(stroops.Int64()), nil
+}
+
+// marketPrice returns the price (in units-of-buy per unit-of-sell) of an offer that
+// crosses the best of asks (the offers selling the buy asset, priced in units-of-sell
+// per unit-of-buy), lowered by slippage percent so that it also crosses worse ones. It's
+// rounded down to 7 decimal places, so it still crosses.
+func marketPrice(asks []microstellar.BidAsk, slippage string) (string, error) {
+	if len(asks) == 0 {
+		return "", errors.New("the orderbook is empty")
+	}
+
+	pct, ok := new(big.Rat).SetString(slippage)
+	if !ok || pct.Sign() < 0 || pct.Cmp(big.NewRat(100, 1)) >= 0 {
+		return "", errors.Errorf("bad --slippage: expecting a percentage from 0 to 100, got: %s", slippage)
+	}
+
+	askPrice, ok := new(big.Rat).SetString(asks[0].Price)
+	if !ok || askPrice.Sign() <= 0 {
+		return "", errors.Errorf("bad price in orderbook: %s", asks[0].Price)
+	}
+
+	// (1 / ask) * (1 - slippage / 100), in stroops
+	rate := new(big.Rat).Inv(askPrice)
+	rate.Mul(rate, new(big.Rat).Sub(big.NewRat(1, 1), new(big.Rat).Quo(pct, big.NewRat(100, 1))))
+	rate.Mul(rate, big.NewRat(amount.One, 1))
+
+	stroops := new(big.Int).Quo(rate.Num(), rate.Denom())
+	if stroops.Sign() <= 0 {
+		return "", errors.Errorf("price is too small (best ask: %s)", asks[0].Price)
+	}
+
+	return new(big.Rat).SetFrac(stroops, big.NewInt(amount.One)).FloatString(7), nil
+}
+
+// fillEstimate is how an offer would fill against the orderbook, see simulateFill.
+type fillEstimate struct {
+	Sold         string
+	Bought       string
+	AveragePrice string
+	Remaining    string
+}
+
+// simulateFill estimates how much of an offer to sell sellAmount at price (in
+// units-of-buy per unit-of-sell) would cross the asks of the opposite orderbook, i.e.,
+// the offers selling the buy asset for the sell asset, best price first. Passive
+// offers don't cross offers at the same price. Amounts are rounded down to the stroop.
+func simulateFill(asks []microstellar.BidAsk, sellAmount, price string, passive bool) (*fillEstimate, error) {
+	sell, err := amount.ParseInt64(sellAmount)
+	if err != nil {
+		return nil, errors.Errorf("bad amount: %s", sellAmount)
+	}
+
+	rate, ok := new(big.Rat).SetString(price)
+	if !ok || rate.Sign() <= 0 {
+		return nil, errors.Errorf("bad price: %s", price)
+	}
+
+	// Asks are priced in units-of-sell per unit-of-buy, so the offer crosses them
+	// when the ask's price times the offer's is at most 1
+	one := big.NewRat(1, 1)
+	remaining := new(big.Rat).SetInt64(sell)
+	bought := new(big.Rat)
+
+	for _, ask := range asks {
+		if remaining.Sign() <= 0 {
+			break
+		}
+
+		askPrice, ok := new(big.Rat).SetString(ask.Price)
+		if !ok || askPrice.Sign() <= 0 {
+			return nil, errors.Errorf("bad price in orderbook: %s", ask.Price)
+		}
+
+		askAmount, err := amount.ParseInt64(ask.Amount)
+		if err != nil {
+			return nil, errors.Errorf("bad amount in orderbook: %s", ask.Amount)
+		}
+
+		cross := new(big.Rat).Mul(askPrice, rate).Cmp(one)
+		if cross > 0 || (passive && cross == 0) {
+			break
+		}
+
+		// Take the whole level, or as much as the rest of the offer pays for
+		cost := new(big.Rat).Mul(new(big.Rat).SetInt64(askAmount), askPrice)
+		if cost.Cmp(remaining) > 0 {
+			cost.Set(remaining)
+		}
+
+		bought.Add(bought, new(big.Rat).Quo(cost, askPrice))
+		remaining.Sub(remaining, cost)
+	}
+
+	stroops := func(r *big.Rat) int64 {
+		return new(big.Int).Quo(r.Num(), r.Denom()).Int64()
+	}
+
+	sold := sell - stroops(remaining)
+	fill := &fillEstimate{
+		Sold:      amount.StringFromInt64(sold),
+		Bought:    amount.StringFromInt64(stroops(bought)),
+		Remaining: amount.StringFromInt64(sell - sold),
+	}
+
+	if sold > 0 {
+		fill.AveragePrice = new(big.Rat).Quo(bought, new(big.Rat).SetInt64(sold)).FloatString(7)
+	}
+
+	return fill, nil
+}
+
+// crossesBook returns true if an offer at price (in units-of-buy per unit-of-sell)
+// would take any of the asks of the opposite orderbook, best price first (see
+// simulateFill.)
+func crossesBook(asks []microstellar.BidAsk, price string, passive bool) (bool, error) {
+	if len(asks) == 0 {
+		return false, nil
+	}
+
+	rate, ok := new(big.Rat).SetString(price)
+	if !ok || rate.Sign() <= 0 {
+		return false, errors.Errorf("bad price: %s", price)
+	}
+
+	askPrice, ok := new(big.Rat).SetString(asks[0].Price)
+	if !ok || askPrice.Sign() <= 0 {
+		return false, errors.Errorf("bad price in orderbook: %s", asks[0].Price)
+	}
+
+	cross := new(big.Rat).Mul(askPrice, rate).Cmp(big.NewRat(1, 1))
+	return cross < 0 || (!passive && cross == 0), nil
+}
+
+// estimateFill loads the orderbook, and returns how an offer to sell amount of
+// sellAsset for buyAsset at price would fill against it (see simulateFill.) It
+// reports errors itself, and returns nil for them.
+func (cli *CLI) estimateFill(logFields logrus.Fields, buyAsset, sellAsset *microstellar.Asset, amount, price string, passive bool) *fillEstimate {
+	// The offer crosses the offers selling what it buys
+	orderbook, err := cli.ms.LoadOrderBook(buyAsset, sellAsset, microstellar.Opts().WithLimit(maxPageSize))
+	if err != nil {
+		cli.errorWithCode(ExitNetworkError, logFields, "can't load offers: %v", cli.errorString(err))
+		return nil
+	}
+
+	fill, err := simulateFill(orderbook.Asks, amount, price, passive)
+	if err != nil {
+		cli.error(logFields, "can't simulate offer: %v", err)
+		return nil
+	}
+
+	debugf(logFields, "estimated fill: %+v", fill)
+	return fill
+}
+
+// loadAccountOffers returns all the offers of address, paging through horizon's. An
+// account can have more offers than fit in a page, and the ones lumen looks for (the
+// newest) come last.
+func (cli *CLI) loadAccountOffers(logFields logrus.Fields, address string) ([]microstellar.Offer, error) {
+	records, err := cli.loadPairOffers(logFields, url.Values{"seller": {address}}, "", math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+
+	offers := make([]microstellar.Offer, len(records))
+	for i, record := range records {
+		id, err := strconv.ParseInt(string(record.ID), 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("bad offer ID: %s", record.ID)
+		}
+
+		offers[i] = microstellar.Offer{
+			ID:      id,
+			Seller:  record.Seller,
+			Selling: record.Selling.asset(),
+			Buying:  record.Buying.asset(),
+			Amount:  record.Amount,
+			Price:   record.Price,
+		}
+	}
+
+	return offers, nil
+}
+
+// loadOfferIDs returns the IDs of the offers of address. It reports errors itself,
+// and returns nil for them.
+func (cli *CLI) loadOfferIDs(logFields logrus.Fields, address string) map[int64]bool {
+	offers, err := cli.loadAccountOffers(logFields, address)
+	if err != nil {
+		cli.errorWithCode(ExitNetworkError, logFields, "can't load offers: %v", cli.errorString(err))
+		return nil
+	}
+
+	ids := map[int64]bool{}
+	for _, offer := range offers {
+		ids[offer.ID] = true
+	}
+
+	return ids
+}
+
+// sameAsset returns true if a and b are the same asset. Native assets loaded from
+// horizon don't have a code, so only their types are compared.
+func sameAsset(a, b microstellar.Asset) bool {
+	if a.Type == microstellar.NativeType || b.Type == microstellar.NativeType {
+		return a.Type == b.Type
+	}
+
+	return a.Code == b.Code && a.Issuer == b.Issuer
+}
+
+// cancelRemainder deletes the offers of the account with seed that sell sellAsset for
+// buyAsset, and aren't in existing, i.e., what's left of an offer that was just made
+// with --immediate-or-cancel. This is a second transaction, because the offer's ID
+// isn't known until the first one is applied, so the remainder can still fill (or be
+// partly filled) in between. The cancellations are signed like the offer from account,
+// with the same transaction flags, and with --sequence, take the sequence numbers after
+// the offer's.
+func (cli *CLI) cancelRemainder(cmd *cobra.Command, logFields logrus.Fields, account, seed string, sellAsset, buyAsset *microstellar.Asset, existing map[int64]bool) {
+	address := addressFromSeed(seed)
+	offers, err := cli.loadAccountOffers(logFields, address)
+	if err != nil {
+		cli.errorWithCode(ExitNetworkError, logFields, "offer submitted, but can't load offers to cancel the rest: %v", cli.errorString(err))
+		return
+	}
+
+	params, err := cli.parseTxParams(cmd, logFields, account)
+	if err != nil {
+		cli.error(logFields, "offer submitted, but can't generate cancellation: %v", err)
+		return
+	}
+
+	opts, err := cli.genTxOptionsFor(cmd, logFields, account)
+	if err != nil {
+		cli.error(logFields, "offer submitted, but can't generate cancellation: %v", err)
+		return
+	}
+
+	// The sequence number of the last transaction submitted with --sequence
+	sequence := params.sequence
+
+	for _, offer := range offers {
+		if existing[offer.ID] || !sameAsset(offer.Selling, *sellAsset) || !sameAsset(offer.Buying, *buyAsset) {
+			continue
+		}
+
+		if sequence > 0 {
+			cli.useSequence(address, uint64(sequence))
+			sequence++
+		}
+
+		debugf(logFields, "cancelling remainder: offer %d (%s %s)", offer.ID, offer.Amount, assetCode(sellAsset))
+		err := cli.ms.ManageOffer(seed, &microstellar.OfferParams{
+			OfferType: microstellar.OfferDelete,
+			SellAsset: sellAsset,
+			BuyAsset:  buyAsset,
+			Price:     offer.Price,
+			OfferID:   strconv.FormatInt(offer.ID, 10),
+		}, opts)
+
+		if err != nil {
+			cli.errorWithCode(txExitCode(err), logFields, "offer submitted, but can't cancel the rest (offer %d): %v", offer.ID, cli.errorString(err))
+			return
+		}
+
+		showSuccess("cancelled: %s %s (offer %d)", offer.Amount, assetCode(sellAsset), offer.ID)
+	}
+}
+
+// offerGone returns true if err is a rejected transaction whose offer update failed
+// because the offer doesn't exist (any more.)
+func offerGone(err error) bool {
+	herr, ok := errors.Cause(err).(*horizon.Error)
+	if !ok {
+		return false
+	}
+
+	codes, codeErr := herr.ResultCodes()
+	if codeErr != nil {
+		return false
+	}
+
+	for _, code := range codes.OperationCodes {
+		if code == "op_offer_not_found" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findOffer returns the offer with id among the offers of address, or nil if it
+// doesn't have one. It reports errors itself, and returns false for them.
+func (cli *CLI) findOffer(logFields logrus.Fields, address string, id int64) (*microstellar.Offer, bool) {
+	offers, err := cli.loadAccountOffers(logFields, address)
+	if err != nil {
+		cli.errorWithCode(ExitNetworkError, logFields, "can't load offers: %v", cli.errorString(err))
+		return nil, false
+	}
+
+	for i := range offers {
+		if offers[i].ID == id {
+			return &offers[i], true
+		}
+	}
+
+	return nil, true
+}
+
+func (cli *CLI) buildDexRepriceCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reprice [account] [offerID] --price [rate] --amount [sellAmount] [--buy asset1 --sell asset2]",
+		Short: "change the price and amount of an offer in one transaction, or place a new offer if it's gone",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "dex", "subcmd": "reprice"}
+
+			account := args[0]
+			id, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil || id <= 0 {
+				cli.error(logFields, "bad offer ID: %s", args[1])
+				return
+			}
+
+			price, _ := cmd.Flags().GetString("price")
+			if err := validateAmount(price, false); err != nil {
+				cli.error(logFields, "bad --price: %v", err)
+				return
+			}
+
+			sellAmount, _ := cmd.Flags().GetString("amount")
+			if err := validateAmount(sellAmount, false); err != nil {
+				cli.error(logFields, "bad --amount: %v", err)
+				return
+			}
+
+			// The assets are only needed if the offer is gone, but must match it if it's not
+			var buyAsset, sellAsset *microstellar.Asset
+			buy, _ := cmd.Flags().GetString("buy")
+			sell, _ := cmd.Flags().GetString("sell")
+			if (buy == "") != (sell == "") {
+				cli.error(logFields, "--buy and --sell must be used together")
+				return
+			}
+
+			if buy != "" {
+				if buyAsset, err = cli.ResolveAsset(buy); err != nil {
+					cli.error(logFields, "invalid buy asset: %s", buy)
+					return
+				}
+
+				if sellAsset, err = cli.ResolveAsset(sell); err != nil {
+					cli.error(logFields, "invalid sell asset: %s", sell)
+					return
+				}
+			}
+
+			source, err := cli.ResolveAccount(logFields, account, "seed")
+			if err != nil || microstellar.ValidSeed(source) != nil {
+				cli.error(logFields, "no seed found in %s", account)
+				return
+			}
+
+			address := addressFromSeed(source)
+			offer, ok := cli.findOffer(logFields, address, id)
+			if !ok {
+				return
+			}
+
+			if offer != nil {
+				if buyAsset != nil && (!sameAsset(offer.Buying, *buyAsset) || !sameAsset(offer.Selling, *sellAsset)) {
+					cli.error(logFields, "offer %d sells %s for %s, not %s for %s", id, assetCode(&offer.Selling), assetCode(&offer.Buying), sell, buy)
+					return
+				}
+
+				// Use the offer's own assets, which are complete (native ones loaded from
+				// horizon have no code, for example)
+				sellAsset, buyAsset = &offer.Selling, &offer.Buying
+			} else if buyAsset == nil {
+				cli.error(logFields, "offer %d of %s is gone (filled or cancelled), use --buy and --sell to place a new one", id, account)
+				return
+			}
+
+			opts, err := cli.genTxOptionsFor(cmd, logFields, account)
+			if err != nil {
+				cli.error(logFields, "can't generate offer: %v", err)
+				return
+			}
+
+			params := &microstellar.OfferParams{
+				OfferType:  microstellar.OfferUpdate,
+				SellAsset:  sellAsset,
+				SellAmount: sellAmount,
+				BuyAsset:   buyAsset,
+				Price:      price,
+				OfferID:    strconv.FormatInt(id, 10),
+			}
+
+			if offer != nil {
+				debugf(logFields, "repricing offer %d: %s %s at %s -> %s at %s", id, offer.Amount, assetCode(sellAsset), offer.Price, sellAmount, price)
+				err = cli.ms.ManageOffer(source, params, opts)
+				if err == nil {
+					showSuccess("repriced: offer %d, %s %s at %s %s/%s", id, sellAmount, assetCode(sellAsset), price, assetCode(buyAsset), assetCode(sellAsset))
+					return
+				}
+
+				// It filled (or was cancelled) after it was loaded
+				if !offerGone(err) {
+					cli.errorWithCode(txExitCode(err), logFields, "failed to reprice offer %d: %v", id, cli.errorString(err))
+					return
+				}
+
+				debugf(logFields, "offer %d is gone: %v", id, cli.errorString(err))
+			}
+
+			// The new offer's ID isn't known until it's applied, so remember the others
+			existing := cli.loadOfferIDs(logFields, address)
+			if existing == nil {
+				return
+			}
+
+			showSuccess("offer %d is gone (filled or cancelled), placing a new one", id)
+			params.OfferType = microstellar.OfferCreate
+			params.OfferID = ""
+			if err := cli.ms.ManageOffer(source, params, opts); err != nil {
+				cli.errorWithCode(txExitCode(err), logFields, "failed to place new offer: %v", cli.errorString(err))
+				return
+			}
+
+			cli.showNewOffers(logFields, address, sellAsset, buyAsset, existing)
+		},
+	}
+
+	cmd.Flags().String("price", "", "new price in units-of-buy per unit-of-sell")
+	cmd.Flags().String("amount", "", "new amount to sell (replaces what's left of the offer)")
+	cmd.Flags().String("buy", "", "asset the offer buys, to place a new offer if it's gone")
+	cmd.Flags().String("sell", "", "asset the offer sells, to place a new offer if it's gone")
+	cmd.MarkFlagRequired("price")
+	cmd.MarkFlagRequired("amount")
+
+	buildFlagsForTxOptions(cmd)
+	return cmd
+}
+
+// showNewOffers shows the IDs of the offers of address that sell sellAsset for
+// buyAsset, and aren't in existing, i.e., the offer that was just placed. There's
+// none if it filled completely, and nothing to look up on the fake network or if
+// the offer wasn't submitted.
+func (cli *CLI) showNewOffers(logFields logrus.Fields, address string, sellAsset, buyAsset *microstellar.Asset, existing map[int64]bool) {
+	if cli.submitted == "" || cli.horizonURL() == "" {
+		return
+	}
+
+	offers, err := cli.newOffers(logFields, address, sellAsset, buyAsset, existing)
+	if err != nil {
+		cli.errorWithCode(ExitNetworkError, logFields, "new offer placed, but can't load offers to find its ID: %v", cli.errorString(err))
+		return
+	}
+
+	for _, offer := range offers {
+		showSuccess("new offer: %d, %s %s at %s", offer.ID, offer.Amount, assetCode(sellAsset), offer.Price)
+	}
+
+	if len(offers) == 0 {
+		showSuccess("new offer filled right away, nothing left on the book")
+	}
+}
+
+// newOffers returns the offers of address that sell sellAsset for buyAsset, and
+// aren't in existing.
+func (cli *CLI) newOffers(logFields logrus.Fields, address string, sellAsset, buyAsset *microstellar.Asset, existing map[int64]bool) ([]microstellar.Offer, error) {
+	offers, err := cli.loadAccountOffers(logFields, address)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []microstellar.Offer
+	for _, offer := range offers {
+		if !existing[offer.ID] && sameAsset(offer.Selling, *sellAsset) && sameAsset(offer.Buying, *buyAsset) {
+			found = append(found, offer)
+		}
+	}
+
+	return found, nil
+}
+
+// offerExpiryKey returns the store key of the expiry time of the offer of address
+// with id, set with dex trade --expire.
+func offerExpiryKey(address string, id int64) string {
+	return fmt.Sprintf("offer-expiry:%s:%d", address, id)
+}
+
+// trackExpiry records expiry as the expiry time of the offers of address that sell
+// sellAsset for buyAsset, and aren't in existing, i.e., the offer that was just
+// placed with --expire, for dex expire-sweep. There's nothing to record if it filled
+// completely, and no offer IDs on the fake network.
+func (cli *CLI) trackExpiry(logFields logrus.Fields, address string, sellAsset, buyAsset *microstellar.Asset, existing map[int64]bool, expiry time.Time) {
+	if cli.submitted == "" || cli.horizonURL() == "" {
+		debugf(logFields, "no offer ID to track for --expire")
+		return
+	}
+
+	offers, err := cli.newOffers(logFields, address, sellAsset, buyAsset, existing)
+	if err != nil {
+		cli.errorWithCode(ExitNetworkError, logFields, "offer placed, but can't load offers to track its expiry: %v", cli.errorString(err))
+		return
+	}
+
+	for _, offer := range offers {
+		if err := cli.SetVar(offerExpiryKey(address, offer.ID), expiry.UTC().Format(time.RFC3339)); err != nil {
+			cli.errorWithCode(ExitStoreError, logFields, "offer %d placed, but can't save its expiry: %v", offer.ID, err)
+			return
+		}
+
+		showSuccess("offer %d expires at %s (run dex expire-sweep to cancel it)", offer.ID, expiry.UTC().Format(time.RFC3339))
+	}
+
+	if len(offers) == 0 {
+		showSuccess("offer filled right away, nothing to expire")
+	}
+}
+
+// offerExpiries returns the expiry times of the offers of address that were placed
+// with dex trade --expire, by offer ID.
+func (cli *CLI) offerExpiries(address string) (map[int64]time.Time, error) {
+	prefix := fmt.Sprintf("offer-expiry:%s:", address)
+	keys, err := cli.ListVars(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	expiries := map[int64]time.Time{}
+	for _, key := range keys {
+		id, err := strconv.ParseInt(strings.TrimPrefix(key, prefix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		val, err := cli.GetVar(key)
+		if err != nil {
+			continue
+		}
+
+		expiry, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad expiry for offer %d", id)
+		}
+
+		expiries[id] = expiry
+	}
+
+	return expiries, nil
+}
+
+// expiredOffers returns the IDs of the offers in expiries that expired by now, in
+// order.
+func expiredOffers(expiries map[int64]time.Time, now time.Time) []int64 {
+	var ids []int64
+	for id, expiry := range expiries {
+		if !expiry.After(now) {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (cli *CLI) buildDexExpireSweepCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "expire-sweep [account]",
+		Short: "cancel the offers of [account] placed with dex trade --expire that have expired",
+		Long: `Cancel the offers of [account] placed with dex trade --expire that have expired.
+
+Stellar offers don't expire, so --expire is enforced by lumen, not the network: an
+offer stays on the book until expire-sweep cancels it. Run it regularly (e.g., from
+cron) on the machine that placed the offers, since expiry times are only stored
+locally.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "dex", "subcmd": "expire-sweep"}
+
+			// Offers are forgotten as they're cancelled
+			batch, _ := cmd.Flags().GetBool("batch")
+			nosubmit, _ := cli.rootCmd.Flags().GetBool("nosubmit")
+			if batch || nosubmit {
+				cli.error(logFields, "expire-sweep needs to submit the cancellations now, so it can't be used with --batch or --nosubmit")
+				return
+			}
+
+			source, err := cli.ResolveAccount(logFields, args[0], "seed")
 			if err != nil {
-				cli.error(logFields, "invalid buy asset: %s", buy)
+				cli.error(logFields, "invalid account: %s", args[0])
 				return
 			}
 
-			sellAsset, err := cli.ResolveAsset(sell)
+			address := addressFromSeed(source)
+			expiries, err := cli.offerExpiries(address)
 			if err != nil {
-				cli.error(logFields, "invalid sell asset: %s", sell)
+				cli.errorWithCode(ExitStoreError, logFields, "can't load offer expiries: %v", err)
 				return
 			}
 
-			offerType := microstellar.OfferCreate
-			offerID := ""
-
-			if update != "" {
-				offerType = microstellar.OfferUpdate
-				offerID = update
-			} else if delete != "" {
-				offerType = microstellar.OfferDelete
-				offerID = delete
-			} else if isPassive {
-				offerType = microstellar.OfferCreatePassive
+			expired := expiredOffers(expiries, time.Now())
+			debugf(logFields, "%d offers tracked, %d expired", len(expiries), len(expired))
+			if len(expired) == 0 {
+				showSuccess("no expired offers")
+				return
 			}
 
-			opts, err := cli.genTxOptions(cmd, logFields)
+			offers, err := cli.loadAccountOffers(logFields, address)
 			if err != nil {
-				cli.error(logFields, "can't generate offer: %v", err)
+				cli.errorWithCode(ExitNetworkError, logFields, "can't load offers: %v", cli.errorString(err))
 				return
 			}
 
-			err = cli.ms.ManageOffer(source, &microstellar.OfferParams{
-				OfferType:  offerType,
-				SellAsset:  sellAsset,
-				SellAmount: amount,
-				BuyAsset:   buyAsset,
-				Price:      price,
-				OfferID:    offerID,
-			}, opts)
+			byID := map[int64]microstellar.Offer{}
+			for _, offer := range offers {
+				byID[offer.ID] = offer
+			}
 
+			opts, err := cli.genTxOptionsFor(cmd, logFields, args[0])
 			if err != nil {
-				cli.error(logFields, "failed to submit offer: %v", microstellar.ErrorString(err))
+				cli.error(logFields, "can't generate transaction: %v", err)
 				return
 			}
-		},
-	}
 
-	cmd.Flags().String("buy", "", "asset to buy")
-	cmd.Flags().String("sell", "", "asset to sell")
-	cmd.Flags().String("amount", "", "amount to sell")
-	cmd.Flags().String("price", "", "price in units-of-buy per unit-of-sell")
-	cmd.Flags().String("update", "", "Offer ID to update")
-	cmd.Flags().String("delete", "", "Offer ID to delete")
-	cmd.Flags().Bool("passive", false, "make this a passive offer")
+			for _, id := range expired {
+				expiry := expiries[id].Format(time.RFC3339)
 
-	cmd.MarkFlagRequired("buy")
-	cmd.MarkFlagRequired("sell")
-	cmd.MarkFlagRequired("price")
+				offer, cancelled := byID[id]
+				if cancelled {
+					debugf(logFields, "cancelling offer %d, expired at %s", id, expiry)
+					err := cli.ms.ManageOffer(source, &microstellar.OfferParams{
+						OfferType: microstellar.OfferDelete,
+						SellAsset: &offer.Selling,
+						BuyAsset:  &offer.Buying,
+						Price:     offer.Price,
+						OfferID:   strconv.FormatInt(id, 10),
+					}, opts)
+
+					// It can fill (or be cancelled) after the offers are loaded
+					if err != nil && !offerGone(err) {
+						cli.errorWithCode(txExitCode(err), logFields, "can't cancel offer %d: %v", id, cli.errorString(err))
+						return
+					}
+
+					cancelled = err == nil
+				}
+
+				if err := cli.DelVar(offerExpiryKey(address, id)); err != nil {
+					cli.errorWithCode(ExitStoreError, logFields, "can't forget offer %d: %v", id, err)
+					return
+				}
+
+				if cancelled {
+					showSuccess("cancelled: %s %s (offer %d, expired at %s)", offer.Amount, assetCode(&offer.Selling), id, expiry)
+				} else {
+					showSuccess("offer %d is already gone (filled or cancelled)", id)
+				}
+			}
+		},
+	}
 
 	buildFlagsForTxOptions(cmd)
 	return cmd
@@ -116,8 +1048,8 @@ func (cli *CLI) buildDexTradeCmd() *cobra.Command {
 
 func (cli *CLI) buildDexListCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "list [account]",
-		Short: "list trade offers made by [account]",
+		Use:   "list [account] [--include-pools]",
+		Short: "list trade offers made by [account], and optionally its liquidity pool shares",
 		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			name := args[0]
@@ -144,15 +1076,47 @@ func (cli *CLI) buildDexListCmd() *cobra.Command {
 			offers, err := cli.ms.LoadOffers(address, opts)
 
 			if err != nil {
-				cli.error(logFields, "can't load offers: %v", microstellar.ErrorString(err))
+				cli.errorWithCode(ExitNetworkError, logFields, "can't load offers: %v", cli.errorString(err))
 				return
 			}
 
 			format, err := cmd.Flags().GetString("format")
+			pretty, _ := cmd.Flags().GetBool("pretty")
+
+			includePools, _ := cmd.Flags().GetBool("include-pools")
+			positions := []poolPosition{}
+
+			if includePools {
+				positions, err = cli.loadPoolPositions(logFields, address)
+				if err != nil {
+					cli.errorWithCode(ExitNetworkError, logFields, "can't load pool positions: %v", cli.errorString(err))
+					return
+				}
+
+				// Keep offers and pool positions apart, so scripts can tell them apart
+				if format == "json" {
+					if offers == nil {
+						offers = []microstellar.Offer{}
+					}
+
+					data, err := marshalJSON(struct {
+						Offers []microstellar.Offer `json:"offers"`
+						Pools  []poolPosition       `json:"pools"`
+					}{offers, positions}, pretty)
+
+					if err != nil {
+						cli.error(logFields, "got bad data: %v", err)
+						return
+					}
+
+					showSuccess("%v", string(data))
+					return
+				}
+			}
 
 			for _, offer := range offers {
 				if format == "json" {
-					data, err := json.MarshalIndent(offer, "", "  ")
+					data, err := marshalJSON(offer, pretty)
 
 					if err != nil {
 						logrus.WithFields(logFields).Errorf("skipping bad data: %v", err)
@@ -177,20 +1141,36 @@ func (cli *CLI) buildDexListCmd() *cobra.Command {
 						offer.ID, offer.Amount, sellingCode, buyingCode, offer.Price, buyingCode, sellingCode)
 				}
 			}
+
+			for _, position := range positions {
+				if format == "struct" {
+					showSuccess("%+v", position)
+					continue
+				}
+
+				var reserves []string
+				for _, reserve := range position.Reserves {
+					reserves = append(reserves, reserve.Amount+" "+reserve.Asset)
+				}
+
+				showSuccess("(pool %s) %s shares, worth %s", position.PoolID, position.Shares, strings.Join(reserves, " and "))
+			}
 		},
 	}
 
 	cmd.Flags().String("format", "line", "output format (json, struct, line)")
+	buildPrettyFlag(cmd)
 	cmd.Flags().String("cursor", "", "start listing from paging token")
 	cmd.Flags().Uint("limit", 10, "return at most this many results")
 	cmd.Flags().Bool("desc", false, "descending order")
+	cmd.Flags().Bool("include-pools", false, "also list the account's liquidity pool shares, and the reserves they're worth")
 
 	return cmd
 }
 
 func (cli *CLI) buildDexOrderBookCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "orderbook [sell_asset] [buy_asset] [--limit 10]",
+		Use:   "orderbook [sell_asset] [buy_asset] [--limit 10] [--depth 5] [--watch [--interval 5s]]",
 		Short: "list bids/asks on the DEX between sell_asset and buy_asset",
 		Args:  cobra.MinimumNArgs(2),
 		Run: func(cmd *cobra.Command, args []string) {
@@ -214,38 +1194,417 @@ func (cli *CLI) buildDexOrderBookCmd() *cobra.Command {
 				return
 			}
 
-			orderbook, err := cli.ms.LoadOrderBook(sellAsset, buyAsset, opts)
+			format, err := cmd.Flags().GetString("format")
+			pretty, _ := cmd.Flags().GetBool("pretty")
+			depth, _ := cmd.Flags().GetUint("depth")
 
-			if err != nil {
-				cli.error(logFields, "can't load offers: %v", microstellar.ErrorString(err))
+			if watching, _ := cmd.Flags().GetBool("watch"); !watching {
+				for _, flag := range []string{"interval", "count"} {
+					if cmd.Flags().Changed(flag) {
+						cli.error(logFields, "--%s is only for --watch", flag)
+						return
+					}
+				}
+
+				orderbook, err := cli.ms.LoadOrderBook(sellAsset, buyAsset, opts)
+				if err != nil {
+					cli.errorWithCode(ExitNetworkError, logFields, "can't load offers: %v", cli.errorString(err))
+					return
+				}
+
+				cli.showOrderBook(logFields, orderbook, int(depth), format, pretty)
 				return
 			}
 
-			format, err := cmd.Flags().GetString("format")
+			interval, _ := cmd.Flags().GetDuration("interval")
+			if interval <= 0 {
+				cli.error(logFields, "bad --interval: %v", interval)
+				return
+			}
+
+			count, _ := cmd.Flags().GetUint("count")
+
+			// Redraw in place on a terminal, and append otherwise, so the output can
+			// still be piped or sent to --output
+			stat, err := os.Stdout.Stat()
+			redraw := err == nil && (stat.Mode()&os.ModeCharDevice) != 0
 
-			if format == "json" {
-				data, err := json.MarshalIndent(*orderbook, "", "  ")
+			for refresh := uint(1); ; refresh++ {
+				orderbook, err := cli.ms.LoadOrderBook(sellAsset, buyAsset, opts)
 
 				if err != nil {
-					cli.error(logFields, "got bad data: %v", err)
-					return
+					// Keep refreshing through transient failures
+					showError(logFields, "can't load offers: %v", cli.errorString(err))
 				} else {
-					showSuccess("%v", string(data))
-				}
-			} else {
-				for _, ask := range orderbook.Asks {
+					if redraw {
+						fmt.Print("\033[H\033[2J")
+					}
 
-					showSuccess("ask: %s %s for %s %s/%s", ask.Amount, orderbook.Base.Code, ask.Price, orderbook.Counter.Code, orderbook.Base.Code)
+					if format != "json" {
+						showSuccess("%s/%s at %s", assetCode(sellAsset), assetCode(buyAsset), time.Now().UTC().Format("2006-01-02 15:04:05"))
+					}
+
+					cli.showOrderBook(logFields, orderbook, int(depth), format, pretty)
 				}
-				for _, bid := range orderbook.Bids {
-					showSuccess("bid: %s %s for %s %s/%s", bid.Amount, orderbook.Counter.Code, bid.Price, orderbook.Counter.Code, orderbook.Base.Code)
+
+				if count > 0 && refresh >= count {
+					return
 				}
+
+				time.Sleep(interval)
 			}
 		},
 	}
 
 	cmd.Flags().String("format", "line", "output format (json, struct, line)")
+	buildPrettyFlag(cmd)
+	cmd.Flags().Uint("limit", 10, "return at most this many results")
+	cmd.Flags().Uint("depth", 0, "aggregate into at most this many equal price ranges, with cumulative amounts and mid-price")
+	cmd.Flags().Bool("watch", false, "refresh the orderbook every --interval until interrupted")
+	cmd.Flags().Duration("interval", 5*time.Second, "time between refreshes with --watch")
+	cmd.Flags().Uint("count", 0, "stop after this many refreshes with --watch, 0 to refresh forever")
+
+	return cmd
+}
+
+// showOrderBook shows the bids and asks in orderbook, aggregated into depth price
+// levels if depth is set.
+func (cli *CLI) showOrderBook(logFields logrus.Fields, orderbook *microstellar.OrderBook, depth int, format string, pretty bool) {
+	if depth > 0 {
+		cli.showOrderBookDepth(logFields, orderbook, depth, format, pretty)
+		return
+	}
+
+	if format == "json" {
+		data, err := marshalJSON(*orderbook, pretty)
+
+		if err != nil {
+			cli.error(logFields, "got bad data: %v", err)
+			return
+		}
+
+		showSuccess("%v", string(data))
+		return
+	}
+
+	for _, ask := range orderbook.Asks {
+		showSuccess("ask: %s %s for %s %s/%s", ask.Amount, orderbook.Base.Code, ask.Price, orderbook.Counter.Code, orderbook.Base.Code)
+	}
+
+	for _, bid := range orderbook.Bids {
+		showSuccess("bid: %s %s for %s %s/%s", bid.Amount, orderbook.Counter.Code, bid.Price, orderbook.Counter.Code, orderbook.Base.Code)
+	}
+}
+
+// depthLevel is an aggregated price level in an orderbook.
+type depthLevel struct {
+	Price      string `json:"price"`
+	Amount     string `json:"amount"`
+	Cumulative string `json:"cumulative"`
+}
+
+// orderBookDepth is an aggregated orderbook, emitted by "dex orderbook --depth".
+type orderBookDepth struct {
+	Base     *microstellar.Asset `json:"base"`
+	Counter  *microstellar.Asset `json:"counter"`
+	MidPrice string              `json:"mid_price,omitempty"`
+	Asks     []depthLevel        `json:"asks"`
+	Bids     []depthLevel        `json:"bids"`
+}
+
+// aggregateLevels groups levels (best price first) into at most depth price buckets,
+// which split the range from the best to the worst price evenly. Each aggregated level
+// has the worst price in its bucket, i.e., the price it takes to fill the cumulative
+// amount. Empty buckets are left out.
+func aggregateLevels(levels []microstellar.BidAsk, depth int) ([]depthLevel, error) {
+	result := []depthLevel{}
+	if len(levels) == 0 {
+		return result, nil
+	}
+
+	prices := make([]int64, len(levels))
+	for i, level := range levels {
+		price, err := amount.ParseInt64(level.Price)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad price: %s", level.Price)
+		}
+		prices[i] = price
+	}
+
+	// Asks go up from the best price, and bids go down
+	span := prices[len(prices)-1] - prices[0]
+	if span < 0 {
+		span = -span
+	}
+
+	bucket := func(price int64) int {
+		offset := price - prices[0]
+		if offset < 0 {
+			offset = -offset
+		}
+
+		if span == 0 {
+			return 0
+		}
+
+		b := int(float64(offset) * float64(depth) / float64(span))
+		if b >= depth {
+			b = depth - 1
+		}
+		return b
+	}
+
+	var cumulative int64
+	for i := 0; i < len(levels); {
+		b := bucket(prices[i])
+
+		var total int64
+		end := i
+		for ; end < len(levels) && bucket(prices[end]) == b; end++ {
+			amt, err := amount.ParseInt64(levels[end].Amount)
+			if err != nil {
+				return nil, errors.Wrapf(err, "bad amount: %s", levels[end].Amount)
+			}
+			total += amt
+		}
+
+		cumulative += total
+		result = append(result, depthLevel{
+			Price:      levels[end-1].Price,
+			Amount:     amount.StringFromInt64(total),
+			Cumulative: amount.StringFromInt64(cumulative),
+		})
+		i = end
+	}
+
+	return result, nil
+}
+
+// midPrice returns the price halfway between the best bid and best ask, or "" if
+// either side of the book is empty.
+func midPrice(orderbook *microstellar.OrderBook) (string, error) {
+	if len(orderbook.Asks) == 0 || len(orderbook.Bids) == 0 {
+		return "", nil
+	}
+
+	ask, err := strconv.ParseFloat(orderbook.Asks[0].Price, 64)
+	if err != nil {
+		return "", errors.Wrapf(err, "bad price: %s", orderbook.Asks[0].Price)
+	}
+
+	bid, err := strconv.ParseFloat(orderbook.Bids[0].Price, 64)
+	if err != nil {
+		return "", errors.Wrapf(err, "bad price: %s", orderbook.Bids[0].Price)
+	}
+
+	return strconv.FormatFloat((ask+bid)/2, 'f', 7, 64), nil
+}
+
+func (cli *CLI) showOrderBookDepth(logFields logrus.Fields, orderbook *microstellar.OrderBook, depth int, format string, pretty bool) {
+	book := orderBookDepth{Base: orderbook.Base, Counter: orderbook.Counter}
+	var err error
+
+	if book.Asks, err = aggregateLevels(orderbook.Asks, depth); err != nil {
+		cli.errorWithCode(ExitNetworkError, logFields, "got bad asks: %v", err)
+		return
+	}
+
+	if book.Bids, err = aggregateLevels(orderbook.Bids, depth); err != nil {
+		cli.errorWithCode(ExitNetworkError, logFields, "got bad bids: %v", err)
+		return
+	}
+
+	if book.MidPrice, err = midPrice(orderbook); err != nil {
+		cli.errorWithCode(ExitNetworkError, logFields, "got bad prices: %v", err)
+		return
+	}
+
+	if format == "json" {
+		data, err := marshalJSON(book, pretty)
+
+		if err != nil {
+			cli.error(logFields, "got bad data: %v", err)
+			return
+		}
+
+		showSuccess("%v", string(data))
+		return
+	}
+
+	base := book.Base.Code
+	counter := book.Counter.Code
+
+	for _, ask := range book.Asks {
+		showSuccess("ask: %s %s for %s %s/%s (cumulative: %s %s)", ask.Amount, base, ask.Price, counter, base, ask.Cumulative, base)
+	}
+
+	if book.MidPrice != "" {
+		showSuccess("mid: %s %s/%s", book.MidPrice, counter, base)
+	}
+
+	for _, bid := range book.Bids {
+		showSuccess("bid: %s %s for %s %s/%s (cumulative: %s %s)", bid.Amount, counter, bid.Price, counter, base, bid.Cumulative, counter)
+	}
+}
+
+func (cli *CLI) buildDexOffersForPairCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "offers-for-pair [sell_asset] [buy_asset] [--seller account] [--limit 10] [--cursor token]",
+		Short: "list the offers (and their sellers) selling sell_asset for buy_asset",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			sellAssetName := args[0]
+			buyAssetName := args[1]
+
+			logFields := logrus.Fields{"cmd": "dex", "subcmd": "offers-for-pair"}
+
+			sellAsset, err := cli.ResolveAsset(sellAssetName)
+			if err != nil {
+				cli.error(logFields, "invalid sell asset: %s", sellAssetName)
+				return
+			}
+
+			buyAsset, err := cli.ResolveAsset(buyAssetName)
+			if err != nil {
+				cli.error(logFields, "invalid buy asset: %s", buyAssetName)
+				return
+			}
+
+			query := url.Values{}
+			setAssetParams(query, "selling", sellAsset)
+			setAssetParams(query, "buying", buyAsset)
+
+			if seller, _ := cmd.Flags().GetString("seller"); seller != "" {
+				address, err := cli.ResolveAccount(logFields, seller, "address")
+				if err != nil {
+					cli.error(logFields, "invalid seller: %s", seller)
+					return
+				}
+
+				query.Set("seller", address)
+			}
+
+			cursor, _ := cmd.Flags().GetString("cursor")
+			limit, _ := cmd.Flags().GetUint("limit")
+
+			offers, err := cli.loadPairOffers(logFields, query, cursor, int(limit))
+			if err != nil {
+				cli.errorWithCode(ExitNetworkError, logFields, "can't load offers: %v", cli.errorString(err))
+				return
+			}
+
+			format, _ := cmd.Flags().GetString("format")
+			pretty, _ := cmd.Flags().GetBool("pretty")
+
+			for _, offer := range offers {
+				if format == "json" {
+					data, err := marshalJSON(offer, pretty)
+
+					if err != nil {
+						logrus.WithFields(logFields).Errorf("skipping bad data: %v", err)
+					} else {
+						showSuccess("%v", string(data))
+					}
+				} else {
+					sellingCode := offer.Selling.code()
+					buyingCode := offer.Buying.code()
+
+					showSuccess("(%s) %s selling %s %s for %s at %s %s/%s",
+						offer.ID, offer.Seller, offer.Amount, sellingCode, buyingCode, offer.Price, buyingCode, sellingCode)
+				}
+			}
+		},
+	}
+
+	cmd.Flags().String("format", "line", "output format (json, line)")
+	buildPrettyFlag(cmd)
+	cmd.Flags().String("seller", "", "only list offers made by this account")
+	cmd.Flags().String("cursor", "", "start listing from paging token")
 	cmd.Flags().Uint("limit", 10, "return at most this many results")
 
 	return cmd
 }
+
+// offerAsset is an asset in a horizon offer record.
+type offerAsset struct {
+	Type   string `json:"asset_type"`
+	Code   string `json:"asset_code,omitempty"`
+	Issuer string `json:"asset_issuer,omitempty"`
+}
+
+// code returns the asset code, or xlm for native assets.
+func (asset offerAsset) code() string {
+	if asset.Code == "" {
+		return "xlm"
+	}
+
+	return asset.Code
+}
+
+// asset returns asset as a microstellar asset.
+func (asset offerAsset) asset() microstellar.Asset {
+	return microstellar.Asset{Code: asset.Code, Issuer: asset.Issuer, Type: microstellar.AssetType(asset.Type)}
+}
+
+// offerID is an offer ID, which older versions of horizon return as a number, and
+// newer ones as a string.
+type offerID string
+
+func (id *offerID) UnmarshalJSON(data []byte) error {
+	*id = offerID(strings.Trim(string(data), `"`))
+	return nil
+}
+
+// pairOffer is an offer returned by horizon's /offers endpoint.
+type pairOffer struct {
+	ID          offerID    `json:"id"`
+	PagingToken string     `json:"paging_token"`
+	Seller      string     `json:"seller"`
+	Selling     offerAsset `json:"selling"`
+	Buying      offerAsset `json:"buying"`
+	Amount      string     `json:"amount"`
+	Price       string     `json:"price"`
+}
+
+// maxPageSize is the largest page horizon returns.
+const maxPageSize = 200
+
+// loadPairOffers pages through horizon's offers matching query, starting after cursor,
+// until it has limit offers or runs out.
+func (cli *CLI) loadPairOffers(logFields logrus.Fields, query url.Values, cursor string, limit int) ([]pairOffer, error) {
+	offers := []pairOffer{}
+
+	for len(offers) < limit {
+		pageSize := limit - len(offers)
+		if pageSize > maxPageSize {
+			pageSize = maxPageSize
+		}
+
+		query.Set("limit", strconv.Itoa(pageSize))
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+
+		var page struct {
+			Embedded struct {
+				Records []pairOffer `json:"records"`
+			} `json:"_embedded"`
+		}
+
+		if err := cli.getHorizonJSON(logFields, "/offers?"+query.Encode(), &page); err != nil {
+			return nil, err
+		}
+
+		records := page.Embedded.Records
+		debugf(logFields, "got %d offers after cursor %q", len(records), cursor)
+		offers = append(offers, records...)
+
+		if len(records) < pageSize {
+			break
+		}
+
+		cursor = records[len(records)-1].PagingToken
+	}
+
+	return offers, nil
+}
@@ -1,6 +1,16 @@
 package cli
 
-import "testing"
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xfe/microstellar"
+)
 
 // Note: add -v to any of these commands to enable verbose logging
 
@@ -25,7 +35,534 @@ func TestDex(t *testing.T) {
 	expectOutput(t, cli, "", "dex trade mo --buy USD --sell EUR --amount 20 --price 2")
 	expectOutput(t, cli, "", "dex trade mo --buy INR --sell USD --amount 20 --price 2 --update 23112")
 	expectOutput(t, cli, "", "dex trade mo --buy INR --sell USD --amount 20 --price 2 --delete 23112")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell INR --amount 20.12345678 --price 2")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell INR --amount 0 --price 2")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell INR --price 2")
+	expectOutput(t, cli, "", "dex trade mo --buy USD --sell INR --buy-amount 40 --price 2")
+	expectOutput(t, cli, "", "dex trade mo --buy INR --sell USD --buy-amount 40 --price 2 --update 23112")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell INR --buy-amount 40 --amount 20 --price 2")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell INR --buy-amount -40 --price 2")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell INR --buy-amount 40 --price 0")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell INR --buy-amount 40 --price cheap")
 	expectOutput(t, cli, "", "dex list mo --cursor 23443 --limit 3 --desc")
 
+	// Dry runs don't need a seed, and nothing crosses on the fake network
+	cli.TestCommand("account set viewer GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+	expectOutput(t, cli, "fills: nothing\nrests: 20.0000000 INR at 2 USD/INR", "dex trade viewer --buy USD --sell INR --amount 20 --price 2 --dry-run")
+	expectOutput(t, cli, "error", "dex trade mo --buy INR --sell USD --price 2 --delete 23112 --dry-run")
+
+	// Nothing crosses on the fake network, so these aren't submitted
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell INR --amount 20 --price 2 --fill-or-kill")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell INR --amount 20 --price 2 --immediate-or-cancel")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell INR --amount 20 --price 2 --fill-or-kill --immediate-or-cancel")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell INR --amount 20 --price 2 --fill-or-kill --update 23112")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell INR --amount 20 --price 2 --immediate-or-cancel --batch")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell INR --amount 20 --price 2 --fill-or-kill --nosubmit")
+
+	// Nothing crosses on the fake network, so post-only offers are submitted
+	expectOutput(t, cli, "", "dex trade mo --buy USD --sell INR --amount 20 --price 2 --post-only")
+	expectOutput(t, cli, "", "dex trade mo --buy USD --sell INR --amount 20 --price 2 --post-only --passive --update 23112")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell INR --price 2 --post-only --delete 23112")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell INR --amount 20 --price 2 --post-only --immediate-or-cancel")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell INR --amount 20 --post-only --market")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell INR --amount 20 --price 2 --post-only --batch")
+
+	// The orderbook is empty on the fake network, so there's no market price
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell INR --amount 20 --market")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell INR --amount 20 --market --price 2")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell INR --amount 20 --market --delete 23112")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell INR --amount 20 --price 2 --slippage 1")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell INR --amount 20")
+
 	expectOutput(t, cli, "", "dex orderbook USD INR --limit 10")
+	expectOutput(t, cli, "", "dex orderbook USD INR --depth 5")
+	expectOutput(t, cli, "error", "dex orderbook USD INR --interval 1s")
+	expectOutput(t, cli, "error", "dex orderbook USD INR --watch --interval 0s")
+
+	// Not a terminal, so refreshes are appended
+	got := cli.TestCommand("dex orderbook USD INR --watch --count 2 --interval 1ms")
+	if lines := strings.Split(strings.TrimSpace(got), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], "USD/INR at ") {
+		t.Errorf("want two refreshes, got:\n%s", got)
+	}
+
+	// One compact orderbook per line, without headers
+	got = cli.TestCommand("dex orderbook USD INR --watch --count 2 --interval 1ms --format json")
+	if n := strings.Count(got, "\n{\"bids\""); !strings.HasPrefix(got, "{\"bids\"") || n != 1 {
+		t.Errorf("want two orderbooks, got:\n%s", got)
+	}
+}
+
+func TestDexReprice(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account new issuer-chase")
+	cli.TestCommand("asset set USD issuer-chase")
+	cli.TestCommand("account set viewer GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+
+	// There are no offers on the fake network, so they're all gone
+	expectOutput(t, cli, "error", "dex reprice mo 23112 --price 2 --amount 10")
+	expectOutput(t, cli, "offer 23112 is gone (filled or cancelled), placing a new one", "dex reprice mo 23112 --price 2 --amount 10 --buy USD --sell native")
+
+	expectOutput(t, cli, "error", "dex reprice mo 23112 --price 2 --amount 10 --buy USD")
+	expectOutput(t, cli, "error", "dex reprice mo 23112 --price 2 --amount 10 --buy INR --sell native")
+	expectOutput(t, cli, "error", "dex reprice mo 23112 --price 2 --amount 0 --buy USD --sell native")
+	expectOutput(t, cli, "error", "dex reprice mo 23112 --price 0 --amount 10 --buy USD --sell native")
+	expectOutput(t, cli, "error", "dex reprice mo offer --price 2 --amount 10 --buy USD --sell native")
+	expectOutput(t, cli, "error", "dex reprice mo 0 --price 2 --amount 10 --buy USD --sell native")
+	expectOutput(t, cli, "error", "dex reprice viewer 23112 --price 2 --amount 10 --buy USD --sell native")
+	expectOutput(t, cli, "error", "dex reprice nobody 23112 --price 2 --amount 10 --buy USD --sell native")
+
+	cli.TestCommand("dex reprice mo 23112 --amount 10 --buy USD --sell native")
+	if cli.ExitCode() != ExitBadArgs {
+		t.Errorf("want exit code %d without --price, got %d", ExitBadArgs, cli.ExitCode())
+	}
+
+	if offerGone(fmt.Errorf("op_offer_not_found")) {
+		t.Errorf("want only horizon errors to mean the offer is gone")
+	}
+}
+
+func TestOrderBookDepth(t *testing.T) {
+	levels := []microstellar.BidAsk{
+		{Price: "1.0000000", Amount: "10.0000000"},
+		{Price: "1.1000000", Amount: "5.0000000"},
+		{Price: "1.2000000", Amount: "2.5000000"},
+	}
+
+	check := func(levels []microstellar.BidAsk, depth int, want []depthLevel) {
+		got, err := aggregateLevels(levels, depth)
+		if err != nil {
+			t.Fatalf("aggregateLevels: %v", err)
+		}
+
+		if len(got) != len(want) {
+			t.Fatalf("want %d levels, got %d: %+v", len(want), len(got), got)
+		}
+
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("level %d: want %+v, got %+v", i, want[i], got[i])
+			}
+		}
+	}
+
+	// Two buckets: [1.0, 1.1) and [1.1, 1.2]
+	check(levels, 2, []depthLevel{
+		{Price: "1.0000000", Amount: "10.0000000", Cumulative: "10.0000000"},
+		{Price: "1.2000000", Amount: "7.5000000", Cumulative: "17.5000000"},
+	})
+
+	// Grouped by price, not by count: three levels near the best price share a bucket,
+	// and the empty bucket between them and 2.0 is left out
+	asks := []microstellar.BidAsk{
+		{Price: "1.0000000", Amount: "1.0000000"},
+		{Price: "1.0100000", Amount: "1.0000000"},
+		{Price: "1.0200000", Amount: "1.0000000"},
+		{Price: "2.0000000", Amount: "1.0000000"},
+	}
+	check(asks, 3, []depthLevel{
+		{Price: "1.0200000", Amount: "3.0000000", Cumulative: "3.0000000"},
+		{Price: "2.0000000", Amount: "1.0000000", Cumulative: "4.0000000"},
+	})
+
+	// Bids go down from the best price
+	bids := []microstellar.BidAsk{
+		{Price: "1.0000000", Amount: "1.0000000"},
+		{Price: "0.9000000", Amount: "2.0000000"},
+		{Price: "0.5000000", Amount: "4.0000000"},
+	}
+	check(bids, 2, []depthLevel{
+		{Price: "0.9000000", Amount: "3.0000000", Cumulative: "3.0000000"},
+		{Price: "0.5000000", Amount: "4.0000000", Cumulative: "7.0000000"},
+	})
+
+	// One price, one level
+	check(levels[:1], 5, []depthLevel{{Price: "1.0000000", Amount: "10.0000000", Cumulative: "10.0000000"}})
+
+	if _, err := aggregateLevels([]microstellar.BidAsk{{Price: "x", Amount: "1"}}, 2); err == nil {
+		t.Errorf("want error for bad price")
+	}
+
+	orderbook := &microstellar.OrderBook{
+		Asks: levels,
+		Bids: []microstellar.BidAsk{{Price: "0.9000000", Amount: "1.0000000"}},
+	}
+
+	if mid, _ := midPrice(orderbook); mid != "0.9500000" {
+		t.Errorf("want mid-price 0.9500000, got %s", mid)
+	}
+
+	orderbook.Bids = nil
+	if mid, _ := midPrice(orderbook); mid != "" {
+		t.Errorf("want no mid-price for one-sided book, got %s", mid)
+	}
+}
+
+func TestSellAmountFor(t *testing.T) {
+	tests := []struct {
+		buyAmount string
+		price     string
+		want      string
+	}{
+		{"40", "2", "20.0000000"},
+		{"10", "0.5", "20.0000000"},
+		{"1", "3", "0.3333334"},
+		{"0.0000001", "1000", "0.0000001"},
+		{"100", "1/3", "300.0000000"},
+	}
+
+	for _, test := range tests {
+		got, err := sellAmountFor(test.buyAmount, test.price)
+		if err != nil || got != test.want {
+			t.Errorf("sellAmountFor(%s, %s): want %s, got %s (%v)", test.buyAmount, test.price, test.want, got, err)
+		}
+	}
+
+	for _, price := range []string{"0", "-1", "cheap", ""} {
+		if _, err := sellAmountFor("1", price); err == nil {
+			t.Errorf("want error for price %q", price)
+		}
+	}
+
+	if _, err := sellAmountFor("922337203685", "0.5"); err == nil {
+		t.Errorf("want error for amount too large")
+	}
+}
+
+func TestCancelRemainder(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account new issuer")
+	cli.TestCommand("asset set USD issuer")
+	seed, _ := cli.ResolveAccount(nil, "mo", "seed")
+	address := addressFromSeed(seed)
+
+	// Offer 1 was there before, and offer 2 is the remainder
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		issuer, _ := cli.ResolveAccount(nil, "issuer", "address")
+		records := []string{}
+		for i := 1; i <= 2 && r.URL.Query().Get("cursor") == ""; i++ {
+			records = append(records, fmt.Sprintf(`{"id": %d, "paging_token": "%d", "seller": "%s",
+				"selling": {"asset_type": "credit_alphanum4", "asset_code": "USD", "asset_issuer": "%s"},
+				"buying": {"asset_type": "native"}, "amount": "10.0000000", "price": "0.5000000"}`, i, i, address, issuer))
+		}
+
+		fmt.Fprintf(w, `{"_embedded": {"records": [%s]}}`, strings.Join(records, ","))
+	})
+	defer server.Close()
+
+	defaultTransport := http.DefaultClient.Transport
+	defer func() { http.DefaultClient.Transport = defaultTransport }()
+
+	// The offer took sequence number 10, so the cancellation takes 11
+	cmd := cli.buildDexTradeCmd()
+	cmd.Flags().Set("sequence", "10")
+	usd, _ := cli.ResolveAsset("USD")
+	cli.Embeddable().cancelRemainder(cmd, nil, "mo", seed, usd, microstellar.NativeAsset, map[int64]bool{1: true})
+
+	transport, ok := http.DefaultClient.Transport.(*sequenceTransport)
+	if !ok || transport.address != address || transport.sequence != 10 {
+		t.Errorf("want cancellation after sequence number 10 of %s, got %+v", address, http.DefaultClient.Transport)
+	}
+}
+
+func TestLoadAccountOffers(t *testing.T) {
+	cli, _ := newTestCLI()
+	address := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+
+	// More offers than fit in a page, so the newest are on the second one
+	var queries []string
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		cursor, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+		records := []string{}
+		for i := cursor + 1; i <= 250 && len(records) < limit; i++ {
+			records = append(records, fmt.Sprintf(`{"id": %d, "paging_token": "%d", "seller": "%s",
+				"selling": {"asset_type": "credit_alphanum4", "asset_code": "USD", "asset_issuer": "issuer"},
+				"buying": {"asset_type": "native"}, "amount": "10.0000000", "price": "0.5000000"}`,
+				i, i, r.URL.Query().Get("seller")))
+		}
+
+		fmt.Fprintf(w, `{"_embedded": {"records": [%s]}}`, strings.Join(records, ","))
+	})
+	defer server.Close()
+
+	offers, err := cli.loadAccountOffers(nil, address)
+	if err != nil || len(offers) != 250 {
+		t.Fatalf("want 250 offers, got %d (%v)", len(offers), err)
+	}
+
+	if len(queries) != 2 || !strings.Contains(queries[1], "seller="+address) || !strings.Contains(queries[1], "cursor=200") {
+		t.Errorf("want two pages of %s's offers, got %v", address, queries)
+	}
+
+	want := microstellar.Offer{ID: 250, Seller: address, Amount: "10.0000000", Price: "0.5000000",
+		Selling: *microstellar.NewAsset("USD", "issuer", microstellar.Credit4Type), Buying: microstellar.Asset{Type: microstellar.NativeType}}
+	if !reflect.DeepEqual(offers[249], want) {
+		t.Errorf("want offer %+v, got %+v", want, offers[249])
+	}
+
+	if ids := cli.loadOfferIDs(nil, address); len(ids) != 250 || !ids[250] {
+		t.Errorf("want IDs of all 250 offers, got %d", len(ids))
+	}
+}
+
+func TestDexOffersForPair(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account set mo GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+	cli.TestCommand("account new issuer")
+	cli.TestCommand("asset set USD issuer")
+
+	expectOutput(t, cli, "", "dex offers-for-pair USD native")
+	expectOutput(t, cli, "error", "dex offers-for-pair USD BAD:nobody")
+	expectOutput(t, cli, "error", "dex offers-for-pair USD native --seller nobody")
+
+	// Three offers, in pages of at most two
+	var queries []string
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		records := []string{}
+		for i := 1; i <= 3; i++ {
+			if cursor := r.URL.Query().Get("cursor"); cursor != "" && fmt.Sprint(i) <= cursor {
+				continue
+			}
+
+			if limit := r.URL.Query().Get("limit"); fmt.Sprint(len(records)) >= limit {
+				break
+			}
+
+			records = append(records, fmt.Sprintf(`{"id": "%d", "paging_token": "%d", "seller": "%s",
+				"selling": {"asset_type": "credit_alphanum4", "asset_code": "USD", "asset_issuer": "issuer"},
+				"buying": {"asset_type": "native"}, "amount": "%d.0000000", "price": "0.5000000"}`,
+				i, i, r.URL.Query().Get("seller"), i*10))
+		}
+
+		fmt.Fprintf(w, `{"_embedded": {"records": [%s]}}`, strings.Join(records, ","))
+	})
+	defer server.Close()
+
+	got := cli.TestCommand("dex offers-for-pair USD native --limit 2 --seller mo")
+	want := "(1) GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM selling 10.0000000 USD for xlm at 0.5000000 xlm/USD\n" +
+		"(2) GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM selling 20.0000000 USD for xlm at 0.5000000 xlm/USD\n"
+	if got != want {
+		t.Errorf("want offers:\n%s\ngot:\n%s", want, got)
+	}
+
+	if !strings.Contains(queries[0], "selling_asset_code=USD") || !strings.Contains(queries[0], "buying_asset_type=native") {
+		t.Errorf("want query for USD/native offers, got %s", queries[0])
+	}
+
+	expectOutput(t, cli, "(3)  selling 30.0000000 USD for xlm at 0.5000000 xlm/USD", "dex offers-for-pair USD native --cursor 2")
+
+	queries = nil
+	if offers, err := cli.loadPairOffers(nil, map[string][]string{}, "", 5); err != nil || len(offers) != 3 {
+		t.Errorf("want 3 offers, got %d (%v)", len(offers), err)
+	}
+
+	if len(queries) != 1 {
+		t.Errorf("want one page for a partial result, got %d: %v", len(queries), queries)
+	}
+}
+
+func TestSimulateFill(t *testing.T) {
+	// Offers selling EUR for USD, priced in USD/EUR. An offer to sell USD for EUR at
+	// 2 EUR/USD crosses asks up to 0.5 USD/EUR.
+	asks := []microstellar.BidAsk{
+		{Price: "0.4000000", Amount: "10.0000000"},
+		{Price: "0.5000000", Amount: "10.0000000"},
+		{Price: "0.6000000", Amount: "100.0000000"},
+	}
+
+	tests := []struct {
+		amount  string
+		passive bool
+		want    fillEstimate
+	}{
+		// Partial fill of the first level
+		{"2", false, fillEstimate{"2.0000000", "5.0000000", "2.5000000", "0.0000000"}},
+		// Both crossing levels (4 + 5 USD), and the rest rests
+		{"20", false, fillEstimate{"9.0000000", "20.0000000", "2.2222222", "11.0000000"}},
+		// Passive offers don't take offers at the same price
+		{"20", true, fillEstimate{"4.0000000", "10.0000000", "2.5000000", "16.0000000"}},
+	}
+
+	for _, test := range tests {
+		got, err := simulateFill(asks, test.amount, "2", test.passive)
+		if err != nil {
+			t.Fatalf("simulateFill(%s): %v", test.amount, err)
+		}
+
+		if *got != test.want {
+			t.Errorf("simulateFill(%s, passive: %v): want %+v, got %+v", test.amount, test.passive, test.want, *got)
+		}
+	}
+
+	if got, _ := simulateFill(nil, "20", "2", false); got.Sold != "0.0000000" || got.Remaining != "20.0000000" {
+		t.Errorf("want no fill on an empty book, got %+v", got)
+	}
+
+	if _, err := simulateFill(asks, "20", "free", false); err == nil {
+		t.Error("want error for bad price")
+	}
+}
+
+func TestCrossesBook(t *testing.T) {
+	// Offers selling EUR for USD, priced in USD/EUR. An offer to sell USD for EUR
+	// crosses the best ask at 2.5 EUR/USD or less.
+	asks := []microstellar.BidAsk{
+		{Price: "0.4000000", Amount: "10.0000000"},
+		{Price: "0.5000000", Amount: "10.0000000"},
+	}
+
+	tests := []struct {
+		price   string
+		passive bool
+		want    bool
+	}{
+		{"2", false, true},
+		{"2.5", false, true},
+		{"2.5", true, false},
+		{"2.6", false, false},
+		{"3", true, false},
+	}
+
+	for _, test := range tests {
+		got, err := crossesBook(asks, test.price, test.passive)
+		if err != nil {
+			t.Fatalf("crossesBook(%s): %v", test.price, err)
+		}
+
+		if got != test.want {
+			t.Errorf("crossesBook(%s, passive: %v): want %v, got %v", test.price, test.passive, test.want, got)
+		}
+	}
+
+	if got, _ := crossesBook(nil, "2", false); got {
+		t.Error("want nothing to cross on an empty book")
+	}
+
+	if _, err := crossesBook(asks, "free", false); err == nil {
+		t.Error("want error for bad price")
+	}
+}
+
+func TestMarketPrice(t *testing.T) {
+	// Offers selling EUR for USD, priced in USD/EUR
+	asks := []microstellar.BidAsk{
+		{Price: "0.4000000", Amount: "10.0000000"},
+		{Price: "0.5000000", Amount: "10.0000000"},
+	}
+
+	tests := []struct {
+		slippage string
+		want     string
+	}{
+		{"0", "2.5000000"},
+		{"1", "2.4750000"},
+		{"20", "2.0000000"},
+		{"0.5", "2.4875000"},
+	}
+
+	for _, test := range tests {
+		got, err := marketPrice(asks, test.slippage)
+		if err != nil {
+			t.Errorf("slippage %s: unexpected error: %v", test.slippage, err)
+			continue
+		}
+
+		if got != test.want {
+			t.Errorf("slippage %s: want price %s, got %s", test.slippage, test.want, got)
+		}
+
+		// The price must cross the best ask
+		if fill, _ := simulateFill(asks, "1", got, false); fill.Sold != "1.0000000" {
+			t.Errorf("slippage %s: want price %s to cross the best ask, got %+v", test.slippage, got, fill)
+		}
+	}
+
+	// Rounded down to the stroop, so that it still crosses
+	if got, _ := marketPrice([]microstellar.BidAsk{{Price: "3", Amount: "1"}}, "0"); got != "0.3333333" {
+		t.Errorf("want price rounded down, got %s", got)
+	}
+
+	for _, slippage := range []string{"-1", "100", "lots"} {
+		if _, err := marketPrice(asks, slippage); err == nil {
+			t.Errorf("want error for slippage %s", slippage)
+		}
+	}
+
+	if _, err := marketPrice(nil, "0"); err == nil {
+		t.Error("want error for empty orderbook")
+	}
+}
+
+func TestSameAsset(t *testing.T) {
+	usd := microstellar.NewAsset("USD", "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM", microstellar.Credit4Type)
+	otherUSD := microstellar.NewAsset("USD", "GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U", microstellar.Credit4Type)
+	horizonNative := microstellar.Asset{Type: microstellar.NativeType}
+
+	if !sameAsset(*usd, *usd) || sameAsset(*usd, *otherUSD) {
+		t.Errorf("want assets compared by code and issuer")
+	}
+
+	if !sameAsset(horizonNative, *microstellar.NativeAsset) || sameAsset(horizonNative, *usd) {
+		t.Errorf("want native assets equal regardless of code")
+	}
+}
+
+func TestExpiredOffers(t *testing.T) {
+	now := time.Now()
+	expiries := map[int64]time.Time{
+		3: now.Add(-time.Minute),
+		1: now,
+		2: now.Add(time.Minute),
+	}
+
+	if got := expiredOffers(expiries, now); !reflect.DeepEqual(got, []int64{1, 3}) {
+		t.Errorf("want offers 1 and 3 expired, got %v", got)
+	}
+}
+
+func TestDexExpire(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account new issuer-chase")
+	cli.TestCommand("asset set USD issuer-chase")
+
+	// There are no offer IDs to track on the fake network
+	expectOutput(t, cli, "", "dex trade mo --buy USD --sell native --amount 10 --price 2 --expire 1h")
+
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell native --amount 10 --price 2 --expire 0s")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell native --amount 10 --price 2 --expire 1h --update 23112")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell native --amount 10 --price 2 --expire 1h --immediate-or-cancel")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell native --amount 10 --price 2 --expire 1h --batch")
+	expectOutput(t, cli, "error", "dex trade mo --buy USD --sell native --amount 10 --price 2 --expire 1h --nosubmit")
+
+	expectOutput(t, cli, "no expired offers", "dex expire-sweep mo")
+	expectOutput(t, cli, "error", "dex expire-sweep nobody")
+	expectOutput(t, cli, "error", "dex expire-sweep mo --nosubmit")
+
+	// There are no offers on the fake network, so the expired ones are all gone
+	address := addressFromSeed(strings.TrimSpace(cli.TestCommand("account seed mo")))
+	cli.SetVar(offerExpiryKey(address, 23112), time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+	cli.SetVar(offerExpiryKey(address, 23113), time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+
+	expectOutput(t, cli, "offer 23112 is already gone (filled or cancelled)", "dex expire-sweep mo")
+	expectOutput(t, cli, "no expired offers", "dex expire-sweep mo")
+
+	expiries, err := cli.offerExpiries(address)
+	if _, ok := expiries[23113]; err != nil || len(expiries) != 1 || !ok {
+		t.Errorf("want only offer 23113 tracked, got %v: %v", expiries, err)
+	}
+
+	cli.SetVar(offerExpiryKey(address, 23114), "soon")
+	expectOutput(t, cli, "error", "dex expire-sweep mo")
 }
@@ -0,0 +1,331 @@
+package cli
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stellar/go/amount"
+)
+
+// historyCutoff is the point in time (a ledger, or a UTC time) that a historical
+// balance is reconstructed at.
+type historyCutoff struct {
+	ledger int64
+	time   time.Time
+}
+
+// after returns true if something in ledger, or closed at createdAt (RFC 3339), is
+// past the cutoff.
+func (cutoff historyCutoff) after(ledger int64, createdAt string) bool {
+	if cutoff.ledger > 0 {
+		return ledger > cutoff.ledger
+	}
+
+	closed, err := time.Parse(time.RFC3339, createdAt)
+	return err == nil && closed.After(cutoff.time)
+}
+
+// poolReserve is an asset amount in a liquidity pool deposit or withdrawal effect.
+type poolReserve struct {
+	Asset  string `json:"asset"`
+	Amount string `json:"amount"`
+}
+
+// balanceEffect is an effect on an account, as returned by horizon. Only the fields
+// of the effects that change balances are included.
+type balanceEffect struct {
+	PagingToken       string        `json:"paging_token"`
+	Type              string        `json:"type"`
+	CreatedAt         string        `json:"created_at"`
+	Amount            string        `json:"amount"`
+	StartingBalance   string        `json:"starting_balance"`
+	AssetType         string        `json:"asset_type"`
+	AssetCode         string        `json:"asset_code"`
+	AssetIssuer       string        `json:"asset_issuer"`
+	SoldAmount        string        `json:"sold_amount"`
+	SoldAssetType     string        `json:"sold_asset_type"`
+	SoldAssetCode     string        `json:"sold_asset_code"`
+	SoldAssetIssuer   string        `json:"sold_asset_issuer"`
+	BoughtAmount      string        `json:"bought_amount"`
+	BoughtAssetType   string        `json:"bought_asset_type"`
+	BoughtAssetCode   string        `json:"bought_asset_code"`
+	BoughtAssetIssuer string        `json:"bought_asset_issuer"`
+	ReservesDeposited []poolReserve `json:"reserves_deposited"`
+	ReservesReceived  []poolReserve `json:"reserves_received"`
+}
+
+// operationID returns the ID of the operation that caused the effect.
+func (effect balanceEffect) operationID() string {
+	return strings.SplitN(effect.PagingToken, "-", 2)[0]
+}
+
+// ledger returns the ledger of the effect, which is in the upper 32 bits of the
+// operation ID.
+func (effect balanceEffect) ledger() int64 {
+	id, err := strconv.ParseInt(effect.operationID(), 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return id >> 32
+}
+
+// effectAsset returns the asset in horizon's canonical form (see horizonAssetString.)
+func effectAsset(assetType, code, issuer string) string {
+	if assetType == "native" {
+		return "native"
+	}
+
+	return code + ":" + issuer
+}
+
+// balanceHistory reconstructs the balances of an account from its effects and fees.
+type balanceHistory struct {
+	balances map[string]int64
+
+	// Path payments have trade effects as well as debits and credits, so trades are
+	// only counted for operations without them (i.e., offers.)
+	payments map[string]bool
+}
+
+func newBalanceHistory() *balanceHistory {
+	return &balanceHistory{balances: map[string]int64{}, payments: map[string]bool{}}
+}
+
+func (history *balanceHistory) add(asset, val string, sign int64) error {
+	amt, err := amount.ParseInt64(val)
+	if err != nil {
+		return errors.Errorf("bad amount for %s: %s", asset, val)
+	}
+
+	history.balances[asset] += sign * amt
+	return nil
+}
+
+// apply applies the effects (in order), and ignores those that don't change balances.
+func (history *balanceHistory) apply(effects []balanceEffect) error {
+	for _, effect := range effects {
+		if effect.Type == "account_credited" || effect.Type == "account_debited" {
+			history.payments[effect.operationID()] = true
+		}
+	}
+
+	for _, effect := range effects {
+		var err error
+
+		switch effect.Type {
+		case "account_created":
+			err = history.add("native", effect.StartingBalance, 1)
+		case "account_credited":
+			err = history.add(effectAsset(effect.AssetType, effect.AssetCode, effect.AssetIssuer), effect.Amount, 1)
+		case "account_debited":
+			err = history.add(effectAsset(effect.AssetType, effect.AssetCode, effect.AssetIssuer), effect.Amount, -1)
+		case "trade":
+			if history.payments[effect.operationID()] {
+				continue
+			}
+
+			if err = history.add(effectAsset(effect.SoldAssetType, effect.SoldAssetCode, effect.SoldAssetIssuer), effect.SoldAmount, -1); err == nil {
+				err = history.add(effectAsset(effect.BoughtAssetType, effect.BoughtAssetCode, effect.BoughtAssetIssuer), effect.BoughtAmount, 1)
+			}
+		case "liquidity_pool_deposited":
+			for _, reserve := range effect.ReservesDeposited {
+				if err = history.add(reserve.Asset, reserve.Amount, -1); err != nil {
+					break
+				}
+			}
+		case "liquidity_pool_withdrew":
+			for _, reserve := range effect.ReservesReceived {
+				if err = history.add(reserve.Asset, reserve.Amount, 1); err != nil {
+					break
+				}
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadEffectsPage returns a page of the effects on address, in order (asc or desc),
+// after cursor.
+func (cli *CLI) loadEffectsPage(logFields logrus.Fields, address, order, cursor string) ([]balanceEffect, error) {
+	query := url.Values{}
+	query.Set("order", order)
+	query.Set("limit", strconv.Itoa(maxPageSize))
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+
+	var page struct {
+		Embedded struct {
+			Records []balanceEffect `json:"records"`
+		} `json:"_embedded"`
+	}
+
+	if err := cli.getHorizonJSON(logFields, "/accounts/"+address+"/effects?"+query.Encode(), &page); err != nil {
+		return nil, err
+	}
+
+	debugf(logFields, "got %d effects after cursor %q", len(page.Embedded.Records), cursor)
+	return page.Embedded.Records, nil
+}
+
+// loadEffects returns the effects on address up to cutoff, oldest first.
+func (cli *CLI) loadEffects(logFields logrus.Fields, address string, cutoff historyCutoff) ([]balanceEffect, error) {
+	effects := []balanceEffect{}
+	cursor := ""
+
+	for {
+		records, err := cli.loadEffectsPage(logFields, address, "asc", cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, effect := range records {
+			if cutoff.after(effect.ledger(), effect.CreatedAt) {
+				return effects, nil
+			}
+
+			effects = append(effects, effect)
+		}
+
+		if len(records) < maxPageSize {
+			return effects, nil
+		}
+
+		cursor = records[len(records)-1].PagingToken
+	}
+}
+
+// feeRecord is the part of a transaction, as returned by horizon, that says who paid
+// what fee for it, and whether it failed.
+type feeRecord struct {
+	PagingToken   string      `json:"paging_token"`
+	Hash          string      `json:"hash"`
+	Ledger        int64       `json:"ledger"`
+	CreatedAt     string      `json:"created_at"`
+	SourceAccount string      `json:"source_account"`
+	FeeAccount    string      `json:"fee_account"`
+	FeeCharged    json.Number `json:"fee_charged"`
+	Successful    *bool       `json:"successful"`
+	ResultXDR     string      `json:"result_xdr"`
+}
+
+// failed returns true if the transaction failed. Horizons before failed transactions
+// were recorded don't say.
+func (tx feeRecord) failed() bool {
+	return tx.Successful != nil && !*tx.Successful
+}
+
+// paidBy returns the fee (in stroops) that address paid for the transaction, which is
+// 0 if someone else paid it.
+func (tx feeRecord) paidBy(address string) (int64, error) {
+	// Fee bumps are paid by the fee account, everything else by the source
+	feeAccount := tx.FeeAccount
+	if feeAccount == "" {
+		feeAccount = tx.SourceAccount
+	}
+
+	if feeAccount != address {
+		return 0, nil
+	}
+
+	// Older horizons return fees as numbers, newer ones as strings
+	fee, err := strconv.ParseInt(string(tx.FeeCharged), 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("bad fee: %s", tx.FeeCharged)
+	}
+
+	return fee, nil
+}
+
+// loadTransactionsPage returns a page of the transactions (including failed ones) of
+// address, in order (asc or desc), after cursor.
+func (cli *CLI) loadTransactionsPage(logFields logrus.Fields, address, order, cursor string) ([]feeRecord, error) {
+	query := url.Values{}
+	query.Set("order", order)
+	query.Set("include_failed", "true")
+	query.Set("limit", strconv.Itoa(maxPageSize))
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+
+	var page struct {
+		Embedded struct {
+			Records []feeRecord `json:"records"`
+		} `json:"_embedded"`
+	}
+
+	if err := cli.getHorizonJSON(logFields, "/accounts/"+address+"/transactions?"+query.Encode(), &page); err != nil {
+		return nil, err
+	}
+
+	debugf(logFields, "got %d transactions after cursor %q", len(page.Embedded.Records), cursor)
+	return page.Embedded.Records, nil
+}
+
+// loadFees returns the total fees (in stroops) that address paid up to cutoff,
+// including for failed transactions. Fees don't show up as effects.
+func (cli *CLI) loadFees(logFields logrus.Fields, address string, cutoff historyCutoff) (int64, error) {
+	var fees int64
+	cursor := ""
+
+	for {
+		records, err := cli.loadTransactionsPage(logFields, address, "asc", cursor)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, tx := range records {
+			if cutoff.after(tx.Ledger, tx.CreatedAt) {
+				return fees, nil
+			}
+
+			fee, err := tx.paidBy(address)
+			if err != nil {
+				return 0, err
+			}
+
+			fees += fee
+		}
+
+		if len(records) < maxPageSize {
+			return fees, nil
+		}
+
+		cursor = records[len(records)-1].PagingToken
+	}
+}
+
+// historicalBalances returns the balances (in stroops, keyed by horizon asset
+// string) of address at cutoff, by replaying all its effects and fees up to then.
+func (cli *CLI) historicalBalances(logFields logrus.Fields, address string, cutoff historyCutoff) (map[string]int64, error) {
+	effects, err := cli.loadEffects(logFields, address, cutoff)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't load effects")
+	}
+
+	fees, err := cli.loadFees(logFields, address, cutoff)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't load transactions")
+	}
+
+	history := newBalanceHistory()
+	if err := history.apply(effects); err != nil {
+		return nil, err
+	}
+
+	history.balances["native"] -= fees
+	debugf(logFields, "replayed %d effects, and %d stroops in fees", len(effects), fees)
+
+	return history.balances, nil
+}
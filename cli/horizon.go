@@ -0,0 +1,205 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/0xfe/microstellar"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// horizonURL returns the base URL of the horizon server for the current network,
+// or "" for the fake network.
+func (cli *CLI) horizonURL() string {
+	parts := strings.Split(cli.network, ";")
+
+	switch parts[0] {
+	case "fake":
+		return ""
+	case "public":
+		return "https://horizon.stellar.org"
+	case "custom":
+		if len(parts) > 1 {
+			return strings.TrimSuffix(parts[1], "/")
+		}
+	}
+
+	return "https://horizon-testnet.stellar.org"
+}
+
+// Network passphrases of the standard networks.
+const (
+	testNetworkPassphrase   = "Test SDF Network ; September 2015"
+	publicNetworkPassphrase = "Public Global Stellar Network ; September 2015"
+)
+
+// networkPassphrase returns the passphrase transactions are signed for on the current
+// network, or "" for the fake network.
+func (cli *CLI) networkPassphrase() string {
+	// The standard passphrases have semicolons in them
+	parts := strings.SplitN(cli.network, ";", 3)
+
+	switch parts[0] {
+	case "fake":
+		return ""
+	case "public":
+		return publicNetworkPassphrase
+	case "custom":
+		if len(parts) > 2 {
+			return parts[2]
+		}
+	}
+
+	return testNetworkPassphrase
+}
+
+// networkMismatchError is returned when horizon is on a different network than the
+// one lumen signs transactions for.
+type networkMismatchError struct {
+	want string
+	got  string
+}
+
+func (e *networkMismatchError) Error() string {
+	return fmt.Sprintf("network passphrase mismatch: signing for %q, but horizon is on %q (check config:network)", e.want, e.got)
+}
+
+// confirmNetworkError is returned when --confirm-network doesn't match the current
+// network.
+type confirmNetworkError struct {
+	want string
+	got  string
+}
+
+func (e *confirmNetworkError) Error() string {
+	return fmt.Sprintf("--confirm-network is %s, but the network is %s (check config:network)", e.want, e.got)
+}
+
+// networkType returns the type of the current network: test, public, custom, or fake.
+func (cli *CLI) networkType() string {
+	return strings.SplitN(cli.network, ";", 2)[0]
+}
+
+// checkNetwork is called before submitting transactions. It returns a
+// confirmNetworkError if --confirm-network is set to another network than the current
+// one, then does checkNetworkPassphrase.
+func (cli *CLI) checkNetwork(logFields logrus.Fields) error {
+	if want, _ := cli.rootCmd.Flags().GetString("confirm-network"); want != "" {
+		if got := cli.networkType(); got != want {
+			return &confirmNetworkError{want: want, got: got}
+		}
+	}
+
+	return cli.checkNetworkPassphrase(logFields)
+}
+
+// checkBeforeSubmit runs the checks on the base64-encoded transaction envelope b64tx
+// that's about to be submitted: checkNetwork, then checkFeeAccountBalance.
+func (cli *CLI) checkBeforeSubmit(logFields logrus.Fields, b64tx string) error {
+	if err := cli.checkNetwork(logFields); err != nil {
+		return err
+	}
+
+	return cli.checkFeeAccountBalance(logFields, b64tx)
+}
+
+// checkNetworkPassphrase returns a networkMismatchError if horizon reports a different
+// network passphrase than the configured one. The check is on unless
+// --skip-network-passphrase-check is set, or config:network_passphrase_check is false,
+// and horizon is only asked once per command.
+func (cli *CLI) checkNetworkPassphrase(logFields logrus.Fields) error {
+	check := true
+	if val, err := cli.GetVar("vars:config:network_passphrase_check"); err == nil {
+		if b, err := strconv.ParseBool(val); err == nil {
+			check = b
+		}
+	}
+
+	if cli.rootCmd.Flag("network-passphrase-check").Changed {
+		check, _ = cli.rootCmd.Flags().GetBool("network-passphrase-check")
+	}
+
+	if skip, _ := cli.rootCmd.Flags().GetBool("skip-network-passphrase-check"); skip {
+		check = false
+	}
+
+	if !check || cli.horizonURL() == "" || cli.networkChecked {
+		return nil
+	}
+
+	var root struct {
+		NetworkPassphrase string `json:"network_passphrase"`
+	}
+
+	if err := cli.getHorizonJSON(logFields, "/", &root); err != nil {
+		return errors.Wrap(err, "can't check network passphrase")
+	}
+
+	if want := cli.networkPassphrase(); root.NetworkPassphrase != want {
+		return &networkMismatchError{want: want, got: root.NetworkPassphrase}
+	}
+
+	debugf(logFields, "horizon is on %q", root.NetworkPassphrase)
+	cli.networkChecked = true
+	return nil
+}
+
+// getHorizonJSON fetches path from horizon and decodes the JSON response into v. This
+// is for endpoints that microstellar doesn't support. On the fake network, v is left
+// untouched, i.e., all results are empty.
+func (cli *CLI) getHorizonJSON(logFields logrus.Fields, path string, v interface{}) error {
+	baseURL := cli.horizonURL()
+	if baseURL == "" {
+		debugf(logFields, "fake network, skipping GET %s", path)
+		return nil
+	}
+
+	url := baseURL + path
+	debugf(logFields, "GET %s", url)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return errors.Wrapf(err, "bad request: %s", url)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := cli.horizonClient().Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "can't reach horizon")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var problem struct {
+			Title  string `json:"title"`
+			Detail string `json:"detail"`
+		}
+
+		if json.NewDecoder(resp.Body).Decode(&problem) == nil && problem.Title != "" {
+			return errors.Errorf("horizon error: %s: %s", problem.Title, problem.Detail)
+		}
+
+		return errors.Errorf("horizon error: %s", resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.Wrapf(err, "bad response from horizon")
+	}
+
+	return nil
+}
+
+// setAssetParams adds asset to query as horizon's prefix_asset_type, prefix_asset_code,
+// and prefix_asset_issuer parameters (e.g., prefix is "selling" or "buying".)
+func setAssetParams(query url.Values, prefix string, asset *microstellar.Asset) {
+	query.Set(prefix+"_asset_type", string(asset.Type))
+	if !asset.IsNative() {
+		query.Set(prefix+"_asset_code", asset.Code)
+		query.Set(prefix+"_asset_issuer", asset.Issuer)
+	}
+}
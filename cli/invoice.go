@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// invoiceKey is where the hash of the transaction that paid invoice id is kept, in
+// the current namespace.
+func invoiceKey(id string) string {
+	return "invoice:" + id + ":tx"
+}
+
+// recordInvoice saves the hash of the transaction the current command submitted as
+// the one that paid invoice id (see pay --invoice.) Nothing is recorded if nothing
+// was submitted, e.g., with --nosubmit or on the fake network.
+func (cli *CLI) recordInvoice(logFields logrus.Fields, id string) error {
+	hash, err := cli.submittedHash()
+	if err != nil || hash == "" {
+		debugf(logFields, "no transaction submitted, not recording invoice %s", id)
+		return err
+	}
+
+	debugf(logFields, "invoice %s paid in transaction %s", id, hash)
+	return cli.SetVar(invoiceKey(id), hash)
+}
+
+func (cli *CLI) buildInvoiceCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "invoice [lookup]",
+		Short: "look up the payments made with pay --invoice",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cli.error(logrus.Fields{"cmd": "invoice"}, "unrecognized invoice command: %s, expecting: lookup", args[0])
+		},
+	}
+
+	cmd.AddCommand(cli.buildInvoiceLookupCmd())
+	return cmd
+}
+
+func (cli *CLI) buildInvoiceLookupCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "lookup [id]",
+		Short: "get the hash of the transaction that paid invoice [id]",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			id := args[0]
+
+			hash, err := cli.GetVar(invoiceKey(id))
+			if err != nil {
+				cli.error(logrus.Fields{"cmd": "invoice", "subcmd": "lookup"}, "no transaction recorded for invoice: %s", id)
+				return
+			}
+
+			showSuccess(hash)
+		},
+	}
+}
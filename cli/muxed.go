@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/binary"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/stellar/go/crc16"
+	"github.com/stellar/go/strkey"
+)
+
+// Muxed accounts (SEP-23) encode an account ID and a 64-bit ID into a single M... address.
+// The vendored XDR predates muxed accounts, so lumen pays the underlying account with
+// the ID as a memo, which is what exchanges expect.
+
+// versionByteMuxedAccount is the strkey version byte of M... addresses.
+const versionByteMuxedAccount = 12 << 3
+
+// encodeMuxedAddress returns the M... address for the G... address and id.
+func encodeMuxedAddress(address string, id uint64) (string, error) {
+	key, err := strkey.Decode(strkey.VersionByteAccountID, address)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid address: %s", address)
+	}
+
+	var raw bytes.Buffer
+	raw.WriteByte(versionByteMuxedAccount)
+	raw.Write(key)
+	binary.Write(&raw, binary.BigEndian, id)
+	binary.Write(&raw, binary.LittleEndian, crc16.Checksum(raw.Bytes()))
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw.Bytes()), nil
+}
+
+// decodeMuxedAddress returns the underlying G... address and id of the M... address.
+func decodeMuxedAddress(muxed string) (string, uint64, error) {
+	raw, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(muxed)
+	if err != nil || len(raw) != 1+32+8+2 || raw[0] != versionByteMuxedAccount {
+		return "", 0, errors.Errorf("invalid muxed address: %s", muxed)
+	}
+
+	payload := raw[:len(raw)-2]
+	if err := crc16.Validate(payload, raw[len(raw)-2:]); err != nil {
+		return "", 0, errors.Errorf("invalid muxed address checksum: %s", muxed)
+	}
+
+	address, err := strkey.Encode(strkey.VersionByteAccountID, payload[1:33])
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "invalid muxed address: %s", muxed)
+	}
+
+	return address, binary.BigEndian.Uint64(payload[33:]), nil
+}
+
+// isMuxedAddress returns true if address is a valid M... address.
+func isMuxedAddress(address string) bool {
+	_, _, err := decodeMuxedAddress(address)
+	return err == nil
+}
+
+func (cli *CLI) buildAddressCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "address [to-muxed|from-muxed]",
+		Short: "convert between account and muxed (M...) addresses",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cli.error(logrus.Fields{"cmd": "address"}, "unrecognized address command: %s, expecting: to-muxed|from-muxed", args[0])
+		},
+	}
+
+	cmd.AddCommand(cli.buildAddressToMuxedCmd())
+	cmd.AddCommand(cli.buildAddressFromMuxedCmd())
+
+	return cmd
+}
+
+func (cli *CLI) buildAddressToMuxedCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "to-muxed [address] [id]",
+		Short: "print the muxed address for [address] and [id]",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "address", "subcmd": "to-muxed"}
+
+			id, err := strconv.ParseUint(args[1], 10, 64)
+			if err != nil {
+				cli.error(logFields, "bad id: %s", args[1])
+				return
+			}
+
+			muxed, err := encodeMuxedAddress(args[0], id)
+			if err != nil {
+				cli.error(logFields, "%v", err)
+				return
+			}
+
+			showSuccess(muxed)
+		},
+	}
+}
+
+func (cli *CLI) buildAddressFromMuxedCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "from-muxed [muxed_address]",
+		Short: "print the underlying address and id of [muxed_address]",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			address, id, err := decodeMuxedAddress(args[0])
+			if err != nil {
+				cli.error(logrus.Fields{"cmd": "address", "subcmd": "from-muxed"}, "%v", err)
+				return
+			}
+
+			showSuccess("%s %d", address, id)
+		},
+	}
+}
@@ -0,0 +1,29 @@
+package cli
+
+import "testing"
+
+// Note: add -v to any of these commands to enable verbose logging
+
+func TestMuxedAddresses(t *testing.T) {
+	cli, _ := newTestCLI()
+
+	// Test vectors from SEP-23
+	address := "GA7QYNF7SOWQ3GLR2BGMZEHXAVIRZA4KVWLTJJFC7MGXUA74P7UJVSGZ"
+	vectors := map[string]string{
+		"0":                   "MA7QYNF7SOWQ3GLR2BGMZEHXAVIRZA4KVWLTJJFC7MGXUA74P7UJUAAAAAAAAAAAACJUQ",
+		"9223372036854775808": "MA7QYNF7SOWQ3GLR2BGMZEHXAVIRZA4KVWLTJJFC7MGXUA74P7UJVAAAAAAAAAAAAAJLK",
+	}
+
+	for id, muxed := range vectors {
+		expectOutput(t, cli, muxed, "address to-muxed "+address+" "+id)
+		expectOutput(t, cli, address+" "+id, "address from-muxed "+muxed)
+	}
+
+	expectOutput(t, cli, "error", "address to-muxed "+address+" abc")
+	expectOutput(t, cli, "error", "address to-muxed "+address+" 18446744073709551616")
+	expectOutput(t, cli, "error", "address to-muxed GNOTANADDRESS 1")
+
+	// Bad checksum
+	expectOutput(t, cli, "error", "address from-muxed MA7QYNF7SOWQ3GLR2BGMZEHXAVIRZA4KVWLTJJFC7MGXUA74P7UJUAAAAAAAAAAAACJVQ")
+	expectOutput(t, cli, "error", "address from-muxed "+address)
+}
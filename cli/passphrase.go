@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/0xfe/microstellar"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// sealVersion is the version of the sealedData format.
+const sealVersion = 1
+
+// sealIterations is the number of PBKDF2 iterations used to derive the key of sealed
+// data. It's stored with the data, so it can be raised without breaking older data.
+const sealIterations = 100000
+
+// sealedData is data (e.g., an export-accounts backup, or a seed) encrypted with
+// AES-256-GCM, with a key derived from a passphrase with PBKDF2-HMAC-SHA256.
+type sealedData struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// passphraseCipher returns the AES-256-GCM cipher for passphrase and salt.
+func passphraseCipher(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2.Key([]byte(passphrase), salt, iterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal returns data encrypted with passphrase, as JSON-encoded sealedData.
+func seal(data []byte, passphrase string) ([]byte, error) {
+	sealed := sealedData{
+		Version:    sealVersion,
+		KDF:        "pbkdf2-sha256",
+		Iterations: sealIterations,
+		Salt:       make([]byte, 16),
+	}
+
+	if _, err := rand.Read(sealed.Salt); err != nil {
+		return nil, errors.Wrap(err, "can't generate salt")
+	}
+
+	aead, err := passphraseCipher(passphrase, sealed.Salt, sealed.Iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(sealed.Nonce); err != nil {
+		return nil, errors.Wrap(err, "can't generate nonce")
+	}
+
+	sealed.Ciphertext = aead.Seal(nil, sealed.Nonce, data, nil)
+	return json.MarshalIndent(sealed, "", "  ")
+}
+
+// unseal returns the data in sealed, decrypted with passphrase.
+func unseal(sealed *sealedData, passphrase string) ([]byte, error) {
+	if sealed.KDF != "pbkdf2-sha256" || sealed.Iterations < 1 {
+		return nil, errors.Errorf("unsupported key derivation: %s with %d iterations", sealed.KDF, sealed.Iterations)
+	}
+
+	aead, err := passphraseCipher(passphrase, sealed.Salt, sealed.Iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed.Nonce) != aead.NonceSize() {
+		return nil, errors.Errorf("bad nonce size: %d", len(sealed.Nonce))
+	}
+
+	data, err := aead.Open(nil, sealed.Nonce, sealed.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("wrong passphrase, or the data is corrupt")
+	}
+
+	return data, nil
+}
+
+// encryptedSeedPrefix starts the stored seeds of accounts set with account set
+// --encrypt. The rest is the base64-encoded sealedData of the seed.
+const encryptedSeedPrefix = "encrypted:"
+
+// seedPassphraseEnv is the environment variable encrypted seeds are decrypted (and
+// encrypted) with, instead of prompting for the passphrase.
+const seedPassphraseEnv = "LUMEN_PASSPHRASE"
+
+// isEncryptedSeed returns true if val is a seed encrypted by encryptSeed.
+func isEncryptedSeed(val string) bool {
+	return strings.HasPrefix(val, encryptedSeedPrefix)
+}
+
+// encryptSeed returns seed encrypted with passphrase, for the store.
+func encryptSeed(seed, passphrase string) (string, error) {
+	sealed, err := seal([]byte(seed), passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	return encryptedSeedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSeed returns the seed in val, a seed encrypted by encryptSeed, decrypted with
+// passphrase.
+func decryptSeed(val, passphrase string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(val, encryptedSeedPrefix))
+	if err != nil {
+		return "", errors.Wrap(err, "bad encrypted seed")
+	}
+
+	var sealed sealedData
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return "", errors.Wrap(err, "bad encrypted seed")
+	}
+
+	seed, err := unseal(&sealed, passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	if microstellar.ValidSeed(string(seed)) != nil {
+		return "", errors.New("bad encrypted seed: not a seed")
+	}
+
+	return string(seed), nil
+}
+
+// seedPassphrase returns the passphrase of the encrypted seed of account name: the
+// value of $LUMEN_PASSPHRASE if set, or else what the user types at a prompt. With
+// confirm, it's asked for twice, and must match.
+func (cli *CLI) seedPassphrase(name string, confirm bool) (string, error) {
+	if passphrase := os.Getenv(seedPassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+
+	stat, err := os.Stdin.Stat()
+	if cli.testing || err != nil || (stat.Mode()&os.ModeCharDevice) == 0 {
+		return "", errors.Errorf("the seed of %s is encrypted: set %s, or run lumen in a terminal to be asked for the passphrase", name, seedPassphraseEnv)
+	}
+
+	passphrase := readHidden(fmt.Sprintf("passphrase for %s: ", name))
+	if passphrase == "" {
+		return "", errors.New("empty passphrase")
+	}
+
+	if confirm && readHidden(fmt.Sprintf("passphrase for %s again: ", name)) != passphrase {
+		return "", errors.New("passphrases don't match")
+	}
+
+	return passphrase, nil
+}
+
+// readHidden prompts on stderr, and returns the line typed on stdin, without echoing
+// it where stty is available.
+func readHidden(prompt string) string {
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = os.Stdin
+		return cmd.Run()
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	if err := stty("-echo"); err == nil {
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimRight(line, "\r\n")
+}
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// From RFC 7914, section 11. Sealed data depends on this key derivation.
+	key := pbkdf2.Key([]byte("passwd"), []byte("salt"), 1, 64, sha256.New)
+	want := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+		"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	if got := hex.EncodeToString(key); got != want {
+		t.Errorf("want key %s, got %s", want, got)
+	}
+}
+
+func TestSeal(t *testing.T) {
+	data, err := seal([]byte("hello"), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sealed sealedData
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := unseal(&sealed, "correct horse"); err != nil || string(got) != "hello" {
+		t.Errorf("want hello, got %q: %v", got, err)
+	}
+
+	if _, err := unseal(&sealed, "battery staple"); err == nil {
+		t.Error("want error for wrong passphrase")
+	}
+}
+
+func TestAccountSetEncrypt(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("ns test")
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new mo")
+	seed := strings.TrimSpace(cli.TestCommand("account seed mo"))
+
+	defer os.Unsetenv(seedPassphraseEnv)
+
+	// No passphrase to encrypt with
+	os.Unsetenv(seedPassphraseEnv)
+	expectOutput(t, cli, "error", "account set kelly "+seed+" --encrypt")
+	expectOutput(t, cli, "error", "account seed kelly")
+
+	os.Setenv(seedPassphraseEnv, "correct horse")
+	expectOutput(t, cli, "error", "account set kelly GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM --encrypt")
+	expectOutput(t, cli, "", "account set kelly "+seed+" --encrypt")
+
+	if stored, _ := cli.GetVar("account:kelly:seed"); !isEncryptedSeed(stored) || strings.Contains(stored, seed) {
+		t.Errorf("want encrypted seed in store, got %s", stored)
+	}
+
+	// The address doesn't need the passphrase
+	os.Unsetenv(seedPassphraseEnv)
+	expectOutput(t, cli, addressFromSeed(seed), "account address kelly")
+	expectOutput(t, cli, "error", "account seed kelly")
+	expectOutput(t, cli, "error", "tx bump-seq kelly 1000")
+
+	os.Setenv(seedPassphraseEnv, "battery staple")
+	expectOutput(t, cli, "error", "account seed kelly")
+	expectOutput(t, cli, "error", "tx bump-seq kelly 1000")
+
+	// Decrypted at use time
+	os.Setenv(seedPassphraseEnv, "correct horse")
+	expectOutput(t, cli, seed, "account seed kelly")
+	expectOutput(t, cli, "", "tx bump-seq kelly 1000")
+
+	// Mixed stores: unencrypted seeds don't need the passphrase
+	os.Unsetenv(seedPassphraseEnv)
+	expectOutput(t, cli, seed, "account seed mo")
+	expectOutput(t, cli, "", "tx bump-seq mo 1000")
+
+	// Deleting doesn't need the passphrase either
+	expectOutput(t, cli, "error", "account del kelly")
+	cli.TestCommand("account del kelly --yes")
+	expectOutput(t, cli, "error", "account address kelly")
+}
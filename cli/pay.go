@@ -1,19 +1,53 @@
 package cli
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/0xfe/microstellar"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/stellar/go/amount"
+	"github.com/stellar/go/clients/horizon"
 )
 
 func (cli *CLI) buildPayCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "pay [amount] [asset] --from [source] --to [target]",
+		Use:   "pay [amount] [asset] --from [source] --to [target] [--send-asset asset --send-max amount [--path assets] [--via-pool]] [--split [--weights w1,w2...]] | pay --from-many a,b,c --to [target] [--sweep-asset asset]",
 		Short: "send [amount] of [asset] from [source] to [target]",
-		Args:  cobra.MinimumNArgs(1),
+		Args: func(cmd *cobra.Command, args []string) error {
+			// Sweeps pay everything, so there's no amount
+			if cmd.Flags().Changed("from-many") {
+				return cobra.NoArgs(cmd, args)
+			}
+
+			return cobra.MinimumNArgs(1)(cmd, args)
+		},
 		Run: func(cmd *cobra.Command, args []string) {
 			fields := logrus.Fields{"cmd": "pay"}
+			if cmd.Flags().Changed("from-many") {
+				cli.paySweep(cmd, fields)
+				return
+			} else if cmd.Flags().Changed("sweep-asset") {
+				cli.error(fields, "--sweep-asset is only for --from-many")
+				return
+			}
+
 			amount := args[0]
+			if err := validateAmount(amount, false); err != nil {
+				cli.error(fields, "%v", err)
+				return
+			}
+
 			assetName := ""
 			if len(args) > 1 {
 				assetName = args[1]
@@ -26,51 +60,258 @@ func (cli *CLI) buildPayCmd() *cobra.Command {
 				return
 			}
 
+			// --from isn't a required flag, since sweeps use --from-many instead
 			to, _ := cmd.Flags().GetString("to")
 			from, _ := cmd.Flags().GetString("from")
+			if from == "" {
+				cli.error(fields, "need --from (or --from-many)")
+				return
+			}
+
 			source, err := cli.ResolveAccount(fields, from, "seed")
 			if err != nil {
 				cli.error(fields, "bad --from address: %s", from)
 				return
 			}
 
-			target, err := cli.ResolveAccount(fields, to, "address")
+			// --invoice is --memo-auto-id, plus a record of the transaction that paid it
+			// (see invoice lookup)
+			invoice, _ := cmd.Flags().GetString("invoice")
+			autoFlag := "memo-auto-id"
+
+			// Invoices and notes are keyed by the transaction's hash, so there has to be
+			// exactly one
+			for _, flag := range []string{"split", "batch"} {
+				if cmd.Flags().Changed(flag) && cmd.Flags().Changed("memo-note") {
+					cli.error(fields, "--memo-note can't be used with --%s", flag)
+					return
+				}
+			}
+
+			if cmd.Flags().Changed("invoice") {
+				for _, flag := range []string{"memo-auto-id", "split", "batch"} {
+					if cmd.Flags().Changed(flag) {
+						cli.error(fields, "--invoice can't be used with --%s", flag)
+						return
+					}
+				}
+
+				if hash, err := cli.GetVar(invoiceKey(invoice)); err == nil {
+					cli.error(fields, "invoice %s was already paid in transaction %s", invoice, hash)
+					return
+				}
+
+				autoFlag = "invoice"
+				cmd.Flags().Set("memo-auto-id", invoice)
+			}
+
+			// --memo-auto-id is shorthand for --memoid, so everything after this sees a
+			// plain memo ID
+			if cmd.Flags().Changed("memo-auto-id") {
+				if hasMemo(cmd) {
+					cli.error(fields, "--%s can't be used with other memo flags", autoFlag)
+					return
+				}
+
+				key, _ := cmd.Flags().GetString("memo-auto-id")
+				if key == "" {
+					cli.error(fields, "--%s needs a non-empty string", autoFlag)
+					return
+				}
+
+				id := autoMemoID(key)
+				debugf(fields, "memo ID for %q: %d", key, id)
+				cmd.Flags().Set("memoid", strconv.FormatUint(id, 10))
+			}
+
+			if split, _ := cmd.Flags().GetBool("split"); split {
+				cli.paySplit(cmd, fields, amount, asset, source, from, to)
+				return
+			} else if cmd.Flags().Changed("weights") {
+				cli.error(fields, "--weights is only for --split")
+				return
+			}
+
+			target, muxedID, err := cli.ResolveDestination(fields, to)
 			if err != nil {
 				cli.error(fields, "bad --to address: %s", to)
 				return
 			}
 
-			opts, err := cli.genTxOptions(cmd, fields)
+			// If --send-asset (or --with) is set, then this is a path payment. Catch bad
+			// combinations and malformed amounts before making any network calls.
+			with, _ := cmd.Flags().GetString("send-asset")
+			max, _ := cmd.Flags().GetString("send-max")
+			path, _ := cmd.Flags().GetStringSlice("path")
+			viaPool, _ := cmd.Flags().GetBool("via-pool")
+
+			if with == "" {
+				for _, flag := range []string{"send-max", "path", "via-pool"} {
+					if cmd.Flags().Changed(flag) {
+						cli.error(fields, "--%s is only for path payments, which need --send-asset", flag)
+						return
+					}
+				}
+			} else if max == "" {
+				cli.error(fields, "--send-max is required for path payments")
+				return
+			}
+
+			if max != "" {
+				if err := validateAmount(max, false); err != nil {
+					cli.error(fields, "bad --send-max: %v", err)
+					return
+				}
+			}
+
+			if keep, _ := cmd.Flags().GetString("keep"); keep != "" {
+				if err := validateAmount(keep, true); err != nil {
+					cli.error(fields, "bad --keep: %v", err)
+					return
+				}
+			}
+
+			confirm, _ := cmd.Flags().GetBool("confirm")
+			if confirm {
+				batch, _ := cmd.Flags().GetBool("batch")
+				nosubmit, _ := cli.rootCmd.Flags().GetBool("nosubmit")
+				if batch || nosubmit {
+					cli.error(fields, "--confirm needs the payment to be submitted now, so it can't be used with --batch or --nosubmit")
+					return
+				}
+			}
+
+			// With --exact-fee-account, the sponsor is the source of the transaction (so it
+			// pays the fee), and the payment is sourced from --from. Both sign it.
+			var sponsorSeed, sponsorAddress string
+			if sponsor, _ := cmd.Flags().GetString("exact-fee-account"); sponsor != "" {
+				if batch, _ := cmd.Flags().GetBool("batch"); batch {
+					cli.error(fields, "--exact-fee-account can't be used with --batch, the batch source pays its fee")
+					return
+				}
+
+				sponsorSeed, err = cli.ResolveAccount(fields, sponsor, "seed")
+				if err != nil || microstellar.ValidSeed(sponsorSeed) != nil {
+					cli.error(fields, "no seed found in --exact-fee-account: %s", sponsor)
+					return
+				}
+
+				if microstellar.ValidSeed(source) != nil {
+					cli.error(fields, "no seed found in --from: %s", from)
+					return
+				}
+
+				sponsorAddress = addressFromSeed(sponsorSeed)
+			}
+
+			opts, err := cli.genTxOptionsFor(cmd, fields, from)
 			if err != nil {
 				cli.error(fields, "can't generate payment: %v", err)
 				return
 			}
 
-			// Is this a fund request?
-			fund, err := cmd.Flags().GetBool("fund")
+			// Pay the underlying account of muxed addresses, with the embedded ID as the memo
+			if muxedID != nil {
+				for _, memo := range memoFlags {
+					if cmd.Flags().Changed(memo) {
+						cli.error(fields, "can't use --%s with muxed address: %s", memo, to)
+						return
+					}
+				}
+
+				opts = opts.WithMemoID(*muxedID)
+			}
+
+			// Is this a fund request? --create-account always creates the account (with XLM),
+			// and --fund creates it only if it doesn't exist, paying it otherwise.
+			createAccount, _ := cmd.Flags().GetBool("create-account")
+			fund, _ := cmd.Flags().GetBool("fund")
+
+			if createAccount && fund {
+				cli.error(fields, "--create-account and --fund are mutually exclusive")
+				return
+			}
+
+			if createAccount && (!asset.IsNative() || with != "") {
+				cli.error(fields, "--create-account can only send XLM, without --send-asset")
+				return
+			}
 
-			// If --with is set, then this is a path payment
-			with, _ := cmd.Flags().GetString("with")
-			if with != "" {
-				max, _ := cmd.Flags().GetString("max")
-				path, _ := cmd.Flags().GetStringSlice("path")
+			// The checks below share one load of the target's account
+			dest := cli.destination(target)
+
+			if fund {
+				account, err := dest.load()
+				if err != nil {
+					cli.errorWithCode(ExitNetworkError, fields, "can't load account %s: %v", to, cli.errorString(err))
+					return
+				}
+
+				if account == nil {
+					if !asset.IsNative() || with != "" {
+						cli.error(fields, "%s doesn't exist, and new accounts can only be funded with XLM", to)
+						return
+					}
+
+					createAccount = true
+				}
+			} else if !createAccount && !cli.checkDestination(cmd, fields, dest, to) {
+				return
+			}
+
+			// Refuse to pay accounts that require a memo (SEP-29) without one. New accounts
+			// can't require memos, and muxed addresses carry their own.
+			if skip, _ := cmd.Flags().GetBool("skip-memo-check"); !skip && !createAccount && muxedID == nil && !cli.sendsMemo(cmd) {
+				required, err := dest.memoRequired()
+				if err != nil {
+					cli.errorWithCode(ExitNetworkError, fields, "can't check if %s requires a memo (use --skip-memo-check to pay anyway): %v", to, cli.errorString(err))
+					return
+				}
 
+				if required {
+					cli.error(fields, "%s requires a memo (SEP-29), use --memotext or --memoid, or --skip-memo-check to pay without one", to)
+					return
+				}
+			}
+
+			// Catch payments of an asset with the right code but the wrong issuer, which
+			// the target can't use. New accounts have no trustlines to compare.
+			if !asset.IsNative() && !createAccount {
+				strict, _ := cmd.Flags().GetBool("strict-asset-match")
+				issuers, err := dest.otherIssuers(asset)
+
+				if err != nil {
+					if strict {
+						cli.errorWithCode(ExitNetworkError, fields, "can't load trustlines of %s for --strict-asset-match: %v", to, cli.errorString(err))
+						return
+					}
+
+					debugf(fields, "can't check the trustlines of %s: %v", to, cli.errorString(err))
+				} else if len(issuers) > 0 {
+					msg := fmt.Sprintf("%s trusts %s from %s, but not from %s", to, asset.Code, strings.Join(issuers, ", "), asset.Issuer)
+					if strict {
+						cli.error(fields, "not paying: %s", msg)
+						return
+					}
+
+					showError(fields, "%s (use --strict-asset-match to refuse)", msg)
+				}
+			}
+
+			if with != "" {
 				var withAsset *microstellar.Asset
 				var assetPath []*microstellar.Asset
 
 				withAsset, err = cli.ResolveAsset(with)
 				if err != nil {
-					cli.error(fields, "bad --with asset: %s", with)
+					cli.error(fields, "bad --send-asset: %s", with)
 					return
 				}
 
-				if max == "" {
-					cli.error(fields, "--max is required for path payments")
-					return
-				}
+				verbose := logrus.GetLevel() == logrus.DebugLevel
+				debugf(fields, "path payment: sending at most %s %s (%s) to deliver %s %s", max, assetCode(withAsset), with, amount, assetCode(asset))
 
 				if len(path) > 0 {
-					debugf(fields, "path payment with %s (max %s) through %+v", with, max, path)
 					for _, a := range path {
 						pathAsset, err := cli.ResolveAsset(a)
 						if err != nil {
@@ -81,21 +322,99 @@ func (cli *CLI) buildPayCmd() *cobra.Command {
 						assetPath = append(assetPath, pathAsset)
 					}
 
+					debugf(fields, "path payment through --path: %s", routeString(withAsset, assetPath, asset))
+
+					if viaPool || verbose {
+						route := append(append([]*microstellar.Asset{withAsset}, assetPath...), asset)
+						hops, err := cli.traceRoute(fields, route)
+						if err != nil {
+							cli.errorWithCode(ExitNetworkError, fields, "can't trace path: %v", cli.errorString(err))
+							return
+						}
+
+						if viaPool && !allPoolHops(hops) {
+							cli.error(fields, "--path has hops without a liquidity pool, see -v")
+							return
+						}
+					}
+
 					opts = opts.WithAsset(withAsset, max).Through(assetPath...)
 				} else {
-					debugf(fields, "path payment with %s (max %s) using pathfinder", with, max)
 					sourceAddress, err := cli.ResolveAccount(fields, from, "address")
 					if err != nil {
 						cli.error(fields, "no address in --from: %s", from)
 						return
 					}
 
-					debugf(fields, "searching for paths from: %s", sourceAddress)
-					opts = opts.WithAsset(withAsset, max).FindPathFrom(sourceAddress)
+					if viaPool {
+						// Paths are just assets, so this only picks one where every hop has a
+						// pool. The network still routes each hop through the orderbook or the
+						// pool, whichever is cheaper.
+						debugf(fields, "path payment with %s (max %s) through liquidity pools", with, max)
+						poolPath, err := cli.findPoolPath(fields, sourceAddress, target, withAsset, max, asset, amount)
+						if err != nil {
+							cli.errorWithCode(ExitNetworkError, fields, "can't find paths: %v", cli.errorString(err))
+							return
+						}
+
+						if poolPath == nil {
+							cli.error(fields, "no path from %s to %s through liquidity pools", with, assetName)
+							return
+						}
+
+						debugf(fields, "path payment through liquidity pools: %s", routeString(withAsset, poolPath.Hops, asset))
+						opts = opts.WithAsset(withAsset, max).Through(poolPath.Hops...)
+					} else {
+						// The network routes each hop through the orderbook or a liquidity pool,
+						// whichever is cheaper.
+						debugf(fields, "path payment using pathfinder, searching for paths from: %s", sourceAddress)
+						if verbose {
+							cli.logFoundPath(fields, sourceAddress, target, withAsset, max, asset, amount)
+						}
+
+						opts = opts.WithAsset(withAsset, max).FindPathFrom(sourceAddress)
+					}
 				}
 			}
 
-			if fund {
+			if keep, _ := cmd.Flags().GetString("keep"); keep != "" {
+				spend := "0"
+				if with != "" {
+					if withAsset, _ := cli.ResolveAsset(with); withAsset != nil && withAsset.IsNative() {
+						spend = max
+					}
+				} else if createAccount || asset.IsNative() {
+					spend = amount
+				}
+
+				sourceAddress, err := cli.ResolveAccount(fields, from, "address")
+				if err != nil {
+					cli.error(fields, "no address in --from: %s", from)
+					return
+				}
+
+				if err := cli.checkKeep(fields, sourceAddress, spend, keep); err != nil {
+					cli.error(fields, "%v", err)
+					return
+				}
+			}
+
+			// There are no balances to confirm on the fake network
+			confirm = confirm && cli.horizonURL() != ""
+			var before int64
+			if confirm && !createAccount {
+				if before, err = cli.pollBalance(target, asset); err != nil {
+					cli.errorWithCode(ExitNetworkError, fields, "can't load balance of %s for --confirm: %v", to, cli.errorString(err))
+					return
+				}
+			}
+
+			if sponsorSeed != "" {
+				debugf(fields, "fee paid by %s", sponsorAddress)
+				cli.ms.Start(sponsorAddress, opts.WithSigner(sponsorSeed).WithSigner(source))
+			}
+
+			if createAccount {
 				logrus.WithFields(fields).Debugf("initial fund from %s to %s, opts: %+v", source, target, opts)
 				err = cli.ms.FundAccount(source, target, amount, opts)
 			} else {
@@ -103,23 +422,927 @@ func (cli *CLI) buildPayCmd() *cobra.Command {
 				err = cli.ms.Pay(source, target, amount, asset, opts)
 			}
 
+			if sponsorSeed != "" && err == nil {
+				err = cli.ms.Submit()
+			}
+
 			if err != nil {
-				cli.error(fields, "payment failed: %v", microstellar.ErrorString(err))
+				cli.errorWithCode(txExitCode(err), fields, "payment failed: %v", cli.errorString(err))
 				return
 			}
+
+			if invoice != "" {
+				if err := cli.recordInvoice(fields, invoice); err != nil {
+					cli.errorWithCode(ExitStoreError, fields, "payment succeeded, but can't record invoice %s: %v", invoice, err)
+					return
+				}
+			}
+
+			if note, _ := cmd.Flags().GetString("memo-note"); note != "" {
+				if err := cli.recordTxNote(fields, note); err != nil {
+					cli.errorWithCode(ExitStoreError, fields, "payment succeeded, but can't save --memo-note: %v", err)
+					return
+				}
+			}
+
+			if confirm {
+				timeout, _ := cmd.Flags().GetDuration("confirm-timeout")
+				poll := func() (int64, error) { return cli.pollBalance(target, asset) }
+
+				balance, err := confirmReceipt(poll, before, amount, timeout, confirmInterval)
+				if err != nil {
+					cli.errorWithCode(ExitNetworkError, fields, "payment succeeded, but %s didn't receive %s %s within %v: %v", to, amount, assetCode(asset), timeout, cli.errorString(err))
+					return
+				}
+
+				showSuccess("confirmed: %s received %s %s, new balance: %s", to, amount, assetCode(asset), balance)
+			}
 		},
 	}
 
 	buildFlagsForTxOptions(cmd)
 	cmd.Flags().String("from", "", "source account seed or name")
 	cmd.Flags().String("to", "", "target account address or name")
-	cmd.Flags().String("with", "", "make a path payment with this asset")
-	cmd.Flags().String("max", "", "spend no more than this much during path payments")
-	cmd.Flags().StringSlice("path", []string{}, "comma-separated list of paths, uses auto pathfinder if empty")
+	cmd.Flags().String("send-asset", "", "make a path payment, sending this asset (alias: --with)")
+	cmd.Flags().String("send-max", "", "send no more than this much of --send-asset (alias: --max)")
+	cmd.Flags().StringSlice("path", []string{}, "comma-separated list of intermediate assets for --send-asset, uses auto pathfinder if empty")
+	cmd.Flags().Bool("via-pool", false, "only use paths where every hop has a liquidity pool (the network still picks the pool or the orderbook for each hop)")
+	cmd.Flags().String("keep", "", "refuse to pay if it leaves less than this much XLM above the reserve")
+	cmd.Flags().Bool("skip-memo-check", false, "pay without a memo, even if the target requires one (SEP-29)")
+	cmd.Flags().Bool("strict-asset-match", false, "refuse to pay if the target trusts [asset]'s code from other issuers, but not [asset]'s (instead of warning)")
+	cmd.Flags().String("exact-fee-account", "", "source the transaction (and its fee) from this account, and only the payment from --from")
+	cmd.Flags().Bool("confirm", false, "after paying, poll the target's balance until the payment shows up (alias: --round-trip-check)")
+	cmd.Flags().Duration("confirm-timeout", 30*time.Second, "how long --confirm waits for the payment to show up")
 
-	cmd.Flags().Bool("fund", false, "fund a new account")
-	cmd.MarkFlagRequired("from")
+	cmd.Flags().String("memo-auto-id", "", "set the memo ID to one derived from this string (e.g., an invoice number), see autoMemoID")
+	cmd.Flags().String("memo-note", "", "save this note locally with the transaction's hash (the memo is unchanged), see: lumen tx show --with-note")
+	cmd.Flags().String("invoice", "", "like --memo-auto-id, and also record the transaction as the one that paid this invoice (see: lumen invoice lookup)")
+	cmd.Flags().Bool("split", false, "split [amount] among the comma-separated accounts in --to, in one transaction")
+	cmd.Flags().StringSlice("weights", []string{}, "with --split, comma-separated weights of the accounts in --to (equal if not set)")
+	cmd.Flags().StringSlice("from-many", []string{}, "sweep all of --sweep-asset (above the reserve, for XLM) from these comma-separated accounts to --to, in one transaction")
+	cmd.Flags().String("sweep-asset", "native", "the asset to sweep with --from-many")
+
+	cmd.Flags().Bool("fund", false, "create the account with [amount] XLM if it doesn't exist, else just pay it")
+	cmd.Flags().Bool("allow-unfunded-destination", false, "don't check that the target exists before paying it")
+	cmd.Flags().Bool("create-account", false, "create a new account with [amount] XLM")
 	cmd.MarkFlagRequired("to")
 
+	// Keep the original path payment flags working
+	cmd.Flags().SetNormalizeFunc(func(flags *pflag.FlagSet, name string) pflag.NormalizedName {
+		if alias, ok := payFlagAliases[name]; ok {
+			name = alias
+		}
+
+		return pflag.NormalizedName(name)
+	})
+
 	return cmd
 }
+
+// payFlagAliases maps alternative (and original) names of pay's flags to the current ones.
+var payFlagAliases = map[string]string{
+	"with":             "send-asset",
+	"max":              "send-max",
+	"round-trip-check": "confirm",
+}
+
+// confirmInterval is the time between balance polls for pay --confirm.
+const confirmInterval = time.Second
+
+// confirmReceipt polls the balance (in stroops) every interval until it's grown by at
+// least received from before, and returns it. It gives up (and returns an error)
+// after timeout.
+func confirmReceipt(poll func() (int64, error), before int64, received string, timeout, interval time.Duration) (string, error) {
+	amt, err := amount.ParseInt64(received)
+	if err != nil {
+		return "", errors.Errorf("bad amount: %s", received)
+	}
+
+	want := before + amt
+	deadline := time.Now().Add(timeout)
+
+	for {
+		balance, err := poll()
+		if err == nil && balance >= want {
+			return amount.StringFromInt64(balance), nil
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			if err != nil {
+				return "", err
+			}
+
+			return "", errors.Errorf("balance is %s, expected at least %s", amount.StringFromInt64(balance), amount.StringFromInt64(want))
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// splitAmount divides total (in stroops) among len(weights) recipients in proportion
+// to their weights. Shares are rounded down, and the stroops left over go to the
+// first recipients, one each, so the shares always add up to total.
+func splitAmount(total int64, weights []int64) ([]int64, error) {
+	sum := new(big.Int)
+	for _, weight := range weights {
+		if weight <= 0 {
+			return nil, errors.Errorf("weights must be positive: %d", weight)
+		}
+
+		sum.Add(sum, big.NewInt(weight))
+	}
+
+	shares := make([]int64, len(weights))
+	left := total
+	for i, weight := range weights {
+		share := new(big.Int).Mul(big.NewInt(total), big.NewInt(weight))
+		shares[i] = share.Quo(share, sum).Int64()
+		left -= shares[i]
+	}
+
+	for i := 0; left > 0; i++ {
+		shares[i]++
+		left--
+	}
+
+	for i, share := range shares {
+		if share == 0 {
+			return nil, errors.Errorf("recipient %d would get nothing", i+1)
+		}
+	}
+
+	return shares, nil
+}
+
+// paySplit pays total of asset from source (named from) to the comma-separated
+// accounts in to, split by --weights, with one payment operation each in a single
+// transaction.
+func (cli *CLI) paySplit(cmd *cobra.Command, fields logrus.Fields, total string, asset *microstellar.Asset, source, from, to string) {
+	for _, flag := range []string{"send-asset", "send-max", "path", "via-pool", "keep", "exact-fee-account", "confirm", "fund", "create-account", "batch"} {
+		if cmd.Flags().Changed(flag) {
+			cli.error(fields, "--%s can't be used with --split", flag)
+			return
+		}
+	}
+
+	if microstellar.ValidSeed(source) != nil {
+		cli.error(fields, "no seed found in --from: %s", from)
+		return
+	}
+
+	recipients := strings.Split(to, ",")
+	if len(recipients) > maxOpsPerTx {
+		cli.error(fields, "can't split among more than %d accounts in one transaction, got %d", maxOpsPerTx, len(recipients))
+		return
+	}
+
+	var targets []string
+	for _, recipient := range recipients {
+		target, muxedID, err := cli.ResolveDestination(fields, recipient)
+		if err != nil {
+			cli.error(fields, "bad --to address: %s", recipient)
+			return
+		}
+
+		// Muxed addresses need their IDs as memos, and there's only one per transaction
+		if muxedID != nil {
+			cli.error(fields, "can't split to muxed address: %s", recipient)
+			return
+		}
+
+		targets = append(targets, target)
+	}
+
+	weights := make([]int64, len(recipients))
+	if specs, _ := cmd.Flags().GetStringSlice("weights"); len(specs) > 0 {
+		if len(specs) != len(recipients) {
+			cli.error(fields, "need one weight per account in --to, got %d weights for %d accounts", len(specs), len(recipients))
+			return
+		}
+
+		for i, spec := range specs {
+			weight, err := strconv.ParseInt(spec, 10, 64)
+			if err != nil || weight <= 0 {
+				cli.error(fields, "bad weight: %s, expecting a positive integer", spec)
+				return
+			}
+
+			weights[i] = weight
+		}
+	} else {
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+
+	stroops, err := amount.ParseInt64(total)
+	if err != nil {
+		cli.error(fields, "bad amount: %s", total)
+		return
+	}
+
+	shares, err := splitAmount(stroops, weights)
+	if err != nil {
+		cli.error(fields, "can't split %s: %v", total, err)
+		return
+	}
+
+	dests := make([]*destination, len(targets))
+	for i, target := range targets {
+		dests[i] = cli.destination(target)
+		if !cli.checkDestination(cmd, fields, dests[i], recipients[i]) {
+			return
+		}
+	}
+
+	// Refuse to pay accounts that require a memo (SEP-29) without one
+	if skip, _ := cmd.Flags().GetBool("skip-memo-check"); !skip && !cli.sendsMemo(cmd) {
+		for i, dest := range dests {
+			required, err := dest.memoRequired()
+			if err != nil {
+				cli.errorWithCode(ExitNetworkError, fields, "can't check if %s requires a memo (use --skip-memo-check to pay anyway): %v", recipients[i], cli.errorString(err))
+				return
+			}
+
+			if required {
+				cli.error(fields, "%s requires a memo (SEP-29), use --memotext or --memoid, or --skip-memo-check to pay without one", recipients[i])
+				return
+			}
+		}
+	}
+
+	opts, err := cli.genTxOptionsFor(cmd, fields, from)
+	if err != nil {
+		cli.error(fields, "can't generate payment: %v", err)
+		return
+	}
+
+	cli.ms.Start(addressFromSeed(source), opts.WithSigner(source))
+	for i, target := range targets {
+		share := amount.StringFromInt64(shares[i])
+		debugf(fields, "paying %s %s to %s", share, assetCode(asset), recipients[i])
+
+		if err := cli.ms.Pay(source, target, share, asset); err != nil {
+			cli.error(fields, "can't add payment to %s: %v", recipients[i], cli.errorString(err))
+			return
+		}
+	}
+
+	if err := cli.ms.Submit(); err != nil {
+		cli.errorWithCode(txExitCode(err), fields, "payment failed: %v", cli.errorString(err))
+		return
+	}
+}
+
+// paySweep pays all of --sweep-asset from each of the accounts in --from-many to --to,
+// with one payment operation (sourced from the account) each in a single transaction.
+// All the accounts sign it, and the first one pays the fee.
+func (cli *CLI) paySweep(cmd *cobra.Command, fields logrus.Fields) {
+	for _, flag := range []string{"from", "split", "weights", "send-asset", "send-max", "path", "via-pool", "keep", "exact-fee-account", "confirm", "fund", "create-account", "batch", "invoice", "memo-note"} {
+		if cmd.Flags().Changed(flag) {
+			cli.error(fields, "--%s can't be used with --from-many", flag)
+			return
+		}
+	}
+
+	assetName, _ := cmd.Flags().GetString("sweep-asset")
+	if assetName == "XLM" {
+		assetName = "native"
+	}
+
+	asset, err := cli.ResolveAsset(assetName)
+	if err != nil {
+		cli.error(fields, "bad --sweep-asset: %s", assetName)
+		return
+	}
+
+	to, _ := cmd.Flags().GetString("to")
+	target, muxedID, err := cli.ResolveDestination(fields, to)
+	if err != nil {
+		cli.error(fields, "bad --to address: %s", to)
+		return
+	}
+
+	if muxedID != nil {
+		cli.error(fields, "can't sweep to muxed address: %s", to)
+		return
+	}
+
+	names, _ := cmd.Flags().GetStringSlice("from-many")
+	if len(names) > maxOpsPerTx {
+		cli.error(fields, "can't sweep more than %d accounts in one transaction, got %d", maxOpsPerTx, len(names))
+		return
+	}
+
+	var seeds, addresses []string
+	seen := map[string]bool{}
+	for _, name := range names {
+		seed, err := cli.ResolveAccount(fields, name, "seed")
+		if err != nil || microstellar.ValidSeed(seed) != nil {
+			cli.error(fields, "no seed found in --from-many: %s", name)
+			return
+		}
+
+		address, err := cli.ResolveAccount(fields, name, "address")
+		if err != nil {
+			cli.error(fields, "no address in --from-many: %s", name)
+			return
+		}
+
+		if microstellar.ValidSeed(address) == nil {
+			address = addressFromSeed(address)
+		}
+
+		if address == target || seen[address] {
+			cli.error(fields, "can't sweep %s: it's the target, or is listed twice", name)
+			return
+		}
+
+		seen[address] = true
+		seeds = append(seeds, seed)
+		addresses = append(addresses, address)
+	}
+
+	amounts, err := cli.sweepAmounts(fields, names, addresses, asset)
+	if err != nil {
+		cli.errorWithCode(ExitNetworkError, fields, "can't sweep: %v", cli.errorString(err))
+		return
+	}
+
+	dest := cli.destination(target)
+	if !cli.checkDestination(cmd, fields, dest, to) {
+		return
+	}
+
+	// Refuse to pay accounts that require a memo (SEP-29) without one
+	if skip, _ := cmd.Flags().GetBool("skip-memo-check"); !skip && !cli.sendsMemo(cmd) {
+		required, err := dest.memoRequired()
+		if err != nil {
+			cli.errorWithCode(ExitNetworkError, fields, "can't check if %s requires a memo (use --skip-memo-check to pay anyway): %v", to, cli.errorString(err))
+			return
+		}
+
+		if required {
+			cli.error(fields, "%s requires a memo (SEP-29), use --memotext or --memoid, or --skip-memo-check to pay without one", to)
+			return
+		}
+	}
+
+	opts, err := cli.genTxOptions(cmd, fields, addresses[0])
+	if err != nil {
+		cli.error(fields, "can't generate payment: %v", err)
+		return
+	}
+
+	for _, seed := range seeds {
+		opts = opts.WithSigner(seed)
+	}
+
+	cli.ms.Start(addresses[0], opts)
+	for i, seed := range seeds {
+		debugf(fields, "sweeping %s %s from %s", amount.StringFromInt64(amounts[i]), assetCode(asset), names[i])
+
+		if err := cli.ms.Pay(seed, target, amount.StringFromInt64(amounts[i]), asset); err != nil {
+			cli.error(fields, "can't add payment from %s: %v", names[i], cli.errorString(err))
+			return
+		}
+	}
+
+	if err := cli.ms.Submit(); err != nil {
+		cli.errorWithCode(txExitCode(err), fields, "sweep failed: %v", cli.errorString(err))
+		return
+	}
+
+	for i, name := range names {
+		showSuccess("swept %s %s from %s", amount.StringFromInt64(amounts[i]), assetCode(asset), name)
+	}
+}
+
+// sweepAmounts returns how much of asset (in stroops) each of addresses (named names)
+// can pay: all of it for credit assets, and everything above the minimum balance for
+// XLM. The first address pays the fees for the whole transaction (one operation per
+// address), so those are held back from its XLM too. It errors if an account has
+// nothing to sweep.
+func (cli *CLI) sweepAmounts(fields logrus.Fields, names, addresses []string, asset *microstellar.Asset) ([]int64, error) {
+	amounts := make([]int64, len(addresses))
+
+	for i, address := range addresses {
+		if !asset.IsNative() {
+			// The first account still pays the fee for every operation, in XLM
+			if i == 0 {
+				reserve, err := cli.loadNativeReserve(fields, address)
+				if err != nil {
+					return nil, errors.Wrapf(err, "can't load reserve of %s", names[i])
+				}
+
+				if fee := reserve.BaseFee * int64(len(addresses)); reserve.Balance-reserve.minimumBalance() < fee {
+					return nil, errors.Errorf("%s can't pay the fee of %s XLM above its minimum balance of %s XLM (balance: %s XLM)",
+						names[i], amount.StringFromInt64(fee), amount.StringFromInt64(reserve.minimumBalance()), amount.StringFromInt64(reserve.Balance))
+				}
+			}
+
+			balance, err := cli.pollBalance(address, asset)
+			if err != nil {
+				return nil, errors.Wrapf(err, "can't load balance of %s", names[i])
+			}
+
+			if balance <= 0 {
+				return nil, errors.Errorf("%s has no %s to sweep", names[i], assetCode(asset))
+			}
+
+			amounts[i] = balance
+			continue
+		}
+
+		reserve, err := cli.loadNativeReserve(fields, address)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't load reserve of %s", names[i])
+		}
+
+		available := reserve.Balance - reserve.minimumBalance()
+		if i == 0 {
+			available -= reserve.BaseFee * int64(len(addresses))
+		}
+
+		debugf(fields, "%s: balance %d, minimum %d, available %d (stroops)", names[i], reserve.Balance, reserve.minimumBalance(), available)
+		if available <= 0 {
+			return nil, errors.Errorf("%s has nothing to sweep above its minimum balance of %s XLM (balance: %s XLM)",
+				names[i], amount.StringFromInt64(reserve.minimumBalance()), amount.StringFromInt64(reserve.Balance))
+		}
+
+		amounts[i] = available
+	}
+
+	return amounts, nil
+}
+
+// routeString returns the route from send to dest through hops, e.g., XLM -> USD -> EUR.
+func routeString(send *microstellar.Asset, hops []*microstellar.Asset, dest *microstellar.Asset) string {
+	codes := []string{assetCode(send)}
+	for _, hop := range hops {
+		codes = append(codes, assetCode(hop))
+	}
+
+	return strings.Join(append(codes, assetCode(dest)), " -> ")
+}
+
+// logFoundPath logs the first path that horizon finds for a path payment, which is
+// the one the pathfinder uses. It's only for verbose mode, so errors are logged too.
+func (cli *CLI) logFoundPath(logFields logrus.Fields, source, target string, sendAsset *microstellar.Asset, max string,
+	destAsset *microstellar.Asset, destAmount string) {
+	paths, err := cli.ms.FindPaths(source, target, destAsset, destAmount, microstellar.Opts().WithAsset(sendAsset, max))
+	if err != nil {
+		debugf(logFields, "can't find paths: %v", cli.errorString(err))
+		return
+	}
+
+	if len(paths) == 0 {
+		debugf(logFields, "no paths found")
+		return
+	}
+
+	debugf(logFields, "found %d paths, using: %s (sending %s %s)", len(paths), routeString(sendAsset, paths[0].Hops, destAsset),
+		paths[0].SourceAmount, assetCode(sendAsset))
+}
+
+// pathHop is a hop in a path payment, along with the sources of liquidity for it. The
+// network routes the hop through whichever of the two is cheaper.
+type pathHop struct {
+	From      *microstellar.Asset
+	To        *microstellar.Asset
+	OrderBook bool
+	Pool      bool
+}
+
+func (hop pathHop) String() string {
+	source := "no liquidity"
+	if hop.OrderBook && hop.Pool {
+		source = "orderbook+pool"
+	} else if hop.OrderBook {
+		source = "orderbook"
+	} else if hop.Pool {
+		source = "pool"
+	}
+
+	return fmt.Sprintf("%s -> %s (%s)", assetCode(hop.From), assetCode(hop.To), source)
+}
+
+func assetCode(asset *microstellar.Asset) string {
+	if asset.IsNative() {
+		return "XLM"
+	}
+
+	return asset.Code
+}
+
+func allPoolHops(hops []pathHop) bool {
+	for _, hop := range hops {
+		if !hop.Pool {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasOrders returns true if there are offers on the DEX selling "to" for "from".
+func (cli *CLI) hasOrders(logFields logrus.Fields, from, to *microstellar.Asset) (bool, error) {
+	var orderbook microstellar.OrderBook
+
+	query := url.Values{}
+	setAssetParams(query, "selling", to)
+	setAssetParams(query, "buying", from)
+	query.Set("limit", "1")
+
+	if err := cli.getHorizonJSON(logFields, "/order_book?"+query.Encode(), &orderbook); err != nil {
+		return false, err
+	}
+
+	return len(orderbook.Asks) > 0, nil
+}
+
+// traceRoute labels each hop in route (source asset first, destination asset last)
+// with its sources of liquidity, and logs them in verbose mode.
+func (cli *CLI) traceRoute(logFields logrus.Fields, route []*microstellar.Asset) ([]pathHop, error) {
+	hops := []pathHop{}
+
+	for i := 0; i+1 < len(route); i++ {
+		hop := pathHop{From: route[i], To: route[i+1]}
+		var err error
+
+		if hop.OrderBook, err = cli.hasOrders(logFields, hop.From, hop.To); err != nil {
+			return nil, err
+		}
+
+		if hop.Pool, err = cli.hasPool(logFields, hop.From, hop.To); err != nil {
+			return nil, err
+		}
+
+		debugf(logFields, "hop %d: %s", i+1, hop)
+		hops = append(hops, hop)
+	}
+
+	return hops, nil
+}
+
+// findPoolPath returns the first path found by horizon where every hop has a liquidity
+// pool, or nil if there's no such path. Paths can't name the pools, so the network can
+// still fill a hop from the orderbook instead, if it's cheaper.
+func (cli *CLI) findPoolPath(logFields logrus.Fields, source, target string, sendAsset *microstellar.Asset, max string,
+	destAsset *microstellar.Asset, destAmount string) (*microstellar.Path, error) {
+	paths, err := cli.ms.FindPaths(source, target, destAsset, destAmount, microstellar.Opts().WithAsset(sendAsset, max))
+	if err != nil {
+		return nil, err
+	}
+
+	for i, path := range paths {
+		route := append(append([]*microstellar.Asset{sendAsset}, path.Hops...), destAsset)
+		hops, err := cli.traceRoute(logFields, route)
+		if err != nil {
+			return nil, err
+		}
+
+		if allPoolHops(hops) {
+			return &paths[i], nil
+		}
+
+		debugf(logFields, "skipping path %d, not all hops go through pools", i+1)
+	}
+
+	return nil, nil
+}
+
+// nativeReserve holds the state required to compute an account's minimum XLM balance.
+// All amounts are in stroops.
+type nativeReserve struct {
+	Balance            int64
+	SellingLiabilities int64
+	Subentries         int64
+	Sponsoring         int64
+	Sponsored          int64
+	BaseReserve        int64
+	BaseFee            int64
+}
+
+// minimumBalance returns the smallest XLM balance the account can hold.
+func (r nativeReserve) minimumBalance() int64 {
+	return (2+r.Subentries+r.Sponsoring-r.Sponsored)*r.BaseReserve + r.SellingLiabilities
+}
+
+// ledgerParams holds the fee and reserve settings of a ledger, in stroops.
+type ledgerParams struct {
+	BaseFee     int64 `json:"base_fee_in_stroops"`
+	BaseReserve int64 `json:"base_reserve_in_stroops"`
+}
+
+// loadLatestLedger fetches the settings of the latest ledger from horizon, or returns
+// nil if there are none (e.g., on the fake network.)
+func (cli *CLI) loadLatestLedger(logFields logrus.Fields) (*ledgerParams, error) {
+	var ledgers struct {
+		Embedded struct {
+			Records []ledgerParams `json:"records"`
+		} `json:"_embedded"`
+	}
+
+	if err := cli.getHorizonJSON(logFields, "/ledgers?order=desc&limit=1", &ledgers); err != nil {
+		return nil, err
+	}
+
+	if len(ledgers.Embedded.Records) == 0 {
+		return nil, nil
+	}
+
+	return &ledgers.Embedded.Records[0], nil
+}
+
+// baseReserve returns the base reserve (in stroops) set with --base-reserve-override
+// or config:base_reserve (in XLM), or else the one in ledger. Private networks may not
+// use the public network's reserve, so there's no default.
+func (cli *CLI) baseReserve(ledger *ledgerParams) (int64, error) {
+	if spec := cli.getSetting("base-reserve-override", "base_reserve"); spec != "" {
+		reserve, err := amount.ParseInt64(spec)
+		if err != nil || reserve <= 0 {
+			return 0, errors.Errorf("bad base reserve: %s, expecting a positive amount of XLM", spec)
+		}
+
+		return reserve, nil
+	}
+
+	if ledger == nil || ledger.BaseReserve <= 0 {
+		return 0, errors.New("unknown base reserve, set config:base_reserve or use --base-reserve-override")
+	}
+
+	return ledger.BaseReserve, nil
+}
+
+// loadNativeReserve fetches the reserve requirements of address from horizon.
+func (cli *CLI) loadNativeReserve(logFields logrus.Fields, address string) (*nativeReserve, error) {
+	var account struct {
+		ID            string `json:"id"`
+		SubentryCount int64  `json:"subentry_count"`
+		NumSponsoring int64  `json:"num_sponsoring"`
+		NumSponsored  int64  `json:"num_sponsored"`
+		Balances      []struct {
+			Balance            string `json:"balance"`
+			AssetType          string `json:"asset_type"`
+			SellingLiabilities string `json:"selling_liabilities"`
+		} `json:"balances"`
+	}
+
+	if err := cli.getHorizonJSON(logFields, "/accounts/"+address, &account); err != nil {
+		return nil, err
+	}
+
+	ledger, err := cli.loadLatestLedger(logFields)
+	if err != nil {
+		return nil, err
+	}
+
+	if account.ID == "" || ledger == nil {
+		return nil, errors.Errorf("no reserve information for %s", address)
+	}
+
+	baseReserve, err := cli.baseReserve(ledger)
+	if err != nil {
+		return nil, err
+	}
+
+	reserve := &nativeReserve{
+		Subentries:  account.SubentryCount,
+		Sponsoring:  account.NumSponsoring,
+		Sponsored:   account.NumSponsored,
+		BaseReserve: baseReserve,
+		BaseFee:     ledger.BaseFee,
+	}
+
+	for _, balance := range account.Balances {
+		if balance.AssetType != "native" {
+			continue
+		}
+
+		var err error
+		if reserve.Balance, err = amount.ParseInt64(balance.Balance); err != nil {
+			return nil, errors.Wrapf(err, "bad balance: %s", balance.Balance)
+		}
+
+		if balance.SellingLiabilities != "" {
+			if reserve.SellingLiabilities, err = amount.ParseInt64(balance.SellingLiabilities); err != nil {
+				return nil, errors.Wrapf(err, "bad liabilities: %s", balance.SellingLiabilities)
+			}
+		}
+	}
+
+	return reserve, nil
+}
+
+// checkKeep returns an error if spending spend XLM (plus fees) from address would
+// leave less than keep XLM above its reserve.
+func (cli *CLI) checkKeep(logFields logrus.Fields, address, spend, keep string) error {
+	keepAmount, err := amount.ParseInt64(keep)
+	if err != nil || keepAmount < 0 {
+		return errors.Errorf("bad --keep amount: %s", keep)
+	}
+
+	spendAmount, err := amount.ParseInt64(spend)
+	if err != nil {
+		return errors.Errorf("bad amount: %s", spend)
+	}
+
+	reserve, err := cli.loadNativeReserve(logFields, address)
+	if err != nil {
+		return errors.Wrapf(err, "can't check --keep")
+	}
+
+	minimum := reserve.minimumBalance() + keepAmount
+	remaining := reserve.Balance - spendAmount - reserve.BaseFee
+	debugf(logFields, "--keep: balance %d, spending %d, minimum %d (stroops)", reserve.Balance, spendAmount, minimum)
+
+	if remaining < minimum {
+		return errors.Errorf("payment would leave %s XLM, below the minimum of %s XLM (%s reserve + %s kept)",
+			amount.StringFromInt64(remaining), amount.StringFromInt64(minimum),
+			amount.StringFromInt64(reserve.minimumBalance()), amount.StringFromInt64(keepAmount))
+	}
+
+	return nil
+}
+
+// destination is the account a payment goes to. It's loaded from horizon the first
+// time a check needs it, so the checks on a payment (exists, memo required, issuers)
+// share one request.
+type destination struct {
+	cli     *CLI
+	address string
+	loaded  bool
+	account *microstellar.Account // nil if the account doesn't exist
+	err     error
+}
+
+// destination returns the destination account at address, not loaded yet.
+func (cli *CLI) destination(address string) *destination {
+	return &destination{cli: cli, address: address}
+}
+
+// load returns the account, or nil if it doesn't exist on the network.
+func (d *destination) load() (*microstellar.Account, error) {
+	if d.loaded {
+		return d.account, d.err
+	}
+
+	d.loaded = true
+	d.account, d.err = d.cli.ms.LoadAccount(d.address)
+	if d.err != nil {
+		d.account = nil
+		if herr, ok := errors.Cause(d.err).(*horizon.Error); ok && herr.Problem.Status == http.StatusNotFound {
+			d.err = nil
+		}
+	}
+
+	return d.account, d.err
+}
+
+// accountExists returns true if address is an account on the network.
+func (cli *CLI) accountExists(address string) (bool, error) {
+	account, err := cli.destination(address).load()
+	return account != nil, err
+}
+
+// checkDestination returns true if target (named to) exists, since payments to missing
+// accounts fail with an opaque op_no_destination. It's skipped with --batch, where an
+// earlier operation may create the account, and with --allow-unfunded-destination.
+func (cli *CLI) checkDestination(cmd *cobra.Command, fields logrus.Fields, target *destination, to string) bool {
+	if allow, _ := cmd.Flags().GetBool("allow-unfunded-destination"); allow {
+		return true
+	}
+
+	if batch, _ := cmd.Flags().GetBool("batch"); batch {
+		debugf(fields, "not checking if %s exists in a batch", to)
+		return true
+	}
+
+	account, err := target.load()
+	if err != nil {
+		cli.errorWithCode(ExitNetworkError, fields, "can't check if %s exists (use --allow-unfunded-destination to pay anyway): %v", to, cli.errorString(err))
+		return false
+	}
+
+	if account == nil {
+		cli.error(fields, "%s doesn't exist, use --fund to create it with XLM (or --allow-unfunded-destination to pay anyway)", to)
+		return false
+	}
+
+	return true
+}
+
+// memoFlags are the flags that set a transaction's memo.
+var memoFlags = []string{"memotext", "memoid", "memohash", "memoreturn"}
+
+// autoMemoID returns the memo ID for key (see pay --memo-auto-id): the first 8 bytes of
+// the SHA-256 hash of key (as UTF-8), read as a big-endian unsigned integer. This must
+// never change, so the same key always gets the same ID.
+func autoMemoID(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// hasMemo returns true if any of the memo flags are set on cmd.
+func hasMemo(cmd *cobra.Command) bool {
+	for _, memo := range memoFlags {
+		if cmd.Flags().Changed(memo) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// defaultMemo returns the memo flag (memotext or memoid) and value that cmd falls back
+// to when it doesn't set a memo itself, from config:default_memotext or
+// config:default_memoid in the current namespace. Returns "" if there's no default, or
+// if --no-memo or --batch (where the memo is set on commit) is set.
+func (cli *CLI) defaultMemo(cmd *cobra.Command) (string, string, error) {
+	noMemo, _ := cmd.Flags().GetBool("no-memo")
+	batch, _ := cmd.Flags().GetBool("batch")
+	if noMemo || batch || hasMemo(cmd) {
+		return "", "", nil
+	}
+
+	text, textErr := cli.GetVar("vars:config:default_memotext")
+	id, idErr := cli.GetVar("vars:config:default_memoid")
+
+	switch {
+	case textErr == nil && idErr == nil:
+		return "", "", errors.Errorf("config:default_memotext and config:default_memoid are both set, delete one")
+	case textErr == nil:
+		return "memotext", text, nil
+	case idErr == nil:
+		return "memoid", id, nil
+	}
+
+	return "", "", nil
+}
+
+// sendsMemo returns true if the transaction that cmd builds has a memo, from its
+// flags or the namespace's default.
+func (cli *CLI) sendsMemo(cmd *cobra.Command) bool {
+	flag, _, err := cli.defaultMemo(cmd)
+	return hasMemo(cmd) || (err == nil && flag != "")
+}
+
+// otherIssuers returns the issuers of the trustlines the account has to assets with
+// the same code as asset, if it doesn't trust asset itself. Accounts that don't exist
+// have no trustlines.
+func (d *destination) otherIssuers(asset *microstellar.Asset) ([]string, error) {
+	account, err := d.load()
+	if err != nil || account == nil {
+		return nil, err
+	}
+
+	return sameCodeIssuers(account, asset), nil
+}
+
+// sameCodeIssuers returns the issuers of the trustlines on account to assets with the
+// same code as asset (but a different issuer), or nil if account trusts asset.
+func sameCodeIssuers(account *microstellar.Account, asset *microstellar.Asset) []string {
+	var issuers []string
+
+	for _, balance := range account.Balances {
+		if balance.Asset == nil || balance.Asset.IsNative() || balance.Asset.Code != asset.Code {
+			continue
+		}
+
+		if balance.Asset.Issuer == asset.Issuer {
+			return nil
+		}
+
+		issuers = append(issuers, balance.Asset.Issuer)
+	}
+
+	return issuers
+}
+
+// memoRequiredKey is the data entry that accounts set to 1 to require incoming
+// payments to have a memo (see SEP-29.)
+const memoRequiredKey = "config.memo_required"
+
+// requiresMemo returns true if account requires incoming payments to have a memo.
+func requiresMemo(account *microstellar.Account) bool {
+	val, ok := account.GetData(memoRequiredKey)
+	return ok && string(val) == "1"
+}
+
+// memoRequired returns true if the account requires incoming payments to have a memo
+// (see requiresMemo.) Accounts that don't exist don't require memos.
+func (d *destination) memoRequired() (bool, error) {
+	account, err := d.load()
+	if err != nil || account == nil {
+		return false, err
+	}
+
+	return requiresMemo(account), nil
+}
+
+// memoRequired returns true if the account at address requires incoming payments to
+// have a memo (see destination.memoRequired.)
+func (cli *CLI) memoRequired(address string) (bool, error) {
+	return cli.destination(address).memoRequired()
+}
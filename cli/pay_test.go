@@ -1,6 +1,16 @@
 package cli
 
-import "testing"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xfe/microstellar"
+	"github.com/spf13/cobra"
+)
 
 // Note: add -v to any of these commands to enable verbose logging
 
@@ -20,6 +30,10 @@ func TestPayments(t *testing.T) {
 	expectOutput(t, cli, "error", "pay 4 --from master --to worker --memoid hello")
 	expectOutput(t, cli, "", "pay 4 --from master --to worker --memoid 234883")
 
+	expectOutput(t, cli, "error", "pay 4 --from master --to worker --sequence 0")
+	expectOutput(t, cli, "error", "pay 4 --from master --to worker --sequence next")
+	expectOutput(t, cli, "", "pay 4 --from master --to worker --sequence 1234")
+
 	cli.TestCommand("ns other")
 	cli.TestCommand("set config:network fake")
 	expectOutput(t, cli, "error", "pay 4 --from master --to worker")
@@ -35,6 +49,20 @@ func TestPayments(t *testing.T) {
 
 	expectOutput(t, cli, "", "pay 4 USD --from master --to worker")
 	expectOutput(t, cli, "", "pay 4 USD-citi --from master --to worker")
+
+	// Accounts always exist on the fake network, so --fund just pays them
+	expectOutput(t, cli, "", "pay 4 --from master --to worker --create-account")
+	expectOutput(t, cli, "", "pay 4 USD --from master --to worker --fund")
+	expectOutput(t, cli, "error", "pay 4 USD --from master --to worker --create-account")
+	expectOutput(t, cli, "error", "pay 4 --from master --to worker --create-account --with USD --max 5")
+	expectOutput(t, cli, "error", "pay 4 --from master --to worker --create-account --fund")
+
+	// Accounts on the fake network don't require memos
+	expectOutput(t, cli, "", "pay 4 --from master --to worker --skip-memo-check")
+
+	expectOutput(t, cli, "error", "pay 4.12345678 --from master --to worker")
+	expectOutput(t, cli, "error", "pay four --from master --to worker")
+	expectOutput(t, cli, "error", "pay 4 USD --from master --to worker --with native --max 5.000000001")
 }
 
 func TestPathPayments(t *testing.T) {
@@ -54,4 +82,657 @@ func TestPathPayments(t *testing.T) {
 	expectOutput(t, cli, "", "pay 4 USD --from mary --to kelly --with XLM --max 20 --path EUR,INR")
 	expectOutput(t, cli, "error", "pay 4 USD --from mary --to kelly --with XLM --path EUR,INR")
 	expectOutput(t, cli, "error", "pay 4 USD --from mary --to kelly --with XLM --path BAD")
+
+	// --send-asset and --send-max are the same as --with and --max
+	expectOutput(t, cli, "", "pay 4 USD --from mary --to kelly --send-asset XLM --send-max 20 --path EUR,INR")
+	expectOutput(t, cli, "", "pay 4 USD --from mary --to kelly --send-asset XLM --max 20")
+	expectOutput(t, cli, "error", "pay 4 USD --from mary --to kelly --send-asset XLM")
+	expectOutput(t, cli, "error", "pay 4 USD --from mary --to kelly --send-asset XLM --send-max 20.12345678")
+
+	// Path payment flags without --send-asset
+	expectOutput(t, cli, "error", "pay 4 USD --from mary --to kelly --path EUR,INR")
+	expectOutput(t, cli, "error", "pay 4 USD --from mary --to kelly --send-max 20")
+	expectOutput(t, cli, "error", "pay 4 USD --from mary --to kelly --max 20")
+	expectOutput(t, cli, "error", "pay 4 USD --from mary --to kelly --via-pool")
+
+	usd := microstellar.NewAsset("USD", "issuer", microstellar.Credit4Type)
+	eur := microstellar.NewAsset("EUR", "issuer", microstellar.Credit4Type)
+	if got := routeString(microstellar.NativeAsset, []*microstellar.Asset{usd}, eur); got != "XLM -> USD -> EUR" {
+		t.Errorf("want route XLM -> USD -> EUR, got %s", got)
+	}
+
+	if got := routeString(usd, nil, eur); got != "USD -> EUR" {
+		t.Errorf("want route USD -> EUR, got %s", got)
+	}
+}
+
+func TestPoolRoutes(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account new kelly")
+	cli.TestCommand("account new issuer")
+	cli.TestCommand("asset set USD issuer")
+	cli.TestCommand("asset set EUR issuer")
+
+	// No pools on the fake network
+	expectOutput(t, cli, "error", "pay 1 EUR --from mo --to kelly --with USD --max 2 --via-pool")
+	expectOutput(t, cli, "error", "pay 1 EUR --from mo --to kelly --with USD --max 2 --path XLM --via-pool")
+
+	// A horizon with USD/XLM (and XLM/INR) liquidity only via a pool, and XLM/EUR
+	// liquidity only via the orderbook
+	cli.TestCommand("asset set INR issuer")
+	var posts []string
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			posts = append(posts, r.URL.Path)
+		}
+
+		switch r.URL.Path {
+		case "/liquidity_pools":
+			if strings.Contains(r.URL.Query().Get("reserves"), "EUR") {
+				w.Write([]byte(`{"_embedded": {"records": []}}`))
+			} else {
+				w.Write([]byte(`{"_embedded": {"records": [{"id": "dd7b1ab831c273310ddbec6f97870aa83c2fbd78ce22aded37ecbf4f3380fac7"}]}}`))
+			}
+		case "/order_book":
+			if r.URL.Query().Get("selling_asset_code") == "EUR" {
+				w.Write([]byte(`{"bids": [], "asks": [{"price": "1.0", "amount": "100.0"}]}`))
+			} else {
+				w.Write([]byte(`{"bids": [], "asks": []}`))
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer server.Close()
+
+	usd, _ := cli.ResolveAsset("USD")
+	eur, _ := cli.ResolveAsset("EUR")
+
+	hops, err := cli.traceRoute(nil, []*microstellar.Asset{usd, microstellar.NativeAsset, eur})
+	if err != nil {
+		t.Fatalf("traceRoute: %v", err)
+	}
+
+	want := []string{"USD -> XLM (pool)", "XLM -> EUR (orderbook)"}
+	if len(hops) != len(want) {
+		t.Fatalf("want %d hops, got %+v", len(want), hops)
+	}
+
+	for i := range want {
+		if hops[i].String() != want[i] {
+			t.Errorf("hop %d: want %s, got %s", i+1, want[i], hops[i])
+		}
+	}
+
+	if allPoolHops(hops) {
+		t.Errorf("want some hops without pools, got %+v", hops)
+	}
+
+	if !allPoolHops(hops[:1]) {
+		t.Errorf("want USD -> XLM through a pool, got %+v", hops[0])
+	}
+
+	// --via-pool refuses paths with a hop that has no pool, before submitting anything
+	flags := " --allow-unfunded-destination --skip-memo-check"
+	expectOutput(t, cli, "error", "pay 1 EUR --from mo --to kelly --with USD --max 2 --path native --via-pool"+flags)
+	if cli.exitCode != ExitBadArgs || len(posts) != 0 {
+		t.Errorf("want path through the orderbook refused, got exit code %d and %v", cli.exitCode, posts)
+	}
+
+	// ... and submits the ones where every hop has one (which fails, since there's no
+	// real horizon.) The network still picks the orderbook or the pool for each hop.
+	expectOutput(t, cli, "error", "pay 1 INR --from mo --to kelly --with USD --max 2 --path native --via-pool"+flags)
+	if cli.exitCode != ExitNetworkError {
+		t.Errorf("want path through pools submitted, got exit code %d", cli.exitCode)
+	}
+}
+
+func TestPayKeep(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account new kelly")
+
+	// No reserve information on the fake network
+	expectOutput(t, cli, "error", "pay 1 --from mo --to kelly --keep 5")
+	expectOutput(t, cli, "error", "pay 1 --from mo --to kelly --keep lots")
+
+	// 100 XLM, with 3 subentries and 10 XLM in selling liabilities: the reserve is
+	// (2 + 3) * 0.5 + 10 = 12.5 XLM
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ledgers":
+			w.Write([]byte(`{"_embedded": {"records": [{"base_fee_in_stroops": 100, "base_reserve_in_stroops": 5000000}]}}`))
+		default:
+			w.Write([]byte(`{"id": "mo", "subentry_count": 3, "balances": [
+				{"balance": "50.0000000", "asset_type": "credit_alphanum4"},
+				{"balance": "100.0000000", "asset_type": "native", "selling_liabilities": "10.0000000"}]}`))
+		}
+	})
+	defer server.Close()
+
+	reserve, err := cli.loadNativeReserve(nil, "mo")
+	if err != nil {
+		t.Fatalf("loadNativeReserve: %v", err)
+	}
+
+	if min := reserve.minimumBalance(); min != 125000000 {
+		t.Errorf("want minimum balance 12.5 XLM, got %d stroops", min)
+	}
+
+	if err := cli.checkKeep(nil, "mo", "80", "5"); err != nil {
+		t.Errorf("want 80 XLM payment keeping 5 XLM to pass, got: %v", err)
+	}
+
+	if err := cli.checkKeep(nil, "mo", "85", "5"); err == nil {
+		t.Errorf("want 85 XLM payment keeping 5 XLM to fail")
+	}
+}
+
+func TestPayUnfundedDestination(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account new kelly")
+
+	// Every account exists on the fake network
+	expectOutput(t, cli, "", "pay 1 --from mo --to kelly")
+	expectOutput(t, cli, "", "pay 1 --from mo --to kelly --allow-unfunded-destination")
+
+	// A horizon where kelly doesn't exist
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"type": "https://stellar.org/horizon-errors/not_found", "title": "Resource Missing", "status": 404}`))
+	})
+	defer server.Close()
+
+	expectOutput(t, cli, "error", "pay 1 --from mo --to kelly")
+	expectOutput(t, cli, "error", "pay 1 --from mo --to kelly,mo --split")
+}
+
+func TestPayLoadsDestinationOnce(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account new kelly")
+	cli.TestCommand("asset set USD " + strings.TrimSpace(cli.TestCommand("account address mo")))
+	kelly := strings.TrimSpace(cli.TestCommand("account address kelly"))
+
+	// The existence, memo (SEP-29), and issuer checks share one load of kelly
+	loads := 0
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/accounts/"+kelly {
+			loads++
+			w.Write([]byte(`{"id": "` + kelly + `", "balances": [], "data": {}}`))
+			return
+		}
+
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	defer server.Close()
+
+	cli.Embeddable().Run("pay", "1", "USD", "--from", "mo", "--to", "kelly")
+	if loads != 1 {
+		t.Errorf("want kelly loaded once, got %d loads", loads)
+	}
+}
+
+func TestBaseReserveOverride(t *testing.T) {
+	cli, _ := newTestCLI()
+
+	// A private network whose horizon doesn't report a base reserve
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ledgers":
+			w.Write([]byte(`{"_embedded": {"records": [{"base_fee_in_stroops": 100}]}}`))
+		default:
+			w.Write([]byte(`{"id": "mo", "subentry_count": 3, "balances": [
+				{"balance": "100.0000000", "asset_type": "native", "selling_liabilities": "10.0000000"}]}`))
+		}
+	})
+	defer server.Close()
+
+	if _, err := cli.loadNativeReserve(nil, "mo"); err == nil {
+		t.Error("want error without a known base reserve")
+	}
+
+	if err := cli.checkKeep(nil, "mo", "1", "5"); err == nil {
+		t.Error("want --keep to fail without a known base reserve")
+	}
+
+	// With a 2 XLM reserve: (2 + 3) * 2 + 10 = 20 XLM
+	cli.TestCommand("set config:base_reserve 2")
+	reserve, err := cli.loadNativeReserve(nil, "mo")
+	if err != nil {
+		t.Fatalf("loadNativeReserve: %v", err)
+	}
+
+	if min := reserve.minimumBalance(); min != 200000000 {
+		t.Errorf("want minimum balance 20 XLM, got %d stroops", min)
+	}
+
+	if err := cli.checkKeep(nil, "mo", "74", "5"); err != nil {
+		t.Errorf("want 74 XLM payment keeping 5 XLM to pass, got: %v", err)
+	}
+
+	if err := cli.checkKeep(nil, "mo", "76", "5"); err == nil {
+		t.Errorf("want 76 XLM payment keeping 5 XLM to fail")
+	}
+
+	for _, spec := range []string{"0", "-1", "lots"} {
+		cli.TestCommand("set config:base_reserve " + spec)
+		if _, err := cli.loadNativeReserve(nil, "mo"); err == nil {
+			t.Errorf("want error for base reserve %s", spec)
+		}
+	}
+
+	// The override also applies on the fake network, which has no ledgers
+	cli.TestCommand("del config:base_reserve")
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new mo")
+
+	cli.TestCommand("set config:base_reserve 2")
+	expectOutput(t, cli, "error", "account new kelly --fund-from mo --start-balance 3.9999999")
+	expectOutput(t, cli, "error", "account new kelly --fund-from mo --start-balance 1 --base-reserve-override 0")
+	cli.TestCommand("account new kelly --fund-from mo --start-balance 1 --base-reserve-override 0.5")
+	if result := cli.TestCommand("account address kelly"); result[0] != 'G' {
+		t.Error("not an address: ", result)
+	}
+}
+
+func TestPayMuxed(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new mo")
+
+	muxed, err := encodeMuxedAddress("GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U", 1234)
+	if err != nil {
+		t.Fatalf("encodeMuxedAddress: %v", err)
+	}
+
+	expectOutput(t, cli, "", "pay 4 --from mo --to "+muxed)
+	expectOutput(t, cli, "error", "pay 4 --from mo --to "+muxed+" --memoid 1")
+	expectOutput(t, cli, "error", "pay 4 --from mo --to "+muxed+" --memotext hi")
+
+	address, id, err := cli.ResolveDestination(nil, muxed)
+	if err != nil || address != "GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U" || id == nil || *id != 1234 {
+		t.Errorf("wrong destination for %s: %s %v %v", muxed, address, id, err)
+	}
+
+	// Store muxed addresses like any other address
+	cli.TestCommand("account set exchange " + muxed)
+	expectOutput(t, cli, muxed, "account address exchange")
+	expectOutput(t, cli, "", "pay 4 --from mo --to exchange")
+
+	address, id, _ = cli.ResolveDestination(nil, "exchange")
+	if address != "GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U" || id == nil || *id != 1234 {
+		t.Errorf("wrong destination for exchange: %s %v", address, id)
+	}
+
+	// Everywhere else, muxed addresses resolve to the underlying account
+	if address, _ := cli.ResolveAccount(nil, "exchange", "address"); address != "GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U" {
+		t.Errorf("wrong account for exchange: %s", address)
+	}
+}
+
+func TestPaySponsoredFee(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account new master")
+	cli.TestCommand("account new worker")
+	cli.TestCommand("account new sponsor")
+	cli.TestCommand("account set viewer GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+
+	expectOutput(t, cli, "", "pay 4 --from master --to worker --exact-fee-account sponsor")
+	expectOutput(t, cli, "", "pay 4 --from master --to worker --exact-fee-account sponsor --memotext hi")
+	expectOutput(t, cli, "", "pay 4 --from master --to worker --exact-fee-account sponsor --create-account")
+
+	// Both the sponsor and the payer need seeds to sign
+	expectOutput(t, cli, "error", "pay 4 --from master --to worker --exact-fee-account viewer")
+	expectOutput(t, cli, "error", "pay 4 --from master --to worker --exact-fee-account nobody")
+	expectOutput(t, cli, "error", "pay 4 --from viewer --to worker --exact-fee-account sponsor")
+
+	// Batches are sourced (and paid for) by their own source
+	cli.TestCommand("batch begin master")
+	expectOutput(t, cli, "error", "pay 4 --from master --to worker --exact-fee-account sponsor --batch")
+}
+
+func TestPayConfirm(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account new master")
+	cli.TestCommand("account new worker")
+
+	// Nothing to poll on the fake network
+	expectOutput(t, cli, "", "pay 4 --from master --to worker --confirm")
+	expectOutput(t, cli, "", "pay 4 --from master --to worker --round-trip-check --confirm-timeout 5s")
+	expectOutput(t, cli, "error", "pay 4 --from master --to worker --confirm --nosubmit")
+
+	cli.TestCommand("batch begin master")
+	expectOutput(t, cli, "error", "pay 4 --from master --to worker --confirm --batch")
+}
+
+func TestConfirmReceipt(t *testing.T) {
+	// The payment shows up on the third poll
+	balances := []int64{100000000, 100000000, 150000000}
+	polls := 0
+	poll := func() (int64, error) {
+		balance := balances[polls]
+		if polls < len(balances)-1 {
+			polls++
+		}
+		return balance, nil
+	}
+
+	got, err := confirmReceipt(poll, 100000000, "5", time.Second, time.Millisecond)
+	if err != nil || got != "15.0000000" {
+		t.Errorf("want balance 15.0000000, got %s (%v)", got, err)
+	}
+
+	// Less than the payment arrives
+	polls = 0
+	if _, err := confirmReceipt(poll, 100000000, "6", 10*time.Millisecond, time.Millisecond); err == nil {
+		t.Error("want error when the balance doesn't grow enough")
+	}
+
+	failing := func() (int64, error) { return 0, errors.New("unreachable") }
+	if _, err := confirmReceipt(failing, 0, "1", 10*time.Millisecond, time.Millisecond); err == nil || err.Error() != "unreachable" {
+		t.Errorf("want the poll error, got %v", err)
+	}
+}
+
+func TestSplitAmount(t *testing.T) {
+	tests := []struct {
+		total   int64
+		weights []int64
+		want    []int64
+	}{
+		{100, []int64{1, 1, 1}, []int64{34, 33, 33}},
+		{101, []int64{1, 1, 1}, []int64{34, 34, 33}},
+		{100, []int64{2, 1, 1}, []int64{50, 25, 25}},
+		{10, []int64{1, 2}, []int64{4, 6}},
+		{7, []int64{1}, []int64{7}},
+		{9223372036854775807, []int64{3, 3}, []int64{4611686018427387904, 4611686018427387903}},
+	}
+
+	for _, test := range tests {
+		got, err := splitAmount(test.total, test.weights)
+		if err != nil || fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("split %d by %v: want %v, got %v (%v)", test.total, test.weights, test.want, got, err)
+		}
+	}
+
+	if _, err := splitAmount(1, []int64{1, 1}); err == nil {
+		t.Errorf("want error when a recipient gets nothing")
+	}
+
+	if _, err := splitAmount(100, []int64{1, 0}); err == nil {
+		t.Errorf("want error for zero weight")
+	}
+}
+
+func TestPaySplit(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account new master")
+	cli.TestCommand("account new a")
+	cli.TestCommand("account new b")
+	cli.TestCommand("account new c")
+	cli.TestCommand("account set viewer GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+
+	expectOutput(t, cli, "", "pay 100 --from master --to a,b,c --split")
+	expectOutput(t, cli, "", "pay 100 --from master --to a,b,c --split --weights 2,1,1 --memotext payroll")
+	expectOutput(t, cli, "error", "pay 100 --from master --to a,b,c --split --weights 2,1")
+	expectOutput(t, cli, "error", "pay 100 --from master --to a,b,c --split --weights 2,0,1")
+	expectOutput(t, cli, "error", "pay 100 --from master --to a,b,c --weights 2,1,1")
+	expectOutput(t, cli, "error", "pay 0.0000001 --from master --to a,b --split")
+	expectOutput(t, cli, "error", "pay 100 --from master --to a,nobody --split")
+	expectOutput(t, cli, "error", "pay 100 --from viewer --to a,b --split")
+	expectOutput(t, cli, "error", "pay 100 --from master --to a,b --split --batch")
+	expectOutput(t, cli, "error", "pay 100 --from master --to a,b --split --confirm")
+
+	many := strings.TrimSuffix(strings.Repeat("a,", maxOpsPerTx+1), ",")
+	expectOutput(t, cli, "error", "pay 100 --from master --to "+many+" --split")
+}
+
+func TestAutoMemoID(t *testing.T) {
+	// Changing these breaks integrations that depend on stable IDs
+	tests := map[string]uint64{
+		"invoice-42": 4337049738231944310,
+		"":           16406829232824261652,
+	}
+
+	for key, want := range tests {
+		for i := 0; i < 2; i++ {
+			if got := autoMemoID(key); got != want {
+				t.Errorf("autoMemoID(%q): want %d, got %d", key, want, got)
+			}
+		}
+	}
+
+	if autoMemoID("invoice-42") == autoMemoID("invoice-43") {
+		t.Errorf("want different IDs for different keys")
+	}
+
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new master")
+	cli.TestCommand("account new worker")
+
+	expectOutput(t, cli, "", "pay 4 --from master --to worker --memo-auto-id invoice-42")
+	expectOutput(t, cli, "error", "pay 4 --from master --to worker --memo-auto-id invoice-42 --memoid 1")
+	expectOutput(t, cli, "error", "pay 4 --from master --to worker --memo-auto-id invoice-42 --memotext hi")
+	expectOutput(t, cli, "error", "pay 4 --from master --to worker --memo-auto-id invoice-42 --batch")
+}
+
+func TestPaySweep(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account set kelly GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+
+	expectOutput(t, cli, "error", "pay --from-many mo --to kelly --from mo")
+	expectOutput(t, cli, "error", "pay --from-many mo --to kelly --split")
+	expectOutput(t, cli, "error", "pay --from-many mo,mo --to kelly")
+	expectOutput(t, cli, "error", "pay --from-many kelly --to mo")
+	expectOutput(t, cli, "error", "pay --from-many mo --to nobody")
+	expectOutput(t, cli, "error", "pay --from-many mo --to kelly --sweep-asset nothing")
+	expectOutput(t, cli, "error", "pay 1 --from mo --to kelly --sweep-asset XLM")
+	expectOutput(t, cli, "error", "pay 1 --to kelly")
+
+	// No reserve information on the fake network
+	expectOutput(t, cli, "error", "pay --from-many mo --to kelly --sweep-asset XLM")
+
+	// 100 XLM each, with 3 subentries and 10 XLM in selling liabilities: the reserve is
+	// (2 + 3) * 0.5 + 10 = 12.5 XLM
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ledgers":
+			w.Write([]byte(`{"_embedded": {"records": [{"base_fee_in_stroops": 100, "base_reserve_in_stroops": 5000000}]}}`))
+		case "/accounts/GPOOR":
+			w.Write([]byte(`{"id": "GPOOR", "balances": [{"balance": "1.0000000", "asset_type": "native"}]}`))
+		default:
+			w.Write([]byte(`{"id": "mo", "subentry_count": 3, "balances": [
+				{"balance": "100.0000000", "asset_type": "native", "selling_liabilities": "10.0000000"}]}`))
+		}
+	})
+	defer server.Close()
+
+	// The first account pays the fee for both operations
+	amounts, err := cli.sweepAmounts(nil, []string{"a", "b"}, []string{"GA", "GB"}, microstellar.NativeAsset)
+	if err != nil {
+		t.Fatalf("sweepAmounts: %v", err)
+	}
+
+	if len(amounts) != 2 || amounts[0] != 875000000-200 || amounts[1] != 875000000 {
+		t.Errorf("want 87.4999800 and 87.5 XLM swept, got %v", amounts)
+	}
+
+	if _, err := cli.sweepAmounts(nil, []string{"a", "poor"}, []string{"GA", "GPOOR"}, microstellar.NativeAsset); err == nil ||
+		!strings.Contains(err.Error(), "poor has nothing to sweep") {
+		t.Errorf("want error for account at its minimum balance, got %v", err)
+	}
+
+	// Sweeping other assets, the first account still needs XLM for the fee
+	usd := microstellar.NewAsset("USD", "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM", microstellar.Credit4Type)
+	if _, err := cli.sweepAmounts(nil, []string{"poor", "a"}, []string{"GPOOR", "GA"}, usd); err == nil ||
+		!strings.Contains(err.Error(), "poor can't pay the fee of 0.0000200 XLM") {
+		t.Errorf("want error for fee payer at its minimum balance, got %v", err)
+	}
+
+	if _, err := cli.sweepAmounts(nil, []string{"a", "poor"}, []string{"GA", "GPOOR"}, usd); err == nil ||
+		!strings.Contains(err.Error(), "a has no USD to sweep") {
+		t.Errorf("want the fee check to pass, and error for no USD, got %v", err)
+	}
+}
+
+func TestDefaultMemo(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new master")
+	cli.TestCommand("account new worker")
+
+	cli.TestCommand("set config:default_memotext partner-42")
+	cli.TestCommand("ns staging")
+	cli.TestCommand("set config:default_memoid 7")
+	cli.TestCommand("ns default")
+
+	expectOutput(t, cli, "", "pay 4 --from master --to worker")
+	expectOutput(t, cli, "", "pay 4 --from master --to worker --memoid 1")
+	expectOutput(t, cli, "", "pay 4 --from master --to worker --no-memo")
+	expectOutput(t, cli, "error", "pay 4 --from master --to worker --no-memo --memotext hi")
+
+	tests := []struct {
+		ns        string
+		args      []string
+		wantFlag  string
+		wantValue string
+	}{
+		{"default", nil, "memotext", "partner-42"},
+		{"default", []string{"--memoid", "1"}, "", ""},
+		{"default", []string{"--memohash", "aGVsbG8="}, "", ""},
+		{"default", []string{"--no-memo"}, "", ""},
+		{"default", []string{"--batch"}, "", ""},
+		{"staging", nil, "memoid", "7"},
+		{"staging", []string{"--memotext", "hi"}, "", ""},
+		{"prod", nil, "", ""},
+	}
+
+	for _, test := range tests {
+		cmd := &cobra.Command{}
+		buildFlagsForTxOptions(cmd)
+		if err := cmd.Flags().Parse(test.args); err != nil {
+			t.Fatalf("can't parse %v: %v", test.args, err)
+		}
+
+		cli.ns = test.ns
+		flag, value, err := cli.defaultMemo(cmd)
+		if err != nil || flag != test.wantFlag || value != test.wantValue {
+			t.Errorf("%s %v: want %q %q, got %q %q (%v)", test.ns, test.args, test.wantFlag, test.wantValue, flag, value, err)
+		}
+	}
+
+	// Only one default memo per namespace
+	cli.ns = "default"
+	cli.TestCommand("set config:default_memoid 8")
+	expectOutput(t, cli, "error", "pay 4 --from master --to worker")
+	expectOutput(t, cli, "", "pay 4 --from master --to worker --memotext hi")
+}
+
+func TestPayInvoice(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new master")
+	cli.TestCommand("account new worker")
+
+	expectOutput(t, cli, "error", "pay 4 --from master --to worker --invoice inv-1 --memoid 1")
+	expectOutput(t, cli, "error", "pay 4 --from master --to worker --invoice inv-1 --memo-auto-id inv-1")
+	expectOutput(t, cli, "error", "pay 4 --from master --to worker --invoice inv-1 --batch")
+	expectOutput(t, cli, "error", "pay 4 --from master --to worker --invoice=")
+	expectOutput(t, cli, "error", "invoice lookup inv-1")
+	expectOutput(t, cli, "error", "invoice nothing")
+
+	// Nothing is submitted on the fake network, so there's nothing to record
+	expectOutput(t, cli, "", "pay 4 --from master --to worker --invoice inv-1")
+	expectOutput(t, cli, "error", "invoice lookup inv-1")
+
+	tx, err := bumpSequenceTx("GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM", 1, 2, nil)
+	if err != nil {
+		t.Fatalf("can't build transaction: %v", err)
+	}
+
+	want, err := txHash(tx, cli.networkPassphrase())
+	if err != nil || len(want) != 64 {
+		t.Fatalf("want a 32-byte hex hash, got %q (%v)", want, err)
+	}
+
+	if other, _ := txHash(tx, publicNetworkPassphrase); other == want {
+		t.Errorf("want different hashes on different networks, got %s", want)
+	}
+
+	cli.submitted = tx
+	if err := cli.recordInvoice(nil, "inv-1"); err != nil {
+		t.Fatalf("can't record invoice: %v", err)
+	}
+
+	expectOutput(t, cli, want, "invoice lookup inv-1")
+
+	// Invoices are only paid once
+	expectOutput(t, cli, "error", "pay 4 --from master --to worker --invoice inv-1")
+	expectOutput(t, cli, "", "pay 4 --from master --to worker --invoice inv-2")
+}
+
+func TestSameCodeIssuers(t *testing.T) {
+	issuerA := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+	issuerB := "GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U"
+	usdA := microstellar.NewAsset("USD", issuerA, microstellar.Credit4Type)
+	usdB := microstellar.NewAsset("USD", issuerB, microstellar.Credit4Type)
+	eurB := microstellar.NewAsset("EUR", issuerB, microstellar.Credit4Type)
+
+	account := func(assets ...*microstellar.Asset) *microstellar.Account {
+		balances := []microstellar.Balance{{Asset: microstellar.NativeAsset, Amount: "10.0000000"}}
+		for _, asset := range assets {
+			balances = append(balances, microstellar.Balance{Asset: asset, Amount: "0.0000000"})
+		}
+
+		return &microstellar.Account{Balances: balances}
+	}
+
+	tests := []struct {
+		account *microstellar.Account
+		asset   *microstellar.Asset
+		want    string
+	}{
+		{account(usdA), usdB, issuerA},
+		{account(usdA, eurB), usdB, issuerA},
+		{account(usdA, usdB), usdB, ""},
+		{account(usdB, usdA), usdB, ""},
+		{account(usdB), usdB, ""},
+		{account(eurB), usdA, ""},
+		{account(), usdA, ""},
+	}
+
+	for i, test := range tests {
+		if got := strings.Join(sameCodeIssuers(test.account, test.asset), ","); got != test.want {
+			t.Errorf("test %d: want issuers %q, got %q", i, test.want, got)
+		}
+	}
+
+	// No trustlines on the fake network
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new master")
+	cli.TestCommand("account new worker")
+	cli.TestCommand("asset set USD " + issuerB)
+
+	expectOutput(t, cli, "", "pay 4 USD --from master --to worker --strict-asset-match")
 }
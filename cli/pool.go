@@ -0,0 +1,603 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"math/big"
+	"net/url"
+
+	"github.com/0xfe/microstellar"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/stellar/go/amount"
+	"github.com/stellar/go/strkey"
+)
+
+// liquidityPool is a liquidity pool, as returned by horizon.
+type liquidityPool struct {
+	ID              string `json:"id"`
+	FeeBP           uint32 `json:"fee_bp"`
+	Type            string `json:"type"`
+	TotalTrustlines string `json:"total_trustlines"`
+	TotalShares     string `json:"total_shares"`
+	Reserves        []struct {
+		Asset  string `json:"asset"`
+		Amount string `json:"amount"`
+	} `json:"reserves"`
+}
+
+// poolFeeBP is the fee of constant product liquidity pools in basis points, the only
+// fee the protocol allows.
+const poolFeeBP = 30
+
+// poolAssetTypes are the XDR asset types of the assets that can be in a liquidity pool.
+var poolAssetTypes = map[microstellar.AssetType]int32{
+	microstellar.NativeType:   0,
+	microstellar.Credit4Type:  1,
+	microstellar.Credit12Type: 2,
+}
+
+// poolAssetLess returns true if a comes before b in the order the protocol requires of
+// a pool's assets: by type (native, then 4-character codes, then 12-character codes),
+// then code, then issuer.
+func poolAssetLess(a, b *microstellar.Asset) bool {
+	if typeA, typeB := poolAssetTypes[a.Type], poolAssetTypes[b.Type]; typeA != typeB {
+		return typeA < typeB
+	}
+
+	if a.IsNative() {
+		return false
+	}
+
+	if a.Code != b.Code {
+		return a.Code < b.Code
+	}
+
+	return a.Issuer < b.Issuer
+}
+
+// writeAssetXDR writes the XDR encoding of asset to buf.
+func writeAssetXDR(buf *bytes.Buffer, asset *microstellar.Asset) error {
+	assetType, ok := poolAssetTypes[asset.Type]
+	if !ok {
+		return errors.Errorf("%s assets can't be in a liquidity pool", asset.Type)
+	}
+
+	binary.Write(buf, binary.BigEndian, assetType)
+	if asset.IsNative() {
+		return nil
+	}
+
+	key, err := strkey.Decode(strkey.VersionByteAccountID, asset.Issuer)
+	if err != nil {
+		return errors.Wrapf(err, "bad issuer: %s", asset.Issuer)
+	}
+
+	code := make([]byte, 4)
+	if asset.Type == microstellar.Credit12Type {
+		code = make([]byte, 12)
+	}
+	copy(code, asset.Code)
+
+	buf.Write(code)
+	binary.Write(buf, binary.BigEndian, int32(0)) // ed25519 public key
+	buf.Write(key)
+	return nil
+}
+
+// liquidityPoolID returns the ID of the constant product liquidity pool of assets a and
+// b, i.e., the hex-encoded SHA-256 hash of its XDR-encoded parameters. The assets must
+// be distinct, and in order (see poolAssetLess.)
+func liquidityPoolID(a, b *microstellar.Asset) (string, error) {
+	if !poolAssetLess(a, b) {
+		if poolAssetLess(b, a) {
+			return "", errors.Errorf("pool assets are out of order, expecting %s before %s", horizonAssetString(b), horizonAssetString(a))
+		}
+
+		return "", errors.Errorf("pool assets must be different")
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, int32(0)) // constant product
+	for _, asset := range []*microstellar.Asset{a, b} {
+		if err := writeAssetXDR(&buf, asset); err != nil {
+			return "", err
+		}
+	}
+	binary.Write(&buf, binary.BigEndian, int32(poolFeeBP))
+
+	hash := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// writePoolXDR writes the XDR encoding of the ID of the pool with hex-encoded ID
+// poolID to buf.
+func writePoolXDR(buf *bytes.Buffer, poolID string) error {
+	id, err := hex.DecodeString(poolID)
+	if err != nil || len(id) != 32 {
+		return errors.Errorf("bad pool ID: %s", poolID)
+	}
+
+	buf.Write(id)
+	return nil
+}
+
+// parsePoolPrice returns the price val, a positive decimal number, as a fraction that
+// fits in the protocol's prices (of int32s.)
+func parsePoolPrice(val string) (*big.Rat, error) {
+	price, ok := new(big.Rat).SetString(val)
+	if !ok || price.Sign() <= 0 {
+		return nil, errors.Errorf("price must be a positive number: %s", val)
+	}
+
+	if !price.Num().IsInt64() || price.Num().Int64() > math.MaxInt32 || price.Denom().Int64() > math.MaxInt32 {
+		return nil, errors.Errorf("price has too many digits: %s", val)
+	}
+
+	return price, nil
+}
+
+// poolDepositOp returns the XDR-encoded parameters of a deposit of at most maxA of the
+// first asset of the pool with ID poolID, and at most maxB of its second, at a price
+// (of the first asset per unit of the second) between minPrice and maxPrice.
+func poolDepositOp(poolID string, maxA, maxB int64, minPrice, maxPrice *big.Rat) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writePoolXDR(&buf, poolID); err != nil {
+		return nil, err
+	}
+
+	binary.Write(&buf, binary.BigEndian, maxA)
+	binary.Write(&buf, binary.BigEndian, maxB)
+	for _, price := range []*big.Rat{minPrice, maxPrice} {
+		binary.Write(&buf, binary.BigEndian, int32(price.Num().Int64()))
+		binary.Write(&buf, binary.BigEndian, int32(price.Denom().Int64()))
+	}
+
+	return buf.Bytes(), nil
+}
+
+// poolWithdrawOp returns the XDR-encoded parameters of a withdrawal of shares from the
+// pool with ID poolID, for at least minA of its first asset and minB of its second.
+func poolWithdrawOp(poolID string, shares, minA, minB int64) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writePoolXDR(&buf, poolID); err != nil {
+		return nil, err
+	}
+
+	binary.Write(&buf, binary.BigEndian, shares)
+	binary.Write(&buf, binary.BigEndian, minA)
+	binary.Write(&buf, binary.BigEndian, minB)
+	return buf.Bytes(), nil
+}
+
+// poolTrustOp returns the XDR-encoded parameters of a trustline to the shares of the
+// pool of assets a and b, which must be in order (see poolAssetLess), with no limit.
+func poolTrustOp(a, b *microstellar.Asset) ([]byte, error) {
+	if _, err := liquidityPoolID(a, b); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, int32(3)) // pool shares
+	binary.Write(&buf, binary.BigEndian, int32(0)) // constant product
+	for _, asset := range []*microstellar.Asset{a, b} {
+		if err := writeAssetXDR(&buf, asset); err != nil {
+			return nil, err
+		}
+	}
+	binary.Write(&buf, binary.BigEndian, int32(poolFeeBP))
+	binary.Write(&buf, binary.BigEndian, int64(math.MaxInt64))
+
+	return buf.Bytes(), nil
+}
+
+// hasPoolTrustline returns true if address has a trustline to the shares of the pool
+// with ID poolID.
+func (cli *CLI) hasPoolTrustline(logFields logrus.Fields, address, poolID string) (bool, error) {
+	var account struct {
+		Balances []struct {
+			LiquidityPoolID string `json:"liquidity_pool_id"`
+		} `json:"balances"`
+	}
+
+	if err := cli.getHorizonJSON(logFields, "/accounts/"+address, &account); err != nil {
+		return false, err
+	}
+
+	for _, balance := range account.Balances {
+		if balance.LiquidityPoolID == poolID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// horizonAssetString returns asset in the canonical form used by horizon's query
+// parameters, e.g., native or USD:GABC...
+func horizonAssetString(asset *microstellar.Asset) string {
+	if asset.IsNative() {
+		return "native"
+	}
+
+	return asset.Code + ":" + asset.Issuer
+}
+
+// hasPool returns true if there's a liquidity pool between assets a and b.
+func (cli *CLI) hasPool(logFields logrus.Fields, a, b *microstellar.Asset) (bool, error) {
+	var pools struct {
+		Embedded struct {
+			Records []liquidityPool `json:"records"`
+		} `json:"_embedded"`
+	}
+
+	query := url.Values{}
+	query.Set("reserves", horizonAssetString(a)+","+horizonAssetString(b))
+	query.Set("limit", "1")
+
+	if err := cli.getHorizonJSON(logFields, "/liquidity_pools?"+query.Encode(), &pools); err != nil {
+		return false, err
+	}
+
+	return len(pools.Embedded.Records) > 0, nil
+}
+
+// poolPosition is an account's share of a liquidity pool, and the amounts of the
+// pool's reserves that the shares could be redeemed for.
+type poolPosition struct {
+	PoolID   string        `json:"pool_id"`
+	Shares   string        `json:"shares"`
+	Reserves []poolReserve `json:"reserves"`
+}
+
+// impliedReserves returns the amount of each of pool's reserves that shares are worth,
+// rounded down to the stroop.
+func impliedReserves(pool liquidityPool, shares string) ([]poolReserve, error) {
+	held, err := amount.ParseInt64(shares)
+	if err != nil {
+		return nil, errors.Errorf("bad shares: %s", shares)
+	}
+
+	total, err := amount.ParseInt64(pool.TotalShares)
+	if err != nil {
+		return nil, errors.Errorf("bad total shares for pool %s: %s", pool.ID, pool.TotalShares)
+	}
+
+	reserves := []poolReserve{}
+	for _, reserve := range pool.Reserves {
+		amt, err := amount.ParseInt64(reserve.Amount)
+		if err != nil {
+			return nil, errors.Errorf("bad reserve for pool %s: %s", pool.ID, reserve.Amount)
+		}
+
+		implied := big.NewInt(0)
+		if total > 0 {
+			implied.Mul(big.NewInt(amt), big.NewInt(held))
+			implied.Quo(implied, big.NewInt(total))
+		}
+
+		reserves = append(reserves, poolReserve{Asset: reserve.Asset, Amount: amount.StringFromInt64(implied.Int64())})
+	}
+
+	return reserves, nil
+}
+
+// loadPoolPositions returns the liquidity pool shares held by address.
+func (cli *CLI) loadPoolPositions(logFields logrus.Fields, address string) ([]poolPosition, error) {
+	var account struct {
+		Balances []struct {
+			AssetType       string `json:"asset_type"`
+			LiquidityPoolID string `json:"liquidity_pool_id"`
+			Balance         string `json:"balance"`
+		} `json:"balances"`
+	}
+
+	if err := cli.getHorizonJSON(logFields, "/accounts/"+address, &account); err != nil {
+		return nil, err
+	}
+
+	positions := []poolPosition{}
+	for _, balance := range account.Balances {
+		if balance.AssetType != "liquidity_pool_shares" {
+			continue
+		}
+
+		var pool liquidityPool
+		if err := cli.getHorizonJSON(logFields, "/liquidity_pools/"+balance.LiquidityPoolID, &pool); err != nil {
+			return nil, errors.Wrapf(err, "can't load pool %s", balance.LiquidityPoolID)
+		}
+
+		reserves, err := impliedReserves(pool, balance.Balance)
+		if err != nil {
+			return nil, err
+		}
+
+		positions = append(positions, poolPosition{PoolID: balance.LiquidityPoolID, Shares: balance.Balance, Reserves: reserves})
+	}
+
+	return positions, nil
+}
+
+func validPoolID(poolID string) bool {
+	id, err := hex.DecodeString(poolID)
+	return err == nil && len(id) == 32
+}
+
+func validPositiveAmount(val string) bool {
+	amt, err := amount.ParseInt64(val)
+	return err == nil && amt > 0
+}
+
+func (cli *CLI) buildPoolCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pool [deposit|withdraw|info]",
+		Short: "manage liquidity pool (AMM) shares",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cli.error(logrus.Fields{"cmd": "pool"}, "unrecognized pool command: %s, expecting: deposit|withdraw|info", args[0])
+		},
+	}
+
+	cmd.AddCommand(cli.buildPoolDepositCmd())
+	cmd.AddCommand(cli.buildPoolWithdrawCmd())
+	cmd.AddCommand(cli.buildPoolInfoCmd())
+
+	return cmd
+}
+
+func (cli *CLI) buildPoolDepositCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "deposit [account] [assetA] [assetB] --amount-a X --amount-b Y --min-price p --max-price q",
+		Short: "deposit assetA and assetB into their liquidity pool, creating the pool share trustline if needed",
+		Args:  cobra.ExactArgs(3),
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "pool", "subcmd": "deposit"}
+
+			name := args[0]
+			amountA, _ := cmd.Flags().GetString("amount-a")
+			amountB, _ := cmd.Flags().GetString("amount-b")
+			minPrice, _ := cmd.Flags().GetString("min-price")
+			maxPrice, _ := cmd.Flags().GetString("max-price")
+
+			tx, seeds, err := cli.newRawTx(cmd, logFields, name)
+			if err != nil {
+				cli.error(logFields, "can't generate deposit transaction: %v", err)
+				return
+			}
+
+			assetA, err := cli.ResolveAsset(args[1])
+			if err != nil {
+				cli.error(logFields, "invalid asset: %s", args[1])
+				return
+			}
+
+			assetB, err := cli.ResolveAsset(args[2])
+			if err != nil {
+				cli.error(logFields, "invalid asset: %s", args[2])
+				return
+			}
+
+			if assetA.Equals(*assetB) {
+				cli.error(logFields, "pool assets must be different")
+				return
+			}
+
+			if !validPositiveAmount(amountA) || !validPositiveAmount(amountB) {
+				cli.error(logFields, "invalid amounts: %s, %s", amountA, amountB)
+				return
+			}
+
+			min, err := parsePoolPrice(minPrice)
+			if err != nil {
+				cli.error(logFields, "invalid min price: %v", err)
+				return
+			}
+
+			max, err := parsePoolPrice(maxPrice)
+			if err != nil || max.Cmp(min) < 0 {
+				cli.error(logFields, "invalid max price: %s", maxPrice)
+				return
+			}
+
+			maxA, _ := amount.ParseInt64(amountA)
+			maxB, _ := amount.ParseInt64(amountB)
+
+			// The protocol orders the pool's assets, and prices the first in the second
+			if poolAssetLess(assetB, assetA) {
+				assetA, assetB = assetB, assetA
+				maxA, maxB = maxB, maxA
+				min, max = new(big.Rat).Inv(max), new(big.Rat).Inv(min)
+			}
+
+			poolID, err := liquidityPoolID(assetA, assetB)
+			if err != nil {
+				cli.error(logFields, "invalid pool: %v", err)
+				return
+			}
+
+			// There are no trustlines to check on the fake network
+			hasTrustline := false
+			if cli.horizonURL() != "" {
+				if hasTrustline, err = cli.hasPoolTrustline(logFields, tx.source, poolID); err != nil {
+					cli.errorWithCode(ExitNetworkError, logFields, "can't load trustlines of %s: %v", name, cli.errorString(err))
+					return
+				}
+			}
+
+			if !hasTrustline {
+				debugf(logFields, "adding trustline to the shares of pool %s", poolID)
+				op, err := poolTrustOp(assetA, assetB)
+				if err != nil {
+					cli.error(logFields, "invalid pool: %v", err)
+					return
+				}
+				tx.addOp(opChangeTrust, op)
+			}
+
+			op, err := poolDepositOp(poolID, maxA, maxB, min, max)
+			if err != nil {
+				cli.error(logFields, "invalid deposit: %v", err)
+				return
+			}
+			tx.addOp(opLiquidityPoolDeposit, op)
+
+			current, err := cli.currentSequence(tx.source, tx.params)
+			if err != nil {
+				cli.errorWithCode(ExitNetworkError, logFields, "can't load sequence number of %s: %v", name, cli.errorString(err))
+				return
+			}
+			tx.seq = current + 1
+
+			debugf(logFields, "depositing into pool %s from %s", poolID, tx.source)
+			if err := cli.submitRawTx(logFields, tx, seeds); err != nil {
+				cli.errorWithCode(txExitCode(err), logFields, "failed to deposit into pool %s: %v", poolID, cli.errorString(err))
+				return
+			}
+		},
+	}
+
+	cmd.Flags().String("amount-a", "", "maximum amount of assetA to deposit")
+	cmd.Flags().String("amount-b", "", "maximum amount of assetB to deposit")
+	cmd.Flags().String("min-price", "", "minimum price of assetB in units of assetA, i.e., assetA deposited per assetB")
+	cmd.Flags().String("max-price", "", "maximum price of assetB in units of assetA, i.e., assetA deposited per assetB")
+
+	cmd.MarkFlagRequired("amount-a")
+	cmd.MarkFlagRequired("amount-b")
+	cmd.MarkFlagRequired("min-price")
+	cmd.MarkFlagRequired("max-price")
+
+	buildFlagsForTxParams(cmd)
+	return cmd
+}
+
+func (cli *CLI) buildPoolWithdrawCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "withdraw [account] [poolID] --shares S --min-a X --min-b Y",
+		Short: "redeem S pool shares for the pool's assets",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "pool", "subcmd": "withdraw"}
+
+			name := args[0]
+			poolID := args[1]
+			shares, _ := cmd.Flags().GetString("shares")
+			minA, _ := cmd.Flags().GetString("min-a")
+			minB, _ := cmd.Flags().GetString("min-b")
+
+			tx, seeds, err := cli.newRawTx(cmd, logFields, name)
+			if err != nil {
+				cli.error(logFields, "can't generate withdrawal transaction: %v", err)
+				return
+			}
+
+			if !validPoolID(poolID) {
+				cli.error(logFields, "invalid pool ID: %s", poolID)
+				return
+			}
+
+			if !validPositiveAmount(shares) {
+				cli.error(logFields, "invalid shares: %s", shares)
+				return
+			}
+
+			if err := validateAmount(minA, true); err != nil {
+				cli.error(logFields, "invalid min-a: %v", err)
+				return
+			}
+
+			if err := validateAmount(minB, true); err != nil {
+				cli.error(logFields, "invalid min-b: %v", err)
+				return
+			}
+
+			amt, _ := amount.ParseInt64(shares)
+			amtA, _ := amount.ParseInt64(minA)
+			amtB, _ := amount.ParseInt64(minB)
+
+			op, err := poolWithdrawOp(poolID, amt, amtA, amtB)
+			if err != nil {
+				cli.error(logFields, "invalid withdrawal: %v", err)
+				return
+			}
+			tx.addOp(opLiquidityPoolWithdraw, op)
+
+			current, err := cli.currentSequence(tx.source, tx.params)
+			if err != nil {
+				cli.errorWithCode(ExitNetworkError, logFields, "can't load sequence number of %s: %v", name, cli.errorString(err))
+				return
+			}
+			tx.seq = current + 1
+
+			debugf(logFields, "withdrawing %s shares of pool %s to %s", shares, poolID, tx.source)
+			if err := cli.submitRawTx(logFields, tx, seeds); err != nil {
+				cli.errorWithCode(txExitCode(err), logFields, "failed to withdraw from pool %s: %v", poolID, cli.errorString(err))
+				return
+			}
+		},
+	}
+
+	cmd.Flags().String("shares", "", "number of pool shares to redeem")
+	cmd.Flags().String("min-a", "0", "minimum amount of the pool's first asset to receive")
+	cmd.Flags().String("min-b", "0", "minimum amount of the pool's second asset to receive")
+
+	cmd.MarkFlagRequired("shares")
+
+	buildFlagsForTxParams(cmd)
+	return cmd
+}
+
+func (cli *CLI) buildPoolInfoCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "info [poolID]",
+		Short: "show the reserves and total shares of liquidity pool [poolID]",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "pool", "subcmd": "info"}
+			poolID := args[0]
+
+			if !validPoolID(poolID) {
+				cli.error(logFields, "invalid pool ID: %s", poolID)
+				return
+			}
+
+			var pool liquidityPool
+			if err := cli.getHorizonJSON(logFields, "/liquidity_pools/"+poolID, &pool); err != nil {
+				cli.errorWithCode(ExitNetworkError, logFields, "can't load pool %s: %v", poolID, cli.errorString(err))
+				return
+			}
+
+			if pool.ID == "" {
+				// Nothing on the fake network
+				return
+			}
+
+			format, _ := cmd.Flags().GetString("format")
+			if format == "json" {
+				pretty, _ := cmd.Flags().GetBool("pretty")
+				data, err := marshalJSON(pool, pretty)
+				if err != nil {
+					cli.error(logFields, "got bad data: %v", err)
+					return
+				}
+
+				showSuccess("%v", string(data))
+				return
+			}
+
+			showSuccess("id: %s", pool.ID)
+			showSuccess("type: %s (fee: %d bp)", pool.Type, pool.FeeBP)
+			for _, reserve := range pool.Reserves {
+				showSuccess("reserve: %s %s", reserve.Amount, reserve.Asset)
+			}
+			showSuccess("total shares: %s (%s trustlines)", pool.TotalShares, pool.TotalTrustlines)
+		},
+	}
+
+	cmd.Flags().String("format", "line", "output format (json, line)")
+	buildPrettyFlag(cmd)
+	return cmd
+}
@@ -0,0 +1,223 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/0xfe/microstellar"
+)
+
+// Note: add -v to any of these commands to enable verbose logging
+
+func TestPool(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account new issuer")
+	cli.TestCommand("asset set USD issuer")
+
+	poolID := "dd7b1ab831c273310ddbec6f97870aa83c2fbd78ce22aded37ecbf4f3380fac7"
+
+	expectOutput(t, cli, "", "pool info "+poolID)
+	expectOutput(t, cli, "error", "pool info nopool")
+
+	expectOutput(t, cli, "error", "pool deposit mo USD USD --amount-a 10 --amount-b 10 --min-price 1 --max-price 2")
+	expectOutput(t, cli, "error", "pool deposit mo USD native --amount-a 10 --amount-b -1 --min-price 1 --max-price 2")
+	expectOutput(t, cli, "error", "pool deposit mo USD native --amount-a 10 --amount-b 10 --min-price 2 --max-price 1")
+	expectOutput(t, cli, "error", "pool withdraw mo nopool --shares 10")
+	expectOutput(t, cli, "error", "pool withdraw mo "+poolID+" --shares 0")
+
+	expectOutput(t, cli, "error", "pool withdraw mo "+poolID+" --shares 10 --min-a -1")
+	expectOutput(t, cli, "error", "pool deposit nobody USD native --amount-a 10 --amount-b 10 --min-price 1 --max-price 2")
+
+	expectOutput(t, cli, "", "pool deposit mo USD native --amount-a 10 --amount-b 10 --min-price 1 --max-price 2")
+	expectOutput(t, cli, "", "pool withdraw mo "+poolID+" --shares 10 --min-a 1 --min-b 1")
+	expectOutput(t, cli, "error", "pool withdraw mo "+poolID+" --shares 10 --max-fee-total 99")
+
+	// There's no account on the fake network, so the deposit adds the share trustline.
+	// The assets are put in protocol order, and the prices inverted to match.
+	issuer, _ := cli.ResolveAccount(nil, "issuer", "address")
+	usd := microstellar.NewAsset("USD", issuer, microstellar.Credit4Type)
+	trust, _ := poolTrustOp(microstellar.NativeAsset, usd)
+
+	pool, _ := liquidityPoolID(microstellar.NativeAsset, usd)
+	deposit, _ := poolDepositOp(pool, 200000000, 100000000, big.NewRat(1, 4), big.NewRat(1, 2))
+
+	got := cli.TestCommand("pool deposit mo USD native --amount-a 10 --amount-b 20 --min-price 2 --max-price 4 --nosubmit")
+	raw, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(got))
+	if len(raw) < 60 || binary.BigEndian.Uint32(raw[56:60]) != 2 {
+		t.Fatalf("want trustline and deposit operations, got %q", got)
+	}
+
+	ops := append(append([]byte{0, 0, 0, 0, 0, 0, 0, 6}, trust...), append([]byte{0, 0, 0, 0, 0, 0, 0, 22}, deposit...)...)
+	if !bytes.Contains(raw, ops) {
+		t.Errorf("want trustline and deposit operations, got %x", raw)
+	}
+}
+
+func TestPoolOps(t *testing.T) {
+	arst := microstellar.NewAsset("ARST", "GB7TAYRUZGE6TVT7NHP5SMIZRNQA6PLM423EYISAOAP3MKYIQMVYP2JO", microstellar.Credit4Type)
+	usd := microstellar.NewAsset("USD", "GCEZWKCA5VLDNRLN3RPRJMRZOX3Z6G5CHCGSNFHEYVXM3XOJMDS674JZ", microstellar.Credit4Type)
+	poolID := "dd7b1ab831c273310ddbec6f97870aa83c2fbd78ce22aded37ecbf4f3380fac7"
+
+	deposit, err := poolDepositOp(poolID, 100000000, 200000000, big.NewRat(1, 2), big.NewRat(2, 1))
+	want := poolID + "0000000005f5e100" + "000000000bebc200" + "0000000100000002" + "0000000200000001"
+	if err != nil || hex.EncodeToString(deposit) != want {
+		t.Errorf("want deposit %s, got %x: %v", want, deposit, err)
+	}
+
+	withdraw, err := poolWithdrawOp(poolID, 100000000, 1, 2)
+	want = poolID + "0000000005f5e100" + "0000000000000001" + "0000000000000002"
+	if err != nil || hex.EncodeToString(withdraw) != want {
+		t.Errorf("want withdrawal %s, got %x: %v", want, withdraw, err)
+	}
+
+	if _, err := poolWithdrawOp("nopool", 1, 0, 0); err == nil {
+		t.Error("want error for bad pool ID")
+	}
+
+	// The pool's parameters hash to its ID, then the limit
+	trust, err := poolTrustOp(arst, usd)
+	if err != nil || len(trust) < 12 {
+		t.Fatalf("can't build trustline: %v", err)
+	}
+
+	params := trust[4 : len(trust)-8]
+	if hash := sha256.Sum256(params); hex.EncodeToString(hash[:]) != poolID || hex.EncodeToString(trust[len(trust)-8:]) != "7fffffffffffffff" {
+		t.Errorf("want trustline to pool %s with no limit, got %x", poolID, trust)
+	}
+
+	if _, err := poolTrustOp(usd, arst); err == nil {
+		t.Error("want error for assets out of order")
+	}
+
+	for val, want := range map[string]string{"1": "1", "0.25": "1/4", "1.5": "3/2"} {
+		if price, err := parsePoolPrice(val); err != nil || price.RatString() != want {
+			t.Errorf("%s: want price %s, got %v: %v", val, want, price, err)
+		}
+	}
+
+	for _, val := range []string{"0", "-1", "lots", "0.00000000001", "3000000000"} {
+		if _, err := parsePoolPrice(val); err == nil {
+			t.Errorf("%s: want error", val)
+		}
+	}
+}
+
+func TestLiquidityPoolID(t *testing.T) {
+	arst := microstellar.NewAsset("ARST", "GB7TAYRUZGE6TVT7NHP5SMIZRNQA6PLM423EYISAOAP3MKYIQMVYP2JO", microstellar.Credit4Type)
+	usd := microstellar.NewAsset("USD", "GCEZWKCA5VLDNRLN3RPRJMRZOX3Z6G5CHCGSNFHEYVXM3XOJMDS674JZ", microstellar.Credit4Type)
+
+	// From the Stellar SDKs
+	poolID, err := liquidityPoolID(arst, usd)
+	if want := "dd7b1ab831c273310ddbec6f97870aa83c2fbd78ce22aded37ecbf4f3380fac7"; err != nil || poolID != want {
+		t.Errorf("want pool %s, got %s: %v", want, poolID, err)
+	}
+
+	if _, err := liquidityPoolID(usd, arst); err == nil {
+		t.Error("want error for assets out of order")
+	}
+
+	if _, err := liquidityPoolID(usd, usd); err == nil {
+		t.Error("want error for the same asset")
+	}
+
+	// Native first, then 4-character codes, then 12-character codes
+	long := microstellar.NewAsset("USDLONG", usd.Issuer, microstellar.Credit12Type)
+	if !poolAssetLess(microstellar.NativeAsset, usd) || !poolAssetLess(usd, long) || poolAssetLess(long, arst) {
+		t.Error("want assets ordered by type first")
+	}
+
+	if _, err := liquidityPoolID(microstellar.NativeAsset, long); err != nil {
+		t.Errorf("want native and credit_alphanum12 pool, got: %v", err)
+	}
+
+	huge := microstellar.NewAsset("USDHUGELONGCODE", usd.Issuer, microstellar.Credit64Type)
+	if _, err := liquidityPoolID(microstellar.NativeAsset, huge); err == nil {
+		t.Error("want error for credit_alphanum64 asset")
+	}
+}
+
+func TestImpliedReserves(t *testing.T) {
+	pool := liquidityPool{ID: "pool", TotalShares: "300.0000000"}
+	pool.Reserves = append(pool.Reserves,
+		struct {
+			Asset  string `json:"asset"`
+			Amount string `json:"amount"`
+		}{"native", "1000.0000000"},
+		struct {
+			Asset  string `json:"asset"`
+			Amount string `json:"amount"`
+		}{"USD:GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM", "200.0000000"})
+
+	got, err := impliedReserves(pool, "100.0000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rounded down to the stroop
+	want := []poolReserve{
+		{"native", "333.3333333"},
+		{"USD:GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM", "66.6666666"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	if _, err := impliedReserves(pool, "lots"); err == nil {
+		t.Error("want error for bad shares")
+	}
+}
+
+func TestDexListPools(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new mo")
+
+	expectOutput(t, cli, "", "dex list mo --include-pools")
+
+	got := strings.Join(strings.Fields(cli.TestCommand("dex list mo --include-pools --format json")), "")
+	if want := `{"offers":[],"pools":[]}`; got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+
+	poolID := "dd7b1ab831c273310ddbec6f97870aa83c2fbd78ce22aded37ecbf4f3380fac7"
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/accounts/"):
+			fmt.Fprintf(w, `{"balances": [
+				{"balance": "10.0000000", "asset_type": "liquidity_pool_shares", "liquidity_pool_id": "%s"},
+				{"balance": "5.0000000", "asset_type": "native"}]}`, poolID)
+		case r.URL.Path == "/liquidity_pools/"+poolID:
+			fmt.Fprintf(w, `{"id": "%s", "total_shares": "100.0000000", "reserves": [
+				{"asset": "native", "amount": "50.0000000"},
+				{"asset": "USD:GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM", "amount": "20.0000000"}]}`, poolID)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer server.Close()
+
+	positions, err := cli.loadPoolPositions(nil, "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []poolPosition{{poolID, "10.0000000", []poolReserve{
+		{"native", "5.0000000"},
+		{"USD:GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM", "2.0000000"},
+	}}}
+	if !reflect.DeepEqual(positions, want) {
+		t.Errorf("want %+v, got %+v", want, positions)
+	}
+}
@@ -0,0 +1,222 @@
+package cli
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/0xfe/microstellar"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"rsc.io/qr"
+)
+
+// qrCode is a QR code symbol.
+type qrCode struct {
+	*qr.Code
+}
+
+// encodeQR returns the smallest QR code for data, at error correction level M.
+func encodeQR(data []byte) (*qrCode, error) {
+	code, err := qr.Encode(string(data), qr.M)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't make a QR code of %d bytes", len(data))
+	}
+
+	return &qrCode{code}, nil
+}
+
+// qrQuietZone is the light border around the symbol, in modules.
+const qrQuietZone = 4
+
+// dark returns true if the module at column x, row y (counting the quiet zone) is dark.
+func (code *qrCode) dark(x, y int) bool {
+	x, y = x-qrQuietZone, y-qrQuietZone
+	return x >= 0 && y >= 0 && x < code.Size && y < code.Size && code.Black(x, y)
+}
+
+// render renders the symbol with Unicode half blocks, two rows per line. Terminals
+// usually have light text on a dark background, so light modules are drawn, unless
+// invert is set.
+func (code *qrCode) render(invert bool) string {
+	blocks := []string{"█", "▀", "▄", " "}
+	if invert {
+		blocks = []string{" ", "▄", "▀", "█"}
+	}
+
+	width := code.Size + 2*qrQuietZone
+	lines := []string{}
+	for y := 0; y < width; y += 2 {
+		line := ""
+		for x := 0; x < width; x++ {
+			i := 0
+			if code.dark(x, y) {
+				i += 2
+			}
+
+			// Past the last row, draw nothing
+			if (y+1 >= width && !invert) || code.dark(x, y+1) {
+				i++
+			}
+
+			line += blocks[i]
+		}
+
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// image returns the symbol as a grayscale image, with scale pixels per module.
+func (code *qrCode) image(scale int) *image.Gray {
+	width := (code.Size + 2*qrQuietZone) * scale
+	img := image.NewGray(image.Rect(0, 0, width, width))
+
+	for y := 0; y < width; y++ {
+		for x := 0; x < width; x++ {
+			c := color.Gray{Y: 255}
+			if code.dark(x/scale, y/scale) {
+				c = color.Gray{Y: 0}
+			}
+
+			img.SetGray(x, y, c)
+		}
+	}
+
+	return img
+}
+
+// sep7Escape escapes a SEP-7 URI parameter, with spaces as %20, which wallets handle
+// more consistently than +.
+func sep7Escape(val string) string {
+	return strings.Replace(url.QueryEscape(val), "+", "%20", -1)
+}
+
+// sep7PayURI returns a SEP-7 URI to pay address. The amount, asset, and memo are
+// optional. Memos are text or id (memoType.)
+func sep7PayURI(address, amt string, asset *microstellar.Asset, memo, memoType string) string {
+	params := []string{"destination=" + sep7Escape(address)}
+	if amt != "" {
+		params = append(params, "amount="+sep7Escape(amt))
+	}
+
+	if asset != nil && !asset.IsNative() {
+		params = append(params, "asset_code="+sep7Escape(asset.Code), "asset_issuer="+sep7Escape(asset.Issuer))
+	}
+
+	if memo != "" {
+		params = append(params, "memo="+sep7Escape(memo), "memo_type="+sep7Escape("MEMO_"+strings.ToUpper(memoType)))
+	}
+
+	return "web+stellar:pay?" + strings.Join(params, "&")
+}
+
+func (cli *CLI) buildAccountQRCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "qr [account] [--amount amount] [--asset asset] [--memo memo [--memo-type text|id]] [--png file]",
+		Short: "show the address of [account] as a QR code, or a SEP-7 payment request with --amount, --asset, or --memo",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			name := args[0]
+			logFields := logrus.Fields{"cmd": "account", "subcmd": "qr"}
+
+			address, err := cli.ResolveAccount(logFields, name, "address")
+			if err != nil {
+				cli.error(logFields, "invalid account: %s", name)
+				return
+			}
+
+			if microstellar.ValidSeed(address) == nil {
+				address = addressFromSeed(address)
+			}
+
+			amt, _ := cmd.Flags().GetString("amount")
+			assetName, _ := cmd.Flags().GetString("asset")
+			memo, _ := cmd.Flags().GetString("memo")
+			memoType, _ := cmd.Flags().GetString("memo-type")
+
+			payload := address
+			if amt != "" || assetName != "" || memo != "" {
+				if amt != "" {
+					if err := validateAmount(amt, false); err != nil {
+						cli.error(logFields, "bad --amount: %v", err)
+						return
+					}
+				}
+
+				var asset *microstellar.Asset
+				if assetName != "" {
+					if asset, err = cli.ResolveAsset(assetName); err != nil {
+						cli.error(logFields, "bad --asset: %s", assetName)
+						return
+					}
+				}
+
+				switch memoType {
+				case "text":
+					if len(memo) > maxMemoText {
+						cli.error(logFields, "--memo is longer than %d bytes: %s", maxMemoText, memo)
+						return
+					}
+				case "id":
+					if _, err := strconv.ParseUint(memo, 10, 64); err != nil {
+						cli.error(logFields, "bad --memo: %s, expecting an ID", memo)
+						return
+					}
+				default:
+					cli.error(logFields, "bad --memo-type: %s, expecting: text|id", memoType)
+					return
+				}
+
+				payload = sep7PayURI(address, amt, asset, memo, memoType)
+			}
+
+			code, err := encodeQR([]byte(payload))
+			if err != nil {
+				cli.error(logFields, "%v", err)
+				return
+			}
+
+			if path, _ := cmd.Flags().GetString("png"); path != "" {
+				if err := writePNG(path, code.image(8)); err != nil {
+					cli.error(logFields, "%v", err)
+					return
+				}
+			} else {
+				invert, _ := cmd.Flags().GetBool("invert")
+				showSuccess(code.render(invert))
+			}
+
+			showSuccess(payload)
+		},
+	}
+
+	cmd.Flags().String("amount", "", "request this amount")
+	cmd.Flags().String("asset", "", "request this asset (default: XLM)")
+	cmd.Flags().String("memo", "", "request this memo")
+	cmd.Flags().String("memo-type", "text", "the type of --memo: text or id")
+	cmd.Flags().String("png", "", "write the QR code to this PNG file, instead of the terminal")
+	cmd.Flags().Bool("invert", false, "draw dark modules, for terminals with dark text on a light background")
+	return cmd
+}
+
+// writePNG writes img to the PNG file at path.
+func writePNG(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return errors.Wrapf(err, "can't create %s", path)
+	}
+
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return errors.Wrapf(err, "can't write %s", path)
+	}
+
+	return errors.Wrapf(file.Close(), "can't write %s", path)
+}
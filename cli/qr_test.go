@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0xfe/microstellar"
+)
+
+func TestQRCode(t *testing.T) {
+	code, err := encodeQR([]byte("GCSQ7TNBQ2XVFH6DPYYWN7TCRTLAT7H4VAI3DHHVGSN4MQP4VFUIR4F6"))
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	// Addresses are alphanumeric, so 56 characters fit in version 3 at level M
+	if code.Size != 29 {
+		t.Errorf("bad size: got %d, want 29", code.Size)
+	}
+
+	lines := strings.Split(code.render(false), "\n")
+	if len(lines) != (code.Size+2*qrQuietZone+1)/2 {
+		t.Errorf("bad number of lines: %d", len(lines))
+	}
+
+	// The finders are surrounded by the (light) quiet zone
+	if !strings.HasPrefix(lines[2], "████ ▄▄▄▄▄ █") {
+		t.Errorf("bad finder: %s", lines[2])
+	}
+
+	// Inverted, dark modules are drawn instead
+	inverted := strings.Split(code.render(true), "\n")
+	if !strings.HasPrefix(inverted[2], "    █▀▀▀▀▀█ ") {
+		t.Errorf("bad inverted finder: %s", inverted[2])
+	}
+
+	img := code.image(2)
+	if width := img.Bounds().Dx(); width != 2*(code.Size+2*qrQuietZone) {
+		t.Errorf("bad image width: %d", width)
+	}
+
+	if img.GrayAt(0, 0).Y != 255 || img.GrayAt(2*qrQuietZone, 2*qrQuietZone).Y != 0 {
+		t.Errorf("want a light quiet zone and a dark finder corner")
+	}
+
+	if _, err := encodeQR(make([]byte, 3000)); err == nil {
+		t.Errorf("want error for too much data")
+	}
+}
+
+func TestSEP7PayURI(t *testing.T) {
+	address := "GCSQ7TNBQ2XVFH6DPYYWN7TCRTLAT7H4VAI3DHHVGSN4MQP4VFUIR4F6"
+	asset := microstellar.NewAsset("USD", "GAKONCKYJ7PRRKBZSWVPG3MURUNX5XNGZ4HFNHVG63JBUAWMVG2M5M4P", microstellar.Credit4Type)
+
+	tests := []struct {
+		amt      string
+		asset    *microstellar.Asset
+		memo     string
+		memoType string
+		want     string
+	}{
+		{"", nil, "", "text", "web+stellar:pay?destination=" + address},
+		{"12.5", microstellar.NativeAsset, "", "text", "web+stellar:pay?destination=" + address + "&amount=12.5"},
+		{"1", asset, "rent for june", "text", "web+stellar:pay?destination=" + address + "&amount=1&asset_code=USD&asset_issuer=" + asset.Issuer + "&memo=rent%20for%20june&memo_type=MEMO_TEXT"},
+		{"", nil, "1234", "id", "web+stellar:pay?destination=" + address + "&memo=1234&memo_type=MEMO_ID"},
+	}
+
+	for _, test := range tests {
+		if got := sep7PayURI(address, test.amt, test.asset, test.memo, test.memoType); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+}
+
+func TestAccountQR(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("account set kelly GCSQ7TNBQ2XVFH6DPYYWN7TCRTLAT7H4VAI3DHHVGSN4MQP4VFUIR4F6")
+
+	result := cli.TestCommand("account qr kelly")
+	if !strings.Contains(result, "█") || !strings.HasSuffix(strings.TrimSpace(result), "GCSQ7TNBQ2XVFH6DPYYWN7TCRTLAT7H4VAI3DHHVGSN4MQP4VFUIR4F6") {
+		t.Errorf("bad QR code: %s", result)
+	}
+
+	result = cli.TestCommand("account qr kelly --amount 10 --memo 42 --memo-type id")
+	if !strings.HasSuffix(strings.TrimSpace(result), "web+stellar:pay?destination=GCSQ7TNBQ2XVFH6DPYYWN7TCRTLAT7H4VAI3DHHVGSN4MQP4VFUIR4F6&amount=10&memo=42&memo_type=MEMO_ID") {
+		t.Errorf("bad payment request: %s", result)
+	}
+
+	expectOutput(t, cli, "error", "account qr kelly --amount foo")
+	expectOutput(t, cli, "error", "account qr kelly --memo foo --memo-type id")
+	expectOutput(t, cli, "error", "account qr kelly --memo foo --memo-type hash")
+	expectOutput(t, cli, "error", "account qr kelly --asset BLAH")
+	expectOutput(t, cli, "error", "account qr nobody")
+
+	dir, err := ioutil.TempDir("", "lumen-qr")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "kelly.png")
+	cli.TestCommand("account qr kelly --png " + path)
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("can't open PNG: %v", err)
+	}
+	defer file.Close()
+
+	img, err := png.Decode(file)
+	if err != nil {
+		t.Fatalf("bad PNG: %v", err)
+	}
+
+	if width := img.Bounds().Dx(); width != (29+2*qrQuietZone)*8 {
+		t.Errorf("bad PNG width: %d", width)
+	}
+}
@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// rateLimiter is a token bucket that allows rate events per second on average, in
+// bursts of up to a second's worth (and at least one.) A zero rate is unlimited.
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	// Replaced in tests
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, now: time.Now, sleep: time.Sleep}
+}
+
+// wait blocks until n events are allowed, and returns how long it waited. Events past
+// the burst borrow from the future, so a chunk bigger than the burst (e.g., a 100
+// operation transaction at 10 per second) waits for its share of time instead of
+// forever.
+func (limiter *rateLimiter) wait(n int) time.Duration {
+	if limiter.rate <= 0 {
+		return 0
+	}
+
+	now := limiter.now()
+	if !limiter.last.IsZero() {
+		limiter.tokens += now.Sub(limiter.last).Seconds() * limiter.rate
+		if limiter.tokens > limiter.burst {
+			limiter.tokens = limiter.burst
+		}
+	}
+
+	limiter.last = now
+	limiter.tokens -= float64(n)
+	if limiter.tokens >= 0 {
+		return 0
+	}
+
+	delay := time.Duration(-limiter.tokens / limiter.rate * float64(time.Second))
+	limiter.sleep(delay)
+	limiter.tokens = 0
+	limiter.last = now.Add(delay)
+	return delay
+}
+
+func buildRateLimitFlag(cmd *cobra.Command, usage string) {
+	cmd.Flags().Float64("rate-limit", 0, usage+" (default unlimited)")
+}
+
+// getRateLimiter returns a limiter for the value of --rate-limit, which is unlimited if
+// it isn't set.
+func getRateLimiter(cmd *cobra.Command) (*rateLimiter, error) {
+	rate, err := cmd.Flags().GetFloat64("rate-limit")
+	if err != nil {
+		return newRateLimiter(0), nil
+	}
+
+	if rate < 0 {
+		return nil, errors.Errorf("bad --rate-limit: expecting a positive number per second, got: %v", rate)
+	}
+
+	return newRateLimiter(rate), nil
+}
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	newLimiter := func(rate float64) *rateLimiter {
+		limiter := newRateLimiter(rate)
+		limiter.now = func() time.Time { return now }
+		limiter.sleep = func(d time.Duration) { now = now.Add(d) }
+		return limiter
+	}
+
+	tests := []struct {
+		rate    float64
+		advance time.Duration
+		n       int
+		want    time.Duration
+	}{
+		// Unlimited
+		{0, 0, 1000, 0},
+
+		// A second's worth of burst, then borrow from the future
+		{10, 0, 10, 0},
+		{10, 0, 5, 500 * time.Millisecond},
+		{10, 0, 1, 100 * time.Millisecond},
+
+		// Idle time refills the bucket, but only up to the burst
+		{10, 10 * time.Second, 100, 9 * time.Second},
+		{10, 200 * time.Millisecond, 2, 0},
+		{10, 0, 1, 100 * time.Millisecond},
+
+		// Rates below one per second still allow one at a time
+		{0.5, 0, 1, 0},
+		{0.5, 0, 1, 2 * time.Second},
+		{0.5, time.Second, 1, time.Second},
+	}
+
+	var limiter *rateLimiter
+	for i, test := range tests {
+		if limiter == nil || limiter.rate != test.rate {
+			limiter = newLimiter(test.rate)
+		}
+
+		now = now.Add(test.advance)
+		if got := limiter.wait(test.n); got != test.want {
+			t.Errorf("test %d (rate %v, %d events): want wait %v, got %v", i, test.rate, test.n, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+
+	"github.com/0xfe/microstellar"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/strkey"
+	"github.com/stellar/go/xdr"
+)
+
+// The types of the operations lumen encodes itself, which were added to the protocol
+// after the vendored stellar/go XDR was generated.
+const (
+	opChangeTrust           int32 = 6
+	opClaimClaimableBalance int32 = 15
+	opSetTrustLineFlags     int32 = 21
+	opLiquidityPoolDeposit  int32 = 22
+	opLiquidityPoolWithdraw int32 = 23
+)
+
+// envelopeTypeTx prefixes a transaction when it's hashed for signing.
+const envelopeTypeTx int32 = 2
+
+// rawTx is a transaction that lumen encodes itself, because the vendored stellar/go
+// XDR can't encode its operations. It's encoded in the original envelope format,
+// which the network still accepts, and hashes the same as its current one.
+type rawTx struct {
+	source string // address
+	seq    int64
+	params *txParams
+	ops    [][]byte // XDR-encoded operation bodies, i.e., the type and its parameters
+}
+
+// fee returns the fee of tx in stroops: the base fee per operation.
+func (tx *rawTx) fee() int64 {
+	return baseFee * int64(len(tx.ops))
+}
+
+// addOp adds the operation of type opType, with XDR-encoded parameters body, to tx.
+func (tx *rawTx) addOp(opType int32, body []byte) {
+	var op bytes.Buffer
+	binary.Write(&op, binary.BigEndian, opType)
+	op.Write(body)
+	tx.ops = append(tx.ops, op.Bytes())
+}
+
+// writeAccountXDR writes the XDR encoding of the account ID of address to buf.
+func writeAccountXDR(buf *bytes.Buffer, address string) error {
+	key, err := strkey.Decode(strkey.VersionByteAccountID, address)
+	if err != nil {
+		return errors.Wrapf(err, "bad address: %s", address)
+	}
+
+	binary.Write(buf, binary.BigEndian, int32(0)) // ed25519 public key
+	buf.Write(key)
+	return nil
+}
+
+// writeMemoXDR writes the XDR encoding of memo to buf.
+func writeMemoXDR(buf *bytes.Buffer, memo xdr.Memo) {
+	binary.Write(buf, binary.BigEndian, int32(memo.Type))
+
+	switch memo.Type {
+	case xdr.MemoTypeMemoText:
+		text := []byte(*memo.Text)
+		binary.Write(buf, binary.BigEndian, uint32(len(text)))
+		buf.Write(text)
+		buf.Write(make([]byte, (4-len(text)%4)%4))
+	case xdr.MemoTypeMemoId:
+		binary.Write(buf, binary.BigEndian, uint64(*memo.Id))
+	case xdr.MemoTypeMemoHash:
+		buf.Write(memo.Hash[:])
+	case xdr.MemoTypeMemoReturn:
+		buf.Write(memo.RetHash[:])
+	}
+}
+
+// encode returns the XDR encoding of tx, without its signatures.
+func (tx *rawTx) encode() ([]byte, error) {
+	if len(tx.ops) == 0 || len(tx.ops) > maxOpsPerTx {
+		return nil, errors.Errorf("transactions need 1 to %d operations, got %d", maxOpsPerTx, len(tx.ops))
+	}
+
+	var buf bytes.Buffer
+	if err := writeAccountXDR(&buf, tx.source); err != nil {
+		return nil, err
+	}
+
+	binary.Write(&buf, binary.BigEndian, uint32(tx.fee()))
+	binary.Write(&buf, binary.BigEndian, tx.seq)
+
+	params := tx.params
+	if params == nil {
+		params = &txParams{}
+	}
+
+	if bounds := params.timeBounds; bounds != nil {
+		binary.Write(&buf, binary.BigEndian, uint32(1))
+		binary.Write(&buf, binary.BigEndian, uint64(bounds.MinTime))
+		binary.Write(&buf, binary.BigEndian, uint64(bounds.MaxTime))
+	} else {
+		binary.Write(&buf, binary.BigEndian, uint32(0))
+	}
+
+	writeMemoXDR(&buf, params.memo)
+
+	binary.Write(&buf, binary.BigEndian, uint32(len(tx.ops)))
+	for _, op := range tx.ops {
+		binary.Write(&buf, binary.BigEndian, uint32(0)) // the transaction's source
+		buf.Write(op)
+	}
+
+	binary.Write(&buf, binary.BigEndian, int32(0)) // no extension
+	return buf.Bytes(), nil
+}
+
+// hash returns the hash of tx, the encoded transaction, signed for the network with
+// passphrase. It's also the ID horizon uses for it.
+func (tx *rawTx) hash(encoded []byte, passphrase string) [32]byte {
+	network := sha256.Sum256([]byte(passphrase))
+
+	var buf bytes.Buffer
+	buf.Write(network[:])
+	binary.Write(&buf, binary.BigEndian, envelopeTypeTx)
+	buf.Write(encoded)
+	return sha256.Sum256(buf.Bytes())
+}
+
+// envelope returns the base64-encoded transaction envelope of tx, signed with seeds
+// for the network with passphrase, and its hex-encoded hash.
+func (tx *rawTx) envelope(passphrase string, seeds []string) (string, string, error) {
+	encoded, err := tx.encode()
+	if err != nil {
+		return "", "", err
+	}
+
+	hash := tx.hash(encoded, passphrase)
+
+	var buf bytes.Buffer
+	buf.Write(encoded)
+	binary.Write(&buf, binary.BigEndian, uint32(len(seeds)))
+	for _, seed := range seeds {
+		kp, err := keypair.Parse(seed)
+		if err != nil || microstellar.ValidSeed(seed) != nil {
+			return "", "", errors.Errorf("can't sign: bad signer seed")
+		}
+
+		signature, err := kp.Sign(hash[:])
+		if err != nil {
+			return "", "", errors.Wrap(err, "signing error")
+		}
+
+		key, err := strkey.Decode(strkey.VersionByteAccountID, kp.Address())
+		if err != nil {
+			return "", "", errors.Wrap(err, "signing error")
+		}
+
+		buf.Write(key[len(key)-4:]) // the signature hint
+		binary.Write(&buf, binary.BigEndian, uint32(len(signature)))
+		buf.Write(signature)
+	}
+
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), hex.EncodeToString(hash[:]), nil
+}
+
+// newRawTx returns a transaction from account name, with no operations and the
+// transaction flags of cmd, and the seeds that sign it unless --signers (or default
+// signers) or --nosign are set: name's own.
+func (cli *CLI) newRawTx(cmd *cobra.Command, logFields logrus.Fields, name string) (*rawTx, []string, error) {
+	params, err := cli.parseTxParams(cmd, logFields, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	address, err := cli.ResolveAccount(logFields, name, "address")
+	if err != nil {
+		return nil, nil, errors.Errorf("invalid account: %s", name)
+	}
+
+	if microstellar.ValidSeed(address) == nil {
+		address = addressFromSeed(address)
+	}
+
+	var seeds []string
+	if len(params.signers) == 0 && !params.nosign {
+		seed, err := cli.ResolveAccount(logFields, name, "seed")
+		if err != nil || microstellar.ValidSeed(seed) != nil {
+			return nil, nil, errors.Errorf("no seed found in %s", name)
+		}
+
+		seeds = append(seeds, seed)
+	}
+
+	return &rawTx{source: address, params: params}, seeds, nil
+}
+
+// submitRawTx signs tx with the signers in its params, or else seeds, and submits it
+// with the checks microstellar's transactions get (see txOptions.) With --nosubmit,
+// it's shown instead.
+func (cli *CLI) submitRawTx(logFields logrus.Fields, tx *rawTx, seeds []string) error {
+	params := tx.params
+	if params.maxFee > 0 && tx.fee() > params.maxFee {
+		return &feeTooHighError{fee: tx.fee(), maxFee: params.maxFee, ops: len(tx.ops)}
+	}
+
+	if len(params.signers) > 0 {
+		seeds = params.signers
+	}
+
+	if params.nosign {
+		seeds = nil
+	}
+
+	signedTx, hash, err := tx.envelope(cli.networkPassphrase(), seeds)
+	if err != nil {
+		return err
+	}
+
+	if noSubmit, _ := cli.rootCmd.Flags().GetBool("nosubmit"); noSubmit {
+		showSuccess(signedTx)
+		return nil
+	}
+
+	if err := cli.checkNetwork(logFields); err != nil {
+		return err
+	}
+
+	if err := cli.checkFeeAccount(logFields, tx.source, tx.fee()); err != nil {
+		return err
+	}
+
+	debugf(logFields, "submitting transaction %s", hash)
+	cli.submitted = signedTx
+	cli.submittedID = hash
+	_, err = cli.ms.SubmitTransaction(signedTx)
+	return err
+}
@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stellar/go/strkey"
+	"github.com/stellar/go/xdr"
+)
+
+func TestRawTx(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new mo")
+
+	address := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+	memo, _ := xdr.NewMemo(xdr.MemoTypeMemoText, "rent")
+	params := &txParams{memo: memo, timeBounds: &xdr.TimeBounds{MinTime: 1, MaxTime: 2}}
+
+	// The same bump sequence operation, encoded by hand, must match the vendored XDR
+	var bumpTo bytes.Buffer
+	binary.Write(&bumpTo, binary.BigEndian, int64(1000))
+
+	tx := &rawTx{source: address, seq: 42, params: params}
+	tx.addOp(int32(xdr.OperationTypeBumpSequence), bumpTo.Bytes())
+
+	got, hash, err := tx.envelope(testNetworkPassphrase, nil)
+	if err != nil {
+		t.Fatalf("can't encode transaction: %v", err)
+	}
+
+	want, _ := bumpSequenceTx(address, 42, 1000, params)
+	if got != want {
+		t.Errorf("want transaction %s, got %s", want, got)
+	}
+
+	if wantHash, _ := txHash(want, testNetworkPassphrase); hash != wantHash {
+		t.Errorf("want hash %s, got %s", wantHash, hash)
+	}
+
+	// Signed with a hint of the signer's key
+	seed, _ := cli.ResolveAccount(nil, "mo", "seed")
+	signed, _, err := tx.envelope(testNetworkPassphrase, []string{seed})
+	if err != nil {
+		t.Fatalf("can't sign transaction: %v", err)
+	}
+
+	var envelope xdr.TransactionEnvelope
+	if err := xdr.SafeUnmarshalBase64(signed, &envelope); err != nil {
+		t.Fatalf("can't decode signed transaction: %v", err)
+	}
+
+	key, _ := strkey.Decode(strkey.VersionByteAccountID, addressFromSeed(seed))
+	if len(envelope.Signatures) != 1 || !bytes.Equal(envelope.Signatures[0].Hint[:], key[28:]) {
+		t.Errorf("want one signature by mo, got %+v", envelope.Signatures)
+	}
+
+	if _, _, err := tx.envelope(testNetworkPassphrase, []string{address}); err == nil {
+		t.Error("want error for signing with an address")
+	}
+
+	if _, err := (&rawTx{source: address}).encode(); err == nil {
+		t.Error("want error for transaction without operations")
+	}
+
+	tx.source = "nobody"
+	if _, err := tx.encode(); err == nil {
+		t.Error("want error for bad source")
+	}
+}
+
+func TestSubmitRawTx(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new mo")
+
+	cli.Embeddable()
+	tx, seeds, err := cli.newRawTx(cli.rootCmd, nil, "mo")
+	if err != nil || len(seeds) != 1 {
+		t.Fatalf("want transaction signed by mo, got %v: %v", seeds, err)
+	}
+
+	tx.addOp(int32(xdr.OperationTypeBumpSequence), make([]byte, 8))
+	tx.params.maxFee = 99
+	if err := cli.submitRawTx(nil, tx, seeds); txExitCode(err) != ExitBadArgs {
+		t.Errorf("want fee over --max-fee-total refused, got: %v", err)
+	}
+
+	// The vendored XDR can't decode the transactions lumen encodes, so their hash is kept
+	tx.params.maxFee = 100
+	if err := cli.submitRawTx(nil, tx, seeds); err != nil {
+		t.Fatalf("can't submit transaction: %v", err)
+	}
+
+	_, want, _ := tx.envelope(cli.networkPassphrase(), nil)
+	if hash, err := cli.submittedHash(); err != nil || hash != want {
+		t.Errorf("want hash %s of submitted transaction, got %s: %v", want, hash, err)
+	}
+
+	if _, _, err := cli.newRawTx(cli.rootCmd, nil, "nobody"); err == nil {
+		t.Error("want error for unknown account")
+	}
+}
@@ -0,0 +1,141 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func (cli *CLI) buildREPLCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "repl",
+		Short: "run commands interactively, one per line (exit to quit)",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if cli.interactive {
+				cli.error(logrus.Fields{"cmd": "repl"}, "already in the repl")
+				return
+			}
+
+			stat, err := os.Stdin.Stat()
+			prompt := err == nil && (stat.Mode()&os.ModeCharDevice) != 0
+			cli.repl(os.Stdin, prompt)
+		},
+	}
+}
+
+// repl reads commands from in, one per line, and runs them until it sees exit or
+// EOF. The store, namespace, and network settings are kept between commands, and
+// errors don't end the session.
+func (cli *CLI) repl(in io.Reader, prompt bool) {
+	logFields := logrus.Fields{"cmd": "repl"}
+	level := logrus.GetLevel()
+	formatter := logrus.StandardLogger().Formatter
+
+	cli.interactive = true
+	defer func() { cli.interactive = false }()
+
+	// The command tree is in use by this command, so start from a fresh one
+	cli.buildRootCmd()
+
+	scanner := bufio.NewScanner(in)
+	for {
+		if prompt {
+			fmt.Fprintf(os.Stderr, "lumen:%s> ", cli.ns)
+		}
+
+		if !scanner.Scan() {
+			break
+		}
+
+		args, err := splitCommandLine(scanner.Text())
+		if err != nil {
+			showError(logFields, "%v", err)
+			continue
+		}
+
+		if len(args) > 0 && args[0] == "lumen" {
+			args = args[1:]
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		if args[0] == "exit" || args[0] == "quit" {
+			break
+		}
+
+		// Don't let -v or --log-format on one command leak into the next
+		logrus.SetLevel(level)
+		logrus.SetFormatter(formatter)
+
+		// Reload the namespace from --ns or the store, in case the last command changed it
+		cli.ns = ""
+		cli.execute(args)
+	}
+
+	if err := scanner.Err(); err != nil {
+		showError(logFields, "can't read command: %v", err)
+	}
+}
+
+// splitCommandLine splits line into arguments at whitespace, like a shell. Single
+// and double quotes group words, and backslashes escape the next character
+// (except in single quotes.)
+func splitCommandLine(line string) ([]string, error) {
+	var args []string
+	var arg bytes.Buffer
+	inArg := false
+	quote := rune(0)
+	escaped := false
+
+	for _, c := range line {
+		switch {
+		case escaped:
+			arg.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, errors.Errorf("unterminated %c quote", quote)
+	}
+
+	if escaped {
+		return nil, errors.Errorf("trailing backslash")
+	}
+
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args, nil
+}
@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Note: add -v to any of these commands to enable verbose logging
+
+func TestREPL(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("ns test")
+	cli.TestCommand("set config:network fake")
+
+	session := `
+set foo bar
+get foo
+ns other
+lumen get foo
+ns
+get nothing
+set greeting "hello world"
+get greeting
+exit
+get foo
+`
+
+	stdin, err := ioutil.TempFile("", "lumen-repl")
+	if err != nil {
+		t.Fatalf("can't create temp file: %v", err)
+	}
+	defer os.Remove(stdin.Name())
+
+	stdin.WriteString(session)
+	stdin.Seek(0, 0)
+
+	oldStdin := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin = oldStdin }()
+
+	got := strings.Fields(cli.TestCommand("repl"))
+	want := []string{"bar", "error", "other", "error", "hello", "world"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want repl output %q, got %q", want, got)
+	}
+
+	// The namespace change sticks after the repl
+	expectOutput(t, cli, "other", "ns")
+	expectOutput(t, cli, "error", "get foo")
+}
+
+func TestSplitCommandLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{"  pay 10   USD\t--from mo ", []string{"pay", "10", "USD", "--from", "mo"}},
+		{`pay 5 --memotext "here's five bucks"`, []string{"pay", "5", "--memotext", "here's five bucks"}},
+		{`set note 'say "hi"'`, []string{"set", "note", `say "hi"`}},
+		{`set note a\ b ""`, []string{"set", "note", "a b", ""}},
+		{`set note 'a\b'`, []string{"set", "note", `a\b`}},
+	}
+
+	for _, test := range tests {
+		got, err := splitCommandLine(test.line)
+		if err != nil {
+			t.Errorf("(%s) unexpected error: %v", test.line, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("(%s) want %q, got %q", test.line, test.want, got)
+		}
+	}
+
+	for _, line := range []string{`pay 5 --memotext "oops`, `set foo 'bar`, `set foo bar\`} {
+		if _, err := splitCommandLine(line); err == nil {
+			t.Errorf("(%s) want error", line)
+		}
+	}
+}
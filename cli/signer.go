@@ -1,23 +1,25 @@
 package cli
 
 import (
-	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/0xfe/microstellar"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 func (cli *CLI) buildSignerCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "signer [list|add|remove|thresholds|masterweight]",
+		Use:   "signer [list|add|remove|replace|sync|thresholds|masterweight|simulate]",
 		Short: "manage signers on account",
 		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			if len(args) > 0 {
-				cli.error(logrus.Fields{"cmd": "signer"}, "unrecognized signer command: %s, expecting: list|add|remove|thresholds|masterweight", args[0])
+				cli.error(logrus.Fields{"cmd": "signer"}, "unrecognized signer command: %s, expecting: list|add|remove|replace|sync|thresholds|masterweight|simulate", args[0])
 				return
 			}
 		},
@@ -25,9 +27,12 @@ func (cli *CLI) buildSignerCmd() *cobra.Command {
 
 	cmd.AddCommand(cli.buildSignerAddCmd())
 	cmd.AddCommand(cli.buildSignerRemoveCmd())
+	cmd.AddCommand(cli.buildSignerReplaceCmd())
+	cmd.AddCommand(cli.buildSignerSyncCmd())
 	cmd.AddCommand(cli.buildSignerThresholdsCmd())
 	cmd.AddCommand(cli.buildSignerMasterWeightCmd())
 	cmd.AddCommand(cli.buildSignerListCmd())
+	cmd.AddCommand(cli.buildSignerSimulateCmd())
 
 	return cmd
 }
@@ -58,21 +63,27 @@ func (cli *CLI) buildSignerAddCmd() *cobra.Command {
 				return
 			}
 
-			opts, err := cli.genTxOptions(cmd, logFields)
+			intWeight, err := strconv.ParseUint(weight, 10, 32)
 			if err != nil {
-				cli.error(logFields, "can't generate transaction: %v", err)
+				cli.error(logFields, "invalid weight: %s", weight)
 				return
 			}
 
-			intWeight, err := strconv.ParseUint(weight, 10, 32)
+			if !cli.checkLockout(cmd, logFields, to, signee, func(weights map[string]int32, high *uint32) {
+				weights[signer] = int32(intWeight)
+			}) {
+				return
+			}
+
+			opts, err := cli.genTxOptions(cmd, logFields, signee)
 			if err != nil {
-				cli.error(logFields, "invalid weight: %s", weight)
+				cli.error(logFields, "can't generate transaction: %v", err)
 				return
 			}
 
 			err = cli.ms.AddSigner(signee, signer, uint32(intWeight), opts)
 			if err != nil {
-				cli.error(logFields, "failed to add signer %s to %s: %v", signerAddress, to, microstellar.ErrorString(err))
+				cli.errorWithCode(txExitCode(err), logFields, "failed to add signer %s to %s: %v", signerAddress, to, cli.errorString(err))
 				return
 			}
 		},
@@ -80,6 +91,7 @@ func (cli *CLI) buildSignerAddCmd() *cobra.Command {
 
 	cmd.Flags().String("to", "", "account seed of signee")
 	cmd.MarkFlagRequired("to")
+	buildAllowLockoutFlag(cmd)
 
 	buildFlagsForTxOptions(cmd)
 	return cmd
@@ -110,7 +122,13 @@ func (cli *CLI) buildSignerRemoveCmd() *cobra.Command {
 				return
 			}
 
-			opts, err := cli.genTxOptions(cmd, logFields)
+			if !cli.checkLockout(cmd, logFields, from, signee, func(weights map[string]int32, high *uint32) {
+				delete(weights, signer)
+			}) {
+				return
+			}
+
+			opts, err := cli.genTxOptions(cmd, logFields, signee)
 			if err != nil {
 				cli.error(logFields, "can't generate transaction: %v", err)
 				return
@@ -118,7 +136,7 @@ func (cli *CLI) buildSignerRemoveCmd() *cobra.Command {
 
 			err = cli.ms.RemoveSigner(signee, signer, opts)
 			if err != nil {
-				cli.error(logFields, "failed to remove signer %s from %s: %v", signerAddress, from, microstellar.ErrorString(err))
+				cli.errorWithCode(txExitCode(err), logFields, "failed to remove signer %s from %s: %v", signerAddress, from, cli.errorString(err))
 				return
 			}
 		},
@@ -126,6 +144,319 @@ func (cli *CLI) buildSignerRemoveCmd() *cobra.Command {
 
 	cmd.Flags().String("from", "", "account seed of signee")
 	cmd.MarkFlagRequired("from")
+	buildAllowLockoutFlag(cmd)
+
+	buildFlagsForTxOptions(cmd)
+	return cmd
+}
+
+func (cli *CLI) buildSignerReplaceCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "replace [account] --old [signer_address] --new [signer_address] --weight [weight]",
+		Short: "replace a signer on [account] with another, in one transaction",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			name := args[0]
+			logFields := logrus.Fields{"cmd": "signer", "subcmd": "replace"}
+
+			oldName, _ := cmd.Flags().GetString("old")
+			oldSigner, err := cli.ResolveAccount(logFields, oldName, "address")
+			if err != nil {
+				cli.error(logFields, "invalid account: %s", oldName)
+				return
+			}
+
+			newName, _ := cmd.Flags().GetString("new")
+			newSigner, err := cli.ResolveAccount(logFields, newName, "address")
+			if err != nil {
+				cli.error(logFields, "invalid account: %s", newName)
+				return
+			}
+
+			if oldSigner == newSigner {
+				cli.error(logFields, "--old and --new are the same signer: %s", oldSigner)
+				return
+			}
+
+			// A weight of 0 would remove the new signer too
+			weight, _ := cmd.Flags().GetString("weight")
+			intWeight, err := strconv.ParseUint(weight, 10, 8)
+			if err != nil || intWeight == 0 {
+				cli.error(logFields, "invalid weight: %s, expecting 1-255", weight)
+				return
+			}
+
+			if batch, _ := cmd.Flags().GetBool("batch"); batch {
+				cli.error(logFields, "signer replace is already one transaction, it can't be batched")
+				return
+			}
+
+			signee, err := cli.ResolveAccount(logFields, name, "seed")
+			if err != nil || microstellar.ValidSeed(signee) != nil {
+				cli.error(logFields, "no seed found in %s", name)
+				return
+			}
+
+			// There are no signers to check on the fake network
+			if cli.horizonURL() != "" {
+				account, err := cli.ms.LoadAccount(addressFromSeed(signee))
+				if err != nil {
+					cli.errorWithCode(ExitNetworkError, logFields, "can't load account %s: %v", name, cli.errorString(err))
+					return
+				}
+
+				if !hasSigner(account, oldSigner) {
+					cli.error(logFields, "%s is not a signer on %s", oldName, name)
+					return
+				}
+			}
+
+			if !cli.checkLockout(cmd, logFields, name, signee, func(weights map[string]int32, high *uint32) {
+				delete(weights, oldSigner)
+				weights[newSigner] = int32(intWeight)
+			}) {
+				return
+			}
+
+			opts, err := cli.genTxOptions(cmd, logFields, signee)
+			if err != nil {
+				cli.error(logFields, "can't generate transaction: %v", err)
+				return
+			}
+
+			// Without --signers, the account signs for itself
+			if signers, _ := cmd.Flags().GetStringSlice("signers"); len(signers) == 0 {
+				opts = opts.WithSigner(signee)
+			}
+
+			cli.ms.Start(addressFromSeed(signee), opts)
+
+			if err := cli.ms.RemoveSigner(signee, oldSigner); err != nil {
+				cli.error(logFields, "can't add removal of %s: %v", oldName, cli.errorString(err))
+				return
+			}
+
+			if err := cli.ms.AddSigner(signee, newSigner, uint32(intWeight)); err != nil {
+				cli.error(logFields, "can't add signer %s: %v", newName, cli.errorString(err))
+				return
+			}
+
+			if err := cli.ms.Submit(); err != nil {
+				cli.errorWithCode(txExitCode(err), logFields, "failed to replace signer %s with %s on %s: %v", oldName, newName, name, cli.errorString(err))
+				return
+			}
+		},
+	}
+
+	cmd.Flags().String("old", "", "the signer to remove")
+	cmd.Flags().String("new", "", "the signer to add in its place")
+	cmd.Flags().String("weight", "", "key weight of the new signer")
+	cmd.MarkFlagRequired("old")
+	cmd.MarkFlagRequired("new")
+	cmd.MarkFlagRequired("weight")
+	buildAllowLockoutFlag(cmd)
+
+	buildFlagsForTxOptions(cmd)
+	return cmd
+}
+
+// hasSigner returns true if address is a signer on account (with any weight.)
+func hasSigner(account *microstellar.Account, address string) bool {
+	for _, signer := range account.Signers {
+		if signer.Key == address || signer.PublicKey == address {
+			return true
+		}
+	}
+
+	return false
+}
+
+// signerChange is a change to one of an account's signers. Old is 0 for signers to
+// add, and New is 0 for signers to remove.
+type signerChange struct {
+	Key string
+	Old int32
+	New int32
+}
+
+// String describes the change, e.g., "change GABC... weight 1 -> 2".
+func (change signerChange) String() string {
+	switch {
+	case change.Old == 0:
+		return fmt.Sprintf("add %s weight %d", change.Key, change.New)
+	case change.New == 0:
+		return fmt.Sprintf("remove %s weight %d", change.Key, change.Old)
+	}
+
+	return fmt.Sprintf("change %s weight %d -> %d", change.Key, change.Old, change.New)
+}
+
+// planSignerSync returns the changes that make the current signer weights (keyed by
+// address) match wanted: the removal of signers that wanted doesn't list (sorted, and
+// only if prune is set), then additions and weight changes in wanted's order. Signers
+// that aren't removed without prune are returned as extras. The master key is left
+// alone unless wanted lists it, and signers that aren't keys (e.g., pre-authorized
+// transactions) are always left alone.
+func planSignerSync(current map[string]int32, master string, wanted []tomlSigner, prune bool) ([]signerChange, []signerChange) {
+	listed := map[string]bool{}
+	for _, signer := range wanted {
+		listed[signer.Key] = true
+	}
+
+	var keys []string
+	for key, weight := range current {
+		if weight > 0 && !listed[key] && key != master && microstellar.ValidAddress(key) == nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var changes, extras []signerChange
+	for _, key := range keys {
+		change := signerChange{Key: key, Old: current[key]}
+		if prune {
+			changes = append(changes, change)
+		} else {
+			extras = append(extras, change)
+		}
+	}
+
+	for _, signer := range wanted {
+		if old := current[signer.Key]; old != int32(signer.Weight) {
+			changes = append(changes, signerChange{Key: signer.Key, Old: old, New: int32(signer.Weight)})
+		}
+	}
+
+	return changes, extras
+}
+
+func (cli *CLI) buildSignerSyncCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sync [account] --from-toml [domain] [--prune]",
+		Short: "add (or reweight) the signers that [domain]'s stellar.toml declares for [account], and remove the others with --prune",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			name := args[0]
+			logFields := logrus.Fields{"cmd": "signer", "subcmd": "sync"}
+
+			if batch, _ := cmd.Flags().GetBool("batch"); batch {
+				cli.error(logFields, "signer sync is already one transaction, it can't be batched")
+				return
+			}
+
+			signee, err := cli.ResolveAccount(logFields, name, "seed")
+			if err != nil || microstellar.ValidSeed(signee) != nil {
+				cli.error(logFields, "no seed found in %s", name)
+				return
+			}
+
+			address := addressFromSeed(signee)
+			domain, _ := cmd.Flags().GetString("from-toml")
+			st, err := cli.fetchStellarToml(logFields, domain)
+			if err != nil {
+				cli.errorWithCode(ExitNetworkError, logFields, "%v", err)
+				return
+			}
+
+			wanted, err := st.signersFor(address)
+			if err != nil {
+				cli.error(logFields, "bad SIGNERS in stellar.toml from %s: %v", domain, err)
+				return
+			}
+
+			if len(wanted) == 0 {
+				cli.error(logFields, "stellar.toml from %s declares no SIGNERS for %s", domain, address)
+				return
+			}
+
+			// There are no signers on the fake network, so they're all added
+			current := map[string]int32{}
+			if cli.horizonURL() != "" {
+				account, err := cli.ms.LoadAccount(address)
+				if err != nil {
+					cli.errorWithCode(ExitNetworkError, logFields, "can't load account %s: %v", name, cli.errorString(err))
+					return
+				}
+
+				for _, signer := range account.Signers {
+					key := signer.Key
+					if key == "" {
+						key = signer.PublicKey
+					}
+
+					current[key] = signer.Weight
+				}
+			}
+
+			prune, _ := cmd.Flags().GetBool("prune")
+			changes, extras := planSignerSync(current, address, wanted, prune)
+
+			for _, change := range changes {
+				showSuccess(change.String())
+			}
+
+			for _, extra := range extras {
+				showSuccess("extra %s weight %d, use --prune to remove", extra.Key, extra.Old)
+			}
+
+			if len(changes) == 0 {
+				showSuccess("signers of %s match %s", name, domain)
+				return
+			}
+
+			if !cli.checkLockout(cmd, logFields, name, signee, func(weights map[string]int32, high *uint32) {
+				for _, change := range changes {
+					weights[change.Key] = change.New
+				}
+			}) {
+				return
+			}
+
+			if !cli.confirm("make these %d signer changes to %s", len(changes), name) {
+				cli.error(logFields, "not syncing signers of %s, use --yes to apply", name)
+				return
+			}
+
+			opts, err := cli.genTxOptions(cmd, logFields, address)
+			if err != nil {
+				cli.error(logFields, "can't generate transaction: %v", err)
+				return
+			}
+
+			// Without --signers, the account signs for itself
+			if signers, _ := cmd.Flags().GetStringSlice("signers"); len(signers) == 0 {
+				opts = opts.WithSigner(signee)
+			}
+
+			cli.ms.Start(address, opts)
+
+			for _, change := range changes {
+				switch {
+				case change.Key == address:
+					err = cli.ms.SetMasterWeight(signee, uint32(change.New))
+				case change.New == 0:
+					err = cli.ms.RemoveSigner(signee, change.Key)
+				default:
+					err = cli.ms.AddSigner(signee, change.Key, uint32(change.New))
+				}
+
+				if err != nil {
+					cli.error(logFields, "can't add %s: %v", change, cli.errorString(err))
+					return
+				}
+			}
+
+			if err := cli.ms.Submit(); err != nil {
+				cli.errorWithCode(txExitCode(err), logFields, "failed to sync signers of %s: %v", name, cli.errorString(err))
+				return
+			}
+		},
+	}
+
+	cmd.Flags().String("from-toml", "", "the domain whose stellar.toml declares the signers")
+	cmd.Flags().Bool("prune", false, "also remove the signers that the stellar.toml doesn't declare")
+	cmd.MarkFlagRequired("from-toml")
+	buildAllowLockoutFlag(cmd)
 
 	buildFlagsForTxOptions(cmd)
 	return cmd
@@ -133,16 +464,39 @@ func (cli *CLI) buildSignerRemoveCmd() *cobra.Command {
 
 func (cli *CLI) buildSignerThresholdsCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "thresholds [account] [low] [medium] [high]",
-		Short: "set low, medium, and high thresholds for [account]",
-		Args:  cobra.ExactArgs(4),
+		Use:   "thresholds [account] [low] [medium] [high] [--show]",
+		Short: "get or set low, medium, and high thresholds for [account]",
+		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			account := args[0]
+			logFields := logrus.Fields{"cmd": "signer", "subcmd": "thresholds"}
+
+			show, _ := cmd.Flags().GetBool("show")
+			if show || len(args) == 1 {
+				if len(args) > 1 {
+					cli.error(logFields, "--show doesn't take thresholds")
+					return
+				}
+
+				info := cli.LoadAccount(logFields, account)
+				if info == nil {
+					return
+				}
+
+				showSuccess("low:%d medium:%d high:%d masterweight:%d",
+					info.Thresholds.Low, info.Thresholds.Medium, info.Thresholds.High, info.GetMasterWeight())
+				return
+			}
+
+			if len(args) != 4 {
+				cli.error(logFields, "need all three thresholds: [low] [medium] [high]")
+				return
+			}
+
 			lowString := args[1]
 			mediumString := args[2]
 			highString := args[3]
 
-			logFields := logrus.Fields{"cmd": "signer", "subcmd": "thresholds"}
 			address, err := cli.ResolveAccount(logFields, account, "seed")
 
 			if err != nil {
@@ -171,7 +525,13 @@ func (cli *CLI) buildSignerThresholdsCmd() *cobra.Command {
 				return
 			}
 
-			opts, err := cli.genTxOptions(cmd, logFields)
+			if !cli.checkLockout(cmd, logFields, account, address, func(weights map[string]int32, newHigh *uint32) {
+				*newHigh = uint32(high)
+			}) {
+				return
+			}
+
+			opts, err := cli.genTxOptions(cmd, logFields, address)
 			if err != nil {
 				cli.error(logFields, "can't generate transaction: %v", err)
 				return
@@ -179,12 +539,14 @@ func (cli *CLI) buildSignerThresholdsCmd() *cobra.Command {
 
 			err = cli.ms.SetThresholds(address, uint32(low), uint32(medium), uint32(high), opts)
 			if err != nil {
-				cli.error(logFields, "failed to set thresholds for %s: %v", account, microstellar.ErrorString(err))
+				cli.errorWithCode(txExitCode(err), logFields, "failed to set thresholds for %s: %v", account, cli.errorString(err))
 				return
 			}
 		},
 	}
 
+	cmd.Flags().Bool("show", false, "show the current thresholds and master weight, without changing them")
+	buildAllowLockoutFlag(cmd)
 	buildFlagsForTxOptions(cmd)
 	return cmd
 }
@@ -214,7 +576,18 @@ func (cli *CLI) buildSignerMasterWeightCmd() *cobra.Command {
 					return
 				}
 
-				opts, err := cli.genTxOptions(cmd, logFields)
+				if weight == 0 && !cli.confirm("set the master key weight of %s to 0, so its master key can no longer sign", account) {
+					cli.error(logFields, "not setting master weight of %s, use --yes to confirm", account)
+					return
+				}
+
+				if !cli.checkLockout(cmd, logFields, account, source, func(weights map[string]int32, high *uint32) {
+					weights[addressFromSeed(source)] = int32(weight)
+				}) {
+					return
+				}
+
+				opts, err := cli.genTxOptions(cmd, logFields, source)
 				if err != nil {
 					cli.error(logFields, "can't generate transaction: %v", err)
 					return
@@ -222,7 +595,7 @@ func (cli *CLI) buildSignerMasterWeightCmd() *cobra.Command {
 
 				err = cli.ms.SetMasterWeight(source, uint32(weight), opts)
 				if err != nil {
-					cli.error(logFields, "failed to set master weight of %s to %s: %v", account, weightString, microstellar.ErrorString(err))
+					cli.errorWithCode(txExitCode(err), logFields, "failed to set master weight of %s to %s: %v", account, weightString, cli.errorString(err))
 					return
 				}
 			} else {
@@ -236,10 +609,64 @@ func (cli *CLI) buildSignerMasterWeightCmd() *cobra.Command {
 		},
 	}
 
+	buildAllowLockoutFlag(cmd)
 	buildFlagsForTxOptions(cmd)
 	return cmd
 }
 
+func buildAllowLockoutFlag(cmd *cobra.Command) {
+	cmd.Flags().Bool("allow-lockout", false, "make the change even if no set of signers could meet the high threshold afterwards")
+}
+
+// controllable returns true if the signers (by weight) can meet the high threshold,
+// i.e., someone can still sign for the account. Keys with no weight can't sign at all.
+func controllable(weights map[string]int32, high uint32) bool {
+	var total int64
+	for _, weight := range weights {
+		if weight > 0 {
+			total += int64(weight)
+		}
+	}
+
+	return total > 0 && total >= int64(high)
+}
+
+// checkLockout applies change to the current signer weights (keyed by address) and
+// high threshold of the account with seed, and returns true if the account would
+// still be controllable afterwards (or --allow-lockout is set.) There are no signers
+// to check on the fake network.
+func (cli *CLI) checkLockout(cmd *cobra.Command, logFields logrus.Fields, name, seed string, change func(weights map[string]int32, high *uint32)) bool {
+	if allow, _ := cmd.Flags().GetBool("allow-lockout"); allow || cli.horizonURL() == "" {
+		return true
+	}
+
+	account, err := cli.ms.LoadAccount(addressFromSeed(seed))
+	if err != nil {
+		cli.errorWithCode(ExitNetworkError, logFields, "can't load account %s: %v", name, cli.errorString(err))
+		return false
+	}
+
+	weights := map[string]int32{}
+	for _, signer := range account.Signers {
+		key := signer.Key
+		if key == "" {
+			key = signer.PublicKey
+		}
+
+		weights[key] = signer.Weight
+	}
+
+	high := uint32(account.Thresholds.High)
+	change(weights, &high)
+
+	if !controllable(weights, high) {
+		cli.error(logFields, "this would lock %s out: no set of signers could meet the high threshold (%d), use --allow-lockout to do it anyway", name, high)
+		return false
+	}
+
+	return true
+}
+
 func (cli *CLI) buildSignerListCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "list [account]",
@@ -256,7 +683,8 @@ func (cli *CLI) buildSignerListCmd() *cobra.Command {
 			format, _ := cmd.Flags().GetString("format")
 
 			if format == "json" {
-				jsonSigners, err := json.MarshalIndent(account.Signers, "", "  ")
+				pretty, _ := cmd.Flags().GetBool("pretty")
+				jsonSigners, err := marshalJSON(account.Signers, pretty)
 				if err != nil {
 					cli.error(logFields, "can't marshall signers: %v", err)
 					return
@@ -272,5 +700,204 @@ func (cli *CLI) buildSignerListCmd() *cobra.Command {
 	}
 
 	cmd.Flags().String("format", "", "output format (json,line)")
+	buildPrettyFlag(cmd)
+	return cmd
+}
+
+// opThresholds is the threshold (low, medium, or high) that each operation needs. It
+// should match thresholdOps.
+var opThresholds = map[string]string{
+	"allow_trust":                      "low",
+	"set_trust_line_flags":             "low",
+	"bump_sequence":                    "low",
+	"claim_claimable_balance":          "low",
+	"create_account":                   "medium",
+	"payment":                          "medium",
+	"path_payment_strict_receive":      "medium",
+	"path_payment_strict_send":         "medium",
+	"manage_sell_offer":                "medium",
+	"manage_buy_offer":                 "medium",
+	"create_passive_sell_offer":        "medium",
+	"change_trust":                     "medium",
+	"manage_data":                      "medium",
+	"create_claimable_balance":         "medium",
+	"liquidity_pool_deposit":           "medium",
+	"liquidity_pool_withdraw":          "medium",
+	"account_merge":                    "high",
+	"set_options":                      "high",
+	"begin_sponsoring_future_reserves": "medium",
+	"end_sponsoring_future_reserves":   "medium",
+	"revoke_sponsorship":               "medium",
+	"clawback":                         "medium",
+	"clawback_claimable_balance":       "medium",
+}
+
+// parseThresholds parses low,medium,high thresholds (e.g., 1,2,3.)
+func parseThresholds(spec string) (microstellar.Thresholds, error) {
+	parts := strings.Split(spec, ",")
+	if len(parts) != 3 {
+		return microstellar.Thresholds{}, errors.Errorf("bad --thresholds: expecting low,medium,high, got: %s", spec)
+	}
+
+	var levels [3]byte
+	for i, part := range parts {
+		val, err := strconv.ParseUint(strings.TrimSpace(part), 10, 8)
+		if err != nil {
+			return microstellar.Thresholds{}, errors.Errorf("bad --thresholds: expecting numbers from 0 to 255, got: %s", spec)
+		}
+
+		levels[i] = byte(val)
+	}
+
+	return microstellar.Thresholds{Low: levels[0], Medium: levels[1], High: levels[2]}, nil
+}
+
+// signedWeight returns the total weight of signers (addresses) in weights, and true
+// if it meets threshold. Like controllable, keys with no weight can't sign at all.
+func signedWeight(weights map[string]int32, signers []string, threshold byte) (int64, bool) {
+	var total int64
+	for _, signer := range signers {
+		if weight := weights[signer]; weight > 0 {
+			total += int64(weight)
+		}
+	}
+
+	return total, total > 0 && total >= int64(threshold)
+}
+
+func (cli *CLI) buildSignerSimulateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "simulate [account] --signers a,b --op payment [--weights a=1,b=2] [--thresholds low,medium,high]",
+		Short: "check if --signers could sign an --op operation for [account], with its current (or hypothetical) weights and thresholds",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			name := args[0]
+			logFields := logrus.Fields{"cmd": "signer", "subcmd": "simulate"}
+
+			op, _ := cmd.Flags().GetString("op")
+			level, ok := opThresholds[op]
+			if !ok {
+				var ops []string
+				for op := range opThresholds {
+					ops = append(ops, op)
+				}
+
+				sort.Strings(ops)
+				cli.error(logFields, "bad --op: %s, expecting one of: %s", op, strings.Join(ops, ", "))
+				return
+			}
+
+			signerNames, _ := cmd.Flags().GetStringSlice("signers")
+			if len(signerNames) == 0 {
+				cli.error(logFields, "need at least one account in --signers")
+				return
+			}
+
+			// Start with the current weights and thresholds, then apply the hypothetical ones
+			account := cli.LoadAccount(logFields, name)
+			if account == nil {
+				return
+			}
+
+			weights := map[string]int32{}
+			for _, signer := range account.Signers {
+				key := signer.Key
+				if key == "" {
+					key = signer.PublicKey
+				}
+
+				weights[key] = signer.Weight
+			}
+
+			resolve := func(signer string) (string, bool) {
+				address, err := cli.ResolveAccount(logFields, signer, "address")
+				if err != nil {
+					cli.error(logFields, "invalid signer: %s", signer)
+					return "", false
+				}
+
+				if microstellar.ValidSeed(address) == nil {
+					address = addressFromSeed(address)
+				}
+
+				return address, true
+			}
+
+			hypothetical, _ := cmd.Flags().GetStringSlice("weights")
+			for _, spec := range hypothetical {
+				parts := strings.SplitN(spec, "=", 2)
+				if len(parts) != 2 {
+					cli.error(logFields, "bad --weights: expecting signer=weight, got: %s", spec)
+					return
+				}
+
+				weight, err := strconv.ParseUint(parts[1], 10, 8)
+				if err != nil {
+					cli.error(logFields, "bad --weights: expecting a weight from 0 to 255 for %s, got: %s", parts[0], parts[1])
+					return
+				}
+
+				address, ok := resolve(parts[0])
+				if !ok {
+					return
+				}
+
+				weights[address] = int32(weight)
+			}
+
+			thresholds := account.Thresholds
+			if spec, _ := cmd.Flags().GetString("thresholds"); spec != "" {
+				var err error
+				if thresholds, err = parseThresholds(spec); err != nil {
+					cli.error(logFields, "%v", err)
+					return
+				}
+			}
+
+			addresses := make([]string, len(signerNames))
+			for i, signer := range signerNames {
+				if addresses[i], ok = resolve(signer); !ok {
+					return
+				}
+			}
+
+			threshold := map[string]byte{"low": thresholds.Low, "medium": thresholds.Medium, "high": thresholds.High}[level]
+			showSuccess("%s needs the %s threshold: %d", op, level, threshold)
+
+			// Each key only counts once, however many names it has
+			var signers []string
+			seen := map[string]bool{}
+
+			for i, signer := range signerNames {
+				address := addresses[i]
+				if seen[address] {
+					showSuccess("%s: counted already", signer)
+					continue
+				}
+
+				seen[address] = true
+				signers = append(signers, address)
+
+				if weight, ok := weights[address]; ok {
+					showSuccess("%s: %d", signer, weight)
+				} else {
+					showSuccess("%s: 0 (not a signer)", signer)
+				}
+			}
+
+			total, authorized := signedWeight(weights, signers, threshold)
+			if authorized {
+				showSuccess("total: %d, authorized", total)
+			} else {
+				showSuccess("total: %d, not authorized", total)
+			}
+		},
+	}
+
+	cmd.Flags().StringSlice("signers", []string{}, "the accounts (or addresses) that would sign, comma separated")
+	cmd.Flags().String("op", "", "the operation type, e.g., payment, change_trust, or account_merge (set_options is assumed to change signers or thresholds)")
+	cmd.Flags().StringSlice("weights", []string{}, "hypothetical signer weights (signer=weight, comma separated) to use instead of the current ones")
+	cmd.Flags().String("thresholds", "", "hypothetical low,medium,high thresholds to use instead of the current ones")
+	cmd.MarkFlagRequired("op")
 	return cmd
 }
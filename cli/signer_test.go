@@ -1,6 +1,13 @@
 package cli
 
-import "testing"
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
 
 // Note: add -v to any of these commands to enable verbose logging
 
@@ -26,6 +33,177 @@ func TestSigners(t *testing.T) {
 
 	expectOutput(t, cli, "error", "signer masterweight mo 400")
 	expectOutput(t, cli, "", "signer masterweight master 400")
+	expectOutput(t, cli, "error", "signer masterweight master 0")
+	expectOutput(t, cli, "", "signer masterweight master 0 --yes")
+	expectOutput(t, cli, "", "signer masterweight master 0 --yes --allow-lockout")
+
+	cli.TestCommand("account set signer3 GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+	expectOutput(t, cli, "", "signer replace worker --old signer2 --new signer3 --weight 2")
+	expectOutput(t, cli, "", "signer replace worker --old signer3 --new signer2 --weight 2 --signers signer1")
+	expectOutput(t, cli, "error", "signer replace worker --old signer1 --new signer1 --weight 2")
+	expectOutput(t, cli, "error", "signer replace worker --old signer1 --new nobody --weight 2")
+	expectOutput(t, cli, "error", "signer replace worker --old signer2 --new signer3 --weight 0")
+	expectOutput(t, cli, "error", "signer replace worker --old signer2 --new signer3 --weight 256")
+	expectOutput(t, cli, "error", "signer replace worker --old signer2 --new signer3 --weight 1 --batch")
 
 	expectOutput(t, cli, "address: weight:0", "signer list master")
+
+	// Read-only, so view-only accounts work too
+	cli.TestCommand("account set viewer GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U")
+	expectOutput(t, cli, "low:0 medium:0 high:0 masterweight:0", "signer thresholds viewer")
+	expectOutput(t, cli, "low:0 medium:0 high:0 masterweight:0", "signer thresholds viewer --show")
+	expectOutput(t, cli, "error", "signer thresholds viewer 1 1 1 --show")
+	expectOutput(t, cli, "error", "signer thresholds master 1 1")
+	expectOutput(t, cli, "", "signer thresholds master 1 1 1")
+}
+
+func TestControllable(t *testing.T) {
+	tests := []struct {
+		weights map[string]int32
+		high    uint32
+		want    bool
+	}{
+		{map[string]int32{"master": 1}, 0, true},
+		{map[string]int32{"master": 0}, 0, false},
+		{map[string]int32{}, 0, false},
+		{map[string]int32{"master": 0, "bob": 1, "mary": 1}, 2, true},
+		{map[string]int32{"master": 0, "bob": 1, "mary": 1}, 3, false},
+		{map[string]int32{"master": 255, "bob": 255}, 255, true},
+	}
+
+	for i, test := range tests {
+		if got := controllable(test.weights, test.high); got != test.want {
+			t.Errorf("%d: controllable(%v, %d): want %v, got %v", i, test.weights, test.high, test.want, got)
+		}
+	}
+}
+
+func TestSignerSimulate(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account new vault")
+	cli.TestCommand("account set alice GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+	cli.TestCommand("account set bob GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U")
+
+	// No signers or thresholds on the fake network, so everything is hypothetical
+	flags := " --weights alice=1,bob=2 --thresholds 1,2,3"
+
+	expectOutput(t, cli, "payment needs the medium threshold: 2\nalice: 1\nbob: 2\ntotal: 3, authorized",
+		"signer simulate vault --signers alice,bob --op payment"+flags)
+	expectOutput(t, cli, "account_merge needs the high threshold: 3\nalice: 1\ntotal: 1, not authorized",
+		"signer simulate vault --signers alice --op account_merge"+flags)
+	expectOutput(t, cli, "bump_sequence needs the low threshold: 1\nalice: 1\nalice: counted already\ntotal: 1, authorized",
+		"signer simulate vault --signers alice,alice --op bump_sequence"+flags)
+	expectOutput(t, cli, "payment needs the medium threshold: 0\nalice: 0 (not a signer)\ntotal: 0, not authorized",
+		"signer simulate vault --signers alice --op payment")
+
+	expectOutput(t, cli, "error", "signer simulate vault --signers alice --op teleport"+flags)
+	expectOutput(t, cli, "error", "signer simulate vault --op payment"+flags)
+	expectOutput(t, cli, "error", "signer simulate vault --signers nobody --op payment"+flags)
+	expectOutput(t, cli, "error", "signer simulate vault --signers alice --op payment --weights alice")
+	expectOutput(t, cli, "error", "signer simulate vault --signers alice --op payment --weights alice=256")
+	expectOutput(t, cli, "error", "signer simulate vault --signers alice --op payment --thresholds 1,2")
+	expectOutput(t, cli, "error", "signer simulate nobody --signers alice --op payment")
+}
+
+func TestPlanSignerSync(t *testing.T) {
+	master := "GCZ4ROI3LS7WWIAGNWHMCR6TOODOWBR4TKVB4U5IQDQKAD4LPCG4DCLJ"
+	alice := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+	bob := "GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U"
+	preauth := "TBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+
+	current := map[string]int32{master: 1, alice: 1, bob: 2, preauth: 1}
+
+	// Reweight alice, keep bob as an extra, and leave the master key and preauth alone
+	changes, extras := planSignerSync(current, master, []tomlSigner{{Key: alice, Weight: 2}}, false)
+	want := []signerChange{{Key: alice, Old: 1, New: 2}}
+	if !reflect.DeepEqual(changes, want) || !reflect.DeepEqual(extras, []signerChange{{Key: bob, Old: 2}}) {
+		t.Errorf("want changes %v and extra bob, got %v and %v", want, changes, extras)
+	}
+
+	// Remove bob first, then change the master key
+	changes, extras = planSignerSync(current, master, []tomlSigner{{Key: master, Weight: 3}, {Key: alice, Weight: 1}}, true)
+	want = []signerChange{{Key: bob, Old: 2}, {Key: master, Old: 1, New: 3}}
+	if !reflect.DeepEqual(changes, want) || len(extras) != 0 {
+		t.Errorf("want changes %v and no extras, got %v and %v", want, changes, extras)
+	}
+
+	// Nothing to do
+	changes, extras = planSignerSync(current, master, []tomlSigner{{Key: alice, Weight: 1}, {Key: bob, Weight: 2}}, true)
+	if len(changes) != 0 || len(extras) != 0 {
+		t.Errorf("want no changes, got %v and %v", changes, extras)
+	}
+
+	wantStrings := map[signerChange]string{
+		{Key: "A", New: 2}:         "add A weight 2",
+		{Key: "A", Old: 1}:         "remove A weight 1",
+		{Key: "A", Old: 1, New: 2}: "change A weight 1 -> 2",
+	}
+
+	for change, want := range wantStrings {
+		if got := change.String(); got != want {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	}
+}
+
+func TestSignerSync(t *testing.T) {
+	alice := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+	bob := "GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U"
+
+	signers := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, signers)
+	}))
+	defer server.Close()
+
+	stellarTomlScheme = "http"
+	defer func() { stellarTomlScheme = "https" }()
+	domain := strings.TrimPrefix(server.URL, "http://")
+
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new vault")
+	address := addressFromSeed(strings.TrimSpace(cli.TestCommand("account seed vault")))
+
+	signers = fmt.Sprintf(`
+[[SIGNERS]]
+key = "%s"
+
+[[SIGNERS]]
+key = "%s"
+weight = 2
+
+[[SIGNERS]]
+account = "GCZ4ROI3LS7WWIAGNWHMCR6TOODOWBR4TKVB4U5IQDQKAD4LPCG4DCLJ"
+key = "%s"
+weight = 5
+`, alice, bob, alice)
+
+	// No signers on the fake network, so they're all added
+	plan := fmt.Sprintf("add %s weight 1\nadd %s weight 2", alice, bob)
+	expectOutput(t, cli, plan+"\nerror", "signer sync vault --from-toml "+domain)
+	expectOutput(t, cli, plan, "signer sync vault --from-toml "+domain+" --yes")
+	expectOutput(t, cli, "error", "signer sync vault --from-toml "+domain+" --yes --batch")
+	expectOutput(t, cli, "error", "signer sync nobody --from-toml "+domain+" --yes")
+	expectOutput(t, cli, "error", "signer sync vault --from-toml "+domain+"/elsewhere --yes")
+
+	bad := map[string]string{
+		"no signers":    ``,
+		"bad key":       `[[SIGNERS]]` + "\n" + `key = "nobody"`,
+		"bad weight":    fmt.Sprintf("[[SIGNERS]]\nkey = \"%s\"\nweight = 256", alice),
+		"listed twice":  fmt.Sprintf("[[SIGNERS]]\nkey = \"%s\"\n[[SIGNERS]]\nkey = \"%s\"", alice, alice),
+		"other account": fmt.Sprintf("[[SIGNERS]]\naccount = \"%s\"\nkey = \"%s\"", bob, alice),
+	}
+
+	for name, toml := range bad {
+		signers = toml
+		if got := cli.TestCommand("signer sync vault --from-toml " + domain + " --yes"); got != "error\n" {
+			t.Errorf("%s: want error, got %q", name, got)
+		}
+	}
+
+	signers = fmt.Sprintf("[[SIGNERS]]\naccount = \"%s\"\nkey = \"%s\"\nweight = 3", address, alice)
+	expectOutput(t, cli, fmt.Sprintf("add %s weight 3", alice), "signer sync vault --from-toml "+domain+" --yes")
 }
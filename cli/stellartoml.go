@@ -0,0 +1,239 @@
+package cli
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/0xfe/microstellar"
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// stellarTomlMaxSize is the largest stellar.toml that lumen reads (SEP-1 allows 100KB.)
+const stellarTomlMaxSize = 100 * 1024
+
+// stellarTomlScheme is the scheme used to fetch stellar.toml files. Tests use http.
+var stellarTomlScheme = "https"
+
+// stellarToml is the part of a domain's stellar.toml (SEP-1) that lumen uses.
+type stellarToml struct {
+	Accounts   []string `toml:"ACCOUNTS"`
+	SigningKey string   `toml:"SIGNING_KEY"`
+	Currencies []struct {
+		Code   string `toml:"code"`
+		Issuer string `toml:"issuer"`
+	} `toml:"CURRENCIES"`
+
+	// SIGNERS isn't part of SEP-1, it's where organizations can publish the signers of
+	// their accounts for signer sync.
+	Signers []tomlSigner `toml:"SIGNERS"`
+}
+
+// tomlSigner is a signer declared in a stellar.toml (see signer sync.) Signers without
+// an account are signers of all the domain's accounts.
+type tomlSigner struct {
+	Account string `toml:"account"`
+	Key     string `toml:"key"`
+	Weight  int64  `toml:"weight"`
+}
+
+// fetchStellarToml fetches and parses the stellar.toml file of domain. Like all
+// requests to horizon, this fails in offline mode.
+func (cli *CLI) fetchStellarToml(logFields logrus.Fields, domain string) (*stellarToml, error) {
+	if domain == "" || strings.ContainsAny(domain, "/?#@") {
+		return nil, errors.Errorf("bad domain: %s", domain)
+	}
+
+	url := stellarTomlScheme + "://" + domain + "/.well-known/stellar.toml"
+	debugf(logFields, "GET %s", url)
+
+	resp, err := cli.horizonClient().Get(url)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't fetch stellar.toml from %s", domain)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("can't fetch stellar.toml from %s: %s", domain, resp.Status)
+	}
+
+	var parsed stellarToml
+	if _, err := toml.DecodeReader(io.LimitReader(resp.Body, stellarTomlMaxSize), &parsed); err != nil {
+		return nil, errors.Wrapf(err, "bad stellar.toml from %s", domain)
+	}
+
+	return &parsed, nil
+}
+
+// currencyIssuer returns the issuer of the currency with code listed in the
+// stellar.toml. It's an error if the currency isn't listed, is listed with more than
+// one issuer, or the issuer isn't a valid address.
+func (st *stellarToml) currencyIssuer(code string) (string, error) {
+	issuer := ""
+
+	for _, currency := range st.Currencies {
+		if currency.Code != code {
+			continue
+		}
+
+		if microstellar.ValidAddress(currency.Issuer) != nil {
+			return "", errors.Errorf("bad issuer for %s: %s", code, currency.Issuer)
+		}
+
+		if issuer != "" && issuer != currency.Issuer {
+			return "", errors.Errorf("%s is listed with more than one issuer", code)
+		}
+
+		issuer = currency.Issuer
+	}
+
+	if issuer == "" {
+		return "", errors.Errorf("%s is not listed", code)
+	}
+
+	return issuer, nil
+}
+
+// resolveDomainAsset returns the asset with code issued by the account that
+// domain's stellar.toml lists for it.
+func (cli *CLI) resolveDomainAsset(logFields logrus.Fields, domain, code string) (*microstellar.Asset, error) {
+	assetType := defaultAssetType(code)
+	if err := validateAssetCode(code, assetType); err != nil {
+		return nil, err
+	}
+
+	st, err := cli.fetchStellarToml(logFields, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer, err := st.currencyIssuer(code)
+	if err != nil {
+		return nil, errors.Wrapf(err, "in stellar.toml from %s", domain)
+	}
+
+	debugf(logFields, "%s on %s is issued by %s", code, domain, issuer)
+	return microstellar.NewAsset(code, issuer, microstellar.AssetType(assetType)), nil
+}
+
+// listings returns where the stellar.toml lists address: in ACCOUNTS, as the
+// SIGNING_KEY, or as the issuer of CURRENCIES.
+func (st *stellarToml) listings(address string) []string {
+	var result []string
+
+	for _, account := range st.Accounts {
+		if account == address {
+			result = append(result, "ACCOUNTS")
+			break
+		}
+	}
+
+	if st.SigningKey == address {
+		result = append(result, "SIGNING_KEY")
+	}
+
+	for _, currency := range st.Currencies {
+		if currency.Issuer == address {
+			result = append(result, "issuer of "+currency.Code)
+		}
+	}
+
+	return result
+}
+
+// signersFor returns the signers the stellar.toml declares for address, in the order it
+// lists them. Weights default to 1.
+func (st *stellarToml) signersFor(address string) ([]tomlSigner, error) {
+	var result []tomlSigner
+	seen := map[string]bool{}
+
+	for _, signer := range st.Signers {
+		if signer.Account != "" && signer.Account != address {
+			continue
+		}
+
+		if microstellar.ValidAddress(signer.Key) != nil {
+			return nil, errors.Errorf("bad signer key: %s", signer.Key)
+		}
+
+		if seen[signer.Key] {
+			return nil, errors.Errorf("signer is listed more than once: %s", signer.Key)
+		}
+		seen[signer.Key] = true
+
+		if signer.Weight == 0 {
+			signer.Weight = 1
+		}
+
+		if signer.Weight < 0 || signer.Weight > 255 {
+			return nil, errors.Errorf("bad weight for signer %s: %d, expecting 1-255", signer.Key, signer.Weight)
+		}
+
+		result = append(result, signer)
+	}
+
+	return result, nil
+}
+
+// loadHomeDomain returns the home domain of address, which is "" if it isn't set.
+func (cli *CLI) loadHomeDomain(logFields logrus.Fields, address string) (string, error) {
+	var account struct {
+		HomeDomain string `json:"home_domain"`
+	}
+
+	if err := cli.getHorizonJSON(logFields, "/accounts/"+address, &account); err != nil {
+		return "", err
+	}
+
+	return account.HomeDomain, nil
+}
+
+func (cli *CLI) buildAccountVerifyDomainCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify-domain [account]",
+		Short: "check that the stellar.toml of [account]'s home domain lists [account] (in ACCOUNTS, as SIGNING_KEY, or as a CURRENCIES issuer)",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			name := args[0]
+			logFields := logrus.Fields{"cmd": "account", "subcmd": "verify-domain"}
+
+			address, err := cli.ResolveAccount(logFields, name, "address")
+			if err != nil {
+				cli.error(logFields, "invalid account: %s", name)
+				return
+			}
+
+			if microstellar.ValidSeed(address) == nil {
+				address = addressFromSeed(address)
+			}
+
+			domain, err := cli.loadHomeDomain(logFields, address)
+			if err != nil {
+				cli.errorWithCode(ExitNetworkError, logFields, "can't load account %s: %v", name, cli.errorString(err))
+				return
+			}
+
+			if domain == "" {
+				cli.error(logFields, "%s has no home domain", name)
+				return
+			}
+
+			st, err := cli.fetchStellarToml(logFields, domain)
+			if err != nil {
+				cli.errorWithCode(ExitNetworkError, logFields, "%v", err)
+				return
+			}
+
+			listings := st.listings(address)
+			if len(listings) == 0 {
+				cli.error(logFields, "mismatch: %s has home domain %s, but its stellar.toml doesn't list %s in ACCOUNTS, as SIGNING_KEY, or as a CURRENCIES issuer", name, domain, address)
+				return
+			}
+
+			showSuccess("verified: %s (%s) is listed by %s: %s", name, address, domain, strings.Join(listings, ", "))
+		},
+	}
+}
@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testStellarToml = `
+FEDERATION_SERVER = "https://example.com/federation"
+
+[[CURRENCIES]]
+code = "USD"
+issuer = "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+
+[[CURRENCIES]]
+code = "EUR"
+issuer = "GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U"
+
+[[CURRENCIES]]
+code = "EUR"
+issuer = "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+
+[[CURRENCIES]]
+code = "BAD"
+issuer = "nobody"
+`
+
+func TestTrustFromDomain(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/stellar.toml":
+			fmt.Fprint(w, testStellarToml)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	stellarTomlScheme = "http"
+	defer func() { stellarTomlScheme = "https" }()
+	domain := strings.TrimPrefix(server.URL, "http://")
+
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new mo")
+
+	expectOutput(t, cli, "", "trust create mo USD --from-domain "+domain)
+	expectOutput(t, cli, "", "trust create mo USD 100 --from-domain "+domain)
+	expectOutput(t, cli, "error", "trust create mo EUR --from-domain "+domain)
+	expectOutput(t, cli, "error", "trust create mo BAD --from-domain "+domain)
+	expectOutput(t, cli, "error", "trust create mo INR --from-domain "+domain)
+	expectOutput(t, cli, "error", "trust create mo US$ --from-domain "+domain)
+	expectOutput(t, cli, "error", "trust create mo USD --from-domain "+domain+"/elsewhere")
+
+	st, err := cli.fetchStellarToml(nil, domain)
+	if err != nil {
+		t.Fatalf("can't fetch stellar.toml: %v", err)
+	}
+
+	if issuer, err := st.currencyIssuer("USD"); err != nil || issuer != "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM" {
+		t.Errorf("want USD issuer from stellar.toml, got %s (%v)", issuer, err)
+	}
+
+	expectOutput(t, cli, "error", "trust create mo USD --from-domain "+domain+" --offline")
+}
+
+func TestAccountVerifyDomain(t *testing.T) {
+	var domain string
+	listed := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+	unlisted := "GCSQ7TNBQ2XVFH6DPYYWN7TCRTLAT7H4VAI3DHHVGSN4MQP4VFUIR4F6"
+	signer := "GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U"
+	nodomain := "GAKONCKYJ7PRRKBZSWVPG3MURUNX5XNGZ4HFNHVG63JBUAWMVG2M5M4P"
+
+	cli, _ := newTestCLI()
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/stellar.toml":
+			fmt.Fprintf(w, "ACCOUNTS = [%q]\nSIGNING_KEY = %q\n%s", signer, signer, testStellarToml)
+		case "/accounts/" + nodomain:
+			fmt.Fprint(w, `{}`)
+		default:
+			fmt.Fprintf(w, `{"home_domain": "%s"}`, domain)
+		}
+	})
+	defer server.Close()
+
+	stellarTomlScheme = "http"
+	defer func() { stellarTomlScheme = "https" }()
+	domain = strings.TrimPrefix(server.URL, "http://")
+
+	cli.TestCommand("account set issuer " + listed)
+	cli.TestCommand("account set signer " + signer)
+	cli.TestCommand("account set other " + unlisted)
+	cli.TestCommand("account set nodomain " + nodomain)
+
+	expectOutput(t, cli, fmt.Sprintf("verified: issuer (%s) is listed by %s: issuer of USD, issuer of EUR", listed, domain), "account verify-domain issuer")
+	expectOutput(t, cli, fmt.Sprintf("verified: signer (%s) is listed by %s: ACCOUNTS, SIGNING_KEY, issuer of EUR", signer, domain), "account verify-domain signer")
+	expectOutput(t, cli, "error", "account verify-domain other")
+	expectOutput(t, cli, "error", "account verify-domain nodomain")
+	expectOutput(t, cli, "error", "account verify-domain nobody")
+}
@@ -1,6 +1,8 @@
 package cli
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
@@ -22,6 +24,19 @@ func newTestCLI() (*CLI, store.API) {
 	return cli, memStore
 }
 
+// newTestHorizon starts a fake horizon server that answers every request with
+// handler, and points cli at it as a custom network with the given passphrase, both
+// in the config (for commands) and directly (for calls that skip the command setup).
+// Callers close the server.
+func newTestHorizon(cli *CLI, passphrase string, handler http.HandlerFunc) *httptest.Server {
+	server := httptest.NewServer(handler)
+	network := "custom;" + server.URL + ";" + passphrase
+
+	cli.TestCommand("set config:network " + network)
+	cli.network = network
+	return server
+}
+
 func TestCLIVersion(t *testing.T) {
 	cli, _ := newTestCLI()
 	expectOutput(t, cli, cli.version, "version")
@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"strings"
+
+	"github.com/0xfe/microstellar"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func (cli *CLI) buildTestnetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "testnet [reset-check]",
+		Short: "tools for the test network",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cli.error(logrus.Fields{"cmd": "testnet"}, "unrecognized testnet command: %s, expecting: reset-check", args[0])
+		},
+	}
+
+	cmd.AddCommand(cli.buildTestnetResetCheckCmd())
+	return cmd
+}
+
+func (cli *CLI) buildTestnetResetCheckCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reset-check [--prune]",
+		Short: "list the stored accounts in the current namespace that no longer exist on the network (e.g., after a testnet reset)",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "testnet", "subcmd": "reset-check"}
+
+			// Accounts don't get reset on the public network, they get merged
+			if strings.Split(cli.network, ";")[0] == "public" {
+				cli.error(logFields, "reset-check is for test networks, not the public network")
+				return
+			}
+
+			names, err := cli.AccountNames()
+			if err != nil {
+				cli.errorWithCode(ExitStoreError, logFields, "could not list accounts")
+				return
+			}
+
+			dead, err := cli.deadAccounts(logFields, names, cli.accountExists)
+			if err != nil {
+				cli.errorWithCode(ExitNetworkError, logFields, "%v", err)
+				return
+			}
+
+			for _, name := range dead {
+				showSuccess("dead: %s", name)
+			}
+
+			debugf(logFields, "%d of %d accounts no longer exist", len(dead), len(names))
+			if prune, _ := cmd.Flags().GetBool("prune"); !prune || len(dead) == 0 {
+				return
+			}
+
+			if !cli.confirm("delete %d accounts (and their seeds) from the local store", len(dead)) {
+				cli.error(logFields, "not pruning accounts, use --yes to confirm")
+				return
+			}
+
+			for _, name := range dead {
+				if err := cli.deleteAccount(name); err != nil {
+					cli.errorWithCode(ExitStoreError, logFields, "could not delete account: %s", name)
+					return
+				}
+			}
+
+			showSuccess("pruned %d accounts", len(dead))
+		},
+	}
+
+	cmd.Flags().Bool("prune", false, "also delete the accounts that no longer exist from the local store")
+	return cmd
+}
+
+// deadAccounts returns the names of the stored accounts that exist doesn't find on the
+// network.
+func (cli *CLI) deadAccounts(logFields logrus.Fields, names []string, exists func(address string) (bool, error)) ([]string, error) {
+	var dead []string
+
+	for _, name := range names {
+		address, err := cli.ResolveAccount(logFields, name, "address")
+		if err != nil {
+			debugf(logFields, "skipping %s: %v", name, err)
+			continue
+		}
+
+		if microstellar.ValidSeed(address) == nil {
+			address = addressFromSeed(address)
+		}
+
+		ok, err := exists(address)
+		if err != nil {
+			return nil, errors.Errorf("can't load account %s: %v", name, cli.errorString(err))
+		}
+
+		if !ok {
+			dead = append(dead, name)
+		}
+	}
+
+	return dead, nil
+}
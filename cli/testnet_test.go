@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestTestnetResetCheck(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	dead := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+	alive := "GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U"
+	cli.TestCommand("account set bob " + dead)
+	cli.TestCommand("account set kelly " + alive)
+	cli.TestCommand("account set mo " + dead)
+
+	// Everything exists on the fake network
+	expectOutput(t, cli, "", "testnet reset-check")
+	expectOutput(t, cli, "", "testnet reset-check --prune")
+	expectOutput(t, cli, "error", "testnet reset-check --network public")
+	expectOutput(t, cli, "error", "testnet nothing")
+
+	exists := func(address string) (bool, error) { return address != dead, nil }
+	names, _ := cli.AccountNames()
+	got, err := cli.deadAccounts(logrus.Fields{"test": "reset-check"}, names, exists)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := []string{"bob", "mo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want dead accounts %v, got %v", want, got)
+	}
+
+	failing := func(address string) (bool, error) { return false, errors.New("horizon is down") }
+	if _, err := cli.deadAccounts(logrus.Fields{"test": "reset-check"}, names, failing); err == nil {
+		t.Error("want error when accounts can't be loaded")
+	}
+
+	// Pruning removes all of an account's keys
+	if err := cli.deleteAccount("bob"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectOutput(t, cli, "kelly "+alive+"\nmo "+dead, "account list")
+}
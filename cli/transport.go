@@ -0,0 +1,292 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// retryTransport retries horizon requests that fail with transient errors, backing
+// off exponentially, and honoring Retry-After if horizon sends it.
+//
+// Reads are retried on 429, 502, 503, and 504. Transaction submissions are only
+// retried on 429 and 503, where horizon guarantees that the transaction wasn't
+// applied. (A 504 means that the transaction may still make it into a ledger.)
+type retryTransport struct {
+	transport http.RoundTripper
+	retries   int
+	baseDelay time.Duration
+	maxDelay  time.Duration // 0 for no limit
+}
+
+// RoundTrip implements http.RoundTripper. Requests with a body that can't be replayed
+// (no GetBody) aren't retried.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	logFields := logrus.Fields{"type": "http", "method": req.Method, "url": req.URL.String()}
+
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	if !replayable {
+		debugf(logFields, "can't replay request body, not retrying")
+		return t.transport.RoundTrip(req)
+	}
+
+	for attempt := 0; ; attempt++ {
+		// The first attempt reads the caller's body, so the others send a copy of the
+		// request with a fresh one, leaving the caller's request as is.
+		attemptReq := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+
+			attemptReq = new(http.Request)
+			*attemptReq = *req
+			attemptReq.Body = body
+		}
+
+		resp, err := t.transport.RoundTrip(attemptReq)
+		if err != nil || attempt >= t.retries || !t.shouldRetry(req, resp) {
+			return resp, err
+		}
+
+		delay := t.delay(attempt, resp)
+		debugf(logFields, "got %s, retrying in %v (%d of %d)", resp.Status, delay, attempt+1, t.retries)
+		resp.Body.Close()
+
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// shouldRetry returns true if resp is a transient failure that's safe to retry.
+func (t *retryTransport) shouldRetry(req *http.Request, resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return req.Method == http.MethodGet || req.Method == http.MethodHead
+	}
+
+	return false
+}
+
+// delay returns how long to wait before the next attempt.
+func (t *retryTransport) delay(attempt int, resp *http.Response) time.Duration {
+	delay := t.baseDelay << uint(attempt)
+
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		if secs, err := strconv.ParseUint(retryAfter, 10, 32); err == nil {
+			delay = time.Duration(secs) * time.Second
+		} else if when, err := http.ParseTime(retryAfter); err == nil {
+			delay = time.Until(when)
+		}
+	}
+
+	if delay < 0 {
+		delay = 0
+	}
+
+	if t.maxDelay > 0 && delay > t.maxDelay {
+		delay = t.maxDelay
+	}
+
+	return delay
+}
+
+// accountPath matches the horizon endpoint used to load an account's sequence number.
+var accountPath = regexp.MustCompile(`^/accounts/([A-Z0-9]+)/?$`)
+
+// sequenceTransport answers lookups of address's account with the sequence number set
+// to sequence. This lets microstellar build transactions with a caller-supplied
+// sequence number (see --sequence). The rest of the account comes from horizon, or if
+// horizon doesn't have it or can't be reached (e.g., offline), the account only has a
+// sequence number. All other requests are passed through.
+type sequenceTransport struct {
+	transport http.RoundTripper
+	address   string
+	sequence  uint64 // the account's sequence, i.e., one less than the transaction's
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *sequenceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	match := accountPath.FindStringSubmatch(req.URL.Path)
+	if req.Method != http.MethodGet || match == nil || match[1] != t.address {
+		return t.transport.RoundTrip(req)
+	}
+
+	logFields := logrus.Fields{"type": "http", "method": req.Method, "url": req.URL.String()}
+	account := map[string]interface{}{"id": t.address, "account_id": t.address}
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		debugf(logFields, "can't load account, using sequence %d only: %v", t.sequence, err)
+	} else {
+		loaded := map[string]interface{}{}
+		if resp.StatusCode != http.StatusOK {
+			debugf(logFields, "can't load account, using sequence %d only: %s", t.sequence, resp.Status)
+		} else if err := json.NewDecoder(resp.Body).Decode(&loaded); err != nil {
+			debugf(logFields, "bad account, using sequence %d only: %v", t.sequence, err)
+		} else {
+			account = loaded
+		}
+		resp.Body.Close()
+	}
+
+	account["sequence"] = strconv.FormatUint(t.sequence, 10)
+	body, err := json.Marshal(account)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// errOffline is returned for all requests to horizon in offline mode (see --offline.)
+var errOffline = errors.New("offline mode: network access disabled")
+
+// offlineTransport fails all requests without retrying, so commands that need
+// horizon fail fast. Account lookups can still be answered by a sequenceTransport
+// wrapped around it, so transactions can be built offline with --sequence and
+// --nosubmit.
+type offlineTransport struct{}
+
+// RoundTrip implements http.RoundTripper
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	logrus.WithFields(logrus.Fields{"type": "http", "method": req.Method, "url": req.URL.String()}).Debugf("%v", errOffline)
+	return nil, errOffline
+}
+
+// xdrTransport writes the base64-encoded XDR of each transaction submitted to horizon
+// to out (see --verbose-xdr), before passing it on. It's written before the response
+// comes back, so it's there even if the submission fails.
+type xdrTransport struct {
+	transport http.RoundTripper
+	out       io.Writer
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *xdrTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || !strings.HasSuffix(req.URL.Path, "/transactions") || req.Body == nil {
+		return t.transport.RoundTrip(req)
+	}
+
+	req, body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if form, err := url.ParseQuery(string(body)); err == nil && form.Get("tx") != "" {
+		fmt.Fprintf(t.out, "xdr: %s\n", form.Get("tx"))
+	}
+
+	return t.transport.RoundTrip(req)
+}
+
+// readRequestBody returns the body of req, and the request to send in its place. That's
+// req itself if GetBody can read the body again. Otherwise, reading drains req's body,
+// so it's a copy of req with the body put back, since RoundTrip mustn't modify the
+// caller's request (see retryTransport.)
+func readRequestBody(req *http.Request) (*http.Request, []byte, error) {
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, nil, err
+		}
+		defer body.Close()
+
+		data, err := ioutil.ReadAll(body)
+		return req, data, err
+	}
+
+	data, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	replay := func() (io.ReadCloser, error) { return ioutil.NopCloser(bytes.NewReader(data)), nil }
+	copied := new(http.Request)
+	*copied = *req
+	copied.Body, _ = replay()
+	copied.GetBody = replay
+	return copied, data, nil
+}
+
+// traceTransport writes each request to horizon, and its response status and body,
+// to out (see --trace.) It's inside the retries, so each attempt is written. Nothing
+// is redacted, since seeds are never sent to horizon. Event streams (e.g., watch) go
+// on indefinitely, so their bodies aren't written.
+type traceTransport struct {
+	transport http.RoundTripper
+	out       io.Writer
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fmt.Fprintf(t.out, "trace: > %s %s\n", req.Method, req.URL)
+
+	if req.Body != nil && req.Body != http.NoBody {
+		var body []byte
+		var err error
+		req, body, err = readRequestBody(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(body) > 0 {
+			fmt.Fprintf(t.out, "trace: > %s\n", body)
+		}
+	}
+
+	start := time.Now()
+	resp, err := t.transport.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		fmt.Fprintf(t.out, "trace: < %s %s failed after %v: %v\n", req.Method, req.URL, elapsed, err)
+		return resp, err
+	}
+
+	fmt.Fprintf(t.out, "trace: < %s %s %s (%v)\n", resp.Status, req.Method, req.URL, elapsed)
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		fmt.Fprintf(t.out, "trace: < (event stream, body not traced)\n")
+		return resp, nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if len(body) > 0 {
+		fmt.Fprintf(t.out, "trace: < %s\n", strings.TrimRight(string(body), "\n"))
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,353 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFlakyServer returns a server that fails the first n requests with status.
+func newFlakyServer(n int, status int, retryAfter string) (*httptest.Server, *int) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts <= n {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+
+	return server, &attempts
+}
+
+func newTestClient(retries int) *http.Client {
+	return &http.Client{Transport: &retryTransport{
+		transport: http.DefaultTransport,
+		retries:   retries,
+		baseDelay: time.Millisecond,
+		maxDelay:  10 * time.Millisecond,
+	}}
+}
+
+func TestRetryTransport(t *testing.T) {
+	server, attempts := newFlakyServer(2, http.StatusServiceUnavailable, "0")
+	defer server.Close()
+
+	resp, err := newTestClient(3).Get(server.URL)
+	if err != nil || resp.StatusCode != http.StatusOK || *attempts != 3 {
+		t.Errorf("want success after 3 attempts, got %v %v after %d", resp, err, *attempts)
+	}
+
+	server, attempts = newFlakyServer(5, http.StatusTooManyRequests, "")
+	defer server.Close()
+
+	resp, _ = newTestClient(2).Get(server.URL)
+	if resp.StatusCode != http.StatusTooManyRequests || *attempts != 3 {
+		t.Errorf("want 429 after 3 attempts, got %d after %d", resp.StatusCode, *attempts)
+	}
+
+	// Submissions may have been applied on a gateway timeout, so don't retry them.
+	server, attempts = newFlakyServer(1, http.StatusGatewayTimeout, "")
+	defer server.Close()
+
+	resp, _ = newTestClient(3).Post(server.URL, "application/x-www-form-urlencoded", strings.NewReader("tx=foo"))
+	if resp.StatusCode != http.StatusGatewayTimeout || *attempts != 1 {
+		t.Errorf("want 504 after 1 attempt, got %d after %d", resp.StatusCode, *attempts)
+	}
+
+	// ... but do retry them if they were rate limited.
+	server, attempts = newFlakyServer(1, http.StatusTooManyRequests, "")
+	defer server.Close()
+
+	resp, _ = newTestClient(3).Post(server.URL, "application/x-www-form-urlencoded", strings.NewReader("tx=foo"))
+	if resp.StatusCode != http.StatusOK || *attempts != 2 {
+		t.Errorf("want success after 2 attempts, got %d after %d", resp.StatusCode, *attempts)
+	}
+}
+
+func TestRetryTransportBody(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	transport := newTestClient(3).Transport
+
+	// Each attempt gets the whole body, and the caller's request isn't changed
+	req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("tx=foo"))
+	body := req.Body
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK || strings.Join(bodies, ",") != "tx=foo,tx=foo" {
+		t.Errorf("want success after 2 attempts with the same body, got %v %v with bodies %q", resp, err, bodies)
+	}
+
+	if req.Body != body {
+		t.Errorf("want the caller's request body untouched")
+	}
+
+	// Bodies that can't be replayed get one attempt
+	bodies = nil
+	req, _ = http.NewRequest(http.MethodPost, server.URL, ioutil.NopCloser(strings.NewReader("tx=foo")))
+
+	resp, err = transport.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable || len(bodies) != 1 {
+		t.Errorf("want 503 after 1 attempt, got %v %v with bodies %q", resp, err, bodies)
+	}
+}
+
+func TestSequenceTransport(t *testing.T) {
+	source := "GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U"
+	other := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path == "/accounts/"+other+"/offers" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		fmt.Fprintf(w, `{"id": "%s", "sequence": "7", "home_domain": "example.com"}`, strings.TrimPrefix(r.URL.Path, "/accounts/"))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &sequenceTransport{transport: http.DefaultTransport, address: source, sequence: 41}}
+
+	load := func(address string) (id, sequence, domain string) {
+		resp, err := client.Get(server.URL + "/accounts/" + address)
+		if err != nil {
+			t.Fatalf("account lookup: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var account struct {
+			ID         string `json:"id"`
+			Sequence   string `json:"sequence"`
+			HomeDomain string `json:"home_domain"`
+		}
+		json.NewDecoder(resp.Body).Decode(&account)
+		return account.ID, account.Sequence, account.HomeDomain
+	}
+
+	// The source's account comes from horizon, with the sequence replaced
+	if id, seq, domain := load(source); id != source || seq != "41" || domain != "example.com" || len(paths) != 1 {
+		t.Errorf("want horizon's account with sequence 41, got %s %s %s after requests %v", id, seq, domain, paths)
+	}
+
+	// Other accounts are left alone, so checks on them still work
+	if _, seq, _ := load(other); seq != "7" || len(paths) != 2 {
+		t.Errorf("want horizon's sequence for other accounts, got %s after requests %v", seq, paths)
+	}
+
+	resp, _ := client.Get(server.URL + "/accounts/" + other + "/offers")
+	if resp.StatusCode != http.StatusNotFound || len(paths) != 3 {
+		t.Errorf("want offers request to reach horizon, got %d after requests %v", resp.StatusCode, paths)
+	}
+}
+
+func TestOfflineTransport(t *testing.T) {
+	server, attempts := newFlakyServer(0, http.StatusOK, "")
+	defer server.Close()
+
+	client := &http.Client{Transport: offlineTransport{}}
+	if _, err := client.Get(server.URL + "/ledgers"); err == nil || !strings.Contains(err.Error(), errOffline.Error()) || *attempts != 0 {
+		t.Errorf("want %v and no requests, got %v after %d requests", errOffline, err, *attempts)
+	}
+
+	// Sequence numbers can still be supplied locally, for the source account only
+	source := "GBH6GGAPBFH6IXCQBPJ7WSN2WMUFU7PO346BIVZXS6Q22YNFBUNVJS4U"
+	client = &http.Client{Transport: &sequenceTransport{transport: offlineTransport{}, address: source, sequence: 41}}
+
+	resp, err := client.Get(server.URL + "/accounts/" + source)
+	if err != nil || *attempts != 0 {
+		t.Fatalf("want local account lookup, got %v after %d requests", err, *attempts)
+	}
+
+	var account struct {
+		ID       string `json:"id"`
+		Sequence string `json:"sequence"`
+	}
+	json.NewDecoder(resp.Body).Decode(&account)
+
+	if account.ID != source || account.Sequence != "41" {
+		t.Errorf("want local account with sequence 41, got %+v", account)
+	}
+
+	if _, err := client.Get(server.URL + "/accounts/GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"); err == nil {
+		t.Errorf("want other accounts to fail offline")
+	}
+}
+
+func TestOffline(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("account new mo")
+
+	server, attempts := newFlakyServer(0, http.StatusOK, "")
+	defer server.Close()
+
+	cli.TestCommand("set config:network custom;" + server.URL + ";passphrase")
+
+	expectOutput(t, cli, "error", "balance mo --offline")
+	if *attempts != 0 {
+		t.Errorf("want no requests to horizon in offline mode, got %d", *attempts)
+	}
+
+	// Only for that command
+	if _, ok := http.DefaultClient.Transport.(offlineTransport); ok {
+		t.Errorf("want http.DefaultClient restored after the command")
+	}
+
+	cli.TestCommand("account flags-explain mo")
+	if *attempts == 0 {
+		t.Errorf("want requests to horizon without --offline")
+	}
+
+	// Local commands still work
+	if got := cli.TestCommand("account new kelly --offline"); strings.Contains(got, "error") {
+		t.Errorf("want new account in offline mode, got %q", got)
+	}
+	expectOutput(t, cli, "", "set foo bar --offline")
+	expectOutput(t, cli, "bar", "get foo --offline")
+}
+
+func TestXDRTransport(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		received = append(received, r.Method+" "+r.URL.Path+" "+r.PostForm.Get("tx"))
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	client := &http.Client{Transport: &xdrTransport{transport: http.DefaultTransport, out: &out}}
+
+	// Written even though the submission fails
+	if _, err := client.PostForm(server.URL+"/transactions", url.Values{"tx": {"AAAA+/=="}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := client.Get(server.URL + "/transactions"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, want := out.String(), "xdr: AAAA+/==\n"; got != want {
+		t.Errorf("want output %q, got %q", want, got)
+	}
+
+	// The transaction is still sent
+	if len(received) != 2 || received[0] != "POST /transactions AAAA+/==" || received[1] != "GET /transactions " {
+		t.Errorf("want the requests passed through, got %q", received)
+	}
+
+	// The caller's request isn't changed, with or without GetBody
+	for _, body := range []io.Reader{strings.NewReader("tx=BBBB"), ioutil.NopCloser(strings.NewReader("tx=CCCC"))} {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/transactions", body)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		reqBody := req.Body
+		if _, err := client.Transport.RoundTrip(req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if req.Body != reqBody {
+			t.Errorf("want the caller's request body untouched")
+		}
+	}
+
+	if len(received) != 4 || received[2] != "POST /transactions BBBB" || received[3] != "POST /transactions CCCC" {
+		t.Errorf("want the bodies sent, got %q", received)
+	}
+}
+
+func TestTraceTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stream" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "retry: 1000\n")
+			return
+		}
+
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"title": "Transaction Failed"}`+"\n")
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	client := &http.Client{Transport: &traceTransport{transport: http.DefaultTransport, out: &out}}
+
+	resp, err := client.PostForm(server.URL+"/transactions", url.Values{"tx": {"AAAA"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The response body is still readable
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != `{"title": "Transaction Failed"}`+"\n" {
+		t.Errorf("want response body passed through, got %q", body)
+	}
+
+	stream, err := client.Get(server.URL + "/stream")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stream.Body.Close()
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := []string{
+		"trace: > POST " + server.URL + "/transactions",
+		"trace: > tx=AAAA",
+		"trace: < 400 Bad Request POST " + server.URL + "/transactions",
+		`trace: < {"title": "Transaction Failed"}`,
+		"trace: > GET " + server.URL + "/stream",
+		"trace: < 200 OK GET " + server.URL + "/stream",
+		"trace: < (event stream, body not traced)",
+	}
+
+	if len(lines) != len(want) {
+		t.Fatalf("want %d lines, got: %q", len(want), lines)
+	}
+
+	for i := range want {
+		if !strings.HasPrefix(lines[i], want[i]) {
+			t.Errorf("want line %d to start with %q, got %q", i, want[i], lines[i])
+		}
+	}
+
+	// The caller's request isn't changed, with or without GetBody
+	for _, body := range []io.Reader{strings.NewReader("tx=BBBB"), ioutil.NopCloser(strings.NewReader("tx=BBBB"))} {
+		out.Reset()
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/transactions", body)
+		reqBody := req.Body
+		resp, err := client.Transport.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+
+		if req.Body != reqBody {
+			t.Errorf("want the caller's request body untouched")
+		}
+
+		if !strings.Contains(out.String(), "trace: > tx=BBBB\n") {
+			t.Errorf("want the body traced, got %q", out.String())
+		}
+	}
+}
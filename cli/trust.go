@@ -1,46 +1,134 @@
 package cli
 
 import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+
 	"github.com/0xfe/microstellar"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/stellar/go/amount"
+	"github.com/stellar/go/xdr"
 )
 
 func (cli *CLI) buildTrustCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "trust [create|remove|allow]",
+		Use:   "trust [create|create-pool|remove|remove-all|allow|authorize]",
 		Short: "manage trustlines between accounts and assets",
 		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			if len(args) > 0 {
-				showError(logrus.Fields{"cmd": "trust"}, "unrecognized trust command: %s, expecting: create|remove", args[0])
+				showError(logrus.Fields{"cmd": "trust"}, "unrecognized trust command: %s, expecting: create|remove|allow|authorize", args[0])
 				return
 			}
 		},
 	}
 
 	cmd.AddCommand(cli.buildTrustCreateCmd())
+	cmd.AddCommand(cli.buildTrustCreatePoolCmd())
 	cmd.AddCommand(cli.buildTrustRemoveCmd())
+	cmd.AddCommand(cli.buildTrustRemoveAllCmd())
 	cmd.AddCommand(cli.buildTrustAllowCmd())
+	cmd.AddCommand(cli.buildTrustAuthorizeCmd())
+
+	return cmd
+}
+
+func (cli *CLI) buildTrustCreatePoolCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "create-pool [account] [assetA] [assetB]",
+		Short: "create a trustline to the shares of the liquidity pool of assetA and assetB for [account], so it can deposit into the pool",
+		Args:  cobra.ExactArgs(3),
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "trust", "subcmd": "create-pool"}
+
+			name := args[0]
+			tx, seeds, err := cli.newRawTx(cmd, logFields, name)
+			if err != nil {
+				cli.error(logFields, "can't generate trustline transaction: %v", err)
+				return
+			}
+
+			assetA, err := cli.ResolveAsset(args[1])
+			if err != nil {
+				cli.error(logFields, "invalid asset: %s", args[1])
+				return
+			}
+
+			assetB, err := cli.ResolveAsset(args[2])
+			if err != nil {
+				cli.error(logFields, "invalid asset: %s", args[2])
+				return
+			}
+
+			poolID, err := liquidityPoolID(assetA, assetB)
+			if err != nil {
+				cli.error(logFields, "%v", err)
+				return
+			}
+
+			op, err := poolTrustOp(assetA, assetB)
+			if err != nil {
+				cli.error(logFields, "%v", err)
+				return
+			}
+			tx.addOp(opChangeTrust, op)
+
+			current, err := cli.currentSequence(tx.source, tx.params)
+			if err != nil {
+				cli.errorWithCode(ExitNetworkError, logFields, "can't load sequence number of %s: %v", name, cli.errorString(err))
+				return
+			}
+			tx.seq = current + 1
+
+			debugf(logFields, "creating trustline from %s to the shares of pool %s", tx.source, poolID)
+			if err := cli.submitRawTx(logFields, tx, seeds); err != nil {
+				cli.errorWithCode(txExitCode(err), logFields, "failed to create trustline to pool %s: %v", poolID, cli.errorString(err))
+				return
+			}
 
+			// The ID is what pool info and pool withdraw take
+			showSuccess("pool: %s", poolID)
+		},
+	}
+
+	buildFlagsForTxParams(cmd)
 	return cmd
 }
 
 func (cli *CLI) buildTrustCreateCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "create [account] [asset] [limit]",
-		Short: "create a new trustline to the asset for [account]",
-		Args:  cobra.MinimumNArgs(2),
+		Use:   "create [account] [asset] [limit] [--from-domain domain]",
+		Short: "create a new trustline to the asset for [account], or change its limit (the maximum if not set)",
+		Args:  cobra.RangeArgs(2, 3),
 		Run: func(cmd *cobra.Command, args []string) {
 			name := args[0]
 			assetName := args[1]
 
+			logFields := logrus.Fields{"cmd": "trust", "subcmd": "create"}
+
+			// No limit means the maximum, which is what --unlimited asks for explicitly
 			limit := ""
+			unlimited, _ := cmd.Flags().GetBool("unlimited")
 			if len(args) > 2 {
+				if unlimited {
+					cli.error(logFields, "can't use --unlimited with a limit")
+					return
+				}
+
 				limit = args[2]
+				if err := validateAmount(limit, true); err != nil {
+					cli.error(logFields, "bad limit: %v", err)
+					return
+				}
+			}
+
+			if unlimited {
+				limit = maxAmount
 			}
 
-			logFields := logrus.Fields{"cmd": "trust", "subcmd": "create"}
 			source, err := cli.ResolveAccount(logFields, name, "seed")
 
 			if err != nil {
@@ -48,13 +136,49 @@ func (cli *CLI) buildTrustCreateCmd() *cobra.Command {
 				return
 			}
 
-			asset, err := cli.ResolveAsset(assetName)
-			if err != nil {
-				cli.error(logFields, "invalid asset: %s", assetName)
-				return
+			// With --from-domain, the asset is a code, and its issuer is looked up in the
+			// domain's stellar.toml
+			var asset *microstellar.Asset
+			if domain, _ := cmd.Flags().GetString("from-domain"); domain != "" {
+				asset, err = cli.resolveDomainAsset(logFields, domain, assetName)
+				if err != nil {
+					cli.errorWithCode(ExitNetworkError, logFields, "can't resolve %s on %s: %v", assetName, domain, err)
+					return
+				}
+			} else {
+				asset, err = cli.ResolveAsset(assetName)
+				if err != nil {
+					cli.error(logFields, "invalid asset: %s", assetName)
+					return
+				}
 			}
 
-			opts, err := cli.genTxOptions(cmd, logFields)
+			// The network rejects limits below the current balance, so catch them early.
+			// There are no balances on the fake network.
+			if limit != "" && limit != maxAmount && cli.horizonURL() != "" {
+				address, err := cli.ResolveAccount(logFields, name, "address")
+				if err != nil {
+					cli.error(logFields, "invalid account: %s", name)
+					return
+				}
+
+				if microstellar.ValidSeed(address) == nil {
+					address = addressFromSeed(address)
+				}
+
+				balance, err := cli.pollBalance(address, asset)
+				if err != nil {
+					cli.errorWithCode(ExitNetworkError, logFields, "can't load balance of %s: %v", name, cli.errorString(err))
+					return
+				}
+
+				if err := checkLimit(limit, balance); err != nil {
+					cli.error(logFields, "%v", err)
+					return
+				}
+			}
+
+			opts, err := cli.genTxOptionsFor(cmd, logFields, name)
 			if err != nil {
 				cli.error(logFields, "can't generate trustline transaction: %v", err)
 				return
@@ -62,16 +186,33 @@ func (cli *CLI) buildTrustCreateCmd() *cobra.Command {
 
 			err = cli.ms.CreateTrustLine(source, asset, limit, opts)
 			if err != nil {
-				cli.error(logFields, "failed to create trustline from %s to %s: %v", name, assetName, microstellar.ErrorString(err))
+				cli.errorWithCode(txExitCode(err), logFields, "failed to create trustline from %s to %s: %v", name, assetName, cli.errorString(err))
 				return
 			}
 		},
 	}
 
+	cmd.Flags().Bool("unlimited", false, "set the maximum limit ("+maxAmount+"), same as no limit")
+	cmd.Flags().String("from-domain", "", "[asset] is a code, issued by the account listed for it in this domain's stellar.toml")
 	buildFlagsForTxOptions(cmd)
 	return cmd
 }
 
+// checkLimit returns an error if the trustline limit (an amount) is below balance
+// (in stroops.)
+func checkLimit(limit string, balance int64) error {
+	limitAmount, err := amount.ParseInt64(limit)
+	if err != nil {
+		return errors.Errorf("bad limit: %s", limit)
+	}
+
+	if limitAmount < balance {
+		return errors.Errorf("limit %s is below the current balance of %s", limit, amount.StringFromInt64(balance))
+	}
+
+	return nil
+}
+
 func (cli *CLI) buildTrustRemoveCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "remove [account] [asset]",
@@ -95,7 +236,7 @@ func (cli *CLI) buildTrustRemoveCmd() *cobra.Command {
 				return
 			}
 
-			opts, err := cli.genTxOptions(cmd, logFields)
+			opts, err := cli.genTxOptionsFor(cmd, logFields, name)
 			if err != nil {
 				cli.error(logFields, "can't generate trustline transaction: %v", err)
 				return
@@ -103,16 +244,203 @@ func (cli *CLI) buildTrustRemoveCmd() *cobra.Command {
 
 			err = cli.ms.RemoveTrustLine(source, asset, opts)
 			if err != nil {
-				cli.error(logFields, "error: %v", name, assetName, microstellar.ErrorString(err))
+				cli.errorWithCode(txExitCode(err), logFields, "failed to remove trustline from %s to %s: %v", name, assetName, cli.errorString(err))
+				return
+			}
+		},
+	}
+
+	buildFlagsForTxOptions(cmd)
+	return cmd
+}
+
+func (cli *CLI) buildTrustRemoveAllCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "remove-all [account] [--merge-to destination] [--signers seed1,seed2...]",
+		Short: "remove all the trustlines of [account] (their balances must be zero), and optionally merge it into [destination]",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			name := args[0]
+			logFields := logrus.Fields{"cmd": "trust", "subcmd": "remove-all"}
+
+			if batch, _ := cmd.Flags().GetBool("batch"); batch {
+				cli.error(logFields, "remove-all can't be batched")
+				return
+			}
+
+			source, err := cli.ResolveAccount(logFields, name, "seed")
+			if err != nil || microstellar.ValidSeed(source) != nil {
+				cli.error(logFields, "no seed found in %s", name)
+				return
+			}
+
+			address, err := cli.ResolveAccount(logFields, name, "address")
+			if err != nil {
+				cli.error(logFields, "invalid account: %s", name)
+				return
+			}
+
+			if microstellar.ValidSeed(address) == nil {
+				address = addressFromSeed(address)
+			}
+
+			params, err := cli.parseTxParams(cmd, logFields, name)
+			if err != nil {
+				cli.error(logFields, "can't generate transaction: %v", err)
+				return
+			}
+
+			mergeTo := ""
+			mergeFlag, _ := cmd.Flags().GetString("merge-to")
+			if mergeFlag != "" {
+				if mergeTo, err = cli.ResolveAccount(logFields, mergeFlag, "address"); err != nil {
+					cli.error(logFields, "invalid --merge-to: %s", mergeFlag)
+					return
+				}
+
+				if microstellar.ValidSeed(mergeTo) == nil {
+					mergeTo = addressFromSeed(mergeTo)
+				}
+
+				if mergeTo == address {
+					cli.error(logFields, "can't merge %s into itself", name)
+					return
+				}
+
+				// Don't send XLM where it'd get lost without a memo
+				required, err := cli.memoRequired(mergeTo)
+				if err != nil {
+					cli.errorWithCode(ExitNetworkError, logFields, "can't check if %s requires a memo: %v", mergeFlag, cli.errorString(err))
+					return
+				}
+
+				if required && params.memo.Type == xdr.MemoTypeMemoNone {
+					cli.error(logFields, "%s requires a memo (SEP-29), set one with --memotext or --memoid", mergeFlag)
+					return
+				}
+
+				// Merging deletes the account, so confirm before removing anything
+				if !cli.confirm("merge %s into %s, deleting %s from the network", name, mergeFlag, name) {
+					cli.error(logFields, "not merging %s, use --yes to confirm", name)
+					return
+				}
+			}
+
+			account, err := cli.ms.LoadAccount(address)
+			if err != nil {
+				cli.errorWithCode(ExitNetworkError, logFields, "can't load account %s: %v", name, cli.errorString(err))
+				return
+			}
+
+			assets, nonZero := trustlinesToRemove(account)
+			if len(nonZero) > 0 {
+				cli.error(logFields, "can't remove trustlines with balances: %s", strings.Join(nonZero, ", "))
+				return
+			}
+
+			opts, err := cli.genTxOptionsFor(cmd, logFields, name)
+			if err != nil {
+				cli.error(logFields, "can't generate transaction: %v", err)
+				return
+			}
+
+			// Without --signers (or default signers), the account signs for itself
+			if len(params.signers) == 0 {
+				opts = opts.WithSigner(source)
+			}
+
+			// Remove the trustlines in as few transactions as possible
+			removals := 0
+			for start := 0; start < len(assets); start += maxOpsPerTx {
+				end := start + maxOpsPerTx
+				if end > len(assets) {
+					end = len(assets)
+				}
+
+				cli.ms.Start(address, opts)
+				for _, asset := range assets[start:end] {
+					if err := cli.ms.RemoveTrustLine(source, asset); err != nil {
+						cli.error(logFields, "can't add removal of %s: %v", assetCode(asset), cli.errorString(err))
+						return
+					}
+				}
+
+				debugf(logFields, "removing trustlines %d to %d of %d from %s", start+1, end, len(assets), address)
+				if err := cli.ms.Submit(); err != nil {
+					cli.errorWithCode(txExitCode(err), logFields, "failed to remove trustlines from %s (%d of %d removed): %v", name, start, len(assets), cli.errorString(err))
+					return
+				}
+				removals++
+			}
+
+			if len(assets) > 0 {
+				showSuccess("removed %d trustlines", len(assets))
+			}
+
+			if mergeTo == "" {
 				return
 			}
+
+			// The removals took the sequence numbers before the merge's, but horizon only
+			// knows about them once they're submitted
+			current, err := cli.currentSequence(address, params)
+			if err != nil {
+				cli.errorWithCode(ExitNetworkError, logFields, "can't load sequence number of %s: %v", name, cli.errorString(err))
+				return
+			}
+
+			if params.sequence > 0 || cli.submitted == "" {
+				current += int64(removals)
+			}
+
+			if err := cli.mergeAccount(logFields, address, current+1, mergeTo, params, []string{source}); err != nil {
+				cli.errorWithCode(txExitCode(err), logFields, "failed to merge %s into %s: %v", name, mergeFlag, cli.errorString(err))
+				return
+			}
+
+			showSuccess("merged %s into %s", name, mergeFlag)
 		},
 	}
 
+	cmd.Flags().String("merge-to", "", "after removing the trustlines, merge the account into this one")
 	buildFlagsForTxOptions(cmd)
 	return cmd
 }
 
+// trustlinesToRemove returns the assets of the trustlines on account, and the
+// trustlines (as "amount asset") that still hold a balance, which can't be removed.
+func trustlinesToRemove(account *microstellar.Account) ([]*microstellar.Asset, []string) {
+	var assets []*microstellar.Asset
+	var nonZero []string
+
+	for _, balance := range account.Balances {
+		if balance.Asset == nil || balance.Asset.IsNative() {
+			continue
+		}
+
+		if amt, err := amount.ParseInt64(balance.Amount); err != nil || amt != 0 {
+			nonZero = append(nonZero, balance.Amount+" "+balance.Asset.Code+":"+balance.Asset.Issuer)
+			continue
+		}
+
+		assets = append(assets, balance.Asset)
+	}
+
+	return assets, nonZero
+}
+
+// mergeAccount merges address, whose next transaction has sequence number seq, into
+// destination. It's signed with the signers in params, or else seeds.
+func (cli *CLI) mergeAccount(logFields logrus.Fields, address string, seq int64, destination string, params *txParams, seeds []string) error {
+	tx, err := accountMergeTx(address, seq, destination, params)
+	if err != nil {
+		return err
+	}
+
+	debugf(logFields, "merging %s into %s", address, destination)
+	return cli.submitTx(logFields, tx, params, seeds)
+}
+
 func (cli *CLI) buildTrustAllowCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "allow [account] [asset] [--revoke] [--signers seed1,seed2...]",
@@ -136,7 +464,7 @@ func (cli *CLI) buildTrustAllowCmd() *cobra.Command {
 				return
 			}
 
-			opts, err := cli.genTxOptions(cmd, logFields)
+			opts, err := cli.genTxOptions(cmd, logFields, asset.Issuer)
 			if err != nil {
 				cli.error(logFields, "can't generate allowtrust transaction: %v", err)
 				return
@@ -145,7 +473,7 @@ func (cli *CLI) buildTrustAllowCmd() *cobra.Command {
 			revoke, _ := cmd.Flags().GetBool("revoke")
 			err = cli.ms.AllowTrust(asset.Issuer, address, asset.Code, !revoke, opts)
 			if err != nil {
-				cli.error(logFields, "failed to create trustline from %s to %s: %v", name, assetName, microstellar.ErrorString(err))
+				cli.errorWithCode(txExitCode(err), logFields, "failed to create trustline from %s to %s: %v", name, assetName, cli.errorString(err))
 				return
 			}
 		},
@@ -155,3 +483,147 @@ func (cli *CLI) buildTrustAllowCmd() *cobra.Command {
 	buildFlagsForTxOptions(cmd)
 	return cmd
 }
+
+func (cli *CLI) buildTrustAuthorizeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "authorize [issuer] [holder] [asset] [--maintain-liabilities|--revoke]",
+		Short: "set the authorization state of [holder]'s trustline to [asset]",
+		Args:  cobra.ExactArgs(3),
+		Run: func(cmd *cobra.Command, args []string) {
+			issuerName := args[0]
+			holderName := args[1]
+			assetName := args[2]
+
+			logFields := logrus.Fields{"cmd": "trust", "subcmd": "authorize"}
+
+			maintain, _ := cmd.Flags().GetBool("maintain-liabilities")
+			revoke, _ := cmd.Flags().GetBool("revoke")
+			if maintain && revoke {
+				cli.error(logFields, "--maintain-liabilities and --revoke are mutually exclusive")
+				return
+			}
+
+			if batch, _ := cmd.Flags().GetBool("batch"); batch && maintain {
+				cli.error(logFields, "can't add --maintain-liabilities to a batch")
+				return
+			}
+
+			issuer, err := cli.ResolveAccount(logFields, issuerName, "seed")
+			if err != nil {
+				cli.error(logFields, "invalid issuer: %s", issuerName)
+				return
+			}
+
+			holder, err := cli.ResolveAccount(logFields, holderName, "address")
+			if err != nil {
+				cli.error(logFields, "invalid account: %s", holderName)
+				return
+			}
+
+			asset, err := cli.ResolveAsset(assetName)
+			if err != nil {
+				cli.error(logFields, "invalid asset: %s", assetName)
+				return
+			}
+
+			if asset.IsNative() {
+				cli.error(logFields, "native assets don't require authorization")
+				return
+			}
+
+			issuerAccount := cli.LoadAccount(logFields, asset.Issuer)
+			if issuerAccount == nil {
+				return
+			}
+
+			if !issuerAccount.Flags.AuthRequired && !issuerAccount.Flags.AuthRevocable {
+				cli.error(logFields, "issuer of %s is neither auth_required nor auth_revocable, so its trustlines are always authorized (see: lumen flags [issuer] auth_required auth_revocable)", assetName)
+				return
+			}
+
+			if (maintain || revoke) && !issuerAccount.Flags.AuthRevocable {
+				cli.error(logFields, "issuer of %s is not auth_revocable, so authorization can't be reduced once granted", assetName)
+				return
+			}
+
+			if maintain {
+				cli.authorizeToMaintainLiabilities(cmd, logFields, issuerName, holderName, holder, asset)
+				return
+			}
+
+			opts, err := cli.genTxOptions(cmd, logFields, issuer)
+			if err != nil {
+				cli.error(logFields, "can't generate authorization transaction: %v", err)
+				return
+			}
+
+			err = cli.ms.AllowTrust(issuer, holder, asset.Code, !revoke, opts)
+			if err != nil {
+				cli.errorWithCode(txExitCode(err), logFields, "failed to authorize %s for %s: %v", holderName, assetName, cli.errorString(err))
+				return
+			}
+		},
+	}
+
+	cmd.Flags().Bool("maintain-liabilities", false, "only allow the holder to maintain existing offers, not receive new funds")
+	cmd.Flags().Bool("revoke", false, "revoke authorization")
+	buildFlagsForTxOptions(cmd)
+	return cmd
+}
+
+// Trustline flags, as set by setTrustLineFlags operations.
+const (
+	trustLineAuthorized                      uint32 = 1
+	trustLineAuthorizedToMaintainLiabilities uint32 = 2
+)
+
+// trustLineFlagsOp returns the XDR-encoded parameters of an operation that sets the
+// flags setFlags, and clears the flags clearFlags, of holder's trustline to asset.
+func trustLineFlagsOp(holder string, asset *microstellar.Asset, clearFlags, setFlags uint32) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeAccountXDR(&buf, holder); err != nil {
+		return nil, err
+	}
+
+	if err := writeAssetXDR(&buf, asset); err != nil {
+		return nil, err
+	}
+
+	binary.Write(&buf, binary.BigEndian, clearFlags)
+	binary.Write(&buf, binary.BigEndian, setFlags)
+	return buf.Bytes(), nil
+}
+
+// authorizeToMaintainLiabilities lets holder keep its offers in asset, but not receive
+// any more of it. AllowTrust can't express that state in the vendored stellar/go XDR,
+// so it's set with a setTrustLineFlags operation from issuerName.
+func (cli *CLI) authorizeToMaintainLiabilities(cmd *cobra.Command, logFields logrus.Fields, issuerName, holderName, holder string, asset *microstellar.Asset) {
+	if microstellar.ValidSeed(holder) == nil {
+		holder = addressFromSeed(holder)
+	}
+
+	tx, seeds, err := cli.newRawTx(cmd, logFields, issuerName)
+	if err != nil {
+		cli.error(logFields, "can't generate authorization transaction: %v", err)
+		return
+	}
+
+	op, err := trustLineFlagsOp(holder, asset, trustLineAuthorized, trustLineAuthorizedToMaintainLiabilities)
+	if err != nil {
+		cli.error(logFields, "%v", err)
+		return
+	}
+	tx.addOp(opSetTrustLineFlags, op)
+
+	current, err := cli.currentSequence(tx.source, tx.params)
+	if err != nil {
+		cli.errorWithCode(ExitNetworkError, logFields, "can't load sequence number of %s: %v", issuerName, cli.errorString(err))
+		return
+	}
+	tx.seq = current + 1
+
+	debugf(logFields, "authorizing %s to maintain liabilities in %s", holder, asset.Code)
+	if err := cli.submitRawTx(logFields, tx, seeds); err != nil {
+		cli.errorWithCode(txExitCode(err), logFields, "failed to authorize %s to maintain liabilities in %s: %v", holderName, asset.Code, cli.errorString(err))
+	}
+}
@@ -1,6 +1,15 @@
 package cli
 
-import "testing"
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/0xfe/microstellar"
+	"github.com/stellar/go/xdr"
+)
 
 // Note: add -v to any of these commands to enable verbose logging
 
@@ -23,4 +32,147 @@ func TestTrust(t *testing.T) {
 	expectOutput(t, cli, "", "trust remove mo USD --memotext ihatechase")
 	expectOutput(t, cli, "", "trust remove kelly USD --memoid 748")
 	expectOutput(t, cli, "", "trust allow kelly USD --revoke --signers issuer-chase")
+	expectOutput(t, cli, "", "trust create mo USD 0")
+	expectOutput(t, cli, "error", "trust create mo USD 1000.123456789")
+	expectOutput(t, cli, "error", "trust create mo USD lots")
+	expectOutput(t, cli, "", "trust create mo USD --unlimited")
+	expectOutput(t, cli, "", "trust create mo USD 922337203685.4775807")
+	expectOutput(t, cli, "error", "trust create mo USD 1000 --unlimited")
+
+	expectOutput(t, cli, "error", "trust authorize issuer-chase kelly USD --maintain-liabilities --revoke")
+	expectOutput(t, cli, "error", "trust authorize nobody kelly USD")
+	expectOutput(t, cli, "error", "trust authorize issuer-chase nobody USD")
+	expectOutput(t, cli, "error", "trust authorize issuer-chase kelly native")
+	expectOutput(t, cli, "error", "trust authorize issuer-chase kelly USD --maintain-liabilities --batch")
+}
+
+func TestTrustLineFlagsOp(t *testing.T) {
+	holder := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+	usd := microstellar.NewAsset("USD", "GCEZWKCA5VLDNRLN3RPRJMRZOX3Z6G5CHCGSNFHEYVXM3XOJMDS674JZ", microstellar.Credit4Type)
+
+	// The holder's account ID, the asset, then the flags to clear and to set
+	op, err := trustLineFlagsOp(holder, usd, trustLineAuthorized, trustLineAuthorizedToMaintainLiabilities)
+	if err != nil {
+		t.Fatalf("can't encode operation: %v", err)
+	}
+
+	var want bytes.Buffer
+	writeAccountXDR(&want, holder)
+	writeAssetXDR(&want, usd)
+	want.Write([]byte{0, 0, 0, 1, 0, 0, 0, 2})
+	if !bytes.Equal(op, want.Bytes()) {
+		t.Errorf("want operation %x, got %x", want.Bytes(), op)
+	}
+
+	if len(op) != 4+32+4+4+4+32+8 {
+		t.Errorf("want 88 bytes, got %d", len(op))
+	}
+
+	if _, err := trustLineFlagsOp("nobody", usd, 0, 0); err == nil {
+		t.Error("want error for bad holder")
+	}
+}
+
+func TestTrustCreatePool(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account set viewer GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+	cli.TestCommand("asset set ARST GB7TAYRUZGE6TVT7NHP5SMIZRNQA6PLM423EYISAOAP3MKYIQMVYP2JO")
+	cli.TestCommand("asset set USD GCEZWKCA5VLDNRLN3RPRJMRZOX3Z6G5CHCGSNFHEYVXM3XOJMDS674JZ")
+
+	expectOutput(t, cli, "error", "trust create-pool mo USD ARST")
+	expectOutput(t, cli, "error", "trust create-pool mo USD USD")
+	expectOutput(t, cli, "error", "trust create-pool mo USD EUR")
+	expectOutput(t, cli, "error", "trust create-pool viewer ARST USD")
+
+	expectOutput(t, cli, "pool: dd7b1ab831c273310ddbec6f97870aa83c2fbd78ce22aded37ecbf4f3380fac7", "trust create-pool mo ARST USD")
+	expectOutput(t, cli, "pool: dd7b1ab831c273310ddbec6f97870aa83c2fbd78ce22aded37ecbf4f3380fac7", "trust create-pool viewer ARST USD --signers mo")
+
+	// One operation: the trustline to the pool's shares
+	arst, _ := cli.ResolveAsset("ARST")
+	usd, _ := cli.ResolveAsset("USD")
+	op, _ := poolTrustOp(arst, usd)
+
+	got := cli.TestCommand("trust create-pool mo ARST USD --nosubmit")
+	raw, _ := base64.StdEncoding.DecodeString(strings.Fields(got)[0])
+	if len(raw) < 60 || binary.BigEndian.Uint32(raw[56:60]) != 1 || !bytes.Contains(raw, append([]byte{0, 0, 0, 0, 0, 0, 0, 6}, op...)) {
+		t.Errorf("want pool share trustline, got %q", got)
+	}
+}
+
+func TestCheckLimit(t *testing.T) {
+	if err := checkLimit("100", 1000000000); err != nil {
+		t.Errorf("want no error for limit at the balance, got: %v", err)
+	}
+
+	if err := checkLimit("1000", 0); err != nil {
+		t.Errorf("want no error for limit above the balance, got: %v", err)
+	}
+
+	err := checkLimit("99.9999999", 1000000000)
+	if err == nil || err.Error() != "limit 99.9999999 is below the current balance of 100.0000000" {
+		t.Errorf("want error for limit below the balance, got: %v", err)
+	}
+
+	if err := checkLimit("lots", 0); err == nil {
+		t.Errorf("want error for bad limit")
+	}
+}
+
+func TestTrustRemoveAll(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account set viewer GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+
+	// There are no trustlines on the fake network
+	expectOutput(t, cli, "", "trust remove-all mo")
+	expectOutput(t, cli, "merged mo into viewer", "trust remove-all mo --merge-to viewer --yes")
+	expectOutput(t, cli, "error", "trust remove-all mo --merge-to mo")
+	expectOutput(t, cli, "error", "trust remove-all mo --merge-to nobody")
+	expectOutput(t, cli, "error", "trust remove-all viewer")
+	expectOutput(t, cli, "error", "trust remove-all mo --batch")
+
+	// Merging needs confirmation, and without it, nothing is removed or merged
+	expectOutput(t, cli, "error", "trust remove-all mo --merge-to viewer")
+	if cli.submitted != "" {
+		t.Errorf("want nothing submitted without --yes, got %s", cli.submitted)
+	}
+
+	expectOutput(t, cli, "error", "trust remove-all mo --merge-to viewer --nosubmit")
+
+	// The merge has the command's memo
+	got := cli.TestCommand("trust remove-all mo --merge-to viewer --memotext closing --nosubmit --yes")
+	var envelope xdr.TransactionEnvelope
+	if err := xdr.SafeUnmarshalBase64(strings.Fields(got)[0], &envelope); err != nil {
+		t.Fatalf("can't decode transaction %q: %v", got, err)
+	}
+
+	if tx := envelope.Tx; tx.SeqNum != 1 || tx.Memo.Text == nil || *tx.Memo.Text != "closing" {
+		t.Errorf("want merge with sequence number 1 and memo closing, got %+v", tx)
+	}
+}
+
+func TestTrustlinesToRemove(t *testing.T) {
+	issuer := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+	account := &microstellar.Account{
+		Balances: []microstellar.Balance{
+			{Asset: microstellar.NativeAsset, Amount: "100.0000000"},
+			{Asset: microstellar.NewAsset("USD", issuer, microstellar.Credit4Type), Amount: "0.0000000"},
+			{Asset: microstellar.NewAsset("EUR", issuer, microstellar.Credit4Type), Amount: "0.0000001"},
+			{Asset: microstellar.NewAsset("BANANAS", issuer, microstellar.Credit12Type), Amount: "0.0000000"},
+		},
+	}
+
+	assets, nonZero := trustlinesToRemove(account)
+	if len(assets) != 2 || assets[0].Code != "USD" || assets[1].Code != "BANANAS" {
+		t.Errorf("want USD and BANANAS removed, got %+v", assets)
+	}
+
+	if len(nonZero) != 1 || nonZero[0] != "0.0000001 EUR:"+issuer {
+		t.Errorf("want EUR balance listed, got %v", nonZero)
+	}
 }
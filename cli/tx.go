@@ -1,21 +1,29 @@
 package cli
 
 import (
-	"encoding/json"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/0xfe/microstellar"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/stellar/go/amount"
+	"github.com/stellar/go/network"
+	"github.com/stellar/go/xdr"
 )
 
 func (cli *CLI) buildTxCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "tx [sign|submit] [base64-encoded string] --signers seed1,seed2...",
+		Use:   "tx [sign|submit|decode|bump-seq|show|estimate] [base64-encoded string] --signers seed1,seed2...",
 		Short: "handle base64 encoded transactions",
 		Args:  cobra.MinimumNArgs(2),
 		Run: func(cmd *cobra.Command, args []string) {
 			if len(args) > 0 {
-				showError(logrus.Fields{"cmd": "tx"}, "unrecognized tx command: %s, expecting: sign|submit", args[0])
+				showError(logrus.Fields{"cmd": "tx"}, "unrecognized tx command: %s, expecting: sign|submit|decode|bump-seq|show|estimate", args[0])
 				return
 			}
 		},
@@ -24,6 +32,9 @@ func (cli *CLI) buildTxCmd() *cobra.Command {
 	cmd.AddCommand(cli.buildTxSignCmd())
 	cmd.AddCommand(cli.buildTxSubmitCmd())
 	cmd.AddCommand(cli.buildTxDecodeCmd())
+	cmd.AddCommand(cli.buildTxBumpSeqCmd())
+	cmd.AddCommand(cli.buildTxShowCmd())
+	cmd.AddCommand(cli.buildTxEstimateCmd())
 
 	return cmd
 }
@@ -84,25 +95,53 @@ func (cli *CLI) buildTxSignCmd() *cobra.Command {
 
 func (cli *CLI) buildTxSubmitCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "submit [base64-encoded transaction]",
+		Use:   "submit [base64-encoded transaction] [--pretty]",
 		Short: "submit the supplied transaction to the current network",
 		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			b64tx := args[0]
 
 			logFields := logrus.Fields{"cmd": "submit"}
+
+			maxFee, err := getMaxFeeTotal(cmd)
+			if err != nil {
+				cli.error(logFields, "%v", err)
+				return
+			}
+
+			if maxFee > 0 {
+				if err := checkFeeTotal(b64tx, maxFee); err != nil {
+					cli.errorWithCode(txExitCode(err), logFields, "not submitting: %v", err)
+					return
+				}
+			}
+
+			if err := cli.checkBeforeSubmit(logFields, b64tx); err != nil {
+				cli.errorWithCode(txExitCode(err), logFields, "not submitting: %v", cli.errorString(err))
+				return
+			}
+
+			cli.submitted = b64tx
 			resp, err := cli.ms.SubmitTransaction(b64tx)
 
 			if err != nil {
-				cli.error(logFields, "submit error: %v", microstellar.ErrorString(err))
+				cli.errorWithCode(txExitCode(err), logFields, "submit error: %v", cli.errorString(err))
+				return
+			}
+
+			pretty, _ := cmd.Flags().GetBool("pretty")
+			respJSON, err := marshalJSON(*resp, pretty)
+			if err != nil {
+				cli.error(logFields, "can't encode response: %v", err)
 				return
 			}
 
-			respJSON, _ := json.MarshalIndent(*resp, "", "  ")
 			showSuccess(string(respJSON))
 		},
 	}
 
+	buildMaxFeeFlag(cmd)
+	buildPrettyFlag(cmd)
 	return cmd
 }
 
@@ -130,3 +169,495 @@ func (cli *CLI) buildTxDecodeCmd() *cobra.Command {
 	cmd.Flags().Bool("pretty", false, "format JSON output")
 	return cmd
 }
+
+func (cli *CLI) buildTxShowCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "show [hash] [--with-note] [--include-failed]",
+		Short: "display the transaction with [hash] in JSON, as horizon returns it",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			hash := strings.ToLower(args[0])
+			logFields := logrus.Fields{"cmd": "tx", "subcmd": "show"}
+
+			if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != 32 {
+				cli.error(logFields, "bad transaction hash: %s", args[0])
+				return
+			}
+
+			tx := map[string]interface{}{}
+			if err := cli.getHorizonJSON(logFields, "/transactions/"+hash, &tx); err != nil {
+				cli.errorWithCode(ExitNetworkError, logFields, "can't load transaction %s: %v", hash, cli.errorString(err))
+				return
+			}
+
+			delete(tx, "_links")
+
+			// Horizons before failed transactions were recorded don't have "successful"
+			if successful, ok := tx["successful"].(bool); ok && !successful {
+				resultXDR, _ := tx["result_xdr"].(string)
+				codes, err := decodeResultCodes(resultXDR)
+				if err != nil {
+					debugf(logFields, "can't decode result of %s: %v", hash, err)
+					codes = &txResultCodes{Transaction: "unknown"}
+				}
+
+				if includeFailed, _ := cmd.Flags().GetBool("include-failed"); !includeFailed {
+					cli.errorWithCode(ExitTxFailed, logFields, "transaction %s failed: %s, use --include-failed to show it", hash, codes)
+					return
+				}
+
+				tx["result_codes"] = codes
+			}
+
+			// The note never leaves the local store, see pay --memo-note
+			if withNote, _ := cmd.Flags().GetBool("with-note"); withNote {
+				if note, err := cli.GetVar(txNoteKey(hash)); err == nil {
+					tx["note"] = note
+				}
+			}
+
+			pretty, _ := cmd.Flags().GetBool("pretty")
+			data, err := marshalJSON(tx, pretty)
+			if err != nil {
+				cli.error(logFields, "can't encode transaction: %v", err)
+				return
+			}
+
+			showSuccess(string(data))
+		},
+	}
+
+	cmd.Flags().Bool("with-note", false, "include the local note saved with pay --memo-note, as \"note\"")
+	cmd.Flags().Bool("include-failed", false, "show the transaction even if it failed, with its result codes as \"result_codes\"")
+	buildPrettyFlag(cmd)
+	return cmd
+}
+
+// opSubentries is the change in the source's subentries (and so, base reserves) for
+// each operation that tx estimate can simulate.
+var opSubentries = map[string]int64{
+	"payment":        0,
+	"path_payment":   0,
+	"create_account": 0,
+	"bump_seq":       0,
+	"set_options":    0,
+	"trust":          1,
+	"trust_remove":   -1,
+	"offer":          1,
+	"offer_remove":   -1,
+	"signer":         1,
+	"signer_remove":  -1,
+	"data":           1,
+	"data_remove":    -1,
+}
+
+// txEstimate is what a transaction would cost its source, in stroops.
+type txEstimate struct {
+	Ops           int
+	Fee           int64
+	Subentries    int64
+	MinimumBefore int64
+	MinimumAfter  int64
+
+	// Available is what's left of the balance above the new minimum balance, after
+	// spending, and paying the fee. It's negative if the balance isn't enough.
+	Available int64
+}
+
+// estimateTx estimates the cost of a transaction with ops (see opSubentries) that
+// spends spend stroops of XLM, from a source with reserve.
+func estimateTx(reserve *nativeReserve, ops []string, spend int64) (*txEstimate, error) {
+	if len(ops) == 0 || len(ops) > maxOpsPerTx {
+		return nil, errors.Errorf("need 1 to %d operations, got %d", maxOpsPerTx, len(ops))
+	}
+
+	estimate := &txEstimate{Ops: len(ops), Fee: int64(len(ops)) * reserve.BaseFee}
+	for _, op := range ops {
+		change, ok := opSubentries[op]
+		if !ok {
+			return nil, errors.Errorf("unknown operation: %s", op)
+		}
+
+		estimate.Subentries += change
+	}
+
+	after := *reserve
+	after.Subentries += estimate.Subentries
+	if after.Subentries < 0 {
+		return nil, errors.Errorf("can't remove %d subentries, there are only %d", -estimate.Subentries, reserve.Subentries)
+	}
+
+	estimate.MinimumBefore = reserve.minimumBalance()
+	estimate.MinimumAfter = after.minimumBalance()
+	estimate.Available = reserve.Balance - spend - estimate.Fee - estimate.MinimumAfter
+
+	return estimate, nil
+}
+
+func (cli *CLI) buildTxEstimateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "estimate [account] --simulate op1,op2... [--amount XLM]",
+		Aliases: []string{"inspect-fee"},
+		Short:   "estimate the fee and reserve change of a transaction from [account] with these operations, and check its balance covers them",
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			name := args[0]
+			logFields := logrus.Fields{"cmd": "tx", "subcmd": "estimate"}
+
+			ops, _ := cmd.Flags().GetStringSlice("simulate")
+			if len(ops) == 0 {
+				cli.error(logFields, "need --simulate with the operations to estimate, e.g., payment,trust")
+				return
+			}
+
+			spendFlag, _ := cmd.Flags().GetString("amount")
+			spend, err := amount.ParseInt64(spendFlag)
+			if err != nil || spend < 0 {
+				cli.error(logFields, "bad --amount: %s", spendFlag)
+				return
+			}
+
+			address, err := cli.ResolveAccount(logFields, name, "address")
+			if err != nil {
+				cli.error(logFields, "invalid account: %s", name)
+				return
+			}
+
+			if microstellar.ValidSeed(address) == nil {
+				address = addressFromSeed(address)
+			}
+
+			reserve, err := cli.loadNativeReserve(logFields, address)
+			if err != nil {
+				cli.errorWithCode(ExitNetworkError, logFields, "can't load reserve of %s: %v", name, cli.errorString(err))
+				return
+			}
+
+			estimate, err := estimateTx(reserve, ops, spend)
+			if err != nil {
+				cli.error(logFields, "can't estimate: %v", err)
+				return
+			}
+
+			showSuccess("operations: %d (%s)", estimate.Ops, strings.Join(ops, ", "))
+			showSuccess("fee: %s XLM (%d x %d stroops)", amount.StringFromInt64(estimate.Fee), estimate.Ops, reserve.BaseFee)
+			showSuccess("reserve change: %s XLM (%+d subentries)", signedAmount(estimate.MinimumAfter-estimate.MinimumBefore), estimate.Subentries)
+			showSuccess("minimum balance: %s XLM -> %s XLM", amount.StringFromInt64(estimate.MinimumBefore), amount.StringFromInt64(estimate.MinimumAfter))
+			showSuccess("balance: %s XLM, available after: %s XLM", amount.StringFromInt64(reserve.Balance), strings.TrimPrefix(signedAmount(estimate.Available), "+"))
+
+			if estimate.Available < 0 {
+				cli.errorWithCode(ExitBelowMin, logFields, "not enough XLM in %s: short by %s XLM", name, amount.StringFromInt64(-estimate.Available))
+			}
+		},
+	}
+
+	cmd.Flags().StringSlice("simulate", []string{}, "the operations, comma separated: payment, path_payment, create_account, trust, offer, signer, data (or their _remove versions), bump_seq, set_options")
+	cmd.Flags().String("amount", "0", "the XLM the operations spend (e.g., payment or starting balances)")
+	return cmd
+}
+
+// signedAmount formats stroops as an amount, with a sign.
+func signedAmount(stroops int64) string {
+	if stroops < 0 {
+		return "-" + amount.StringFromInt64(-stroops)
+	}
+
+	return "+" + amount.StringFromInt64(stroops)
+}
+
+func (cli *CLI) buildTxBumpSeqCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bump-seq [account] [sequence] [--signers seed1,seed2...]",
+		Short: "bump the sequence number of [account] to [sequence], invalidating transactions with lower ones",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			name := args[0]
+			logFields := logrus.Fields{"cmd": "tx", "subcmd": "bump-seq"}
+
+			bumpTo, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil || bumpTo <= 0 {
+				cli.error(logFields, "bad sequence number: %s", args[1])
+				return
+			}
+
+			params, err := cli.parseTxParams(cmd, logFields, name)
+			if err != nil {
+				cli.error(logFields, "can't generate transaction: %v", err)
+				return
+			}
+
+			address, err := cli.ResolveAccount(logFields, name, "address")
+			if err != nil {
+				cli.error(logFields, "invalid account: %s", name)
+				return
+			}
+
+			if microstellar.ValidSeed(address) == nil {
+				address = addressFromSeed(address)
+			}
+
+			var seeds []string
+			if len(params.signers) == 0 && !params.nosign {
+				seed, err := cli.ResolveAccount(logFields, name, "seed")
+				if err != nil || microstellar.ValidSeed(seed) != nil {
+					cli.error(logFields, "no seed found in %s", name)
+					return
+				}
+
+				seeds = append(seeds, seed)
+			}
+
+			current, err := cli.currentSequence(address, params)
+			if err != nil {
+				cli.errorWithCode(ExitNetworkError, logFields, "can't load sequence number of %s: %v", name, cli.errorString(err))
+				return
+			}
+
+			// There's no sequence number to check on the fake network
+			if current > 0 && bumpTo <= current {
+				cli.error(logFields, "sequence number must be greater than the current one (%d): %d", current, bumpTo)
+				return
+			}
+
+			tx, err := bumpSequenceTx(address, current+1, bumpTo, params)
+			if err != nil {
+				cli.error(logFields, "can't build transaction: %v", err)
+				return
+			}
+
+			debugf(logFields, "bumping sequence number of %s from %d to %d", address, current, bumpTo)
+			if err := cli.submitTx(logFields, tx, params, seeds); err != nil {
+				cli.errorWithCode(txExitCode(err), logFields, "failed to bump sequence number: %v", cli.errorString(err))
+				return
+			}
+		},
+	}
+
+	buildFlagsForTxParams(cmd)
+	return cmd
+}
+
+// txHash returns the hex-encoded hash of the base64-encoded transaction envelope
+// b64tx, signed for the network with passphrase. This is the ID horizon uses for it.
+func txHash(b64tx, passphrase string) (string, error) {
+	var envelope xdr.TransactionEnvelope
+	if err := xdr.SafeUnmarshalBase64(b64tx, &envelope); err != nil {
+		return "", errors.Wrap(err, "can't decode transaction")
+	}
+
+	hash, err := network.HashTransaction(&envelope.Tx, passphrase)
+	if err != nil {
+		return "", errors.Wrap(err, "can't hash transaction")
+	}
+
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// submittedHash returns the hash of the transaction the current command submitted,
+// or "" if it didn't submit one (e.g., with --nosubmit, or on the fake network.)
+func (cli *CLI) submittedHash() (string, error) {
+	if cli.submitted == "" || cli.submittedID != "" {
+		return cli.submittedID, nil
+	}
+
+	return txHash(cli.submitted, cli.networkPassphrase())
+}
+
+// txNoteKey is where the local note for the transaction with hash is kept, in the
+// current namespace (see pay --memo-note.)
+func txNoteKey(hash string) string {
+	return "tx:" + hash + ":note"
+}
+
+// recordTxNote saves note locally, keyed by the hash of the transaction the current
+// command submitted. Nothing is saved if nothing was submitted.
+func (cli *CLI) recordTxNote(logFields logrus.Fields, note string) error {
+	hash, err := cli.submittedHash()
+	if err != nil || hash == "" {
+		debugf(logFields, "no transaction submitted, not saving note")
+		return err
+	}
+
+	debugf(logFields, "saving note for transaction %s", hash)
+	return cli.SetVar(txNoteKey(hash), note)
+}
+
+// baseFee is the fee per operation, in stroops, of the transactions lumen builds
+// itself. It's the one microstellar uses for the rest.
+const baseFee = 100
+
+// bumpSequenceTx returns an unsigned base64-encoded transaction from address (with
+// sequence number seq) that bumps its sequence number to bumpTo. microstellar has
+// no bump sequence operation, so this builds the XDR directly.
+func bumpSequenceTx(address string, seq, bumpTo int64, params *txParams) (string, error) {
+	return singleOpTx(address, seq, params, xdr.OperationTypeBumpSequence, xdr.BumpSequenceOp{BumpTo: xdr.SequenceNumber(bumpTo)})
+}
+
+// accountMergeTx returns an unsigned base64-encoded transaction from address (with
+// sequence number seq) that merges it into destination. Like bumpSequenceTx, it
+// builds the XDR directly, since microstellar has no account merge operation.
+func accountMergeTx(address string, seq int64, destination string, params *txParams) (string, error) {
+	var dest xdr.AccountId
+	if err := dest.SetAddress(destination); err != nil {
+		return "", errors.Wrapf(err, "bad destination: %s", destination)
+	}
+
+	return singleOpTx(address, seq, params, xdr.OperationTypeAccountMerge, dest)
+}
+
+// singleOpTx returns an unsigned base64-encoded transaction from address (with
+// sequence number seq) with one operation of type opType, and the memo and time
+// bounds in params, if any.
+func singleOpTx(address string, seq int64, params *txParams, opType xdr.OperationType, value interface{}) (string, error) {
+	var source xdr.AccountId
+	if err := source.SetAddress(address); err != nil {
+		return "", errors.Wrapf(err, "bad address: %s", address)
+	}
+
+	body, err := xdr.NewOperationBody(opType, value)
+	if err != nil {
+		return "", errors.Wrap(err, "can't build operation")
+	}
+
+	tx := xdr.Transaction{
+		SourceAccount: source,
+		Fee:           baseFee,
+		SeqNum:        xdr.SequenceNumber(seq),
+		Operations:    []xdr.Operation{{Body: body}},
+	}
+
+	if params != nil {
+		tx.Memo = params.memo
+		tx.TimeBounds = params.timeBounds
+	}
+
+	return xdr.MarshalBase64(xdr.TransactionEnvelope{Tx: tx})
+}
+
+// currentSequence returns the sequence number of address, i.e., one less than the
+// next transaction's: the one before --sequence if it's set, 0 on the fake network,
+// or else the one horizon has.
+func (cli *CLI) currentSequence(address string, params *txParams) (int64, error) {
+	if params.sequence > 0 {
+		return params.sequence - 1, nil
+	}
+
+	if cli.horizonURL() == "" {
+		return 0, nil
+	}
+
+	account, err := cli.ms.LoadAccount(address)
+	if err != nil {
+		return 0, errors.Wrap(err, "can't load account")
+	}
+
+	current, err := strconv.ParseInt(account.Sequence, 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("bad sequence number: %s", account.Sequence)
+	}
+
+	return current, nil
+}
+
+// submitTx signs the base64-encoded transaction b64tx (see singleOpTx) with the
+// signers in params, or else seeds, and submits it with the checks microstellar's
+// transactions get (see txOptions.) With --nosubmit, it's shown instead.
+func (cli *CLI) submitTx(logFields logrus.Fields, b64tx string, params *txParams, seeds []string) error {
+	if params.maxFee > 0 {
+		if err := checkFeeTotal(b64tx, params.maxFee); err != nil {
+			return err
+		}
+	}
+
+	if len(params.signers) > 0 {
+		seeds = params.signers
+	}
+
+	signedTx := b64tx
+	if !params.nosign {
+		for _, seed := range seeds {
+			if microstellar.ValidSeed(seed) != nil {
+				return errors.Errorf("can't sign: bad signer seed")
+			}
+		}
+
+		var err error
+		if signedTx, err = cli.ms.SignTransaction(b64tx, seeds...); err != nil {
+			return errors.Wrap(err, "signing error")
+		}
+	}
+
+	if noSubmit, _ := cli.rootCmd.Flags().GetBool("nosubmit"); noSubmit {
+		showSuccess(signedTx)
+		return nil
+	}
+
+	if err := cli.checkBeforeSubmit(logFields, signedTx); err != nil {
+		return err
+	}
+
+	cli.submitted = signedTx
+	_, err := cli.ms.SubmitTransaction(signedTx)
+	return err
+}
+
+func (cli *CLI) buildDecodeXDRCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "decode-xdr [tx|txresult|txmeta] [base64-encoded XDR|-] [--pretty]",
+		Short: "display a base64-encoded XDR transaction, result, or meta in JSON. Use - to read from stdin",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			xdrType := args[0]
+			b64 := args[1]
+
+			logFields := logrus.Fields{"cmd": "decode-xdr"}
+			pretty, _ := cmd.Flags().GetBool("pretty")
+
+			if b64 == "-" {
+				data, err := ioutil.ReadAll(os.Stdin)
+				if err != nil {
+					cli.error(logFields, "can't read stdin: %v", err)
+					return
+				}
+				b64 = strings.TrimSpace(string(data))
+			}
+
+			var decoded interface{}
+
+			switch xdrType {
+			case "tx":
+				txe, err := microstellar.DecodeTxToJSON(b64, pretty)
+				if err != nil {
+					cli.error(logFields, "decode error: %v", microstellar.ErrorString(err))
+					return
+				}
+
+				showSuccess(txe)
+				return
+			case "txresult":
+				decoded = &xdr.TransactionResult{}
+			case "txmeta":
+				decoded = &xdr.TransactionMeta{}
+			default:
+				cli.error(logFields, "unrecognized XDR type: %s, expecting: tx|txresult|txmeta", xdrType)
+				return
+			}
+
+			if err := xdr.SafeUnmarshalBase64(b64, decoded); err != nil {
+				cli.error(logFields, "decode error: %v", err)
+				return
+			}
+
+			data, err := marshalJSON(decoded, pretty)
+			if err != nil {
+				cli.error(logFields, "can't encode %s: %v", xdrType, err)
+				return
+			}
+
+			showSuccess(string(data))
+		},
+	}
+
+	buildPrettyFlag(cmd)
+	return cmd
+}
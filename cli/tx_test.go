@@ -0,0 +1,508 @@
+package cli
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stellar/go/xdr"
+)
+
+// Note: add -v to any of these commands to enable verbose logging
+
+func TestDecodeXDR(t *testing.T) {
+	cli, _ := newTestCLI()
+
+	// A successful payment, with a fee of 100 stroops
+	result := "AAAAAAAAAGQAAAAAAAAAAQAAAAAAAAABAAAAAAAAAAA="
+
+	got := cli.TestCommand("decode-xdr txresult " + result)
+	if !strings.Contains(got, `"FeeCharged":100`) {
+		t.Errorf("wrong txresult: %s", got)
+	}
+
+	if got := cli.TestCommand("decode-xdr txresult " + result + " --pretty"); !strings.Contains(got, `"FeeCharged": 100`) {
+		t.Errorf("wrong indented txresult: %s", got)
+	}
+
+	expectOutput(t, cli, "error", "decode-xdr txresult notbase64!")
+	expectOutput(t, cli, "error", "decode-xdr ledger "+result)
+
+	// Read from stdin
+	r, w, _ := os.Pipe()
+	w.WriteString(result + "\n")
+	w.Close()
+
+	oldStdin := os.Stdin
+	os.Stdin = r
+	got = cli.TestCommand("decode-xdr txresult -")
+	os.Stdin = oldStdin
+
+	if !strings.Contains(got, `"FeeCharged":100`) {
+		t.Errorf("wrong txresult from stdin: %s", got)
+	}
+}
+
+func TestTxBumpSeq(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account new kelly")
+	cli.TestCommand("account set viewer GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+
+	expectOutput(t, cli, "", "tx bump-seq mo 1000")
+	expectOutput(t, cli, "", "tx bump-seq mo 1000 --signers kelly")
+	expectOutput(t, cli, "error", "tx bump-seq mo 0")
+	expectOutput(t, cli, "error", "tx bump-seq mo next")
+	expectOutput(t, cli, "error", "tx bump-seq nobody 1000")
+	expectOutput(t, cli, "error", "tx bump-seq viewer 1000")
+	expectOutput(t, cli, "", "tx bump-seq viewer 1000 --signers mo")
+
+	address := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+	tx, err := bumpSequenceTx(address, 42, 1000, nil)
+	if err != nil {
+		t.Fatalf("bumpSequenceTx: %v", err)
+	}
+
+	var envelope xdr.TransactionEnvelope
+	if err := xdr.SafeUnmarshalBase64(tx, &envelope); err != nil {
+		t.Fatalf("can't decode transaction: %v", err)
+	}
+
+	if envelope.Tx.SeqNum != 42 || len(envelope.Tx.Operations) != 1 {
+		t.Fatalf("want one operation with sequence number 42, got %+v", envelope.Tx)
+	}
+
+	body := envelope.Tx.Operations[0].Body
+	if body.Type != xdr.OperationTypeBumpSequence || body.BumpSequenceOp.BumpTo != 1000 {
+		t.Errorf("want bump to 1000, got %+v", body)
+	}
+
+	if _, err := bumpSequenceTx("nobody", 42, 1000, nil); err == nil {
+		t.Errorf("want error for bad address")
+	}
+
+	// The transaction flags apply, as they do to the transactions microstellar builds
+	expectOutput(t, cli, "error", "tx bump-seq mo 1000 --memoid text")
+	expectOutput(t, cli, "error", "tx bump-seq mo 1000 --mintime 2030-01-01")
+	expectOutput(t, cli, "error", "tx bump-seq mo 1000 --sequence next")
+	expectOutput(t, cli, "error", "tx bump-seq mo 1000 --sequence 1001")
+
+	got := cli.Embeddable().Run("tx", "bump-seq", "mo", "1000", "--memotext", "rent", "--sequence", "7", "--nosubmit",
+		"--mintime", "2030-01-01 00:00:00", "--maxtime", "2030-01-02 00:00:00")
+
+	envelope = xdr.TransactionEnvelope{}
+	if err := xdr.SafeUnmarshalBase64(strings.TrimSpace(got), &envelope); err != nil {
+		t.Fatalf("can't decode transaction %q: %v", got, err)
+	}
+
+	if tx := envelope.Tx; tx.SeqNum != 7 || tx.Fee != 100 || tx.Memo.Text == nil || *tx.Memo.Text != "rent" {
+		t.Errorf("want sequence number 7, fee 100 and memo rent, got %+v", tx)
+	}
+
+	if bounds := envelope.Tx.TimeBounds; bounds == nil || bounds.MinTime != 1893456000 || bounds.MaxTime != 1893542400 {
+		t.Errorf("want time bounds from --mintime and --maxtime, got %+v", bounds)
+	}
+}
+
+func TestMaxFeeTotal(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+
+	cli.TestCommand("account new mo")
+	cli.TestCommand("account new kelly")
+
+	expectOutput(t, cli, "", "tx bump-seq mo 1000 --max-fee-total 100")
+	expectOutput(t, cli, "error", "tx bump-seq mo 1000 --max-fee-total 99")
+	expectOutput(t, cli, "error", "tx bump-seq mo 1000 --max-fee-total lots")
+	expectOutput(t, cli, "error", "pay 1 --from mo --to kelly --max-fee-total 0")
+	expectOutput(t, cli, "error", "pay 1 --from mo --to kelly --max-fee-total -100")
+	expectOutput(t, cli, "", "pay 1 --from mo --to kelly --max-fee-total 1000")
+
+	address := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+	tx, err := bumpSequenceTx(address, 42, 1000, nil)
+	if err != nil {
+		t.Fatalf("bumpSequenceTx: %v", err)
+	}
+
+	expectOutput(t, cli, "error", "tx submit "+tx+" --max-fee-total 50")
+
+	if err := checkFeeTotal(tx, 100); err != nil {
+		t.Errorf("want no error for fee at the cap, got: %v", err)
+	}
+
+	err = checkFeeTotal(tx, 99)
+	if err == nil || !strings.Contains(err.Error(), "100 stroops") || !strings.Contains(err.Error(), "--max-fee-total 99") {
+		t.Errorf("want total fee versus cap in error, got: %v", err)
+	}
+
+	if code := txExitCode(err); code != ExitBadArgs {
+		t.Errorf("want exit code %d for fee over the cap, got: %d", ExitBadArgs, code)
+	}
+
+	if err := checkFeeTotal("garbage", 100); err == nil {
+		t.Errorf("want error for bad transaction")
+	}
+}
+
+func TestNetworkPassphraseCheck(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new mo")
+
+	// Nothing to check on the fake network
+	expectOutput(t, cli, "", "tx bump-seq mo 1000")
+
+	networks := map[string]string{
+		"test":                         testNetworkPassphrase,
+		"public":                       publicNetworkPassphrase,
+		"custom;http://localhost;Mine": "Mine",
+		"fake":                         "",
+	}
+
+	for network, want := range networks {
+		cli.network = network
+		if got := cli.networkPassphrase(); got != want {
+			t.Errorf("%s: want passphrase %q, got %q", network, want, got)
+		}
+	}
+
+	checks := 0
+	server := newTestHorizon(cli, "Mine", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			checks++
+		}
+
+		fmt.Fprintf(w, `{"network_passphrase": "%s"}`, testNetworkPassphrase)
+	})
+	defer server.Close()
+
+	address := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+	tx, _ := bumpSequenceTx(address, 42, 1000, nil)
+
+	// Signed for another network
+	expectOutput(t, cli, "error", "tx submit "+tx)
+	if cli.exitCode != ExitBadArgs || checks != 1 {
+		t.Errorf("want refusal (exit code %d) after one check, got exit code %d after %d checks", ExitBadArgs, cli.exitCode, checks)
+	}
+
+	// On by default, and can be turned off per command or per namespace
+	cli.TestCommand("tx submit " + tx + " --skip-network-passphrase-check")
+	if checks != 1 {
+		t.Errorf("want no check with --skip-network-passphrase-check, got %d checks", checks)
+	}
+
+	cli.TestCommand("set config:network_passphrase_check false")
+	cli.TestCommand("tx submit " + tx)
+	if checks != 1 {
+		t.Errorf("want no check with config:network_passphrase_check false, got %d checks", checks)
+	}
+
+	expectOutput(t, cli, "error", "tx submit "+tx+" --network-passphrase-check")
+	if cli.exitCode != ExitBadArgs || checks != 2 {
+		t.Errorf("want refusal (exit code %d) with --network-passphrase-check, got exit code %d after %d checks", ExitBadArgs, cli.exitCode, checks)
+	}
+
+	cli.TestCommand("set config:network_passphrase_check true")
+	expectOutput(t, cli, "error", "tx submit "+tx)
+	if cli.exitCode != ExitBadArgs || checks != 3 {
+		t.Errorf("want refusal (exit code %d) after config check, got exit code %d after %d checks", ExitBadArgs, cli.exitCode, checks)
+	}
+
+	// The passphrases match, so it's submitted (and fails, since there's no real horizon)
+	cli.Embeddable().Run("set", "config:network", "custom;"+server.URL+";"+testNetworkPassphrase)
+	expectOutput(t, cli, "error", "tx submit "+tx)
+	if cli.exitCode == ExitBadArgs || checks != 4 {
+		t.Errorf("want submission after a passing check, got exit code %d after %d checks", cli.exitCode, checks)
+	}
+}
+
+func TestConfirmNetwork(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new mo")
+
+	expectOutput(t, cli, "", "tx bump-seq mo 1000 --confirm-network fake")
+
+	expectOutput(t, cli, "error", "tx bump-seq mo 1000 --confirm-network public")
+	if cli.exitCode != ExitBadArgs {
+		t.Errorf("want refusal (exit code %d), got exit code %d", ExitBadArgs, cli.exitCode)
+	}
+
+	address := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+	tx, _ := bumpSequenceTx(address, 42, 1000, nil)
+
+	// Custom networks match by type, whatever their URL
+	cli.TestCommand("set config:network custom;http://localhost:1;" + publicNetworkPassphrase)
+	expectOutput(t, cli, "error", "tx submit "+tx+" --confirm-network public")
+	if cli.exitCode != ExitBadArgs {
+		t.Errorf("want refusal (exit code %d), got exit code %d", ExitBadArgs, cli.exitCode)
+	}
+
+	// Submitted (and fails, since there's no horizon)
+	expectOutput(t, cli, "error", "tx submit "+tx+" --confirm-network custom")
+	if cli.exitCode == ExitBadArgs {
+		t.Errorf("want submission with matching --confirm-network, got exit code %d", cli.exitCode)
+	}
+
+	// Transactions that aren't submitted aren't checked
+	cli.TestCommand("set config:network fake")
+	if got := cli.TestCommand("pay 1 --from mo --to mo --nosubmit --confirm-network public"); got == "error\n" {
+		t.Errorf("want --nosubmit transaction, got %q", got)
+	}
+}
+
+func TestFeeAccountBalanceCheck(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new mo")
+
+	// No balances to check on the fake network
+	expectOutput(t, cli, "", "tx bump-seq mo 1000 --fee-account-balance-check")
+
+	// 1.00001 XLM with no subentries and a 0.5 XLM base reserve: 1000 stroops above
+	// the reserve
+	server := newTestHorizon(cli, testNetworkPassphrase, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ledgers":
+			w.Write([]byte(`{"_embedded": {"records": [{"base_fee_in_stroops": 100, "base_reserve_in_stroops": 5000000}]}}`))
+		default:
+			w.Write([]byte(`{"id": "mo", "balances": [{"balance": "1.0001000", "asset_type": "native"}]}`))
+		}
+	})
+	defer server.Close()
+
+	address := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+	tx, _ := bumpSequenceTx(address, 42, 1000, nil)
+
+	check := func(flags ...string) error {
+		cli.Embeddable()
+		cli.rootCmd.ParseFlags(flags)
+		return cli.checkFeeAccountBalance(nil, tx)
+	}
+
+	if err := check(); err != nil {
+		t.Errorf("want no check without --fee-account-balance-check, got: %v", err)
+	}
+
+	if err := check("--fee-account-balance-check"); err != nil {
+		t.Errorf("want 100 stroop fee to pass, got: %v", err)
+	}
+
+	// 1000 stroops in selling liabilities leave nothing for the fee
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ledgers":
+			w.Write([]byte(`{"_embedded": {"records": [{"base_fee_in_stroops": 100, "base_reserve_in_stroops": 5000000}]}}`))
+		default:
+			w.Write([]byte(`{"id": "mo", "balances": [{"balance": "1.0001000", "asset_type": "native", "selling_liabilities": "0.0001000"}]}`))
+		}
+	})
+
+	err := check("--fee-account-balance-check")
+	if _, ok := err.(*feeBalanceError); !ok {
+		t.Fatalf("want feeBalanceError, got: %v", err)
+	}
+
+	if !strings.Contains(err.Error(), "0.0000100 XLM short") {
+		t.Errorf("want shortfall in error, got: %v", err)
+	}
+
+	if code := txExitCode(err); code != ExitBelowMin {
+		t.Errorf("want exit code %d, got %d", ExitBelowMin, code)
+	}
+
+	// Also enabled by config:fee_account_balance_check
+	cli.TestCommand("set config:fee_account_balance_check true")
+	if err := check(); err == nil {
+		t.Error("want config:fee_account_balance_check to enable the check")
+	}
+}
+
+func TestTxShowWithNote(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new master")
+	cli.TestCommand("account new worker")
+
+	expectOutput(t, cli, "error", "pay 4 --from master --to worker --memo-note rent --batch")
+	expectOutput(t, cli, "error", "pay 4 --from master --to a,b --split --memo-note rent")
+	expectOutput(t, cli, "error", "tx show nothing")
+	expectOutput(t, cli, "error", "tx show abcd")
+
+	// Nothing is submitted on the fake network, so there's nothing to save
+	expectOutput(t, cli, "", "pay 4 --from master --to worker --memohash aGVsbG8= --memo-note rent")
+
+	tx, err := bumpSequenceTx("GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM", 1, 2, nil)
+	if err != nil {
+		t.Fatalf("can't build transaction: %v", err)
+	}
+
+	cli.submitted = tx
+	if err := cli.recordTxNote(nil, "march rent"); err != nil {
+		t.Fatalf("can't save note: %v", err)
+	}
+
+	hash, _ := txHash(tx, cli.networkPassphrase())
+	expectOutput(t, cli, "{}", "tx show "+hash)
+	expectOutput(t, cli, `{"note":"march rent"}`, "tx show "+strings.ToUpper(hash)+" --with-note")
+
+	// Notes are per namespace
+	cli.TestCommand("ns other")
+	cli.TestCommand("set config:network fake")
+	expectOutput(t, cli, "{}", "tx show "+hash+" --with-note")
+	cli.TestCommand("ns default")
+
+	var paths []string
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		fmt.Fprintf(w, `{"_links": {"self": {"href": "x"}}, "hash": "%s", "memo_type": "hash"}`, hash)
+	})
+	defer server.Close()
+
+	want := fmt.Sprintf(`{"hash":"%s","memo_type":"hash","note":"march rent"}`, hash)
+	expectOutput(t, cli, want, "tx show "+hash+" --with-note")
+
+	if len(paths) != 1 || paths[0] != "/transactions/"+hash {
+		t.Errorf("want one request for the transaction, got %v", paths)
+	}
+}
+
+func TestTxEstimate(t *testing.T) {
+	// 100 XLM, with 3 subentries and 10 XLM in selling liabilities: the minimum balance
+	// is (2 + 3) * 0.5 + 10 = 12.5 XLM
+	reserve := &nativeReserve{Balance: 1000000000, Subentries: 3, SellingLiabilities: 100000000, BaseReserve: 5000000, BaseFee: 100}
+
+	estimate, err := estimateTx(reserve, []string{"payment", "trust", "offer", "data_remove"}, 500000000)
+	if err != nil {
+		t.Fatalf("estimateTx: %v", err)
+	}
+
+	want := txEstimate{Ops: 4, Fee: 400, Subentries: 1, MinimumBefore: 125000000, MinimumAfter: 130000000, Available: 369999600}
+	if *estimate != want {
+		t.Errorf("want %+v, got %+v", want, *estimate)
+	}
+
+	if estimate, err := estimateTx(reserve, []string{"payment"}, 900000000); err != nil || estimate.Available >= 0 {
+		t.Errorf("want a negative available balance spending 90 XLM, got %+v (%v)", estimate, err)
+	}
+
+	for _, ops := range [][]string{nil, {"payment", "burn"}, {"trust_remove", "trust_remove", "trust_remove", "trust_remove"}} {
+		if _, err := estimateTx(reserve, ops, 0); err == nil {
+			t.Errorf("want error for operations %v", ops)
+		}
+	}
+
+	cli, _ := newTestCLI()
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ledgers":
+			w.Write([]byte(`{"_embedded": {"records": [{"base_fee_in_stroops": 100, "base_reserve_in_stroops": 5000000}]}}`))
+		default:
+			w.Write([]byte(`{"id": "mo", "subentry_count": 3, "balances": [
+				{"balance": "100.0000000", "asset_type": "native", "selling_liabilities": "10.0000000"}]}`))
+		}
+	})
+	defer server.Close()
+
+	cli.TestCommand("account set mo GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM")
+
+	expectOutput(t, cli, "operations: 2 (payment, trust)\n"+
+		"fee: 0.0000200 XLM (2 x 100 stroops)\n"+
+		"reserve change: +0.5000000 XLM (+1 subentries)\n"+
+		"minimum balance: 12.5000000 XLM -> 13.0000000 XLM\n"+
+		"balance: 100.0000000 XLM, available after: 36.9999800 XLM",
+		"tx estimate mo --simulate payment,trust --amount 50")
+
+	cli.TestCommand("tx inspect-fee mo --simulate payment --amount 90")
+	if code := cli.ExitCode(); code != ExitBelowMin {
+		t.Errorf("want exit code %d for too low a balance, got %d", ExitBelowMin, code)
+	}
+
+	expectOutput(t, cli, "error", "tx estimate mo")
+	expectOutput(t, cli, "error", "tx estimate mo --simulate burn")
+	expectOutput(t, cli, "error", "tx estimate mo --simulate payment --amount lots")
+}
+
+// resultXDR returns a base64-encoded TransactionResult with code, and op_inner
+// results with the given operation types and codes.
+func resultXDR(code int32, ops ...[2]int32) string {
+	data := make([]byte, 8)
+	put := func(val int32) {
+		data = append(data, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(data[len(data)-4:], uint32(val))
+	}
+
+	put(code)
+	if code == 0 || code == -1 {
+		put(int32(len(ops)))
+		for _, op := range ops {
+			put(0)
+			put(op[0])
+			put(op[1])
+		}
+	}
+
+	put(0)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func TestDecodeResultCodes(t *testing.T) {
+	tests := []struct {
+		result string
+		want   string
+	}{
+		{resultXDR(-5), "tx_bad_seq"},
+		{resultXDR(0, [2]int32{1, 0}), "tx_success (op_success)"},
+		{resultXDR(-1, [2]int32{0, 0}, [2]int32{1, -2}), "tx_failed (op_success, op_underfunded)"},
+		{resultXDR(-1, [2]int32{8, -4}), "tx_failed (op_has_sub_entries)"},
+		{resultXDR(-1, [2]int32{6, -4}, [2]int32{99, -1}), "tx_failed (op_low_reserve, op_code_-1)"},
+		{resultXDR(-99), "tx_code_-99"},
+
+		// Successful path payments have the offers they took, which aren't decoded, so
+		// the operations after them are unknown
+		{resultXDR(-1, [2]int32{2, 0}, [2]int32{1, -5}), "tx_failed (op_success)"},
+	}
+
+	for _, test := range tests {
+		codes, err := decodeResultCodes(test.result)
+		if err != nil {
+			t.Errorf("got error for %s: %v", test.want, err)
+			continue
+		}
+
+		if got := codes.String(); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+
+	for _, bad := range []string{"", "AAAA", "not base64"} {
+		if _, err := decodeResultCodes(bad); err == nil {
+			t.Errorf("want error for %q", bad)
+		}
+	}
+}
+
+func TestTxShowIncludeFailed(t *testing.T) {
+	cli, _ := newTestCLI()
+	hash := strings.Repeat("ab", 32)
+
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"hash": "%s", "successful": false, "result_xdr": "%s"}`, hash, resultXDR(-1, [2]int32{1, -5}))
+	})
+	defer server.Close()
+
+	expectOutput(t, cli, "error", "tx show "+hash)
+	if cli.ExitCode() != ExitTxFailed {
+		t.Errorf("want exit code %d, got %d", ExitTxFailed, cli.ExitCode())
+	}
+
+	want := fmt.Sprintf(`{"hash":"%s","result_codes":{"transaction":"tx_failed","operations":["op_no_destination"]},"result_xdr":"%s","successful":false}`,
+		hash, resultXDR(-1, [2]int32{1, -5}))
+	expectOutput(t, cli, want, "tx show "+hash+" --include-failed")
+}
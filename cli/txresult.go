@@ -0,0 +1,238 @@
+package cli
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// txResultCodes are the result codes of a transaction, named like horizon names them in
+// the result_codes of failed submissions.
+type txResultCodes struct {
+	Transaction string   `json:"transaction"`
+	Operations  []string `json:"operations,omitempty"`
+}
+
+// String returns the codes as "tx_failed (op_success, op_underfunded)".
+func (codes *txResultCodes) String() string {
+	if len(codes.Operations) == 0 {
+		return codes.Transaction
+	}
+
+	return codes.Transaction + " (" + strings.Join(codes.Operations, ", ") + ")"
+}
+
+// txResultNames are the names of transaction result codes.
+var txResultNames = map[int32]string{
+	1:   "tx_fee_bump_inner_success",
+	0:   "tx_success",
+	-1:  "tx_failed",
+	-2:  "tx_too_early",
+	-3:  "tx_too_late",
+	-4:  "tx_missing_operation",
+	-5:  "tx_bad_seq",
+	-6:  "tx_bad_auth",
+	-7:  "tx_insufficient_balance",
+	-8:  "tx_no_source_account",
+	-9:  "tx_insufficient_fee",
+	-10: "tx_bad_auth_extra",
+	-11: "tx_internal_error",
+	-12: "tx_not_supported",
+	-13: "tx_fee_bump_inner_failed",
+	-14: "tx_bad_sponsorship",
+	-15: "tx_bad_minseq_age_or_gap",
+	-16: "tx_malformed",
+}
+
+// opResultNames are the names of operation result codes, other than op_inner (which
+// means the result is the operation's own, see opInnerResultNames.)
+var opResultNames = map[int32]string{
+	-1: "op_bad_auth",
+	-2: "op_no_source_account",
+	-3: "op_not_supported",
+	-4: "op_too_many_subentries",
+	-5: "op_exceeded_work_limit",
+	-6: "op_too_many_sponsoring",
+}
+
+var (
+	paymentResultNames = []string{"op_malformed", "op_underfunded", "op_src_no_trust", "op_src_not_authorized",
+		"op_no_destination", "op_no_trust", "op_not_authorized", "op_line_full", "op_no_issuer"}
+	offerResultNames = []string{"op_malformed", "op_sell_no_trust", "op_buy_no_trust", "op_sell_not_authorized",
+		"op_buy_not_authorized", "op_line_full", "op_underfunded", "op_cross_self", "op_sell_no_issuer",
+		"op_buy_no_issuer", "op_offer_not_found", "op_low_reserve"}
+)
+
+// opInnerResultNames are the names of the failure codes (-1, -2, ...) of common
+// operations, by operation type.
+var opInnerResultNames = map[int32][]string{
+	0:  {"op_malformed", "op_underfunded", "op_low_reserve", "op_already_exists"},
+	1:  paymentResultNames,
+	2:  append(paymentResultNames, "op_too_few_offers", "op_cross_self", "op_over_source_max"),
+	3:  offerResultNames,
+	4:  offerResultNames,
+	5:  {"op_low_reserve", "op_too_many_signers", "op_bad_flags", "op_invalid_inflation", "op_cant_change", "op_unknown_flag", "op_threshold_out_of_range", "op_bad_signer", "op_invalid_home_domain"},
+	6:  {"op_malformed", "op_no_issuer", "op_invalid_limit", "op_low_reserve", "op_self_not_allowed"},
+	7:  {"op_malformed", "op_no_trustline", "op_not_required", "op_cant_revoke", "op_self_not_allowed"},
+	8:  {"op_malformed", "op_no_account", "op_immutable_set", "op_has_sub_entries", "op_seq_num_too_far", "op_dest_full", "op_is_sponsor"},
+	10: {"op_not_supported_yet", "op_data_name_not_found", "op_low_reserve", "op_data_invalid_name"},
+	11: {"op_bad_seq"},
+	12: offerResultNames,
+	13: append(paymentResultNames, "op_too_few_offers", "op_cross_self", "op_under_dest_min"),
+}
+
+// opInnerResultName returns the name of the result code of an operation of opType.
+func opInnerResultName(opType, code int32) string {
+	if code == 0 {
+		return "op_success"
+	}
+
+	if names := opInnerResultNames[opType]; code < 0 && int(-code) <= len(names) {
+		return names[-code-1]
+	}
+
+	return "op_code_" + strconv.Itoa(int(code))
+}
+
+// opResultSize returns the size of the rest of an operation's result, after its code,
+// or -1 if it's not known. Most results are empty, but successful path payments and
+// offers have the offers they took, for example.
+func opResultSize(opType, code int32) int {
+	switch opType {
+	case 0, 1, 5, 6, 7, 10, 11:
+		return 0
+	case 8:
+		// The merged balance
+		if code == 0 {
+			return 8
+		}
+
+		return 0
+	case 3, 4, 12:
+		if code != 0 {
+			return 0
+		}
+	case 2, 13:
+		// op_no_issuer has the asset
+		if code != 0 && code != -9 {
+			return 0
+		}
+	}
+
+	return -1
+}
+
+// xdrReader reads the XDR that transaction results are encoded in.
+type xdrReader struct {
+	data []byte
+}
+
+func (r *xdrReader) int32() (int32, error) {
+	if len(r.data) < 4 {
+		return 0, errors.New("truncated result")
+	}
+
+	val := int32(binary.BigEndian.Uint32(r.data))
+	r.data = r.data[4:]
+	return val, nil
+}
+
+func (r *xdrReader) skip(n int) error {
+	if len(r.data) < n {
+		return errors.New("truncated result")
+	}
+
+	r.data = r.data[n:]
+	return nil
+}
+
+// decodeResultCodes returns the result codes in a base64-encoded TransactionResult
+// (the result_xdr of a horizon transaction.) Operation results that can't be decoded
+// (see opResultSize) end the list, since the ones after them can't be found.
+func decodeResultCodes(resultXDR string) (*txResultCodes, error) {
+	data, err := base64.StdEncoding.DecodeString(resultXDR)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad result XDR")
+	}
+
+	r := &xdrReader{data: data}
+	codes := &txResultCodes{}
+
+	// Skip the fee (int64)
+	if err := r.skip(8); err != nil {
+		return nil, err
+	}
+
+	code, err := r.int32()
+	if err != nil {
+		return nil, err
+	}
+
+	// Fee bumps have the inner transaction's hash and result, which has the codes
+	if code == 1 || code == -13 {
+		if err := r.skip(32 + 8); err != nil {
+			return nil, err
+		}
+
+		if code, err = r.int32(); err != nil {
+			return nil, err
+		}
+	}
+
+	name, ok := txResultNames[code]
+	if !ok {
+		name = "tx_code_" + strconv.Itoa(int(code))
+	}
+
+	codes.Transaction = name
+	if code != 0 && code != -1 {
+		return codes, nil
+	}
+
+	count, err := r.int32()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := int32(0); i < count; i++ {
+		code, err := r.int32()
+		if err != nil {
+			return nil, err
+		}
+
+		if code != 0 {
+			name, ok := opResultNames[code]
+			if !ok {
+				name = "op_code_" + strconv.Itoa(int(code))
+			}
+
+			codes.Operations = append(codes.Operations, name)
+			continue
+		}
+
+		opType, err := r.int32()
+		if err != nil {
+			return nil, err
+		}
+
+		if code, err = r.int32(); err != nil {
+			return nil, err
+		}
+
+		codes.Operations = append(codes.Operations, opInnerResultName(opType, code))
+
+		size := opResultSize(opType, code)
+		if size < 0 {
+			break
+		}
+
+		if err := r.skip(size); err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
@@ -0,0 +1,357 @@
+package cli
+
+import (
+	"encoding/base64"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/0xfe/microstellar"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// sep7Scheme is the scheme of SEP-7 URIs.
+const sep7Scheme = "web+stellar"
+
+// sep7Params are the parameters of each SEP-7 operation, in the order the spec lists
+// them.
+var sep7Params = map[string][]string{
+	"pay": {"destination", "amount", "asset_code", "asset_issuer", "memo", "memo_type", "callback", "msg", "network_passphrase", "origin_domain", "signature"},
+	"tx":  {"xdr", "replace", "callback", "pubkey", "chain", "msg", "network_passphrase", "origin_domain", "signature"},
+}
+
+// sep7Required is the parameter each SEP-7 operation can't do without.
+var sep7Required = map[string]string{
+	"pay": "destination",
+	"tx":  "xdr",
+}
+
+// sep7Request is a parsed SEP-7 URI.
+type sep7Request struct {
+	Operation string
+	Params    url.Values
+}
+
+// parseSEP7URI parses a SEP-7 (web+stellar:) payment or transaction request URI.
+func parseSEP7URI(uri string) (*sep7Request, error) {
+	parts := strings.SplitN(uri, ":", 2)
+	if len(parts) != 2 || parts[0] != sep7Scheme {
+		return nil, errors.Errorf("not a SEP-7 URI, expecting %s:pay?... or %s:tx?...", sep7Scheme, sep7Scheme)
+	}
+
+	op := parts[1]
+	query := ""
+	if i := strings.Index(op, "?"); i >= 0 {
+		op, query = op[:i], op[i+1:]
+	}
+
+	if _, ok := sep7Params[op]; !ok {
+		return nil, errors.Errorf("unsupported operation: %s, expecting: pay|tx", op)
+	}
+
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad parameters")
+	}
+
+	for key, vals := range params {
+		if len(vals) > 1 {
+			return nil, errors.Errorf("repeated parameter: %s", key)
+		}
+	}
+
+	if required := sep7Required[op]; params.Get(required) == "" {
+		return nil, errors.Errorf("missing parameter: %s", required)
+	}
+
+	if op == "pay" {
+		destination := params.Get("destination")
+		if microstellar.ValidAddress(destination) != nil && !isMuxedAddress(destination) {
+			return nil, errors.Errorf("bad destination: %s", destination)
+		}
+
+		if amt := params.Get("amount"); amt != "" {
+			if err := validateAmount(amt, false); err != nil {
+				return nil, errors.Wrap(err, "bad amount")
+			}
+		}
+
+		if (params.Get("asset_code") == "") != (params.Get("asset_issuer") == "") {
+			return nil, errors.Errorf("asset_code and asset_issuer must be used together")
+		}
+	}
+
+	return &sep7Request{Operation: op, Params: params}, nil
+}
+
+// networkPassphrase returns the network the request is for, which is the public
+// network unless it says otherwise.
+func (req *sep7Request) networkPassphrase() string {
+	if passphrase := req.Params.Get("network_passphrase"); passphrase != "" {
+		return passphrase
+	}
+
+	return publicNetworkPassphrase
+}
+
+// checkSEP7Network returns an error if req is for a different network than the
+// current one. There's nothing to compare with on the fake network.
+func (cli *CLI) checkSEP7Network(req *sep7Request) error {
+	if want := cli.networkPassphrase(); want != "" && req.networkPassphrase() != want {
+		return errors.Errorf("URI is for network %q, but lumen is on %q (check config:network)", req.networkPassphrase(), want)
+	}
+
+	return nil
+}
+
+// fields returns the parameters of the request as key, value pairs, the known ones in
+// the spec's order, then the rest sorted.
+func (req *sep7Request) fields() [][2]string {
+	result := [][2]string{}
+	known := map[string]bool{}
+
+	for _, key := range sep7Params[req.Operation] {
+		known[key] = true
+		if val := req.Params.Get(key); val != "" {
+			result = append(result, [2]string{key, val})
+		}
+	}
+
+	others := []string{}
+	for key := range req.Params {
+		if !known[key] {
+			others = append(others, key)
+		}
+	}
+
+	sort.Strings(others)
+	for _, key := range others {
+		result = append(result, [2]string{key, req.Params.Get(key)})
+	}
+
+	return result
+}
+
+// asset returns the asset the payment request is for.
+func (req *sep7Request) asset() (*microstellar.Asset, error) {
+	code := req.Params.Get("asset_code")
+	if code == "" {
+		return microstellar.NativeAsset, nil
+	}
+
+	issuer := req.Params.Get("asset_issuer")
+	if microstellar.ValidAddress(issuer) != nil {
+		return nil, errors.Errorf("bad asset_issuer: %s", issuer)
+	}
+
+	switch {
+	case len(code) <= 4:
+		return microstellar.NewAsset(code, issuer, microstellar.Credit4Type), nil
+	case len(code) <= 12:
+		return microstellar.NewAsset(code, issuer, microstellar.Credit12Type), nil
+	}
+
+	return nil, errors.Errorf("bad asset_code: %s", code)
+}
+
+// withMemo adds the payment request's memo (if any) to opts.
+func (req *sep7Request) withMemo(opts *microstellar.Options) (*microstellar.Options, error) {
+	memo := req.Params.Get("memo")
+	if memo == "" {
+		return opts, nil
+	}
+
+	switch memoType := req.Params.Get("memo_type"); memoType {
+	case "", "MEMO_TEXT":
+		if len(memo) > maxMemoText {
+			return nil, errors.Errorf("memo is longer than %d bytes: %s", maxMemoText, memo)
+		}
+
+		return opts.WithMemoText(memo), nil
+	case "MEMO_ID":
+		id, err := strconv.ParseUint(memo, 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("bad memo ID: %s", memo)
+		}
+
+		return opts.WithMemoID(id), nil
+	case "MEMO_HASH", "MEMO_RETURN":
+		hash, err := base64.StdEncoding.DecodeString(memo)
+		if err != nil || len(hash) != 32 {
+			return nil, errors.Errorf("bad memo hash, expecting 32 base64-encoded bytes: %s", memo)
+		}
+
+		var memoHash [32]byte
+		copy(memoHash[:], hash)
+		if memoType == "MEMO_RETURN" {
+			return opts.WithMemoReturn(memoHash), nil
+		}
+
+		return opts.WithMemoHash(memoHash), nil
+	default:
+		return nil, errors.Errorf("bad memo_type: %s, expecting: MEMO_TEXT|MEMO_ID|MEMO_HASH|MEMO_RETURN", memoType)
+	}
+}
+
+func (cli *CLI) buildURICmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "uri [parse|pay]",
+		Short: "handle SEP-7 (web+stellar:) payment and transaction request URIs",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cli.error(logrus.Fields{"cmd": "uri"}, "unrecognized uri command: %s, expecting: parse|pay", args[0])
+		},
+	}
+
+	cmd.AddCommand(cli.buildURIParseCmd())
+	cmd.AddCommand(cli.buildURIPayCmd())
+	return cmd
+}
+
+func (cli *CLI) buildURIParseCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "parse [web+stellar:...]",
+		Short: "show the fields of a SEP-7 payment or transaction request URI",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "uri", "subcmd": "parse"}
+
+			req, err := parseSEP7URI(args[0])
+			if err != nil {
+				cli.error(logFields, "bad URI: %v", err)
+				return
+			}
+
+			showSuccess("operation: %s", req.Operation)
+			for _, field := range req.fields() {
+				showSuccess("%s: %s", field[0], field[1])
+			}
+		},
+	}
+}
+
+func (cli *CLI) buildURIPayCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pay [web+stellar:pay?...] --from [account] [--amount amount]",
+		Short: "make the payment requested by a SEP-7 URI, from [account]",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			logFields := logrus.Fields{"cmd": "uri", "subcmd": "pay"}
+
+			req, err := parseSEP7URI(args[0])
+			if err != nil {
+				cli.error(logFields, "bad URI: %v", err)
+				return
+			}
+
+			if req.Operation != "pay" {
+				cli.error(logFields, "not a payment request: %s, use tx sign to sign the transaction in a tx request", req.Operation)
+				return
+			}
+
+			if err := cli.checkSEP7Network(req); err != nil {
+				cli.error(logFields, "%v", err)
+				return
+			}
+
+			from, _ := cmd.Flags().GetString("from")
+			source, err := cli.ResolveAccount(logFields, from, "seed")
+			if err != nil {
+				cli.error(logFields, "bad --from: %s", from)
+				return
+			}
+
+			// Requests without an amount leave it to the payer
+			amt := req.Params.Get("amount")
+			if override, _ := cmd.Flags().GetString("amount"); override != "" {
+				if amt != "" && override != amt {
+					cli.error(logFields, "URI requests %s, can't use --amount %s", amt, override)
+					return
+				}
+
+				if err := validateAmount(override, false); err != nil {
+					cli.error(logFields, "bad --amount: %v", err)
+					return
+				}
+
+				amt = override
+			}
+
+			if amt == "" {
+				cli.error(logFields, "URI has no amount, use --amount")
+				return
+			}
+
+			asset, err := req.asset()
+			if err != nil {
+				cli.error(logFields, "bad URI: %v", err)
+				return
+			}
+
+			opts, err := cli.genTxOptionsFor(cmd, logFields, from)
+			if err != nil {
+				cli.error(logFields, "can't generate payment: %v", err)
+				return
+			}
+
+			// The request's memo identifies the payment to the recipient, so it can't be
+			// overridden, and replaces any default memo.
+			destination := req.Params.Get("destination")
+			memo := req.Params.Get("memo")
+			if memo != "" || isMuxedAddress(destination) {
+				for _, flag := range memoFlags {
+					if cmd.Flags().Changed(flag) {
+						cli.error(logFields, "can't use --%s, the URI sets the memo", flag)
+						return
+					}
+				}
+			}
+
+			if opts, err = req.withMemo(opts); err != nil {
+				cli.error(logFields, "bad URI: %v", err)
+				return
+			}
+
+			target := destination
+			if isMuxedAddress(destination) {
+				if memo != "" {
+					cli.error(logFields, "bad URI: memo can't be used with muxed destination: %s", destination)
+					return
+				}
+
+				var id uint64
+				target, id, _ = decodeMuxedAddress(destination)
+				opts = opts.WithMemoID(id)
+			}
+
+			if !cli.checkDestination(cmd, logFields, cli.destination(target), destination) {
+				return
+			}
+
+			if msg := req.Params.Get("msg"); msg != "" {
+				showSuccess("message: %s", msg)
+			}
+
+			if !cli.confirm("pay %s %s from %s to %s", amt, assetCode(asset), addressFromSeed(source), destination) {
+				cli.error(logFields, "not paying, use --yes to confirm")
+				return
+			}
+
+			debugf(logFields, "paying %s %s/%s from %s to %s, opts: %+v", amt, asset.Code, asset.Issuer, from, target, opts)
+			if err := cli.ms.Pay(source, target, amt, asset, opts); err != nil {
+				cli.errorWithCode(txExitCode(err), logFields, "payment failed: %v", cli.errorString(err))
+				return
+			}
+		},
+	}
+
+	buildFlagsForTxOptions(cmd)
+	cmd.Flags().String("from", "", "source account seed or name")
+	cmd.Flags().String("amount", "", "amount to pay, if the URI doesn't have one")
+	cmd.Flags().Bool("allow-unfunded-destination", false, "pay even if the destination doesn't exist")
+	cmd.MarkFlagRequired("from")
+	return cmd
+}
@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSEP7URI(t *testing.T) {
+	address := "GCSQ7TNBQ2XVFH6DPYYWN7TCRTLAT7H4VAI3DHHVGSN4MQP4VFUIR4F6"
+
+	req, err := parseSEP7URI("web+stellar:pay?destination=" + address + "&amount=10&memo=rent%20for%20june&foo=bar")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+
+	want := [][2]string{{"destination", address}, {"amount", "10"}, {"memo", "rent for june"}, {"foo", "bar"}}
+	got := req.fields()
+	if len(got) != len(want) {
+		t.Fatalf("got fields %v, want %v", got, want)
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got field %v, want %v", got[i], want[i])
+		}
+	}
+
+	if req.networkPassphrase() != publicNetworkPassphrase {
+		t.Errorf("want public network by default, got %s", req.networkPassphrase())
+	}
+
+	// Round trips with account qr
+	uri := sep7PayURI(address, "1.5", nil, "42", "id")
+	if req, err = parseSEP7URI(uri); err != nil || req.Params.Get("memo_type") != "MEMO_ID" || req.Params.Get("amount") != "1.5" {
+		t.Errorf("can't parse %s: %+v, %v", uri, req, err)
+	}
+
+	bad := []string{
+		"http://example.com",
+		"web+stellar:buy?destination=" + address,
+		"web+stellar:pay?amount=10",
+		"web+stellar:pay?destination=GBAD",
+		"web+stellar:pay?destination=" + address + "&amount=-1",
+		"web+stellar:pay?destination=" + address + "&asset_code=USD",
+		"web+stellar:pay?destination=" + address + "&amount=1&amount=2",
+		"web+stellar:tx?callback=url:https://example.com",
+	}
+
+	for _, uri := range bad {
+		if _, err := parseSEP7URI(uri); err == nil {
+			t.Errorf("want error for %s", uri)
+		}
+	}
+}
+
+func TestURI(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new kelly")
+	cli.TestCommand("account set mo GCSQ7TNBQ2XVFH6DPYYWN7TCRTLAT7H4VAI3DHHVGSN4MQP4VFUIR4F6")
+	cli.TestCommand("asset set USD GCSQ7TNBQ2XVFH6DPYYWN7TCRTLAT7H4VAI3DHHVGSN4MQP4VFUIR4F6")
+
+	base := "web+stellar:pay?destination=GCSQ7TNBQ2XVFH6DPYYWN7TCRTLAT7H4VAI3DHHVGSN4MQP4VFUIR4F6"
+
+	result := cli.TestCommand("uri parse " + base + "&amount=10&msg=thanks%21")
+	want := "operation: pay\ndestination: GCSQ7TNBQ2XVFH6DPYYWN7TCRTLAT7H4VAI3DHHVGSN4MQP4VFUIR4F6\namount: 10\nmsg: thanks!"
+	if strings.TrimSpace(result) != want {
+		t.Errorf("got %q, want %q", result, want)
+	}
+
+	expectOutput(t, cli, "error", "uri parse web+stellar:pay?amount=10")
+
+	// Payments need confirmation
+	expectOutput(t, cli, "error", "uri pay "+base+"&amount=10 --from kelly")
+	expectOutput(t, cli, "", "uri pay "+base+"&amount=10 --from kelly --yes")
+	expectOutput(t, cli, "", "uri pay "+base+"&amount=10&memo=42&memo_type=MEMO_ID --from kelly --yes")
+	expectOutput(t, cli, "", "uri pay "+base+" --amount 5 --from kelly --yes")
+	expectOutput(t, cli, "message: thanks!", "uri pay "+base+"&amount=10&msg=thanks%21 --from kelly --yes")
+
+	// The URI's memo and amount can't be overridden
+	expectOutput(t, cli, "error", "uri pay "+base+"&amount=10&memo=rent --memotext other --from kelly --yes")
+	expectOutput(t, cli, "error", "uri pay "+base+"&amount=10 --amount 5 --from kelly --yes")
+	expectOutput(t, cli, "error", "uri pay "+base+" --from kelly --yes")
+	expectOutput(t, cli, "error", "uri pay "+base+"&amount=10&memo=foo&memo_type=MEMO_ID --from kelly --yes")
+	expectOutput(t, cli, "error", "uri pay "+base+"&amount=10&memo=foo&memo_type=MEMO_HASH --from kelly --yes")
+	expectOutput(t, cli, "error", "uri pay web+stellar:tx?xdr=AAAA --from kelly --yes")
+	expectOutput(t, cli, "error", "uri pay "+base+"&amount=10 --from nobody --yes")
+
+	// URIs are for the public network, unless they say otherwise
+	cli.TestCommand("set config:network test")
+	expectOutput(t, cli, "error", "uri pay "+base+"&amount=10 --from kelly --yes")
+
+	cli.network = "test"
+	req, _ := parseSEP7URI(base)
+	if err := cli.checkSEP7Network(req); err == nil {
+		t.Errorf("want error for public network URI on the test network")
+	}
+
+	req, _ = parseSEP7URI(base + "&network_passphrase=Test%20SDF%20Network%20%3B%20September%202015")
+	if err := cli.checkSEP7Network(req); err != nil {
+		t.Errorf("got error for test network URI: %v", err)
+	}
+}
@@ -1,8 +1,11 @@
 package cli
 
 import (
+	"bufio"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"strconv"
 	"strings"
@@ -13,6 +16,10 @@ import (
 	"github.com/0xfe/microstellar"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"github.com/stellar/go/amount"
+	"github.com/stellar/go/clients/horizon"
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/xdr"
 )
 
 func showSuccess(msg string, args ...interface{}) {
@@ -23,13 +30,70 @@ func showError(fields logrus.Fields, msg string, args ...interface{}) {
 	logrus.WithFields(fields).Errorf(msg, args...)
 }
 
+// Exit codes, so scripts can distinguish between classes of failure.
+const (
+	// ExitBadArgs means that the command line, or an account, asset, or value
+	// referenced in it, was invalid.
+	ExitBadArgs = 2
+
+	// ExitStoreError means that the local store could not be read or written.
+	ExitStoreError = 3
+
+	// ExitNetworkError means that horizon could not be reached, or returned an error.
+	ExitNetworkError = 4
+
+	// ExitTxFailed means that the transaction was submitted, but rejected by the network.
+	ExitTxFailed = 5
+
+	// ExitBelowMin means that the balance was below balance --min, too low for tx
+	// estimate (the balance is still printed), or too low to pay the fee with
+	// --fee-account-balance-check.
+	ExitBelowMin = 6
+
+	// ExitPending means that the transaction was submitted, but wasn't in a closed
+	// ledger before --wait-timeout. It may still be included later.
+	ExitPending = 7
+)
+
+// txExitCode returns ExitTxFailed if err is a transaction rejected by the
+// network, ExitBadArgs if it was refused by --max-fee-total, --confirm-network, or
+// the network passphrase check, ExitBelowMin if it was refused by
+// --fee-account-balance-check, and ExitNetworkError otherwise.
+func txExitCode(err error) int {
+	switch errors.Cause(err).(type) {
+	case *feeTooHighError, *confirmNetworkError, *networkMismatchError:
+		return ExitBadArgs
+	case *feeBalanceError:
+		return ExitBelowMin
+	}
+
+	if herr, ok := errors.Cause(err).(*horizon.Error); ok {
+		if _, codeErr := herr.ResultCodes(); codeErr == nil {
+			return ExitTxFailed
+		}
+	}
+
+	return ExitNetworkError
+}
+
+// errorString returns a human-readable description of err. Timeouts are reported
+// with the timeout in effect.
+func (cli *CLI) errorString(err error) string {
+	if netErr, ok := errors.Cause(err).(net.Error); ok && netErr.Timeout() {
+		return fmt.Sprintf("no response from horizon after %v (see --horizon-timeout)", cli.horizonTimeout)
+	}
+
+	return microstellar.ErrorString(err)
+}
+
 func (cli *CLI) help(cmd *cobra.Command, args []string) {
 	fmt.Fprint(os.Stderr, cmd.UsageString())
+	cli.exitCode = ExitBadArgs
 
-	if !cli.testing {
-		os.Exit(-1)
-	} else {
-		fmt.Println("error")
+	if cli.testing {
+		fmt.Fprintln(cli.terminal(), "error")
+	} else if !cli.interactive {
+		os.Exit(ExitBadArgs)
 	}
 }
 
@@ -37,41 +101,264 @@ func debugf(fields logrus.Fields, msg string, args ...interface{}) {
 	logrus.WithFields(fields).Debugf(msg, args...)
 }
 
+// error reports a failure caused by invalid input, and exits with ExitBadArgs. Use
+// errorWithCode for other classes of failure.
 func (cli *CLI) error(logFields logrus.Fields, msg string, args ...interface{}) {
+	cli.errorWithCode(ExitBadArgs, logFields, msg, args...)
+}
+
+// errorWithCode reports a failure and exits with code. In test mode, it prints "error"
+// and records code instead of exiting, and in the repl it just records code.
+func (cli *CLI) errorWithCode(code int, logFields logrus.Fields, msg string, args ...interface{}) {
 	showError(logFields, msg, args...)
+	cli.exitCode = code
 
-	if !cli.testing {
-		os.Exit(-1)
-	} else {
-		fmt.Println("error")
+	if cli.testing {
+		fmt.Fprintln(cli.terminal(), "error")
+	} else if !cli.interactive {
+		os.Exit(code)
 	}
 }
 
+// confirm asks the user to confirm the destructive operation described by msg. It
+// returns true immediately if --yes was set. When stdin is not a terminal (or in test
+// mode) it returns false instead of blocking on input.
+func (cli *CLI) confirm(msg string, args ...interface{}) bool {
+	yes, _ := cli.rootCmd.Flags().GetBool("yes")
+	noConfirm, _ := cli.rootCmd.Flags().GetBool("no-confirm")
+	if yes || noConfirm {
+		return true
+	}
+
+	stat, err := os.Stdin.Stat()
+	if cli.testing || err != nil || (stat.Mode()&os.ModeCharDevice) == 0 {
+		return false
+	}
+
+	fmt.Fprintf(os.Stderr, "About to "+msg+". Are you sure? [y/N] ", args...)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
+
 func buildFlagsForTxOptions(cmd *cobra.Command) {
+	buildFlagsForTxParams(cmd)
+	cmd.Flags().Bool("batch", false, "add to the pending batch instead of submitting (see: lumen batch)")
+}
+
+// buildFlagsForTxParams adds the transaction flags except --batch, for commands that
+// build their transactions themselves (see singleOpTx), which can't be batched.
+func buildFlagsForTxParams(cmd *cobra.Command) {
 	cmd.Flags().Bool("nosign", false, "don't sign transaction")
 	cmd.Flags().String("memotext", "", "memo text")
 	cmd.Flags().String("memoid", "", "memo ID")
 	cmd.Flags().String("memohash", "", "memo hash (base64-encoded)")
 	cmd.Flags().String("memoreturn", "", "memo return (base64-encoded)")
+	cmd.Flags().Bool("no-memo", false, "don't add the default memo (config:default_memotext or config:default_memoid)")
 	cmd.Flags().String("mintime", "", "not valid before 'YYYY-MM-DD HH:MM:SS' in UTC")
 	cmd.Flags().String("maxtime", "", "not valid after 'YYYY-MM-DD HH:MM:SS' in UTC")
 	cmd.Flags().StringSlice("signers", []string{}, "alternate signers (comma separated)")
+	cmd.Flags().String("sequence", "", "use this sequence number instead of loading it from horizon")
+	buildMaxFeeFlag(cmd)
 }
 
-func (cli *CLI) genTxOptions(cmd *cobra.Command, logFields logrus.Fields) (*microstellar.Options, error) {
-	opts := microstellar.Opts()
+func buildMaxFeeFlag(cmd *cobra.Command) {
+	cmd.Flags().String("max-fee-total", "", "refuse to submit if the transaction's total fee is more than this many stroops")
+}
+
+// getMaxFeeTotal returns the value of --max-fee-total in stroops, or 0 if it isn't set.
+func getMaxFeeTotal(cmd *cobra.Command) (int64, error) {
+	maxFee, err := cmd.Flags().GetString("max-fee-total")
+	if err != nil || maxFee == "" {
+		return 0, nil
+	}
+
+	fee, err := strconv.ParseInt(maxFee, 10, 64)
+	if err != nil || fee <= 0 {
+		return 0, errors.Errorf("bad --max-fee-total: expecting a positive number of stroops, got: %s", maxFee)
+	}
+
+	return fee, nil
+}
+
+// feeTooHighError is returned for transactions whose total fee exceeds --max-fee-total.
+type feeTooHighError struct {
+	fee    int64
+	maxFee int64
+	ops    int
+}
+
+func (e *feeTooHighError) Error() string {
+	return fmt.Sprintf("total fee of %d stroops (%d operations) is more than --max-fee-total %d, raise it to submit anyway", e.fee, e.ops, e.maxFee)
+}
+
+// checkFeeTotal returns a feeTooHighError if the total fee of the base64-encoded
+// transaction envelope b64tx (i.e., the base fee times the number of operations) is
+// more than maxFee stroops.
+func checkFeeTotal(b64tx string, maxFee int64) error {
+	var envelope xdr.TransactionEnvelope
+	if err := xdr.SafeUnmarshalBase64(b64tx, &envelope); err != nil {
+		return errors.Wrap(err, "can't decode transaction to check its fee")
+	}
+
+	if fee := int64(envelope.Tx.Fee); fee > maxFee {
+		return &feeTooHighError{fee: fee, maxFee: maxFee, ops: len(envelope.Tx.Operations)}
+	}
+
+	return nil
+}
+
+// feeBalanceError is returned by --fee-account-balance-check for transactions whose
+// source account can't cover their fee.
+type feeBalanceError struct {
+	account   string
+	fee       int64
+	available int64
+}
+
+func (e *feeBalanceError) Error() string {
+	return fmt.Sprintf("fee account %s can't cover the fee of %d stroops: it has %s XLM above its reserve, %s XLM short",
+		e.account, e.fee, amount.StringFromInt64(e.available), amount.StringFromInt64(e.fee-e.available))
+}
+
+// checkFeeAccountBalance returns a feeBalanceError if --fee-account-balance-check (or
+// config:fee_account_balance_check) is set, and the source account of the
+// base64-encoded transaction envelope b64tx, which pays its fee, can't cover it (see
+// checkFeeAccount.)
+func (cli *CLI) checkFeeAccountBalance(logFields logrus.Fields, b64tx string) error {
+	if !cli.feeAccountBalanceCheck() {
+		return nil
+	}
+
+	var envelope xdr.TransactionEnvelope
+	if err := xdr.SafeUnmarshalBase64(b64tx, &envelope); err != nil {
+		return errors.Wrap(err, "can't decode transaction to check its fee account")
+	}
 
-	if memotext, err := cmd.Flags().GetString("memotext"); err == nil && memotext != "" {
-		opts = opts.WithMemoText(memotext)
+	return cli.checkFeeAccount(logFields, envelope.Tx.SourceAccount.Address(), int64(envelope.Tx.Fee))
+}
+
+// feeAccountBalanceCheck returns true if --fee-account-balance-check (or
+// config:fee_account_balance_check) is set. There are no balances to check on the
+// fake network.
+func (cli *CLI) feeAccountBalanceCheck() bool {
+	check, _ := cli.rootCmd.Flags().GetBool("fee-account-balance-check")
+	if !cli.rootCmd.Flag("fee-account-balance-check").Changed {
+		if val, err := cli.GetVar("vars:config:fee_account_balance_check"); err == nil {
+			check, _ = strconv.ParseBool(val)
+		}
+	}
+
+	return check && cli.horizonURL() != ""
+}
+
+// checkFeeAccount returns a feeBalanceError if the balance check is on (see
+// feeAccountBalanceCheck), and address doesn't have enough XLM above its reserve
+// (and selling liabilities) to cover a fee of fee stroops.
+func (cli *CLI) checkFeeAccount(logFields logrus.Fields, address string, fee int64) error {
+	if !cli.feeAccountBalanceCheck() {
+		return nil
+	}
+
+	reserve, err := cli.loadNativeReserve(logFields, address)
+	if err != nil {
+		return errors.Wrapf(err, "can't check balance of fee account %s", address)
+	}
+
+	available := reserve.Balance - reserve.minimumBalance()
+	debugf(logFields, "fee account %s: %d stroops above reserve, fee: %d", address, available, fee)
+
+	if available < fee {
+		return &feeBalanceError{account: address, fee: fee, available: available}
+	}
+
+	return nil
+}
+
+// txSigners returns the signers of a transaction from account: --signers if set, or
+// else the default signers of account (see account set-signers.) Signers are stored as
+// names (or seeds), and resolved when the transaction is signed.
+func (cli *CLI) txSigners(cmd *cobra.Command, logFields logrus.Fields, account string) []string {
+	if signers, err := cmd.Flags().GetStringSlice("signers"); err == nil && len(signers) > 0 {
+		return signers
+	}
+
+	if account == "" {
+		return nil
+	}
+
+	signers, err := cli.GetVar(fmt.Sprintf("account:%s:signers", account))
+	if err != nil || signers == "" {
+		return nil
+	}
+
+	logrus.WithFields(logFields).Debugf("using default signers of %s: %s", account, signers)
+	return strings.Split(signers, ",")
+}
+
+// genTxOptions returns the options set by the transaction flags (see
+// buildFlagsForTxOptions) for a transaction from source, an address or seed.
+func (cli *CLI) genTxOptions(cmd *cobra.Command, logFields logrus.Fields, source string) (*microstellar.Options, error) {
+	return cli.txOptions(cmd, logFields, "", source)
+}
+
+// genTxOptionsFor is genTxOptions for transactions from account, which are signed by
+// its default signers unless --signers is set.
+func (cli *CLI) genTxOptionsFor(cmd *cobra.Command, logFields logrus.Fields, account string) (*microstellar.Options, error) {
+	return cli.txOptions(cmd, logFields, account, account)
+}
+
+// txParams are the transaction flags (see buildFlagsForTxOptions) that set what's in a
+// transaction, as opposed to how it's handled. txOptions passes them to microstellar,
+// and singleOpTx builds them into the transactions that microstellar can't.
+type txParams struct {
+	memo       xdr.Memo
+	timeBounds *xdr.TimeBounds
+	signers    []string // resolved to seeds, empty for the source's own signature
+	sequence   int64    // from --sequence, 0 to load it from horizon
+	maxFee     int64    // in stroops, 0 if there's no --max-fee-total
+	nosign     bool
+}
+
+// parseTxParams returns the transaction flags of cmd for a transaction from account,
+// which is signed by its default signers unless --signers is set (see txSigners.)
+func (cli *CLI) parseTxParams(cmd *cobra.Command, logFields logrus.Fields, account string) (*txParams, error) {
+	params := &txParams{}
+
+	if noMemo, _ := cmd.Flags().GetBool("no-memo"); noMemo && hasMemo(cmd) {
+		return nil, errors.Errorf("--no-memo can't be used with other memo flags")
+	}
+
+	memotext, _ := cmd.Flags().GetString("memotext")
+	memoid, _ := cmd.Flags().GetString("memoid")
+
+	defaultFlag, defaultValue, err := cli.defaultMemo(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	switch defaultFlag {
+	case "memotext":
+		logrus.WithFields(logFields).Debugf("using default memo text: %s", defaultValue)
+		memotext = defaultValue
+	case "memoid":
+		logrus.WithFields(logFields).Debugf("using default memo ID: %s", defaultValue)
+		memoid = defaultValue
+	}
+
+	// As with microstellar's options, the last memo set wins
+	if memotext != "" {
+		params.memo, _ = xdr.NewMemo(xdr.MemoTypeMemoText, memotext)
 	}
 
-	if memoid, err := cmd.Flags().GetString("memoid"); err == nil && memoid != "" {
+	if memoid != "" {
 		id, err := strconv.ParseUint(memoid, 10, 64)
 		if err != nil {
 			logrus.WithFields(logFields).Debugf("error parsing memoid: %v", err)
 			return nil, errors.Errorf("bad memoid: %s", memoid)
 		}
-		opts = opts.WithMemoID(id)
+		params.memo, _ = xdr.NewMemo(xdr.MemoTypeMemoId, xdr.Uint64(id))
 	}
 
 	if memohash, err := cmd.Flags().GetString("memohash"); err == nil && memohash != "" {
@@ -81,9 +368,9 @@ func (cli *CLI) genTxOptions(cmd *cobra.Command, logFields logrus.Fields) (*micr
 			return nil, errors.Errorf("bad memohash: %s", memohash)
 		}
 
-		var memoHash [32]byte
+		var memoHash xdr.Hash
 		copy(memoHash[:], hash[:])
-		opts = opts.WithMemoHash(memoHash)
+		params.memo, _ = xdr.NewMemo(xdr.MemoTypeMemoHash, memoHash)
 	}
 
 	if memoreturn, err := cmd.Flags().GetString("memoreturn"); err == nil && memoreturn != "" {
@@ -93,23 +380,21 @@ func (cli *CLI) genTxOptions(cmd *cobra.Command, logFields logrus.Fields) (*micr
 			return nil, errors.Errorf("bad memoreturn: %s", memoreturn)
 		}
 
-		var memoReturn [32]byte
+		var memoReturn xdr.Hash
 		copy(memoReturn[:], hash[:])
-		opts = opts.WithMemoReturn(memoReturn)
+		params.memo, _ = xdr.NewMemo(xdr.MemoTypeMemoReturn, memoReturn)
 	}
 
-	if signers, err := cmd.Flags().GetStringSlice("signers"); err == nil && len(signers) > 0 {
-		for _, signer := range signers {
-			logrus.WithFields(logFields).Debugf("adding signer: %s", signer)
-			address, err := cli.ResolveAccount(logFields, signer, "seed")
-
-			if err != nil {
-				logrus.WithFields(logFields).Debugf("bad signer %s: %v", signer, err)
-				return nil, errors.Errorf("bad signer: %s", signer)
-			}
+	for _, signer := range cli.txSigners(cmd, logFields, account) {
+		logrus.WithFields(logFields).Debugf("adding signer: %s", signer)
+		address, err := cli.ResolveAccount(logFields, signer, "seed")
 
-			opts = opts.WithSigner(address)
+		if err != nil {
+			logrus.WithFields(logFields).Debugf("bad signer %s: %v", signer, err)
+			return nil, errors.Errorf("bad signer: %s", signer)
 		}
+
+		params.signers = append(params.signers, address)
 	}
 
 	hasMinTime := false
@@ -135,32 +420,227 @@ func (cli *CLI) genTxOptions(cmd *cobra.Command, logFields logrus.Fields) (*micr
 	}
 
 	if hasMinTime && hasMaxTime {
-		opts = opts.WithTimeBounds(minTimeBound.UTC(), maxTimeBound.UTC())
+		params.timeBounds = &xdr.TimeBounds{
+			MinTime: xdr.Uint64(minTimeBound.Unix()),
+			MaxTime: xdr.Uint64(maxTimeBound.Unix()),
+		}
 	} else if hasMinTime || hasMaxTime {
 		return nil, errors.Errorf("need both --mintime and --maxtime")
 	}
 
-	if nosubmit, _ := cli.rootCmd.Flags().GetBool("nosubmit"); nosubmit {
-		handler := func(args ...interface{}) (bool, error) {
+	if sequence, err := cmd.Flags().GetString("sequence"); err == nil && sequence != "" {
+		seq, err := strconv.ParseUint(sequence, 10, 63)
+		if err != nil || seq == 0 {
+			return nil, errors.Errorf("bad --sequence: expecting a positive integer, got: %s", sequence)
+		}
+		params.sequence = int64(seq)
+	}
+
+	if params.maxFee, err = getMaxFeeTotal(cmd); err != nil {
+		return nil, err
+	}
+
+	params.nosign, _ = cmd.Flags().GetBool("nosign")
+	return params, nil
+}
+
+// options returns the microstellar options for params.
+func (params *txParams) options() *microstellar.Options {
+	opts := microstellar.Opts()
+
+	switch params.memo.Type {
+	case xdr.MemoTypeMemoText:
+		opts = opts.WithMemoText(*params.memo.Text)
+	case xdr.MemoTypeMemoId:
+		opts = opts.WithMemoID(uint64(*params.memo.Id))
+	case xdr.MemoTypeMemoHash:
+		opts = opts.WithMemoHash(*params.memo.Hash)
+	case xdr.MemoTypeMemoReturn:
+		opts = opts.WithMemoReturn(*params.memo.RetHash)
+	}
+
+	for _, signer := range params.signers {
+		opts = opts.WithSigner(signer)
+	}
+
+	if bounds := params.timeBounds; bounds != nil {
+		opts = opts.WithTimeBounds(time.Unix(int64(bounds.MinTime), 0).UTC(), time.Unix(int64(bounds.MaxTime), 0).UTC())
+	}
+
+	if params.nosign {
+		opts = opts.SkipSignatures()
+	}
+
+	return opts
+}
+
+func (cli *CLI) txOptions(cmd *cobra.Command, logFields logrus.Fields, account, source string) (*microstellar.Options, error) {
+	params, err := cli.parseTxParams(cmd, logFields, account)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := params.options()
+
+	if params.sequence > 0 {
+		address, err := cli.ResolveAccount(logFields, source, "address")
+		if err != nil {
+			return nil, errors.Errorf("can't use --sequence: bad source account: %s", source)
+		}
+
+		if microstellar.ValidSeed(address) == nil {
+			address = addressFromSeed(address)
+		}
+
+		logrus.WithFields(logFields).Debugf("using sequence %d for %s, no on-chain check was performed", params.sequence, address)
+		cli.useSequence(address, uint64(params.sequence-1))
+	}
+
+	batch, _ := cmd.Flags().GetBool("batch")
+	batch = batch && !cli.replaying
+	nosubmit, _ := cli.rootCmd.Flags().GetBool("nosubmit")
+
+	if batch {
+		if nosubmit {
+			return nil, errors.Errorf("--batch and --nosubmit are mutually exclusive")
+		}
+
+		// The batch is one transaction, so it has one memo
+		if hasMemo(cmd) {
+			return nil, errors.Errorf("can't set a memo with --batch, set it on batch commit")
+		}
+
+		// Fail early if there's no batch, but only add the command to it once it's built
+		// its transaction, see: recordBatched
+		if _, _, err := cli.loadBatch(); err != nil {
+			return nil, err
+		}
+
+		cli.batching = true
+		logrus.WithFields(logFields).Debugf("batching transaction")
+	}
+
+	if nosubmit {
+		logrus.WithFields(logFields).Debugf("sign-only transaction")
+	}
+
+	// microstellar keeps one handler per event, so all the checks go in this one
+	handler := func(args ...interface{}) (bool, error) {
+		if params.maxFee > 0 {
+			if err := checkFeeTotal(args[0].(string), params.maxFee); err != nil {
+				return false, err
+			}
+		}
+
+		if batch {
+			// Build and sign the transaction to validate it, but don't submit it
+			cli.batchBuilt = true
+			return false, nil
+		}
+
+		if nosubmit {
 			showSuccess(args[0].(string))
 			return false, nil
 		}
 
-		txHandler := microstellar.TxHandler(handler)
-		logrus.WithFields(logFields).Debugf("sign-only transaction")
-		opts = opts.On(microstellar.EvBeforeSubmit, &txHandler)
+		if err := cli.checkBeforeSubmit(logFields, args[0].(string)); err != nil {
+			return false, err
+		}
+
+		cli.submitted = args[0].(string)
+		return true, nil
 	}
 
-	if nosign, err := cmd.Flags().GetBool("nosign"); err == nil && nosign {
-		opts = opts.SkipSignatures()
+	txHandler := microstellar.TxHandler(handler)
+	return opts.On(microstellar.EvBeforeSubmit, &txHandler), nil
+}
+
+// maxAmount is the largest amount the network can represent (in XLM or asset units.)
+const maxAmount = "922337203685.4775807"
+
+// validateAmount returns a descriptive error if val isn't a valid amount, i.e., a
+// non-negative decimal number with at most 7 decimal places that fits in an int64 in
+// stroops. Zero is only valid if allowZero is set.
+func validateAmount(val string, allowZero bool) error {
+	if val == "" {
+		return errors.Errorf("amount is required")
+	}
+
+	if strings.HasPrefix(val, "-") {
+		return errors.Errorf("amount must not be negative: %s", val)
+	}
+
+	parts := strings.SplitN(val, ".", 2)
+	for _, part := range parts {
+		if part == "" || strings.Trim(part, "0123456789") != "" {
+			return errors.Errorf("amount must be a decimal number: %s", val)
+		}
+	}
+
+	if len(parts) == 2 && len(parts[1]) > 7 {
+		return errors.Errorf("amount must have at most 7 decimal places: %s", val)
+	}
+
+	stroops, err := amount.ParseInt64(val)
+	if err != nil {
+		return errors.Errorf("amount must be at most %s: %s", maxAmount, val)
+	}
+
+	if stroops == 0 && !allowZero {
+		return errors.Errorf("amount must be greater than 0: %s", val)
+	}
+
+	return nil
+}
+
+// addressFromSeed returns the address for seed, or an empty string if
+// seed is invalid.
+func addressFromSeed(seed string) string {
+	kp, err := keypair.Parse(seed)
+	if err != nil {
+		return ""
 	}
 
-	return opts, nil
+	return kp.Address()
 }
 
 // ResolveAccount returns an address or seed (depending on keyType), by looking up lookupKey
 // in the local store (or in federation servers.)
+//
+// Muxed (M...) addresses resolve to their underlying account. Use ResolveDestination to
+// get the embedded ID too.
 func (cli *CLI) ResolveAccount(fields logrus.Fields, lookupKey string, keyType string) (string, error) {
+	addressOrSeed, err := cli.resolveAccount(fields, lookupKey, keyType)
+	if err != nil {
+		return "", err
+	}
+
+	if isMuxedAddress(addressOrSeed) {
+		address, _, _ := decodeMuxedAddress(addressOrSeed)
+		return address, nil
+	}
+
+	return addressOrSeed, nil
+}
+
+// ResolveDestination returns the address of lookupKey, like ResolveAccount. If it's a
+// muxed address, it also returns the embedded ID.
+func (cli *CLI) ResolveDestination(fields logrus.Fields, lookupKey string) (string, *uint64, error) {
+	address, err := cli.resolveAccount(fields, lookupKey, "address")
+	if err != nil {
+		return "", nil, err
+	}
+
+	if isMuxedAddress(address) {
+		address, id, _ := decodeMuxedAddress(address)
+		logrus.WithFields(fields).Debugf("muxed address %s = %s, id %d", lookupKey, address, id)
+		return address, &id, nil
+	}
+
+	return address, nil, nil
+}
+
+func (cli *CLI) resolveAccount(fields logrus.Fields, lookupKey string, keyType string) (string, error) {
 	var err error
 	addressOrSeed := lookupKey
 
@@ -175,7 +655,7 @@ func (cli *CLI) ResolveAccount(fields logrus.Fields, lookupKey string, keyType s
 		}
 	}
 
-	if !microstellar.ValidAddressOrSeed(lookupKey) {
+	if !microstellar.ValidAddressOrSeed(lookupKey) && !isMuxedAddress(lookupKey) {
 		addressOrSeed, err = cli.GetAccountOrSeed(lookupKey, keyType)
 		if err != nil {
 			logrus.WithFields(fields).Debugf("invalid address, seed, or account name: %s", lookupKey)
@@ -183,7 +663,7 @@ func (cli *CLI) ResolveAccount(fields logrus.Fields, lookupKey string, keyType s
 		}
 
 		if strings.Contains(addressOrSeed, "*") {
-			return cli.ResolveAccount(fields, addressOrSeed, keyType)
+			return cli.resolveAccount(fields, addressOrSeed, keyType)
 		}
 	}
 
@@ -210,13 +690,11 @@ func (cli *CLI) ResolveAsset(name string) (*microstellar.Asset, error) {
 		if len(parts) > 2 {
 			assetType = parts[2]
 		} else {
-			if len(code) <= 4 {
-				assetType = string(microstellar.Credit4Type)
-			} else if len(code) <= 12 {
-				assetType = string(microstellar.Credit12Type)
-			} else {
-				assetType = string(microstellar.Credit64Type)
-			}
+			assetType = defaultAssetType(code)
+		}
+
+		if err := validateAssetCode(code, assetType); err != nil {
+			return nil, err
 		}
 
 		var err error
@@ -243,6 +721,20 @@ func (cli *CLI) ResolveAsset(name string) (*microstellar.Asset, error) {
 		if err1 != nil || err2 != nil || err3 != nil {
 			return nil, errors.Errorf("could not read asset: %v, %v, %v", err1, err2, err3)
 		}
+
+		// Follow the issuer's account name if it was set with one, so assets stay in
+		// sync if the account's address changes
+		if alias, err := readField("issuer_alias"); err == nil {
+			address, err := cli.GetAccount(alias, "address")
+			if err != nil {
+				return nil, errors.Errorf("issuer %s of asset %s no longer exists", alias, name)
+			}
+
+			if address != issuer {
+				logrus.WithFields(logrus.Fields{"method": "ResolveAsset"}).Debugf("issuer %s of %s is now %s, was %s", alias, name, address, issuer)
+				issuer = address
+			}
+		}
 	}
 
 	var asset *microstellar.Asset
@@ -276,14 +768,60 @@ func (cli *CLI) GetAccount(name, keyType string) (string, error) {
 		return name, err
 	}
 
+	if keyType == "seed" && isEncryptedSeed(code) {
+		return cli.decryptAccountSeed(name, code)
+	}
+
 	return code, nil
 }
 
+// seedDecryptError is returned for encrypted seeds that can't be decrypted, e.g.,
+// because the passphrase is wrong.
+type seedDecryptError struct {
+	name string
+	err  error
+}
+
+func (e *seedDecryptError) Error() string {
+	return fmt.Sprintf("can't decrypt the seed of %s: %v", e.name, e.err)
+}
+
+// decryptAccountSeed returns the seed of account name, decrypted from val with its
+// passphrase (see seedPassphrase.) Each seed is only decrypted once per command.
+func (cli *CLI) decryptAccountSeed(name, val string) (string, error) {
+	if seed, ok := cli.seeds[name]; ok {
+		return seed, nil
+	}
+
+	passphrase, err := cli.seedPassphrase(name, false)
+	if err != nil {
+		return name, &seedDecryptError{name: name, err: err}
+	}
+
+	seed, err := decryptSeed(val, passphrase)
+	if err != nil {
+		return name, &seedDecryptError{name: name, err: err}
+	}
+
+	if cli.seeds == nil {
+		cli.seeds = map[string]string{}
+	}
+
+	cli.seeds[name] = seed
+	return seed, nil
+}
+
 // GetAccountOrSeed returns the account address or seed for "name". It prefers
 // keyType ("address" or "seed")
 func (cli *CLI) GetAccountOrSeed(name, keyType string) (string, error) {
 	code, err := cli.GetAccount(name, keyType)
 
+	// Don't hide a seed that can't be decrypted behind the address
+	if _, ok := err.(*seedDecryptError); ok {
+		showError(logrus.Fields{"type": "account"}, "%v", err)
+		return code, err
+	}
+
 	if err != nil {
 		if keyType == "address" {
 			keyType = "seed"
@@ -309,9 +847,24 @@ func (cli *CLI) LoadAccount(logFields logrus.Fields, name string) *microstellar.
 	account, err := cli.ms.LoadAccount(address)
 
 	if err != nil {
-		cli.error(logFields, "can't load account: %v", microstellar.ErrorString(err))
+		cli.errorWithCode(ExitNetworkError, logFields, "can't load account: %v", cli.errorString(err))
 		return nil
 	}
 
 	return account
 }
+
+// buildPrettyFlag adds --pretty to commands with JSON output, which is compact (one
+// line per object) by default, so it's easy to feed to line-based tools.
+func buildPrettyFlag(cmd *cobra.Command) {
+	cmd.Flags().Bool("pretty", false, "indent JSON output")
+}
+
+// marshalJSON encodes v as compact JSON, or indented JSON if pretty is set.
+func marshalJSON(v interface{}, pretty bool) ([]byte, error) {
+	if pretty {
+		return json.MarshalIndent(v, "", "  ")
+	}
+
+	return json.Marshal(v)
+}
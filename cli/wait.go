@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// waitInterval is the time between transaction polls for --wait.
+const waitInterval = time.Second
+
+// pendingError is returned when a submitted transaction isn't in a closed ledger
+// before --wait-timeout. It may still be included later.
+type pendingError struct {
+	hash    string
+	timeout time.Duration
+	err     error
+}
+
+func (e *pendingError) Error() string {
+	msg := fmt.Sprintf("transaction %s submitted, but still pending after %v", e.hash, e.timeout)
+	if e.err != nil {
+		msg += fmt.Sprintf(" (last error: %v)", e.err)
+	}
+
+	return msg
+}
+
+// waitForLedger polls for the transaction with hash every interval until it's in a
+// closed ledger, and returns the ledger's sequence number. Lookup errors (e.g., horizon
+// hasn't ingested the ledger yet) are retried until timeout, after which it returns a
+// pendingError.
+func waitForLedger(poll func() (int64, error), hash string, timeout, interval time.Duration) (int64, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		ledger, err := poll()
+		if err == nil && ledger > 0 {
+			return ledger, nil
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			return 0, &pendingError{hash: hash, timeout: timeout, err: err}
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// waitForSubmitted runs after every command. With --wait, it waits for the transaction
+// the command submitted (the last one, if there were several) to be in a closed
+// ledger, and prints the ledger's sequence number.
+func (cli *CLI) waitForSubmitted(cmd *cobra.Command, args []string) {
+	logFields := logrus.Fields{"type": "wait"}
+
+	if wait, _ := cli.rootCmd.Flags().GetBool("wait"); !wait || cli.exitCode != 0 {
+		return
+	}
+
+	// Nothing to wait for on the fake network
+	if cli.submitted == "" || cli.horizonURL() == "" {
+		debugf(logFields, "no transaction submitted, not waiting")
+		return
+	}
+
+	timeout, _ := cli.rootCmd.Flags().GetDuration("wait-timeout")
+	if timeout <= 0 {
+		cli.error(logFields, "bad --wait-timeout: must be positive: %v", timeout)
+		return
+	}
+
+	hash, err := cli.submittedHash()
+	if err != nil {
+		cli.error(logFields, "can't hash submitted transaction: %v", err)
+		return
+	}
+
+	poll := func() (int64, error) {
+		var tx struct {
+			Ledger int64 `json:"ledger"`
+		}
+
+		err := cli.getHorizonJSON(logFields, "/transactions/"+hash, &tx)
+		return tx.Ledger, err
+	}
+
+	debugf(logFields, "waiting up to %v for transaction %s", timeout, hash)
+	ledger, err := waitForLedger(poll, hash, timeout, waitInterval)
+	if err != nil {
+		cli.errorWithCode(ExitPending, logFields, "%v", cli.errorString(err))
+		return
+	}
+
+	showSuccess("confirmed: transaction %s in ledger %d", hash, ledger)
+}
@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestWaitForLedger(t *testing.T) {
+	polls := 0
+	poll := func() (int64, error) {
+		polls++
+		if polls < 3 {
+			return 0, errors.New("horizon error: Resource Missing")
+		}
+
+		return 42, nil
+	}
+
+	ledger, err := waitForLedger(poll, "abcd", time.Second, time.Millisecond)
+	if err != nil || ledger != 42 || polls != 3 {
+		t.Errorf("want ledger 42 after 3 polls, got %d after %d polls: %v", ledger, polls, err)
+	}
+
+	missing := func() (int64, error) { return 0, errors.New("horizon error: Resource Missing") }
+	_, err = waitForLedger(missing, "abcd", 10*time.Millisecond, time.Millisecond)
+	if _, ok := err.(*pendingError); !ok {
+		t.Errorf("want pendingError, got %v", err)
+	}
+}
+
+func TestWait(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new mo")
+
+	// Nothing to wait for on the fake network
+	expectOutput(t, cli, "", "tx bump-seq mo 1000 --wait")
+
+	ledger := 0
+	server := newTestHorizon(cli, testNetworkPassphrase, func(w http.ResponseWriter, r *http.Request) {
+		if ledger == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"title": "Resource Missing", "detail": "not found"}`)
+			return
+		}
+
+		fmt.Fprintf(w, `{"ledger": %d}`, ledger)
+	})
+	defer server.Close()
+
+	address := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+	tx, _ := bumpSequenceTx(address, 42, 1000, nil)
+
+	wait := func() {
+		cli.Embeddable()
+		cli.exitCode = 0
+		cli.submitted = tx
+		cli.rootCmd.ParseFlags([]string{"--wait", "--wait-timeout", "10ms"})
+		cli.waitForSubmitted(cli.rootCmd, nil)
+	}
+
+	wait()
+	if cli.exitCode != ExitPending {
+		t.Errorf("want pending (exit code %d), got exit code %d", ExitPending, cli.exitCode)
+	}
+
+	ledger = 1234
+	wait()
+	if cli.exitCode != 0 {
+		t.Errorf("want confirmation, got exit code %d", cli.exitCode)
+	}
+}
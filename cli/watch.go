@@ -1,8 +1,12 @@
 package cli
 
 import (
-	"encoding/json"
+	"encoding/csv"
 	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/0xfe/microstellar"
@@ -11,9 +15,9 @@ import (
 	"github.com/spf13/cobra"
 )
 
-func showEntry(logFields logrus.Fields, entry interface{}, format string) {
+func showEntry(logFields logrus.Fields, entry interface{}, format string, pretty bool) {
 	if format == "json" {
-		data, err := json.MarshalIndent(entry, "", "  ")
+		data, err := marshalJSON(entry, pretty)
 
 		if err != nil {
 			logrus.WithFields(logFields).Errorf("skipping bad data: %v", err)
@@ -38,7 +42,180 @@ func showPayment(logFields logrus.Fields, payment *microstellar.Payment) {
 	}
 }
 
-func watch(ms *microstellar.MicroStellar, logFields logrus.Fields, entity string, address string, format string, stopFunc *func(), opts *microstellar.Options) error {
+// isPayment returns true if the payment record is a payment (or path payment), and not
+// another operation that moves funds, like create_account or account_merge.
+func isPayment(payment *microstellar.Payment) bool {
+	switch payment.Type {
+	case "payment", "path_payment", "path_payment_strict_receive", "path_payment_strict_send":
+		return true
+	}
+
+	return false
+}
+
+// paymentCSVHeader is the header of the CSV files written by watch --to-csv.
+var paymentCSVHeader = []string{"timestamp", "from", "to", "asset", "amount", "memo"}
+
+// paymentRow returns the CSV row for payment, or false if it doesn't move a known
+// amount between accounts (e.g., account merges.) Accounts created by the payment
+// have no from address.
+func paymentRow(payment *microstellar.Payment) ([]string, bool) {
+	memo := ""
+	if payment.Memo.Type != "none" {
+		memo = payment.Memo.Value
+	}
+
+	if payment.Type == "create_account" {
+		return []string{payment.CreatedAt, "", payment.Account, "native", payment.StartingBalance, memo}, true
+	}
+
+	if !isPayment(payment) {
+		return nil, false
+	}
+
+	asset := "native"
+	if payment.AssetType != "native" {
+		asset = payment.AssetCode + ":" + payment.AssetIssuer
+	}
+
+	return []string{payment.CreatedAt, payment.From, payment.To, asset, payment.Amount, memo}, true
+}
+
+// paymentExport appends watched payments to a CSV file, one row at a time.
+type paymentExport struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+// newPaymentExport opens the CSV file at path for appending, and writes the header if
+// the file is new (or empty), so interrupted exports can be resumed with --cursor.
+func newPaymentExport(path string) (*paymentExport, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't open %s", path)
+	}
+
+	export := &paymentExport{file: file, writer: csv.NewWriter(file)}
+
+	info, err := file.Stat()
+	if err == nil && info.Size() == 0 {
+		err = export.writeRow(paymentCSVHeader)
+	}
+
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrapf(err, "can't write to %s", path)
+	}
+
+	return export, nil
+}
+
+// writeRow writes row and flushes it, so the file is complete whenever the watch stops.
+func (export *paymentExport) writeRow(row []string) error {
+	if err := export.writer.Write(row); err != nil {
+		return err
+	}
+
+	export.writer.Flush()
+	return export.writer.Error()
+}
+
+func (export *paymentExport) write(payment *microstellar.Payment) error {
+	row, ok := paymentRow(payment)
+	if !ok {
+		return nil
+	}
+
+	return export.writeRow(row)
+}
+
+func (export *paymentExport) close() error {
+	return export.file.Close()
+}
+
+// heartbeat emits a liveness line whenever a connected stream goes interval without
+// an event, so monitors can tell an idle stream from a hung one. A nil heartbeat is
+// disabled.
+type heartbeat struct {
+	interval time.Duration
+	emit     func(now time.Time)
+	events   chan bool
+	done     chan struct{}
+}
+
+// newHeartbeat returns a heartbeat that writes to stderr, or to stdout as JSON in json
+// format, or nil if interval is 0.
+func newHeartbeat(interval time.Duration, format string) *heartbeat {
+	if interval == 0 {
+		return nil
+	}
+
+	return startHeartbeat(interval, func(now time.Time) {
+		if format == "json" {
+			showSuccess(`{"heartbeat":%q}`, now.UTC().Format(time.RFC3339))
+		} else {
+			fmt.Fprintf(os.Stderr, "heartbeat: no events for %v at %s\n", interval, now.UTC().Format(time.RFC3339))
+		}
+	})
+}
+
+// startHeartbeat calls emit every interval until the next event. It starts paused,
+// until the stream connects.
+func startHeartbeat(interval time.Duration, emit func(now time.Time)) *heartbeat {
+	beat := &heartbeat{interval: interval, emit: emit, events: make(chan bool), done: make(chan struct{})}
+
+	go func() {
+		timer := time.NewTimer(interval)
+		timer.Stop()
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-beat.done:
+				return
+			case active := <-beat.events:
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+
+				if active {
+					timer.Reset(interval)
+				}
+			case now := <-timer.C:
+				beat.emit(now)
+				timer.Reset(interval)
+			}
+		}
+	}()
+
+	return beat
+}
+
+// reset restarts the interval, on every event and when the stream (re)connects.
+func (beat *heartbeat) reset() {
+	if beat != nil {
+		beat.events <- true
+	}
+}
+
+// pause stops the heartbeats while the stream is disconnected, so a dead stream
+// doesn't look alive.
+func (beat *heartbeat) pause() {
+	if beat != nil {
+		beat.events <- false
+	}
+}
+
+func (beat *heartbeat) stop() {
+	if beat != nil {
+		close(beat.done)
+	}
+}
+
+func watch(ms *microstellar.MicroStellar, logFields logrus.Fields, entity string, address string, format string, pretty bool, stopFunc *func(), opts *microstellar.Options, paymentsOnly bool, export *paymentExport, beat *heartbeat) error {
 	var watcher interface{}
 	var err error
 	var streamErr *error
@@ -49,31 +226,49 @@ func watch(ms *microstellar.MicroStellar, logFields logrus.Fields, entity string
 			watcher, err = ms.WatchPayments(address, opts)
 			*stopFunc = watcher.(*microstellar.PaymentWatcher).Done
 			streamErr = watcher.(*microstellar.PaymentWatcher).Err
+			beat.reset()
 			for entry := range watcher.(*microstellar.PaymentWatcher).Ch {
+				beat.reset()
+				if paymentsOnly && !isPayment(entry) {
+					continue
+				}
+
+				if export != nil {
+					if err := export.write(entry); err != nil {
+						(*stopFunc)()
+						return errors.Wrapf(err, "can't export payment %s", entry.ID)
+					}
+				}
+
 				if format == "line" {
 					showPayment(logFields, entry)
 				} else {
-					showEntry(logFields, entry, format)
+					showEntry(logFields, entry, format, pretty)
 				}
 			}
 		case "transactions":
 			watcher, err = ms.WatchTransactions(address, opts)
 			*stopFunc = watcher.(*microstellar.TransactionWatcher).Done
 			streamErr = watcher.(*microstellar.TransactionWatcher).Err
+			beat.reset()
 			for entry := range watcher.(*microstellar.TransactionWatcher).Ch {
-				showEntry(logFields, entry, format)
+				beat.reset()
+				showEntry(logFields, entry, format, pretty)
 			}
 		case "ledger":
 			watcher, err = ms.WatchLedgers(opts)
 			*stopFunc = watcher.(*microstellar.LedgerWatcher).Done
 			streamErr = watcher.(*microstellar.LedgerWatcher).Err
+			beat.reset()
 			for entry := range watcher.(*microstellar.LedgerWatcher).Ch {
-				showEntry(logFields, entry, format)
+				beat.reset()
+				showEntry(logFields, entry, format, pretty)
 			}
 		default:
 			return errors.Errorf("invalid watch entity: %s", entity)
 		}
 
+		beat.pause()
 		if *streamErr != nil {
 			debugf(logFields, "connection closed: %v", *streamErr)
 		}
@@ -89,9 +284,75 @@ func watch(ms *microstellar.MicroStellar, logFields logrus.Fields, entity string
 	return nil
 }
 
+// resolveCursor returns the horizon cursor for --cursor: "now" for only new events, ""
+// (no cursor) for "start", i.e., from the beginning, or a paging token.
+func resolveCursor(cursor string) (string, error) {
+	switch cursor {
+	case "now":
+		return cursor, nil
+	case "start":
+		return "", nil
+	}
+
+	// Paging tokens are IDs, some with an index (e.g., 123-1 for effects)
+	parts := strings.SplitN(cursor, "-", 2)
+	for _, part := range parts {
+		if _, err := strconv.ParseUint(part, 10, 64); err != nil {
+			return "", errors.Errorf("bad --cursor: %s, expecting: now, start, or a paging token", cursor)
+		}
+	}
+
+	return cursor, nil
+}
+
+// cursorSince returns the cursor to watch address from since, which is the paging
+// token of its last operation before then. It's "" (from the beginning) if there's
+// none. Operation paging tokens also order transactions and payments.
+func (cli *CLI) cursorSince(logFields logrus.Fields, address string, since time.Time) (string, error) {
+	cursor := ""
+
+	for {
+		query := url.Values{}
+		query.Set("order", "desc")
+		query.Set("limit", strconv.Itoa(maxPageSize))
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+
+		var page struct {
+			Embedded struct {
+				Records []struct {
+					PagingToken string `json:"paging_token"`
+					CreatedAt   string `json:"created_at"`
+				} `json:"records"`
+			} `json:"_embedded"`
+		}
+
+		if err := cli.getHorizonJSON(logFields, "/accounts/"+address+"/operations?"+query.Encode(), &page); err != nil {
+			return "", err
+		}
+
+		records := page.Embedded.Records
+		debugf(logFields, "got %d operations after cursor %q", len(records), cursor)
+
+		for _, op := range records {
+			if closedBefore(op.CreatedAt, since) {
+				debugf(logFields, "last operation before %v: %s", since, op.PagingToken)
+				return op.PagingToken, nil
+			}
+		}
+
+		if len(records) < maxPageSize {
+			return "", nil
+		}
+
+		cursor = records[len(records)-1].PagingToken
+	}
+}
+
 func (cli *CLI) buildWatchCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "watch [payments|transactions|ledger] [account]",
+		Use:   "watch [payments|transactions|ledger] [account] [--cursor now|start|paging_token|--since time]",
 		Short: "watch the account on the ledger",
 		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
@@ -111,25 +372,88 @@ func (cli *CLI) buildWatchCmd() *cobra.Command {
 				}
 			}
 
-			opts := microstellar.Opts()
 			cursor, _ := cmd.Flags().GetString("cursor")
+			cursor, err := resolveCursor(cursor)
+			if err != nil {
+				cli.error(logFields, "%v", err)
+				return
+			}
+
+			if sinceFlag, _ := cmd.Flags().GetString("since"); sinceFlag != "" {
+				if cmd.Flags().Changed("cursor") {
+					cli.error(logFields, "--cursor and --since are mutually exclusive")
+					return
+				}
+
+				if address == "" {
+					cli.error(logFields, "--since needs an account to look up")
+					return
+				}
+
+				since, err := parseSince(sinceFlag, time.Now().UTC())
+				if err != nil {
+					cli.error(logFields, "bad --since: %v", err)
+					return
+				}
+
+				cursor, err = cli.cursorSince(logFields, address, since)
+				if err != nil {
+					cli.errorWithCode(ExitNetworkError, logFields, "can't find where to start watching: %v", cli.errorString(err))
+					return
+				}
+			}
 
-			if cursor != "start" {
+			opts := microstellar.Opts()
+			if cursor != "" {
 				opts = opts.WithCursor(cursor)
 			}
 
+			paymentsOnly, _ := cmd.Flags().GetBool("payments-only")
+			toCSV, _ := cmd.Flags().GetString("to-csv")
+
+			if entity != "payments" && (paymentsOnly || toCSV != "") {
+				cli.error(logFields, "--payments-only and --to-csv only work with: watch payments")
+				return
+			}
+
+			interval, _ := cmd.Flags().GetDuration("heartbeat")
+			if interval < 0 {
+				cli.error(logFields, "bad --heartbeat: %v", interval)
+				return
+			}
+
+			var export *paymentExport
+			if toCSV != "" {
+				var err error
+				export, err = newPaymentExport(toCSV)
+				if err != nil {
+					cli.error(logFields, "%v", err)
+					return
+				}
+				defer export.close()
+			}
+
 			format, _ := cmd.Flags().GetString("format")
-			err := watch(cli.ms, logFields, entity, address, format, &cli.stopWatcher, opts)
+			pretty, _ := cmd.Flags().GetBool("pretty")
+			beat := newHeartbeat(interval, format)
+			defer beat.stop()
+
+			err = watch(cli.ms, logFields, entity, address, format, pretty, &cli.stopWatcher, opts, paymentsOnly, export, beat)
 
 			if err != nil {
-				cli.error(logFields, "can't watch stream: %v", microstellar.ErrorString(err))
+				cli.errorWithCode(ExitNetworkError, logFields, "can't watch stream: %v", cli.errorString(err))
 				return
 			}
 		},
 	}
 
 	cmd.Flags().String("format", "line", "output format (json, yaml, struct)")
+	buildPrettyFlag(cmd)
 	cmd.Flags().String("cursor", "now", "start watching from (now, start, paging_token)")
+	cmd.Flags().String("since", "", "start watching from the account's first operation since 'YYYY-MM-DD HH:MM:SS' in UTC, or this long ago (e.g., 24h)")
+	cmd.Flags().Bool("payments-only", false, "skip account creations and merges when watching payments")
+	cmd.Flags().String("to-csv", "", "also append the payments to this CSV file (timestamp, from, to, asset, amount, memo)")
+	cmd.Flags().Duration("heartbeat", 0, "emit a heartbeat line (to stderr, or stdout with --format json) after this long without events, 0 to disable")
 
 	return cmd
 }
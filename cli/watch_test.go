@@ -0,0 +1,223 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xfe/microstellar"
+	"github.com/sirupsen/logrus"
+)
+
+func TestPaymentExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lumen-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	payment := &microstellar.Payment{
+		Type: "payment", CreatedAt: "2018-01-01T00:00:00Z", From: "GFROM", To: "GTO",
+		AssetType: "credit_alphanum4", AssetCode: "USD", AssetIssuer: "GISSUER", Amount: "10.0000000",
+	}
+	payment.Memo.Type = "text"
+	payment.Memo.Value = "rent, january"
+
+	created := &microstellar.Payment{Type: "create_account", CreatedAt: "2018-01-02T00:00:00Z", Account: "GNEW", StartingBalance: "5.0000000"}
+	created.Memo.Type = "none"
+
+	merged := &microstellar.Payment{Type: "account_merge", CreatedAt: "2018-01-03T00:00:00Z"}
+
+	if isPayment(created) || isPayment(merged) || !isPayment(payment) {
+		t.Error("wrong payment types")
+	}
+
+	path := filepath.Join(dir, "payments.csv")
+	for _, payments := range [][]*microstellar.Payment{{payment, created}, {merged, payment}} {
+		export, err := newPaymentExport(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, p := range payments {
+			if err := export.write(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		export.close()
+	}
+
+	// The header is only written once, and merges are skipped
+	got, _ := ioutil.ReadFile(path)
+	want := "timestamp,from,to,asset,amount,memo\n" +
+		"2018-01-01T00:00:00Z,GFROM,GTO,USD:GISSUER,10.0000000,\"rent, january\"\n" +
+		"2018-01-02T00:00:00Z,,GNEW,native,5.0000000,\n" +
+		"2018-01-01T00:00:00Z,GFROM,GTO,USD:GISSUER,10.0000000,\"rent, january\"\n"
+	if string(got) != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, got)
+	}
+
+	if _, err := newPaymentExport(filepath.Join(dir, "nodir", "payments.csv")); err == nil {
+		t.Error("want error for missing directory")
+	}
+}
+
+func TestWatchFlags(t *testing.T) {
+	cli, _ := newTestCLI()
+	cli.TestCommand("set config:network fake")
+	cli.TestCommand("account new mo")
+
+	expectOutput(t, cli, "error", "watch transactions mo --payments-only")
+	expectOutput(t, cli, "error", "watch ledger --to-csv payments.csv")
+	expectOutput(t, cli, "error", "watch payments mo --to-csv /nonexistent/payments.csv")
+	expectOutput(t, cli, "error", "watch ledger --heartbeat -1s")
+}
+
+func TestHeartbeat(t *testing.T) {
+	beats := make(chan time.Time, 10)
+	beat := startHeartbeat(20*time.Millisecond, func(now time.Time) { beats <- now })
+	defer beat.stop()
+
+	expectBeats := func(want bool) {
+		select {
+		case <-beats:
+			if !want {
+				t.Error("want no heartbeat")
+			}
+		case <-time.After(100 * time.Millisecond):
+			if want {
+				t.Error("want a heartbeat")
+			}
+		}
+	}
+
+	// No heartbeats until the stream connects
+	expectBeats(false)
+
+	beat.reset()
+	expectBeats(true)
+	expectBeats(true)
+
+	// Disconnected streams don't look alive
+	beat.pause()
+	for len(beats) > 0 {
+		<-beats
+	}
+	expectBeats(false)
+
+	// Events push heartbeats back
+	beat.reset()
+	for i := 0; i < 10; i++ {
+		time.Sleep(5 * time.Millisecond)
+		beat.reset()
+	}
+
+	if len(beats) > 0 {
+		t.Error("want no heartbeat while events are arriving")
+	}
+
+	// Disabled heartbeats are nil, and do nothing
+	var disabled *heartbeat
+	disabled.reset()
+	disabled.pause()
+	disabled.stop()
+
+	if newHeartbeat(0, "json") != nil {
+		t.Error("want no heartbeat for interval 0")
+	}
+}
+
+func TestResolveCursor(t *testing.T) {
+	tests := []struct {
+		cursor string
+		want   string
+		err    bool
+	}{
+		{"now", "now", false},
+		{"start", "", false},
+		{"12884905984", "12884905984", false},
+		{"12884905985-1", "12884905985-1", false},
+		{"", "", true},
+		{"yesterday", "", true},
+		{"-1", "", true},
+		{"123-", "", true},
+	}
+
+	for _, test := range tests {
+		got, err := resolveCursor(test.cursor)
+		if (err != nil) != test.err || got != test.want {
+			t.Errorf("cursor %q: want %q (error: %v), got %q (%v)", test.cursor, test.want, test.err, got, err)
+		}
+	}
+}
+
+func TestCursorSince(t *testing.T) {
+	cli, _ := newTestCLI()
+	address := "GBY7XDYKXBDHQ2B523SF7K6BNJNRYHVQMWY7AYAEKTYLCQMYVFHL57UM"
+
+	// 250 operations, one a minute (newest first) from 2020-01-01 04:09:00
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var queries []string
+	server := newTestHorizon(cli, "passphrase", func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Path+"?"+r.URL.RawQuery)
+
+		first := 250
+		if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+			first, _ = strconv.Atoi(cursor)
+			first--
+		}
+
+		records := []string{}
+		for i := first; i > 0 && len(records) < maxPageSize; i-- {
+			createdAt := start.Add(time.Duration(i-1) * time.Minute).Format(time.RFC3339)
+			records = append(records, fmt.Sprintf(`{"paging_token": "%d", "created_at": "%s"}`, i, createdAt))
+		}
+
+		fmt.Fprintf(w, `{"_embedded": {"records": [%s]}}`, strings.Join(records, ","))
+	})
+	defer server.Close()
+
+	logFields := logrus.Fields{"test": "cursor"}
+
+	tests := []struct {
+		since   time.Time
+		want    string
+		queries int
+	}{
+		{start.Add(200 * time.Minute), "200", 1},
+		{start.Add(30 * time.Minute), "30", 2},
+		{start.Add(30*time.Minute + time.Second), "31", 2},
+		{start, "", 2},
+		{start.Add(time.Hour * 24), "250", 1},
+	}
+
+	for _, test := range tests {
+		queries = nil
+		got, err := cli.cursorSince(logFields, address, test.since)
+		if err != nil {
+			t.Errorf("since %v: unexpected error: %v", test.since, err)
+			continue
+		}
+
+		if got != test.want || len(queries) != test.queries {
+			t.Errorf("since %v: want cursor %q (%d queries), got %q (%v)", test.since, test.want, test.queries, got, queries)
+		}
+	}
+
+	if !strings.HasPrefix(queries[0], "/accounts/"+address+"/operations?") || !strings.Contains(queries[0], "order=desc") {
+		t.Errorf("want the account's operations newest first, got %s", queries[0])
+	}
+
+	cli.TestCommand("set config:network fake")
+	expectOutput(t, cli, "error", "watch payments "+address+" --since 24h --cursor now")
+	expectOutput(t, cli, "error", "watch payments "+address+" --since yesterday")
+	expectOutput(t, cli, "error", "watch ledger --since 24h")
+	expectOutput(t, cli, "error", "watch payments "+address+" --cursor yesterday")
+}
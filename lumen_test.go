@@ -1,6 +1,7 @@
 package lumen
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -19,6 +20,9 @@ const payBack = "GCXZW4IEBTCQQ6JY4COH3O2SSCBUAMPJ4WM4EU2GWBZ4MNVZJSTISBOE"
 // Suck funds from here if friendbot fails
 const fundSource = "SDPWNPMCESNRW47YS2XIZ3BZTGTGBO54A3EPGUG72DYPQJO5MAEGK6JY"
 
+// The cli package is shadowed by the cli variable in tests
+const exitTxFailed = cli.ExitTxFailed
+
 func getTempFile() (string, func()) {
 	dir, err := ioutil.TempDir("", "example")
 	if err != nil {
@@ -116,6 +120,51 @@ func TestPayments(t *testing.T) {
 	if balance > 99 {
 		t.Fatalf("expected balance <= 99 got %v", balance)
 	}
+
+	// New accounts can only be funded with XLM
+	run(cli, "account new bill")
+	run(cli, "asset set USD mo")
+	expectOutput(t, cli, "error", "pay 10 USD --from mo --to bill --fund")
+	expectOutput(t, cli, "error", "pay 10 USD --from mo --to bill --create-account")
+	expectOutput(t, cli, "", "pay 10 --from mo --to bill --create-account")
+
+	// ... and --fund pays existing accounts
+	expectOutput(t, cli, "", "pay 10 --from mo --to bill --fund")
+	if balance := getBalance(cli, "bill"); balance != 20 {
+		t.Fatalf("expected balance 20 got %v", balance)
+	}
+
+	// Sponsored fees: mo pays the fee for bill's payment, so bill pays exactly 5 XLM
+	expectOutput(t, cli, "", "pay 5 --from bill --to kelly --exact-fee-account mo")
+	if balance := getBalance(cli, "bill"); balance != 15 {
+		t.Fatalf("expected balance 15 got %v", balance)
+	}
+
+	// Confirm that payments arrive
+	if got := run(cli, "pay 1 --from mo --to kelly --confirm"); !strings.HasPrefix(got, "confirmed: kelly received 1 XLM") {
+		t.Fatalf("expected confirmation, got %v", got)
+	}
+
+	// Create and fund accounts in one step
+	run(cli, "account new sam --fund-from mo --start-balance 15")
+	if balance := getBalance(cli, "sam"); balance != 15 {
+		t.Fatalf("expected balance 15 got %v", balance)
+	}
+
+	// ... with at least the minimum balance
+	expectOutput(t, cli, "error", "account new sue --fund-from mo --start-balance 0.5")
+
+	// Accounts can require memos on incoming payments (SEP-29)
+	expectOutput(t, cli, "", "data bill config.memo_required 1")
+	expectOutput(t, cli, "error", "pay 1 --from mo --to bill")
+	expectOutput(t, cli, "", "pay 1 --from mo --to bill --memoid 42")
+	expectOutput(t, cli, "", "pay 1 --from mo --to bill --skip-memo-check")
+
+	// Overspending is rejected by the network
+	expectOutput(t, cli, "error", "pay 1000000 --from kelly --to mo")
+	if code := cli.ExitCode(); code != exitTxFailed {
+		t.Fatalf("expected exit code %d got %d", exitTxFailed, code)
+	}
 }
 
 func TestAssets(t *testing.T) {
@@ -140,11 +189,78 @@ func TestAssets(t *testing.T) {
 	// Verify balance on kelly's account
 	expectOutput(t, cli, "100.0000000", "balance kelly USD")
 
+	// The limit can't go below the balance, but can be raised or removed
+	expectOutput(t, cli, "error", "trust create kelly USD 99")
+	expectOutput(t, cli, "", "trust create kelly USD 100")
+	expectOutput(t, cli, "", "trust create kelly USD --unlimited")
+
+	// Same again with a 12-character (credit_alphanum12) asset code
+	run(cli, "asset set USDCOINCITI citibank")
+	expectOutput(t, cli, "credit_alphanum12", "asset type USDCOINCITI")
+	expectOutput(t, cli, "", "trust create kelly USDCOINCITI 1000")
+	expectOutput(t, cli, "", "pay 100 USDCOINCITI --from citibank --to kelly")
+	expectOutput(t, cli, "100.0000000", "balance kelly USDCOINCITI")
+
 	// Change the flags on the issuers account
 	expectOutput(t, cli, "", "flags citibank auth_revocable")
 	expectOutput(t, cli, "", "flags citibank auth_revocable --clear")
 }
 
+func TestBatch(t *testing.T) {
+	cli, cleanupFunc := newCLI()
+	defer cleanupFunc()
+
+	createFundedAccount(t, cli, "mo")
+
+	run(cli, "account new citibank")
+	expectOutput(t, cli, "", "pay 100 --from mo --to citibank --fund")
+	run(cli, "asset set USD citibank")
+
+	run(cli, "account new kelly")
+	expectOutput(t, cli, "", "pay 10 --from mo --to kelly --fund")
+
+	// Create kelly's trustline and pay her in one transaction
+	expectOutput(t, cli, "", "batch begin kelly")
+	expectOutput(t, cli, "", "trust create kelly USD 1000 --batch")
+	expectOutput(t, cli, "", "pay 100 USD --from citibank --to kelly --batch")
+	expectOutput(t, cli, "0", "balance kelly USD")
+
+	expectOutput(t, cli, "", "batch commit --signers kelly,citibank")
+	expectOutput(t, cli, "100.0000000", "balance kelly USD")
+}
+
+func TestTrustAuthorization(t *testing.T) {
+	cli, cleanupFunc := newCLI()
+	defer cleanupFunc()
+
+	createFundedAccount(t, cli, "mo")
+
+	run(cli, "account new citibank")
+	run(cli, "account new kelly")
+	expectOutput(t, cli, "", "pay 100 --from mo --to citibank --memoid 1 --fund")
+	expectOutput(t, cli, "", "pay 10 --from mo --to kelly --memoid 1 --fund")
+	run(cli, "asset set USD citibank")
+
+	// Trustlines to USD can't be authorized until citibank requires it
+	expectOutput(t, cli, "", "trust create kelly USD 1000")
+	expectOutput(t, cli, "error", "trust authorize citibank kelly USD")
+
+	expectOutput(t, cli, "", "flags citibank auth_required auth_revocable")
+
+	// Fully authorized
+	expectOutput(t, cli, "", "trust authorize citibank kelly USD")
+	expectOutput(t, cli, "", "pay 10 USD --from citibank --to kelly")
+
+	// Authorized to maintain liabilities: kelly keeps its USD, but can't receive more
+	expectOutput(t, cli, "", "trust authorize citibank kelly USD --maintain-liabilities")
+	expectOutput(t, cli, "error", "pay 10 USD --from citibank --to kelly")
+	expectOutput(t, cli, "10.0000000", "balance kelly USD")
+
+	// Revoked
+	expectOutput(t, cli, "", "trust authorize citibank kelly USD --revoke")
+	expectOutput(t, cli, "error", "pay 10 USD --from citibank --to kelly")
+}
+
 func TestMultisig(t *testing.T) {
 	cli, cleanupFunc := newCLI()
 	defer cleanupFunc()
@@ -192,13 +308,30 @@ func TestMultisig(t *testing.T) {
 	// Make another multisig payment
 	expectOutput(t, cli, "", "pay 10 --from sharon --to fred --signers sharon,mary")
 
+	// Swap mary for bob in one transaction. Removing mary first on its own would leave
+	// sharon unable to meet her thresholds.
+	expectOutput(t, cli, "error", "signer replace sharon --old fred --new bob --weight 1 --signers sharon,mary")
+	expectOutput(t, cli, "", "signer replace sharon --old mary --new bob --weight 1 --signers sharon,mary")
+	expectOutput(t, cli, "error", "pay 10 --from sharon --to fred --signers sharon,mary")
+	expectOutput(t, cli, "", "pay 10 --from sharon --to fred --signers sharon,bob")
+	expectOutput(t, cli, "", "signer replace sharon --old bob --new mary --weight 1 --signers sharon,bob")
+
 	// Kill sharon's keys
 	expectOutput(t, cli, "", "signer thresholds sharon 1 1 1 --signers sharon,mary")
-	expectOutput(t, cli, "", "signer masterweight sharon 0")
+	expectOutput(t, cli, "", "signer masterweight sharon 0 --yes")
 
 	expectOutput(t, cli, "error", "pay 10 --from sharon --to fred")
 	expectOutput(t, cli, "", "pay 10 --from sharon --to fred --signers mary")
 
+	// Changes that leave no set of signers able to meet the high threshold are refused
+	expectOutput(t, cli, "error", "signer remove mary --from sharon --signers mary")
+	expectOutput(t, cli, "error", "signer thresholds sharon 1 1 2 --signers mary")
+	expectOutput(t, cli, "error", "signer add mary 0 --to sharon --signers mary")
+
+	// ... unless it's deliberate
+	expectOutput(t, cli, "", "signer remove mary --from sharon --signers mary --allow-lockout")
+	expectOutput(t, cli, "error", "pay 10 --from sharon --to fred --signers mary")
+
 	// Stop watching sharon's ledger
 	// done()
 }
@@ -260,6 +393,16 @@ func TestDex(t *testing.T) {
 		t.Errorf("unexpected result, want empty string, got: %v", out)
 	}
 
+	out = run(cli, "dex orderbook USD EUR --depth 2")
+	if !strings.Contains(out, "cumulative") {
+		t.Errorf("unexpected result, want aggregated orderbook, got: %v", out)
+	}
+
+	out = run(cli, "dex orderbook EUR USD --limit 0 --depth 2")
+	if out != "" {
+		t.Errorf("unexpected result, want empty string, got: %v", out)
+	}
+
 	// Create counterparty offers
 	expectOutput(t, cli, "", "dex trade citibank --sell EUR --buy USD --amount 10 --price 0.5")
 	expectOutput(t, cli, "", "dex trade chase --sell EUR --buy USD --amount 2 --price 1")
@@ -322,3 +465,38 @@ func TestTimeBounds(t *testing.T) {
 
 	log.Print(output)
 }
+
+func TestBumpSequence(t *testing.T) {
+	cli, cleanupFunc := newCLI()
+	defer cleanupFunc()
+
+	createFundedAccount(t, cli, "mo")
+
+	var account struct {
+		Sequence string `json:"seq"`
+	}
+
+	if err := json.Unmarshal([]byte(run(cli, "info mo")), &account); err != nil {
+		t.Fatalf("can't parse account info: %v", err)
+	}
+
+	seq, err := strconv.ParseInt(account.Sequence, 10, 64)
+	if err != nil {
+		t.Fatalf("bad sequence number: %v", account.Sequence)
+	}
+
+	bumpTo := strconv.FormatInt(seq+100, 10)
+	expectOutput(t, cli, "", "tx bump-seq mo "+bumpTo)
+	expectOutput(t, cli, "error", "tx bump-seq mo "+strconv.FormatInt(seq, 10))
+
+	if err := json.Unmarshal([]byte(run(cli, "info mo")), &account); err != nil {
+		t.Fatalf("can't parse account info: %v", err)
+	}
+
+	if account.Sequence != bumpTo {
+		t.Errorf("want sequence number %s, got %s", bumpTo, account.Sequence)
+	}
+
+	// The account still works after the bump
+	expectOutput(t, cli, "", "pay 1 --from mo --to landlord")
+}
@@ -3,6 +3,10 @@ package store
 import (
 	"encoding/json"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -35,19 +39,24 @@ func newFileData() *fileData {
 }
 
 // newFileDataFromFile tries to load data from fileName, creating a
-// new file with empty data if it can't read it. Returns error if it
-// can't parse an existing file, or reads invalid data.
+// new file with empty data if it doesn't exist. Returns error if it
+// can't read or parse an existing file, or reads invalid data. An
+// existing file is never replaced, so a failed read can't erase it.
 func newFileDataFromFile(fileName string) (*fileData, error) {
 	fileData := newFileData()
 
 	logrus.WithFields(logrus.Fields{"type": "filestore", "method": "new"}).Debugf("reading file: %s", fileName)
 	data, err := ioutil.ReadFile(fileName)
-	if err != nil {
-		logrus.WithFields(logrus.Fields{"type": "filestore", "method": "new"}).Debugf("read error: %v", err)
+	if os.IsNotExist(err) {
 		logrus.WithFields(logrus.Fields{"type": "filestore", "method": "new"}).Infof("creating new file: %s", fileName)
 		return fileData, fileData.sync(fileName)
 	}
 
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"type": "filestore", "method": "new"}).Errorf("read error: %v", err)
+		return nil, errors.Errorf("can't read %s: %v", fileName, err)
+	}
+
 	err = json.Unmarshal(data, &fileData)
 
 	if err != nil {
@@ -67,7 +76,7 @@ func (data *fileData) sync(fileName string) error {
 	}
 
 	logrus.WithFields(logrus.Fields{"type": "filestore", "method": "sync"}).Debugf("writing to file: %s", fileName)
-	err = ioutil.WriteFile(fileName, jsonData, 0600)
+	err = writeFileAtomic(fileName, jsonData)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{"type": "filestore", "method": "sync"}).Errorf("write error: %v", err)
 		return errors.Errorf("could not write to file: %v", err)
@@ -76,6 +85,34 @@ func (data *fileData) sync(fileName string) error {
 	return nil
 }
 
+// writeFileAtomic writes data to a temporary file next to fileName, and renames it
+// over fileName, so readers never see a partially written file.
+func writeFileAtomic(fileName string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), fileName)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
+
 // DataStore represents the conntection to the Google Cloud Datastore.
 type FileStore struct {
 	*Store
@@ -84,8 +121,20 @@ type FileStore struct {
 	data *fileData
 }
 
+// lockPath returns the path of the lock file that serializes writes to the file at
+// path, across processes.
+func lockPath(path string) string {
+	return path + ".lock"
+}
+
 func NewFileStore(path string) (*FileStore, error) {
+	unlock, err := lockFile(lockPath(path))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't lock file store")
+	}
+
 	fileData, err := newFileDataFromFile(path)
+	unlock()
 
 	if err != nil {
 		return nil, errors.Wrap(err, "can't read or create file store")
@@ -104,9 +153,30 @@ func NewFileStore(path string) (*FileStore, error) {
 	return fileStore, nil
 }
 
-// sync must be called under mu
-func (fs *FileStore) sync() error {
-	return fs.data.sync(fs.path)
+// update applies change to the latest data in the file, and writes it back. Other
+// processes may have written the file since it was loaded, so the file is reloaded
+// (under the file lock) first. update must be called under mu.
+func (fs *FileStore) update(change func(data *fileData)) error {
+	unlock, err := lockFile(lockPath(fs.path))
+	if err != nil {
+		return errors.Wrap(err, "can't lock file store")
+	}
+	defer unlock()
+
+	data, err := newFileDataFromFile(fs.path)
+	if err != nil {
+		return err
+	}
+
+	change(data)
+	data.Seq++
+
+	if err := data.sync(fs.path); err != nil {
+		return err
+	}
+
+	fs.data = data
+	return nil
 }
 
 func (fs *FileStore) Set(k string, v string, ttl time.Duration) error {
@@ -114,14 +184,30 @@ func (fs *FileStore) Set(k string, v string, ttl time.Duration) error {
 	defer fs.mu.Unlock()
 
 	logrus.WithFields(logrus.Fields{"type": "filestore", "method": "set", "key": k}).Debugf("writing val: %s (ttl: %v)", v, ttl)
-	fs.data.Pairs[k] = fileEntry{
-		Value:     v,
-		NoExpire:  ttl == 0,
-		ExpiresOn: time.Now().Add(ttl),
-	}
+	return fs.update(func(data *fileData) {
+		data.Pairs[k] = fileEntry{
+			Value:     v,
+			NoExpire:  ttl == 0,
+			ExpiresOn: time.Now().Add(ttl),
+		}
+	})
+}
+
+// SetMany sets all of pairs with one rewrite of the file (see BulkSetter.)
+func (fs *FileStore) SetMany(pairs map[string]string, ttl time.Duration) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 
-	fs.data.Seq++
-	return fs.sync()
+	logrus.WithFields(logrus.Fields{"type": "filestore", "method": "setmany"}).Debugf("writing %d vals (ttl: %v)", len(pairs), ttl)
+	return fs.update(func(data *fileData) {
+		for k, v := range pairs {
+			data.Pairs[k] = fileEntry{
+				Value:     v,
+				NoExpire:  ttl == 0,
+				ExpiresOn: time.Now().Add(ttl),
+			}
+		}
+	})
 }
 
 func (fs *FileStore) Get(k string) (string, error) {
@@ -143,6 +229,23 @@ func (fs *FileStore) Delete(k string) error {
 	defer fs.mu.Unlock()
 
 	logrus.WithFields(logrus.Fields{"type": "filestore", "method": "delete", "key": k}).Debugf("deleting")
-	delete(fs.data.Pairs, k)
-	return fs.sync()
+	return fs.update(func(data *fileData) {
+		delete(data.Pairs, k)
+	})
+}
+
+func (fs *FileStore) Keys(prefix string) ([]string, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	keys := []string{}
+	for k, v := range fs.data.Pairs {
+		if strings.HasPrefix(k, prefix) && !v.expired() {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+	logrus.WithFields(logrus.Fields{"type": "filestore", "method": "keys", "prefix": prefix}).Debugf("found %d keys", len(keys))
+	return keys, nil
 }
@@ -1,9 +1,12 @@
 package store
 
 import (
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"sync"
 	"testing"
 )
 
@@ -41,3 +44,140 @@ func TestFileStore_TTL(t *testing.T) {
 
 	testTTL(t, store)
 }
+
+func TestFileStore_Keys(t *testing.T) {
+	tmpDir, tmpFile := getTempFile()
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStore("file", tmpFile)
+
+	if err != nil {
+		t.Errorf("couldn't setup internal store, want %v, got %v", nil, err)
+	}
+
+	testKeys(t, store)
+}
+
+func TestFileStore_ConcurrentWrites(t *testing.T) {
+	tmpDir, tmpFile := getTempFile()
+	defer os.RemoveAll(tmpDir)
+
+	// Each store has its own copy of the data, like separate lumen processes would
+	const writers = 8
+	const keysPerWriter = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*keysPerWriter)
+
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			store, err := NewStore("file", tmpFile)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			for j := 0; j < keysPerWriter; j++ {
+				errs <- store.Set(fmt.Sprintf("writer:%d:key:%d", i, j), "val", 0)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(tmpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var contents fileData
+	if err := json.Unmarshal(data, &contents); err != nil {
+		t.Fatalf("store isn't valid JSON: %v", err)
+	}
+
+	if got := len(contents.Pairs); got != writers*keysPerWriter {
+		t.Errorf("want %d keys, got %d", writers*keysPerWriter, got)
+	}
+
+	store, _ := NewStore("file", tmpFile)
+	keys, _ := store.Keys("writer:")
+	if len(keys) != writers*keysPerWriter {
+		t.Errorf("want %d keys, got %d", writers*keysPerWriter, len(keys))
+	}
+}
+
+func TestFileStore_UnreadableFile(t *testing.T) {
+	tmpDir, tmpFile := getTempFile()
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewStore("file", tmpFile)
+	if err != nil {
+		t.Fatalf("couldn't setup file store: %v", err)
+	}
+
+	if err := store.Set("seed", "SSEED", 0); err != nil {
+		t.Fatalf("couldn't set key: %v", err)
+	}
+
+	want, _ := ioutil.ReadFile(tmpFile)
+	if err := os.Chmod(tmpFile, 0); err != nil {
+		t.Fatalf("couldn't make file unreadable: %v", err)
+	}
+	defer os.Chmod(tmpFile, 0600)
+
+	if _, err := ioutil.ReadFile(tmpFile); err == nil {
+		t.Skip("file is still readable (running as root?)")
+	}
+
+	// A file that can't be read must not be replaced by an empty store
+	if err := store.Set("other", "value", 0); err == nil {
+		t.Error("want error setting a key in an unreadable file")
+	}
+
+	os.Chmod(tmpFile, 0600)
+	if got, _ := ioutil.ReadFile(tmpFile); string(got) != string(want) {
+		t.Errorf("want contents kept:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestFileStore_SetMany(t *testing.T) {
+	tmpDir, tmpFile := getTempFile()
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewFileStore(tmpFile)
+	if err != nil {
+		t.Fatalf("couldn't setup file store: %v", err)
+	}
+
+	store.Set("mo", "old", 0)
+	pairs := map[string]string{"mo": "new", "kelly": "GKELLY", "bob": "GBOB"}
+	if err := store.SetMany(pairs, 0); err != nil {
+		t.Fatalf("couldn't set keys: %v", err)
+	}
+
+	// Written together, and visible to other processes
+	other, err := NewFileStore(tmpFile)
+	if err != nil {
+		t.Fatalf("couldn't reopen file store: %v", err)
+	}
+
+	if other.data.Seq != 2 {
+		t.Errorf("want 2 writes, got %d", other.data.Seq)
+	}
+
+	for k, want := range pairs {
+		if got, err := other.Get(k); err != nil || got != want {
+			t.Errorf("%s: want %q, got %q (%v)", k, want, got, err)
+		}
+	}
+}
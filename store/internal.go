@@ -4,6 +4,8 @@ package store
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -123,3 +125,19 @@ func (store *Internal) Delete(k string) error {
 
 	return fmt.Errorf("No value in store for key: %v", k)
 }
+
+// Keys returns the sorted list of unexpired keys that start with prefix.
+func (store *Internal) Keys(prefix string) ([]string, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	keys := []string{}
+	for k, v := range store.entries {
+		if strings.HasPrefix(k, prefix) && !v.expired() {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
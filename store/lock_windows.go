@@ -0,0 +1,39 @@
+//go:build windows
+// +build windows
+
+package store
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// lockTimeout is how long to wait for another process to release the lock.
+const lockTimeout = 10 * time.Second
+
+// lockFile takes an exclusive lock by creating the file at path, waiting (up to
+// lockTimeout) for other processes to remove it. Call the returned function to
+// release it.
+func lockFile(path string) (func() error, error) {
+	deadline := time.Now().Add(lockTimeout)
+
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			file.Close()
+			return func() error { return os.Remove(path) }, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, errors.Wrapf(err, "can't create lock file")
+		}
+
+		if time.Now().After(deadline) {
+			return nil, errors.Errorf("timed out waiting for lock, remove %s if no other lumen is running", path)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
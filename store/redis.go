@@ -1,7 +1,10 @@
 package store
 
 import (
+	"bytes"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis"
@@ -62,3 +65,53 @@ func (store *Redis) Delete(k string) error {
 	}
 	return err
 }
+
+// Keys returns the keys that start with prefix. It uses SCAN rather than KEYS, which
+// blocks the server while it walks the whole keyspace.
+func (store *Redis) Keys(prefix string) ([]string, error) {
+	match := escapeGlob(store.prefix+prefix) + "*"
+
+	keys := []string{}
+	var cursor uint64
+	for {
+		redisKeys, next, err := store.client.Scan(cursor, match, 100).Result()
+		if err != nil {
+			log.WithFields(log.Fields{"type": "redis", "method": "keys"}).Errorf("Scan: %v", err)
+			return nil, err
+		}
+
+		for _, k := range redisKeys {
+			keys = append(keys, strings.TrimPrefix(k, store.prefix))
+		}
+
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+
+	// SCAN may return a key more than once
+	sort.Strings(keys)
+	unique := keys[:0]
+	for i, k := range keys {
+		if i == 0 || k != keys[i-1] {
+			unique = append(unique, k)
+		}
+	}
+
+	return unique, nil
+}
+
+// escapeGlob escapes the characters in s that are special in redis's glob-style
+// patterns, so the pattern matches s literally.
+func escapeGlob(s string) string {
+	var b bytes.Buffer
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
@@ -31,3 +31,29 @@ func TestRedisStore_TTL(t *testing.T) {
 
 	testTTL(t, store)
 }
+
+func TestRedisStore_Keys(t *testing.T) {
+	store, err := NewStore("redis", "localhost:6379")
+
+	if err != nil {
+		log.Printf("skipping tests: couldn't setup internal store, want %v, got %v", nil, err)
+		return
+	}
+
+	testKeys(t, store)
+}
+
+func TestRedisStore_EscapeGlob(t *testing.T) {
+	tests := map[string]string{
+		"keys:":          "keys:",
+		"keys:[a]*?:":    `keys:\[a\]\*\?:`,
+		`back\slash`:     `back\\slash`,
+		"default:acct:*": `default:acct:\*`,
+	}
+
+	for in, want := range tests {
+		if got := escapeGlob(in); got != want {
+			t.Errorf("escapeGlob(%q): want %q, got %q", in, want, got)
+		}
+	}
+}
@@ -12,6 +12,13 @@ type API interface {
 	Set(k string, v string, ttl time.Duration) error
 	Get(k string) (string, error)
 	Delete(k string) error
+	Keys(prefix string) ([]string, error)
+}
+
+// BulkSetter is implemented by stores that can set many keys in one write, so either
+// all of them are set, or none are.
+type BulkSetter interface {
+	SetMany(pairs map[string]string, ttl time.Duration) error
 }
 
 // Store represents the storage backend. Currently, only "internal" and "redis" are supported.
@@ -36,6 +43,66 @@ func NewStore(driver, parameters string) (API, error) {
 	return nil, errors.Errorf("Driver not found: %s", driver)
 }
 
+// Copy copies all the keys in src (in every namespace) to dst, and returns the keys it
+// copied. If dst already has any of the keys with a different value, nothing is copied
+// unless overwrite is set. API can't read a key's TTL, so the copies never expire,
+// even if the originals do (lumen itself only sets keys without one.)
+//
+// If dst is a BulkSetter (e.g., a file store), the keys are written at once, and a
+// failed write copies nothing. Otherwise, they're set one by one, and if a write fails,
+// the keys before it stay copied (and are returned with the error.)
+func Copy(src, dst API, overwrite bool) ([]string, error) {
+	keys, err := src.Keys("")
+	if err != nil {
+		return nil, errors.Wrap(err, "can't list keys")
+	}
+
+	values := make(map[string]string, len(keys))
+	for _, k := range keys {
+		v, err := src.Get(k)
+		if err != nil {
+			// Expired since it was listed
+			continue
+		}
+
+		if current, err := dst.Get(k); err == nil && current != v && !overwrite {
+			return nil, errors.Errorf("destination already has a different value for %s", k)
+		}
+
+		values[k] = v
+	}
+
+	copied := []string{}
+	if bulk, ok := dst.(BulkSetter); ok {
+		if err := bulk.SetMany(values, 0); err != nil {
+			return copied, errors.Wrap(err, "can't write keys")
+		}
+
+		for _, k := range keys {
+			if _, ok := values[k]; ok {
+				copied = append(copied, k)
+			}
+		}
+
+		return copied, nil
+	}
+
+	for _, k := range keys {
+		v, ok := values[k]
+		if !ok {
+			continue
+		}
+
+		if err := dst.Set(k, v, 0); err != nil {
+			return copied, errors.Wrapf(err, "can't write %s", k)
+		}
+
+		copied = append(copied, k)
+	}
+
+	return copied, nil
+}
+
 type DummyStore struct {
 	store *Store
 }
@@ -53,3 +120,7 @@ func (store *DummyStore) Get(k string) (string, error) {
 func (store *DummyStore) Delete(k string) error {
 	return errors.Errorf("Dummy store stores nothing!")
 }
+
+func (store *DummyStore) Keys(prefix string) ([]string, error) {
+	return nil, errors.Errorf("Dummy store stores nothing!")
+}
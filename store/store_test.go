@@ -0,0 +1,76 @@
+package store
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCopy(t *testing.T) {
+	tmpDir, tmpFile := getTempFile()
+	defer os.RemoveAll(tmpDir)
+
+	file, err := NewStore("file", tmpFile)
+	if err != nil {
+		t.Fatalf("couldn't setup file store: %v", err)
+	}
+
+	file.Set("default:account:mo:address", "GMO", 0)
+	file.Set("default:vars:config:network", "test", 0)
+	file.Set("staging:account:kelly:address", "GKELLY", 0)
+
+	// file -> internal -> file
+	mem, _ := NewStore("internal", "")
+	if keys, err := Copy(file, mem, false); err != nil || len(keys) != 3 {
+		t.Fatalf("want 3 keys copied to internal store, got %v (%v)", keys, err)
+	}
+
+	_, backFile := getTempFile()
+	back, err := NewStore("file", backFile)
+	if err != nil {
+		t.Fatalf("couldn't setup file store: %v", err)
+	}
+
+	seq := back.(*FileStore).data.Seq
+	if keys, err := Copy(mem, back, false); err != nil || len(keys) != 3 {
+		t.Fatalf("want 3 keys copied back to file store, got %v (%v)", keys, err)
+	}
+
+	// File stores get all the keys in one write
+	if writes := back.(*FileStore).data.Seq - seq; writes != 1 {
+		t.Errorf("want 1 write to the file store, got %d", writes)
+	}
+
+	for _, k := range []string{"default:account:mo:address", "default:vars:config:network", "staging:account:kelly:address"} {
+		want, _ := file.Get(k)
+		if got, err := back.Get(k); err != nil || got != want {
+			t.Errorf("%s: want %q, got %q (%v)", k, want, got, err)
+		}
+	}
+
+	// Copying again is fine, but clobbering a different value needs overwrite
+	if _, err := Copy(file, back, false); err != nil {
+		t.Errorf("want same values to copy again, got: %v", err)
+	}
+
+	back.Set("default:vars:config:network", "public", 0)
+	back.Set("default:account:bob:address", "GBOB", 0)
+	if _, err := Copy(file, back, false); err == nil {
+		t.Error("want error overwriting a different value")
+	}
+
+	if got, _ := back.Get("default:vars:config:network"); got != "public" {
+		t.Errorf("want nothing copied after a conflict, got %q", got)
+	}
+
+	if _, err := Copy(file, back, true); err != nil {
+		t.Errorf("want overwrite to copy, got: %v", err)
+	}
+
+	if got, _ := back.Get("default:vars:config:network"); got != "test" {
+		t.Errorf("want overwritten value, got %q", got)
+	}
+
+	if got, _ := back.Get("default:account:bob:address"); got != "GBOB" {
+		t.Errorf("want other keys in the destination kept, got %q", got)
+	}
+}
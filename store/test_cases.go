@@ -1,6 +1,7 @@
 package store
 
 import (
+	"reflect"
 	"testing"
 	"time"
 )
@@ -51,3 +52,39 @@ func testTTL(t *testing.T, store API) {
 
 	store.Delete("mo")
 }
+
+func testKeys(t *testing.T, store API) {
+	store.Set("keys:a:one", "1", 0)
+	store.Set("keys:a:two", "2", 0)
+	store.Set("keys:b:one", "3", 0)
+	store.Set("keys:a:gone", "4", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	keys, err := store.Keys("keys:a:")
+	if err != nil {
+		t.Errorf("couldn't list keys: %v", err)
+	}
+
+	want := []string{"keys:a:one", "keys:a:two"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("incorrect keys: want %v, got %v", want, keys)
+	}
+
+	// Prefixes are matched literally, even with glob characters in them
+	store.Set("keys:[a]*?\\:one", "5", 0)
+	store.Set("keys:ab:one", "6", 0)
+
+	keys, err = store.Keys("keys:[a]*?\\:")
+	if err != nil {
+		t.Errorf("couldn't list keys: %v", err)
+	}
+
+	want = []string{"keys:[a]*?\\:one"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("incorrect keys: want %v, got %v", want, keys)
+	}
+
+	for _, k := range []string{"keys:a:one", "keys:a:two", "keys:b:one", "keys:a:gone", "keys:[a]*?\\:one", "keys:ab:one"} {
+		store.Delete(k)
+	}
+}